@@ -1,49 +1,375 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"compress/gzip"
+	"container/list"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
 	"io"
+	"log/slog"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	krbclient "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	krbcredentials "github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
 	"github.com/mark3labs/mcp-go/mcp"
 	mcpserver "github.com/mark3labs/mcp-go/server"
+	"gopkg.in/yaml.v3"
 )
 
 // ConfluenceConfig holds the configuration for the Confluence client.
 type ConfluenceConfig struct {
 	BaseURL string
 	Token   string
+
+	// TokenFile and TokenCommand name where the token was read from, if not the environment.
+	TokenFile    string
+	TokenCommand string
+
+	// Username and Password select basic authentication instead of the bearer token.
+	Username string
+	Password string
+
+	// AuthScheme is how the credentials are sent: "bearer", "basic", "header:<name>" for the token as
+	// the value of a custom header, or "kerberos". Empty means basic when a username is set, bearer otherwise.
+	AuthScheme string
+
+	// Kerberos authenticates the requests with the kerberos scheme.
+	Kerberos *kerberosAuth
+
+	// ImpersonationHeader names the header that tells a trusted authenticator in front of Confluence
+	// which user a request acts for. ImpersonateUser is the user when a tool call names none.
+	ImpersonationHeader string
+	ImpersonateUser     string
+
+	// ImpersonationAllowedUsers lists the users a tool call may act for through its impersonateUser
+	// argument, or "*" for any user. When it is empty, tool calls cannot name a user.
+	ImpersonationAllowedUsers []string
+
+	// SpacePermissionsReadOnly disables the tools that grant and revoke space permissions.
+	SpacePermissionsReadOnly bool
+
+	// Timeout bounds each request to Confluence. Zero means the default of 30 seconds.
+	Timeout time.Duration
+
+	// TLSConfig, when set, replaces the default TLS settings of connections to Confluence.
+	TLSConfig *tls.Config
+
+	// HTTPCacheBytes bounds the cache of GET responses that are revalidated with ETag and
+	// Last-Modified. Zero disables the cache.
+	HTTPCacheBytes int64
+
+	// CacheTTL is how long lookups of slowly changing reference data, such as spaces, users, and
+	// templates, are answered from memory. Zero disables the cache.
+	CacheTTL time.Duration
+}
+
+// FileConfig is the content of the YAML configuration file. Settings of the default instance sit at
+// the top level; further instances are listed under instances.
+type FileConfig struct {
+	InstanceFileConfig `yaml:",inline"`
+
+	Instances []InstanceFileConfig `yaml:"instances"`
+	Transport string               `yaml:"transport"`
+	Listen    string               `yaml:"listen"`
+	Tools     ToolsConfig          `yaml:"tools"`
+	Logging   LoggingConfig        `yaml:"logging"`
+
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+
+	// SessionCredentials lets each MCP session supply its own token, so that its tool calls act as
+	// its user rather than with the configured credentials. CONFLUENCE_MCP_SESSION_CREDENTIALS overrides it.
+	SessionCredentials bool `yaml:"sessionCredentials"`
+
+	// StartupCheck controls whether the server verifies each instance before it starts. The default
+	// is true; CONFLUENCE_MCP_STARTUP_CHECK overrides it.
+	StartupCheck *bool `yaml:"startupCheck"`
+}
+
+// InstanceFileConfig holds the file settings of one Confluence instance. The token is best given by
+// reference, as the name of the environment variable holding it.
+type InstanceFileConfig struct {
+	Name                      string             `yaml:"name"`
+	BaseURL                   string             `yaml:"baseUrl"`
+	Token                     string             `yaml:"token"`
+	TokenEnv                  string             `yaml:"tokenEnv"`
+	TokenFile                 string             `yaml:"tokenFile"`
+	TokenCommand              string             `yaml:"tokenCommand"`
+	Username                  string             `yaml:"username"`
+	Password                  string             `yaml:"password"`
+	PasswordEnv               string             `yaml:"passwordEnv"`
+	AuthScheme                string             `yaml:"authScheme"`
+	Kerberos                  KerberosFileConfig `yaml:"kerberos"`
+	ImpersonationHeader       string             `yaml:"impersonationHeader"`
+	ImpersonateUser           string             `yaml:"impersonateUser"`
+	ImpersonationAllowedUsers []string           `yaml:"impersonationAllowedUsers"`
+	CACert                    string             `yaml:"caCert"`
+	TLSSkipVerify             bool               `yaml:"tlsSkipVerify"`
+	Timeout                   time.Duration      `yaml:"timeout"`
+	HTTPCacheMB               int                `yaml:"httpCacheMB"`
+	CacheTTL                  time.Duration      `yaml:"cacheTTL"`
+	SpacePermissionsReadOnly  bool               `yaml:"spacePermissionsReadOnly"`
+}
+
+// KerberosFileConfig holds the Kerberos settings of an instance. Either a keytab with the principal
+// to log in as, or a credentials cache, provides the credentials.
+type KerberosFileConfig struct {
+	Config    string `yaml:"config"`
+	Keytab    string `yaml:"keytab"`
+	Principal string `yaml:"principal"`
+	CCache    string `yaml:"ccache"`
+	SPN       string `yaml:"spn"`
+}
+
+// ToolsConfig selects the tools the server exposes and bounds the size of their results.
+type ToolsConfig struct {
+	ToolFilter `yaml:",inline"`
+
+	// MaxResultBytes caps the text of read tool results; longer results are returned in parts.
+	// Zero means defaultMaxResultBytes when loaded from the configuration, and a negative value no cap.
+	MaxResultBytes int `yaml:"maxResultBytes"`
+	// Compact leaves the links, expandable field names, and other boilerplate of Confluence responses
+	// out of tool results, unless a call asks for them.
+	Compact bool `yaml:"compact"`
+}
+
+// ToolFilter selects the tools the server exposes by name, using path.Match patterns. An empty
+// include list includes every tool; exclusions apply after inclusions.
+type ToolFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// SubscriptionsConfig sets how changes to subscribed content are detected.
+type SubscriptionsConfig struct {
+	// PollInterval is how often subscribed content is checked for new versions. The default is
+	// defaultSubscriptionPollInterval; CONFLUENCE_MCP_SUBSCRIPTION_POLL_INTERVAL overrides it.
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// WebhookSecret enables the webhook endpoint of the sse transport, through which Confluence
+	// reports changes as they happen. CONFLUENCE_MCP_WEBHOOK_SECRET overrides it.
+	WebhookSecret string `yaml:"webhookSecret"`
+}
+
+// LoggingConfig sets where the server logs and how much.
+type LoggingConfig struct {
+	// Level is debug, info, warn, or error. The default is info.
+	Level string `yaml:"level"`
+	// File is the path of the log file. The default is standard error.
+	File string `yaml:"file"`
+	// ClientLevel is the least severe MCP log level of the messages sent to clients until they set
+	// one: debug, info, notice, warning, error, critical, alert, or emergency. The default is info.
+	ClientLevel string `yaml:"clientLevel"`
 }
 
 const (
 	// defaultLimit is the default number of results for paginated requests.
 	defaultLimit = 25
+
+	// searchPageSize is the page size used when walking search results automatically.
+	searchPageSize = 50
+
+	// defaultMaxResults is the default number of results collected by auto-paginated searches.
+	defaultMaxResults = 100
+
+	// maxFetchResults caps the number of results any auto-paginated search may collect.
+	maxFetchResults = 1000
+
+	// maxFetchBytes caps the total size of the results any auto-paginated search may collect.
+	maxFetchBytes = 5 * 1024 * 1024
+
+	// excerptLength is the maximum number of characters in generated body excerpts.
+	excerptLength = 300
+
+	// defaultTreeDepth is the default number of levels returned by descendant tree walks.
+	defaultTreeDepth = 3
+
+	// maxTreeNodes caps the number of pages collected by a descendant tree walk.
+	maxTreeNodes = 500
+
+	// defaultTextBudget is the default number of characters returned by plain text extraction.
+	defaultTextBudget = 20000
+
+	// defaultSubscriptionPollInterval is how often subscribed content is checked for new versions by default.
+	defaultSubscriptionPollInterval = time.Minute
+
+	// webhookPath is where the sse transport receives Confluence webhooks.
+	webhookPath = "/webhooks/confluence"
+
+	// maxCompletionValues is the most values a completion may return, as set by MCP.
+	maxCompletionValues = 100
+
+	// completionTimeout bounds the lookups behind a completion.
+	completionTimeout = 10 * time.Second
+
+	// completionSpaceLimit is the number of spaces space keys are completed from.
+	completionSpaceLimit = 500
+
+	// completionLabelSources is the number of recently modified pages and blog posts labels are completed from.
+	completionLabelSources = 100
+
+	// defaultConflictRetries is how often an update is retried after a version conflict by default.
+	defaultConflictRetries = 3
+
+	// maxConflictRetries caps the retries of an update after version conflicts.
+	maxConflictRetries = 10
+
+	// maxErrorBodyBytes caps how much of an error response is read.
+	maxErrorBodyBytes = 64 * 1024
+
+	// maxErrorMessageBytes caps how much of an error response that is not JSON goes into an error message.
+	maxErrorMessageBytes = 1000
+
+	// defaultMaxResultBytes is the default cap of the text of read tool results.
+	defaultMaxResultBytes = 100000
+
+	// maxBulkFetch caps the number of pieces of content fetched by a single bulk fetch.
+	maxBulkFetch = 100
+
+	// bulkFetchWorkers bounds the number of concurrent requests of a bulk fetch.
+	bulkFetchWorkers = 8
+
+	// maxIncludeDepth caps how many levels of included pages are expanded into a page.
+	maxIncludeDepth = 3
+
+	// maxBatchOperations caps the number of operations of a single batch.
+	maxBatchOperations = 50
+
+	// maxTreePages caps the number of pages of a tree created by a single call.
+	maxTreePages = 100
+)
+
+var (
+	// longTaskPollInterval is the initial delay between long-running task status checks.
+	longTaskPollInterval = time.Second
+
+	// longTaskMaxPollInterval caps the backoff between long-running task status checks.
+	longTaskMaxPollInterval = 10 * time.Second
+
+	// longTaskTimeout bounds how long a tool waits for a long-running task to finish.
+	longTaskTimeout = 5 * time.Minute
+
+	// progressHeartbeatInterval is how often a tool waiting on a single long request reports that it
+	// is still waiting.
+	progressHeartbeatInterval = 5 * time.Second
+
+	// shutdownTimeout bounds how long the server waits for tool calls in flight when stopping.
+	shutdownTimeout = 30 * time.Second
+
+	// tokenCommandTimeout bounds how long a token command may run.
+	tokenCommandTimeout = 30 * time.Second
+
+	// tokenFilePollInterval is how often token files are checked for changes.
+	tokenFilePollInterval = 30 * time.Second
+
+	// startupCheckTimeout bounds the checks of all instances before the server starts.
+	startupCheckTimeout = 30 * time.Second
 )
 
 // loadConfig loads configuration from environment variables.
 func loadConfig() (*ConfluenceConfig, error) {
-	token := os.Getenv("CONFLUENCE_API_TOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("CONFLUENCE_API_TOKEN environment variable is required")
+	return loadConfigWithPrefix("CONFLUENCE_", InstanceFileConfig{})
+}
+
+// loadConfigWithPrefix loads a configuration from the environment variables starting with prefix,
+// such as CONFLUENCE_STAGING_ for the variables of an instance named staging. Settings from the
+// configuration file apply where the corresponding variable is not set.
+func loadConfigWithPrefix(prefix string, file InstanceFileConfig) (*ConfluenceConfig, error) {
+	token := os.Getenv(prefix + "API_TOKEN")
+	tokenFile := os.Getenv(prefix + "API_TOKEN_FILE")
+	tokenCommand := os.Getenv(prefix + "TOKEN_COMMAND")
+	// The configuration file names the token only when the environment does not.
+	if token == "" && tokenFile == "" && tokenCommand == "" {
+		if file.TokenEnv != "" {
+			token = os.Getenv(file.TokenEnv)
+		}
+		if token == "" {
+			token, tokenFile, tokenCommand = file.Token, file.TokenFile, file.TokenCommand
+		}
+	}
+	// A token given directly wins over a token file, which wins over a token command.
+	switch {
+	case token != "":
+		tokenFile, tokenCommand = "", ""
+	case tokenFile != "":
+		tokenCommand = ""
+	}
+	if tokenFile != "" || tokenCommand != "" {
+		var err error
+		if token, err = readToken(tokenFile, tokenCommand); err != nil {
+			return nil, err
+		}
+	}
+
+	username := os.Getenv(prefix + "USERNAME")
+	if username == "" {
+		username = file.Username
+	}
+	password := os.Getenv(prefix + "PASSWORD")
+	if password == "" && file.PasswordEnv != "" {
+		password = os.Getenv(file.PasswordEnv)
+	}
+	if password == "" {
+		password = file.Password
+	}
+
+	scheme := os.Getenv(prefix + "AUTH_SCHEME")
+	if scheme == "" {
+		scheme = file.AuthScheme
+	}
+	if err := validateCredentials(prefix, scheme, token, username, password); err != nil {
+		return nil, err
 	}
 
-	rawURL := os.Getenv("CONFLUENCE_BASE_URL")
+	rawURL := os.Getenv(prefix + "BASE_URL")
+	if rawURL == "" {
+		rawURL = os.Getenv(prefix + "API_BASE_PATH")
+	}
 	if rawURL == "" {
-		rawURL = os.Getenv("CONFLUENCE_API_BASE_PATH")
+		rawURL = os.Getenv(prefix + "HOST")
 	}
 	if rawURL == "" {
-		rawURL = os.Getenv("CONFLUENCE_HOST")
+		rawURL = file.BaseURL
 	}
 
 	if rawURL == "" {
-		return nil, fmt.Errorf("CONFLUENCE_BASE_URL (or CONFLUENCE_HOST) environment variable is required")
+		return nil, fmt.Errorf("%sBASE_URL (or %sHOST) environment variable is required", prefix, prefix)
 	}
 
 	if !strings.Contains(rawURL, "://") {
@@ -63,137 +389,1625 @@ func loadConfig() (*ConfluenceConfig, error) {
 		u.Path = strings.TrimSuffix(u.Path, "/") + "/rest/api"
 	}
 
-	return &ConfluenceConfig{
-		BaseURL: u.String(),
-		Token:   token,
-	}, nil
-}
-
-// ConfluenceClient is a client for the Confluence API.
-type ConfluenceClient struct {
-	config     *ConfluenceConfig
-	httpClient *http.Client
-}
+	readOnly := file.SpacePermissionsReadOnly
+	if raw := os.Getenv(prefix + "SPACE_PERMISSIONS_READ_ONLY"); raw != "" {
+		readOnly, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sSPACE_PERMISSIONS_READ_ONLY value %q: %w", prefix, raw, err)
+		}
+	}
 
-// NewConfluenceClient creates a new instance of ConfluenceClient with a default timeout.
-func NewConfluenceClient(config *ConfluenceConfig) *ConfluenceClient {
-	return &ConfluenceClient{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+	timeout := file.Timeout
+	if raw := os.Getenv(prefix + "TIMEOUT"); raw != "" {
+		timeout, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sTIMEOUT value %q: %w", prefix, raw, err)
+		}
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative")
 	}
-}
 
-// executeRequest performs an authenticated HTTP request and returns the response.
-// The caller is responsible for closing the response body.
-func (c *ConfluenceClient) executeRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
-	u, err := url.Parse(c.config.BaseURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid base URL: %w", err)
+	cacheMB := file.HTTPCacheMB
+	if raw := os.Getenv(prefix + "HTTP_CACHE_MB"); raw != "" {
+		cacheMB, err = strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sHTTP_CACHE_MB value %q: %w", prefix, raw, err)
+		}
+	}
+	if cacheMB < 0 {
+		return nil, fmt.Errorf("HTTP cache size must not be negative")
 	}
 
-	u = u.JoinPath(path)
+	cacheTTL := file.CacheTTL
+	if raw := os.Getenv(prefix + "CACHE_TTL"); raw != "" {
+		cacheTTL, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sCACHE_TTL value %q: %w", prefix, raw, err)
+		}
+	}
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("cache TTL must not be negative")
+	}
 
-	if len(query) > 0 {
-		u.RawQuery = query.Encode()
+	impersonationHeader := os.Getenv(prefix + "IMPERSONATION_HEADER")
+	if impersonationHeader == "" {
+		impersonationHeader = file.ImpersonationHeader
+	}
+	impersonateUser := os.Getenv(prefix + "IMPERSONATE_USER")
+	if impersonateUser == "" {
+		impersonateUser = file.ImpersonateUser
+	}
+	if impersonationHeader != "" && !headerNamePattern.MatchString(impersonationHeader) {
+		return nil, fmt.Errorf("invalid %sIMPERSONATION_HEADER value %q", prefix, impersonationHeader)
+	}
+	if impersonateUser != "" && impersonationHeader == "" {
+		return nil, fmt.Errorf("%sIMPERSONATION_HEADER is required with %sIMPERSONATE_USER", prefix, prefix)
+	}
+	allowedUsers := file.ImpersonationAllowedUsers
+	if list := os.Getenv(prefix + "IMPERSONATION_ALLOWED_USERS"); strings.TrimSpace(list) != "" {
+		allowedUsers = nil
+		for _, user := range strings.Split(list, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				allowedUsers = append(allowedUsers, user)
+			}
+		}
+	}
+	if len(allowedUsers) > 0 && impersonationHeader == "" {
+		return nil, fmt.Errorf("%sIMPERSONATION_HEADER is required with %sIMPERSONATION_ALLOWED_USERS", prefix, prefix)
 	}
 
-	var reqBody io.Reader
-	if body != nil {
-		jsonBytes, err := json.Marshal(body)
+	caCert := os.Getenv(prefix + "CA_CERT")
+	if caCert == "" {
+		caCert = file.CACert
+	}
+	skipVerify := file.TLSSkipVerify
+	if raw := os.Getenv(prefix + "TLS_SKIP_VERIFY"); raw != "" {
+		skipVerify, err = strconv.ParseBool(raw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+			return nil, fmt.Errorf("invalid %sTLS_SKIP_VERIFY value %q: %w", prefix, raw, err)
 		}
-		reqBody = bytes.NewBuffer(jsonBytes)
 	}
-
-	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	tlsConfig, err := newTLSConfig(caCert, skipVerify)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	if skipVerify {
+		slog.Warn("TLS certificate verification is disabled", "url", u.String())
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.config.Token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	// Kerberos comes last, as a keytab login is the one check that talks to the network.
+	var kerberos *kerberosAuth
+	if scheme == "kerberos" {
+		settings := file.Kerberos
+		for _, v := range []struct {
+			name  string
+			value *string
+		}{
+			{"KRB5_CONFIG", &settings.Config},
+			{"KRB5_KEYTAB", &settings.Keytab},
+			{"KRB5_PRINCIPAL", &settings.Principal},
+			{"KRB5_CCACHE", &settings.CCache},
+			{"KRB5_SPN", &settings.SPN},
+		} {
+			if env := os.Getenv(prefix + v.name); env != "" {
+				*v.value = env
+			}
+		}
+		if kerberos, err = newKerberosAuth(prefix, settings); err != nil {
+			return nil, err
+		}
+	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	return &ConfluenceConfig{
+		BaseURL:                   u.String(),
+		Token:                     token,
+		TokenFile:                 tokenFile,
+		TokenCommand:              tokenCommand,
+		Username:                  username,
+		Password:                  password,
+		AuthScheme:                scheme,
+		Kerberos:                  kerberos,
+		ImpersonationHeader:       impersonationHeader,
+		ImpersonateUser:           impersonateUser,
+		ImpersonationAllowedUsers: allowedUsers,
+		SpacePermissionsReadOnly:  readOnly,
+		Timeout:                   timeout,
+		TLSConfig:                 tlsConfig,
+		HTTPCacheBytes:            int64(cacheMB) << 20,
+		CacheTTL:                  cacheTTL,
+	}, nil
+}
+
+// newTLSConfig returns the TLS settings for trusting the PEM certificates in the file named caCert
+// on top of the system's, and for skipping certificate verification. With neither, it returns nil
+// to keep the defaults.
+func newTLSConfig(caCert string, skipVerify bool) (*tls.Config, error) {
+	if caCert == "" && !skipVerify {
+		return nil, nil
 	}
 
-	return resp, nil
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: skipVerify}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
 }
 
-// doRequest performs an authenticated HTTP request and returns the body as bytes.
-// It handles basic error checking and limits the response size.
-func (c *ConfluenceClient) doRequest(ctx context.Context, method, path string, query url.Values, body any) ([]byte, error) {
-	resp, err := c.executeRequest(ctx, method, path, query, body)
-	if err != nil {
-		return nil, err
+// readToken reads a token from the file named filename or, when filename is empty, from the
+// standard output of command. The command is split into words and run without a shell, so that a
+// credential helper such as a vault client can supply the token.
+func readToken(filename, command string) (string, error) {
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("token file %s is empty", filename)
+		}
+		return token, nil
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
-	respBytes, err := io.ReadAll(resp.Body)
+	words := strings.Fields(command)
+	if len(words) == 0 {
+		return "", fmt.Errorf("token command is empty")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, words[0], words[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("token command failed: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token command printed no token")
 	}
+	return token, nil
+}
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBytes))
+// validateCredentials checks that the credentials suit the authentication scheme. The basic scheme
+// takes a username and password, or the token as the password with an optional username; the
+// bearer and header schemes take a token only.
+func validateCredentials(prefix, scheme, token, username, password string) error {
+	switch {
+	case scheme == "" && username == "" && password == "" && token == "":
+		return fmt.Errorf("%sAPI_TOKEN environment variable (or %sUSERNAME and %sPASSWORD) is required", prefix, prefix, prefix)
+	case scheme == "" && username != "" && token != "":
+		return fmt.Errorf("set either %sAPI_TOKEN or %sUSERNAME and %sPASSWORD, not both", prefix, prefix, prefix)
+	case scheme == "" && username != "", scheme == "basic" && token == "":
+		if username == "" || password == "" {
+			return fmt.Errorf("%sUSERNAME and %sPASSWORD are both required for basic authentication", prefix, prefix)
+		}
+	case scheme == "" && password != "":
+		return fmt.Errorf("%sUSERNAME environment variable is required with %sPASSWORD", prefix, prefix)
+	case scheme == "basic":
+		if password != "" {
+			return fmt.Errorf("set either %sAPI_TOKEN or %sPASSWORD for basic authentication, not both", prefix, prefix)
+		}
+	case scheme == "bearer" || strings.HasPrefix(scheme, "header:"):
+		if name := strings.TrimPrefix(scheme, "header:"); scheme != "bearer" && !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid header name %q in %sAUTH_SCHEME", name, prefix)
+		}
+		if username != "" || password != "" {
+			return fmt.Errorf("%sUSERNAME and %sPASSWORD apply only to basic authentication", prefix, prefix)
+		}
+		if token == "" {
+			return fmt.Errorf("%sAPI_TOKEN environment variable is required for %s authentication", prefix, scheme)
+		}
+	case scheme == "kerberos":
+		if token != "" || username != "" || password != "" {
+			return fmt.Errorf("%sAPI_TOKEN, %sUSERNAME, and %sPASSWORD do not apply to kerberos authentication", prefix, prefix, prefix)
+		}
+	case scheme != "":
+		return fmt.Errorf("invalid %sAUTH_SCHEME value %q: must be bearer, basic, header:<name>, or kerberos", prefix, scheme)
 	}
+	return nil
+}
 
-	return respBytes, nil
+// loadFileConfig reads the YAML configuration file named filename. An empty filename yields an empty
+// configuration. Server settings from the environment are applied on top.
+func loadFileConfig(filename string) (*FileConfig, error) {
+	file := &FileConfig{}
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read configuration file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", filename, err)
+		}
+	}
+
+	for _, pattern := range append(slices.Clone(file.Tools.Include), file.Tools.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tool pattern %q in configuration file", pattern)
+		}
+	}
+	if raw := os.Getenv("CONFLUENCE_MCP_MAX_RESULT_BYTES"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFLUENCE_MCP_MAX_RESULT_BYTES value %q: %w", raw, err)
+		}
+		// Zero given explicitly turns the cap off, where the file cannot tell it from unset.
+		file.Tools.MaxResultBytes = limit
+		if limit == 0 {
+			file.Tools.MaxResultBytes = -1
+		}
+	}
+	if file.Tools.MaxResultBytes == 0 {
+		file.Tools.MaxResultBytes = defaultMaxResultBytes
+	}
+	if raw := os.Getenv("CONFLUENCE_MCP_COMPACT"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFLUENCE_MCP_COMPACT value %q: %w", raw, err)
+		}
+		file.Tools.Compact = enabled
+	}
+	if raw := os.Getenv("CONFLUENCE_MCP_SUBSCRIPTION_POLL_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFLUENCE_MCP_SUBSCRIPTION_POLL_INTERVAL value %q: %w", raw, err)
+		}
+		file.Subscriptions.PollInterval = interval
+	}
+	if file.Subscriptions.PollInterval == 0 {
+		file.Subscriptions.PollInterval = defaultSubscriptionPollInterval
+	}
+	if file.Subscriptions.PollInterval < time.Second {
+		return nil, fmt.Errorf("subscription poll interval must be at least a second")
+	}
+	if secret := os.Getenv("CONFLUENCE_MCP_WEBHOOK_SECRET"); secret != "" {
+		file.Subscriptions.WebhookSecret = secret
+	}
+	if raw := os.Getenv("CONFLUENCE_MCP_SESSION_CREDENTIALS"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFLUENCE_MCP_SESSION_CREDENTIALS value %q: %w", raw, err)
+		}
+		file.SessionCredentials = enabled
+	}
+	if raw := os.Getenv("CONFLUENCE_MCP_STARTUP_CHECK"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFLUENCE_MCP_STARTUP_CHECK value %q: %w", raw, err)
+		}
+		file.StartupCheck = &enabled
+	}
+	for _, instance := range file.Instances {
+		if instance.Name == "" {
+			return nil, fmt.Errorf("every instance in the configuration file needs a name")
+		}
+	}
+	return file, nil
 }
 
-// getJSON is a helper to perform a GET request and unmarshal the result into a target object efficiently.
-func (c *ConfluenceClient) getJSON(ctx context.Context, path string, query url.Values, target any) error {
-	resp, err := c.executeRequest(ctx, "GET", path, query, nil)
-	if err != nil {
-		return err
+// loadRegistry loads the configured Confluence instances. The instance names come from
+// CONFLUENCE_INSTANCES, a comma-separated list, or else from the instances of the configuration file.
+// Each named instance is configured by the variables prefixed with CONFLUENCE_<NAME>_ and its entry
+// in the file, and the first instance is the default. Without named instances, the single instance
+// configured by the CONFLUENCE_ variables and the top level of the file is named "default".
+func loadRegistry(file *FileConfig) (*ClientRegistry, error) {
+	registry := newClientRegistry()
+
+	var names []string
+	if list := os.Getenv("CONFLUENCE_INSTANCES"); strings.TrimSpace(list) != "" {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for _, instance := range file.Instances {
+			names = append(names, instance.Name)
+		}
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
-	if resp.StatusCode >= 400 {
-		respBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBytes))
+	if len(names) == 0 {
+		config, err := loadConfigWithPrefix("CONFLUENCE_", file.InstanceFileConfig)
+		if err != nil {
+			return nil, err
+		}
+		registry.add(defaultInstance, NewConfluenceClient(config))
+		return registry, nil
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-		return fmt.Errorf("failed to decode JSON: %w", err)
+	for _, name := range names {
+		if _, ok := registry.clients[name]; ok {
+			return nil, fmt.Errorf("instance %q is configured twice", name)
+		}
+		var settings InstanceFileConfig
+		if i := slices.IndexFunc(file.Instances, func(instance InstanceFileConfig) bool { return instance.Name == name }); i >= 0 {
+			settings = file.Instances[i]
+		}
+		config, err := loadConfigWithPrefix("CONFLUENCE_"+instanceEnvName(name)+"_", settings)
+		if err != nil {
+			return nil, fmt.Errorf("instance %s: %w", name, err)
+		}
+		registry.add(name, NewConfluenceClient(config))
 	}
-	return nil
+	return registry, nil
 }
 
-// SpaceRef represents a reference to a Confluence space in API responses/requests.
-type SpaceRef struct {
-	Key string `json:"key" `
+// instanceEnvName returns the form of an instance name used in environment variable names:
+// upper case, with every character other than a letter or digit replaced by an underscore.
+func instanceEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
 }
 
-// BodyStorage represents the storage format of the body content.
-type BodyStorage struct {
-	Value          string `json:"value"`
-	Representation string `json:"representation"`
+// version is the version of the server, which builds can set with -ldflags "-X main.version=...".
+var version = "1.0.0"
+
+// defaultInstance is the name of the instance configured without CONFLUENCE_INSTANCES.
+const defaultInstance = "default"
+
+// ClientRegistry holds the clients of the configured Confluence instances.
+type ClientRegistry struct {
+	// names lists the instance names in configuration order; the first is the default instance.
+	names   []string
+	clients map[string]*ConfluenceClient
 }
 
-// Body represents the body of a Confluence page, typically containing storage format.
-type Body struct {
-	Storage *BodyStorage `json:"storage,omitempty"`
+// newClientRegistry creates an empty registry.
+func newClientRegistry() *ClientRegistry {
+	return &ClientRegistry{clients: map[string]*ConfluenceClient{}}
 }
 
-// Version represents the version information of a Confluence page.
-type Version struct {
-	Number  int    `json:"number"`
-	Message string `json:"message,omitempty"`
+// singleClientRegistry creates a registry holding client as the default instance.
+func singleClientRegistry(client *ConfluenceClient) *ClientRegistry {
+	registry := newClientRegistry()
+	registry.add(defaultInstance, client)
+	return registry
 }
 
-// Ancestor represents an ancestor page of a Confluence page.
-type Ancestor struct {
-	ID string `json:"id"`
+// add registers the client of a named instance.
+func (r *ClientRegistry) add(name string, client *ConfluenceClient) {
+	client.instance = name
+	r.names = append(r.names, name)
+	r.clients[name] = client
+}
+
+// ConfluenceClient is a client for the Confluence API.
+type ConfluenceClient struct {
+	config     *ConfluenceConfig
+	httpClient *http.Client
+
+	// instance is the name the client is registered under, which selects the session token to use.
+	instance string
+
+	// auth is replaced when the token is reloaded, while requests may be using it.
+	auth atomic.Pointer[Authenticator]
+
+	// references caches lookups of reference data; nil when CacheTTL is zero.
+	references *referenceCache
+}
+
+// Authenticator attaches credentials to the requests a client sends to Confluence.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// bearerAuth authenticates with a personal access token.
+type bearerAuth struct {
+	token string
+}
+
+func (a bearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// basicAuth authenticates with a username and password.
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// headerAuth authenticates with the token as the value of a custom header, as some proxies in
+// front of Confluence require.
+type headerAuth struct {
+	name  string
+	token string
+}
+
+func (a headerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set(a.name, a.token)
+	return nil
+}
+
+// kerberosAuth authenticates with SPNEGO, using a Kerberos client logged in with a keytab or a
+// credentials cache.
+type kerberosAuth struct {
+	client *krbclient.Client
+	// spn is the service principal of Confluence. Empty means HTTP/<host> of the request.
+	spn string
+}
+
+func (a *kerberosAuth) Authenticate(req *http.Request) error {
+	return spnego.SetSPNEGOHeader(a.client, req, a.spn)
+}
+
+// newKerberosAuth loads the Kerberos configuration and credentials of settings. The Kerberos
+// configuration defaults to KRB5_CONFIG or /etc/krb5.conf, and the credentials cache to KRB5CCNAME.
+// A client with a keytab logs in right away, so that bad credentials fail the start.
+func newKerberosAuth(prefix string, settings KerberosFileConfig) (*kerberosAuth, error) {
+	if settings.Config == "" {
+		settings.Config = os.Getenv("KRB5_CONFIG")
+	}
+	if settings.Config == "" {
+		settings.Config = "/etc/krb5.conf"
+	}
+	krb5conf, err := krbconfig.Load(settings.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kerberos configuration %s: %w", settings.Config, err)
+	}
+
+	var client *krbclient.Client
+	if settings.Keytab != "" {
+		username, realm, ok := strings.Cut(settings.Principal, "@")
+		if !ok || username == "" || realm == "" {
+			return nil, fmt.Errorf("%sKRB5_PRINCIPAL must be a principal of the form user@REALM to use with the keytab", prefix)
+		}
+		kt, err := keytab.Load(settings.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keytab: %w", err)
+		}
+		client = krbclient.NewWithKeytab(username, realm, kt, krb5conf, krbclient.DisablePAFXFAST(true))
+		if err := client.Login(); err != nil {
+			return nil, fmt.Errorf("kerberos login as %s failed: %w", settings.Principal, err)
+		}
+	} else {
+		if settings.CCache == "" {
+			settings.CCache = strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+		}
+		if settings.CCache == "" {
+			return nil, fmt.Errorf("%sKRB5_KEYTAB or %sKRB5_CCACHE is required for kerberos authentication", prefix, prefix)
+		}
+		ccache, err := krbcredentials.LoadCCache(settings.CCache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials cache: %w", err)
+		}
+		if client, err = krbclient.NewFromCCache(ccache, krb5conf, krbclient.DisablePAFXFAST(true)); err != nil {
+			return nil, fmt.Errorf("failed to use credentials cache: %w", err)
+		}
+	}
+	return &kerberosAuth{client: client, spn: settings.SPN}, nil
+}
+
+// newAuthenticator returns the authenticator for the credentials and scheme of config.
+func newAuthenticator(config *ConfluenceConfig) Authenticator {
+	switch {
+	case config.Kerberos != nil:
+		return config.Kerberos
+	case strings.HasPrefix(config.AuthScheme, "header:"):
+		return headerAuth{name: strings.TrimPrefix(config.AuthScheme, "header:"), token: config.Token}
+	case config.AuthScheme == "basic" || config.Username != "":
+		password := config.Password
+		if config.Token != "" {
+			password = config.Token
+		}
+		return basicAuth{username: config.Username, password: password}
+	}
+	return bearerAuth{token: config.Token}
+}
+
+// NewConfluenceClient creates a new instance of ConfluenceClient with a default timeout.
+func NewConfluenceClient(config *ConfluenceConfig) *ConfluenceClient {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	c := &ConfluenceClient{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+	if config.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config.TLSConfig
+		c.httpClient.Transport = transport
+	}
+	if config.HTTPCacheBytes > 0 {
+		c.httpClient.Transport = newConditionalCache(c.httpClient.Transport, config.HTTPCacheBytes)
+	}
+	if config.CacheTTL > 0 {
+		c.references = &referenceCache{ttl: config.CacheTTL, entries: map[string]referenceEntry{}}
+	}
+	auth := newAuthenticator(config)
+	c.auth.Store(&auth)
+	return c
+}
+
+// withHTTPClient returns a client with the configuration and current credentials of c that sends
+// its requests through httpClient.
+func (c *ConfluenceClient) withHTTPClient(httpClient *http.Client) *ConfluenceClient {
+	clone := &ConfluenceClient{config: c.config, httpClient: httpClient, instance: c.instance, references: c.references}
+	clone.auth.Store(c.auth.Load())
+	return clone
+}
+
+// reloadToken reads the token again from its file or command and swaps it in for the requests that
+// follow. It reports whether the token changed; a token from anywhere else never does.
+func (c *ConfluenceClient) reloadToken() (bool, error) {
+	if c.config.TokenFile == "" && c.config.TokenCommand == "" {
+		return false, nil
+	}
+	token, err := readToken(c.config.TokenFile, c.config.TokenCommand)
+	if err != nil {
+		return false, err
+	}
+
+	config := *c.config
+	config.Token = token
+	auth := newAuthenticator(&config)
+	if old := c.auth.Swap(&auth); *old == auth {
+		return false, nil
+	}
+	c.clearCaches()
+	return true, nil
+}
+
+// authorize attaches the configured credentials to an outgoing request, or the token of the MCP
+// session the request is made for, if the session supplied one. A session token is sent as a bearer
+// token, or in the custom header of the header scheme. Only requests made with the configured
+// credentials act for an impersonated user, as a session token already belongs to its own user.
+func (c *ConfluenceClient) authorize(req *http.Request) error {
+	auth := *c.auth.Load()
+	token := sessionToken(req.Context(), c.instance)
+	if token != "" {
+		config := ConfluenceConfig{Token: token}
+		if strings.HasPrefix(c.config.AuthScheme, "header:") {
+			config.AuthScheme = c.config.AuthScheme
+		}
+		auth = newAuthenticator(&config)
+	}
+	if err := auth.Authenticate(req); err != nil {
+		return fmt.Errorf("failed to authenticate request: %w", err)
+	}
+
+	if c.config.ImpersonationHeader != "" && token == "" {
+		user, _ := req.Context().Value(impersonatedUserKey{}).(string)
+		if user != "" && !c.config.mayImpersonate(user) {
+			return fmt.Errorf("acting for user %q is not allowed", user)
+		}
+		if user == "" {
+			user = c.config.ImpersonateUser
+		}
+		if user != "" {
+			req.Header.Set(c.config.ImpersonationHeader, user)
+		}
+	}
+	return nil
+}
+
+// mayImpersonate reports whether a tool call may act for user.
+func (c *ConfluenceConfig) mayImpersonate(user string) bool {
+	return slices.ContainsFunc(c.ImpersonationAllowedUsers, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(allowed, user)
+	})
+}
+
+// conditionalCache is an http.RoundTripper that keeps the bodies of GET responses carrying an ETag
+// or Last-Modified header and revalidates them with conditional requests. On 304 Not Modified it
+// serves the kept body, which saves Confluence from rendering the content again. Entries are keyed
+// by URL and by the credentials of the request, so users never see each other's responses, and the
+// least recently used ones are evicted once the total size exceeds maxBytes. Kerberos tokens change
+// with every request, so the cache does not help with that scheme.
+type conditionalCache struct {
+	next     http.RoundTripper
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// cachedResponse is a response kept by conditionalCache.
+type cachedResponse struct {
+	key          string
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+// newConditionalCache returns a conditionalCache of at most maxBytes that sends its requests
+// through next, or through http.DefaultTransport when next is nil.
+func newConditionalCache(next http.RoundTripper, maxBytes int64) *conditionalCache {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &conditionalCache{next: next, maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// cacheKey identifies the response to req by its URL and all of its headers but the correlation ID,
+// which include the credentials and the impersonated user. The headers are hashed rather than kept.
+func cacheKey(req *http.Request) string {
+	h := sha256.New()
+	for _, name := range slices.Sorted(maps.Keys(req.Header)) {
+		if name == requestIDHeader {
+			continue
+		}
+		h.Write([]byte(name + ":" + strings.Join(req.Header[name], ",") + "\n"))
+	}
+	return req.URL.String() + "#" + hex.EncodeToString(h.Sum(nil))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (cc *conditionalCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return cc.next.RoundTrip(req)
+	}
+	key := cacheKey(req)
+
+	entry := cc.get(key)
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.etag != "" {
+			req.Header.Set("If-None-Match", entry.etag)
+		}
+		if entry.lastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.lastModified)
+		}
+	}
+
+	resp, err := cc.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		resp.Body.Close()
+		header := entry.header.Clone()
+		for _, name := range []string{"Etag", "Last-Modified", "Date"} {
+			if v := resp.Header.Get(name); v != "" {
+				header.Set(name, v)
+			}
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(entry.body)),
+			ContentLength: int64(len(entry.body)),
+			Request:       req,
+		}, nil
+	case resp.StatusCode == http.StatusOK:
+		etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag == "" && lastModified == "" || strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+			cc.remove(key)
+			return resp, nil
+		}
+		// Bodies too large to be worth keeping are passed through unread.
+		if resp.ContentLength > cc.maxBytes/4 {
+			cc.remove(key)
+			return resp, nil
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, cc.maxBytes/4+1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if int64(len(body)) > cc.maxBytes/4 {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			cc.remove(key)
+			return resp, nil
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		cc.put(&cachedResponse{key: key, etag: etag, lastModified: lastModified, header: resp.Header.Clone(), body: body})
+	default:
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			cc.remove(key)
+		}
+	}
+	return resp, nil
+}
+
+// get returns the entry stored under key and marks it as recently used.
+func (cc *conditionalCache) get(key string) *cachedResponse {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	elem, ok := cc.entries[key]
+	if !ok {
+		return nil
+	}
+	cc.order.MoveToFront(elem)
+	return elem.Value.(*cachedResponse)
+}
+
+// put stores entry, replacing an earlier one with its key, and evicts the least recently used
+// entries beyond maxBytes.
+func (cc *conditionalCache) put(entry *cachedResponse) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.removeLocked(entry.key)
+	cc.entries[entry.key] = cc.order.PushFront(entry)
+	cc.size += int64(len(entry.body))
+	for cc.size > cc.maxBytes {
+		cc.removeLocked(cc.order.Back().Value.(*cachedResponse).key)
+	}
+}
+
+// remove drops the entry stored under key, if any.
+func (cc *conditionalCache) remove(key string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.removeLocked(key)
+}
+
+func (cc *conditionalCache) removeLocked(key string) {
+	if elem, ok := cc.entries[key]; ok {
+		cc.order.Remove(elem)
+		delete(cc.entries, key)
+		cc.size -= int64(len(elem.Value.(*cachedResponse).body))
+	}
+}
+
+// referenceCache answers repeated lookups of reference data from memory for ttl. Entries are keyed
+// by the request URL together with the session token and impersonated user, so that each user only
+// sees what Confluence returned to them.
+type referenceCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]referenceEntry
+}
+
+// referenceEntry is a response kept by referenceCache.
+type referenceEntry struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// get returns the unexpired entry stored under key.
+func (rc *referenceCache) get(key string) (referenceEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return referenceEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores a response under key, dropping the entries that have expired.
+func (rc *referenceCache) put(key string, header http.Header, body []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	maps.DeleteFunc(rc.entries, func(_ string, entry referenceEntry) bool {
+		return now.After(entry.expires)
+	})
+	rc.entries[key] = referenceEntry{header: header, body: body, expires: now.Add(rc.ttl)}
+}
+
+// clear drops all entries and returns how many there were.
+func (rc *referenceCache) clear() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	n := len(rc.entries)
+	clear(rc.entries)
+	return n
+}
+
+// isReferenceLookup reports whether a GET of path with query reads reference data: a space, users,
+// templates, or a search for spaces or users. The current user is not reference data, as it has to
+// follow the credentials when a token is revoked or reloaded.
+func isReferenceLookup(path string, query url.Values) bool {
+	switch {
+	case path == "/search":
+		return referenceCQLPattern.MatchString(query.Get("cql"))
+	case strings.HasPrefix(path, "/space/"):
+		return !strings.Contains(strings.TrimPrefix(path, "/space/"), "/")
+	case path == "/user/current":
+		return false
+	}
+	return path == "/space" || path == "/user" || strings.HasPrefix(path, "/user/") && !strings.HasPrefix(path, "/user/watch") ||
+		path == "/template" || strings.HasPrefix(path, "/template/")
+}
+
+// invalidatesReferences reports whether a request that is not a GET may change cached reference data.
+func invalidatesReferences(path string) bool {
+	return path == "/space" || strings.HasPrefix(path, "/space/") || path == "/template" || strings.HasPrefix(path, "/template/")
+}
+
+// referenceKey identifies the lookup of u for the user of ctx.
+func (c *ConfluenceClient) referenceKey(ctx context.Context, u *url.URL) string {
+	user, _ := ctx.Value(impersonatedUserKey{}).(string)
+	token := sha256.Sum256([]byte(sessionToken(ctx, c.instance)))
+	return u.String() + "#" + hex.EncodeToString(token[:]) + "#" + user
+}
+
+// clearCaches drops the cached reference data and HTTP responses of the client, and returns the
+// number of entries dropped.
+func (c *ConfluenceClient) clearCaches() int {
+	n := 0
+	if c.references != nil {
+		n += c.references.clear()
+	}
+	if cache, ok := c.httpClient.Transport.(*conditionalCache); ok {
+		cache.mu.Lock()
+		n += len(cache.entries)
+		clear(cache.entries)
+		cache.order.Init()
+		cache.size = 0
+		cache.mu.Unlock()
+	}
+	return n
+}
+
+// gzipBody is the decompressed body of a gzip-encoded response.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// decompressResponse replaces the body of a gzip-encoded response with its decompressed content.
+// The request asks for gzip itself, so the transport leaves the decoding to us; page bodies and
+// search results shrink to a fraction of their size on the way from Confluence.
+func decompressResponse(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	switch {
+	case errors.Is(err, io.EOF):
+		// An empty body, as of a 204 No Content, has nothing to decompress.
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{bytes.NewReader(nil), resp.Body}
+	case err != nil:
+		return fmt.Errorf("failed to decompress response: %w", err)
+	default:
+		resp.Body = gzipBody{Reader: zr, body: resp.Body}
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// requestIDHeader carries the correlation ID of a tool call on its requests to Confluence, so that
+// they can be found in the logs of Confluence and of the proxies in front of it.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDKey is the context key of the correlation ID of a tool call.
+type requestIDKey struct{}
+
+// correlate gives each tool call a correlation ID, sent with its requests to Confluence, and logs
+// the call at debug level.
+func correlate(next mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		requestID := hex.EncodeToString(b)
+		ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+
+		start := time.Now()
+		result, err := next(ctx, req)
+		slog.DebugContext(ctx, "tool call", "tool", req.Params.Name, "requestId", requestID, "duration", time.Since(start),
+			"failed", err != nil || result != nil && result.IsError)
+		return result, err
+	}
+}
+
+// progressKey is the context key of the progress reporter of a tool call.
+type progressKey struct{}
+
+// progressReporter sends the progress notifications of a tool call whose client asked for them.
+type progressReporter struct {
+	send func(params map[string]any)
+
+	mu   sync.Mutex
+	last float64
+}
+
+// trackProgress gives the tool calls that carry a progress token a progress reporter that sends
+// notifications to the client of the call.
+func trackProgress(next mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		server := mcpserver.ServerFromContext(ctx)
+		if req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil || server == nil {
+			return next(ctx, req)
+		}
+		token := req.Params.Meta.ProgressToken
+		sessionCtx := ctx
+		reporter := &progressReporter{send: func(params map[string]any) {
+			params["progressToken"] = token
+			if err := server.SendNotificationToClient(sessionCtx, "notifications/progress", params); err != nil {
+				slog.DebugContext(sessionCtx, "failed to send progress", "error", err)
+			}
+		}}
+		return next(context.WithValue(ctx, progressKey{}, reporter), req)
+	}
+}
+
+// reportProgress reports that a tool call has done progress of total steps, or of an unknown
+// number when total is 0. Progress has to grow with every notification, so a report that does not
+// move on from the last is sent as one step further without a total.
+func reportProgress(ctx context.Context, progress, total float64, message string) {
+	reporter, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok {
+		return
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if progress <= reporter.last {
+		progress, total = reporter.last+1, 0
+	}
+	reporter.last = progress
+	params := map[string]any{"progress": progress, "message": message}
+	if total > 0 {
+		params["total"] = total
+	}
+	reporter.send(params)
+}
+
+// reportStep reports that a tool call of unknown length has done one more step.
+func reportStep(ctx context.Context, message string) {
+	reportProgress(ctx, 0, 0, message)
+}
+
+// reportWhileWaiting reports every progressHeartbeatInterval that a tool call is still waiting on
+// what message describes, until the returned function is called.
+func reportWhileWaiting(ctx context.Context, message string) func() {
+	if ctx.Value(progressKey{}) == nil {
+		return func() {}
+	}
+	reportStep(ctx, message)
+	done := make(chan struct{})
+	go func() {
+		start := time.Now()
+		ticker := time.NewTicker(progressHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reportStep(ctx, fmt.Sprintf("%s (%s)", message, time.Since(start).Round(time.Second)))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// redactURL returns u for logging, with its password and the values of query parameters that
+// look like secrets masked.
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		if secretNamePattern.MatchString(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.Redacted()
+}
+
+// Kinds of structured output, by the shape of the text result they are built from.
+const (
+	contentOutputKind     = "content"
+	contentListOutputKind = "contentList"
+	bulkFetchOutputKind   = "bulkFetch"
+	healthOutputKind      = "health"
+)
+
+// outputKinds gives the kind of structured output of the tools whose results have a known shape. The
+// other tools return their JSON result as an object, a JSON array under results, or text under text.
+var outputKinds = map[string]string{
+	"confluence_get_content":          contentOutputKind,
+	"confluence_create_content":       contentOutputKind,
+	"confluence_update_section":       contentOutputKind,
+	"confluence_update_content":       contentOutputKind,
+	"confluence_get_page_by_title":    contentOutputKind,
+	"confluence_get_space_homepage":   contentOutputKind,
+	"confluence_create_from_template": contentOutputKind,
+	"confluence_search_content":       contentListOutputKind,
+	"confluence_find":                 contentListOutputKind,
+	"confluence_find_by_label":        contentListOutputKind,
+	"confluence_get_children":         contentListOutputKind,
+	"confluence_get_space_content":    contentListOutputKind,
+	"confluence_list_blogposts":       contentListOutputKind,
+	"confluence_list_favourites":      contentListOutputKind,
+	"confluence_get_user_content":     contentListOutputKind,
+	"confluence_recently_updated":     contentListOutputKind,
+	"confluence_get_many":             bulkFetchOutputKind,
+	"confluence_health":               healthOutputKind,
+}
+
+// objectOutput is the structured output of the tools without a known result shape.
+type objectOutput map[string]any
+
+// withStructuredOutput returns a tool registration function that gives every tool an output schema
+// and adds structured content in that schema to its successful results, built from the text result.
+func withStructuredOutput(client *ConfluenceClient, add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		kind := outputKinds[tool.Name]
+		switch kind {
+		case contentOutputKind:
+			mcp.WithOutputSchema[ContentOutput]()(&tool)
+		case contentListOutputKind:
+			mcp.WithOutputSchema[ContentListOutput]()(&tool)
+		case bulkFetchOutputKind:
+			mcp.WithOutputSchema[BulkFetchOutput]()(&tool)
+		case healthOutputKind:
+			mcp.WithOutputSchema[HealthReport]()(&tool)
+		default:
+			mcp.WithRawOutputSchema(json.RawMessage(`{"type":"object"}`))(&tool)
+		}
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := handler(ctx, req)
+			if err != nil || result == nil || result.IsError || result.StructuredContent != nil {
+				return result, err
+			}
+			var text string
+			if len(result.Content) > 0 {
+				if content, ok := result.Content[0].(mcp.TextContent); ok {
+					text = content.Text
+				}
+			}
+			result.StructuredContent = client.structuredOutput(kind, text)
+			return result, nil
+		})
+	}
+}
+
+// withWebURLs returns a tool registration function that gives the pages, blog posts, comments, and
+// attachments in the JSON results of the tools an absolute webUrl, as the links of API responses are
+// relative to the site and of no use to someone asked to open them.
+func withWebURLs(client *ConfluenceClient, add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := handler(ctx, req)
+			if err != nil || result == nil || result.IsError || len(result.Content) == 0 {
+				return result, err
+			}
+			if content, ok := result.Content[0].(mcp.TextContent); ok {
+				if value, ok := decodeResultJSON(content.Text); ok && client.addWebURLs(value) {
+					if text, ok := encodeResultJSON(value); ok {
+						content.Text = text
+						result.Content[0] = content
+					}
+				}
+			}
+			if output, ok := result.StructuredContent.(objectOutput); ok {
+				client.addWebURLs(map[string]any(output))
+			}
+			return result, nil
+		})
+	}
+}
+
+// withResultFormats returns a tool registration function that gives the tools that search or list
+// content a resultFormat argument. The summary format returns a flat array of ResultSummary in place
+// of the Confluence envelope, followed by a note naming the cursor of the next results, if any.
+func withResultFormats(add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		if outputKinds[tool.Name] != contentListOutputKind {
+			add(tool, handler)
+			return
+		}
+		mcp.WithString("resultFormat", mcp.Description("The format of the results: 'raw' for the Confluence response, or 'summary' for a flat array of id, title, type, space, url, lastModified, and excerpt (default: raw)"))(&tool)
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			format, _ := req.GetArguments()["resultFormat"].(string)
+			if format != "" && format != "raw" && format != "summary" {
+				return mcp.NewToolResultError("resultFormat must be 'raw' or 'summary'"), nil
+			}
+			result, err := handler(ctx, req)
+			if format != "summary" || err != nil || result == nil || result.IsError {
+				return result, err
+			}
+			list, ok := result.StructuredContent.(ContentListOutput)
+			if !ok {
+				return result, nil
+			}
+			summaries := make([]ResultSummary, 0, len(list.Results))
+			for _, item := range list.Results {
+				summaries = append(summaries, ResultSummary{
+					ID:           item.ID,
+					Title:        item.Title,
+					Type:         item.Type,
+					Space:        item.Space,
+					URL:          item.URL,
+					LastModified: item.LastModified,
+					Excerpt:      item.Excerpt,
+				})
+			}
+			text, ok := encodeResultJSON(summaries)
+			if !ok {
+				return mcp.NewToolResultError("failed to encode results"), nil
+			}
+			result.Content = []mcp.Content{mcp.NewTextContent(text)}
+			if list.NextCursor != "" {
+				result.Content = append(result.Content, mcp.NewTextContent(fmt.Sprintf("[More results: call the tool again with cursor %s]", list.NextCursor)))
+			}
+			return result, nil
+		})
+	}
+}
+
+// structuredOutput builds the structured output of the given kind from the text result of a tool.
+func (c *ConfluenceClient) structuredOutput(kind, text string) any {
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		value = nil
+	}
+	object, _ := value.(map[string]any)
+
+	switch kind {
+	case contentOutputKind:
+		return c.contentOutput(object)
+	case contentListOutputKind:
+		return c.contentListOutput(object)
+	case bulkFetchOutputKind:
+		output := BulkFetchOutput{Results: []BulkFetchItem{}}
+		items, _ := object["results"].([]any)
+		for _, item := range items {
+			fetched, _ := item.(map[string]any)
+			result := BulkFetchItem{ID: stringField(fetched, "id"), Error: stringField(fetched, "error")}
+			if content, ok := fetched["content"].(map[string]any); ok {
+				out := c.contentOutput(content)
+				result.Content = &out
+			}
+			output.Results = append(output.Results, result)
+		}
+		return output
+	case healthOutputKind:
+		var report HealthReport
+		_ = json.Unmarshal([]byte(text), &report)
+		return report
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		return objectOutput(value)
+	case []any:
+		return objectOutput{"results": value}
+	}
+	return objectOutput{"text": text}
+}
+
+// contentListOutput normalizes a paginated list or search response. Responses that stop early say so
+// with a next link, a truncated flag, or a total size above the number of results.
+func (c *ConfluenceClient) contentListOutput(list map[string]any) ContentListOutput {
+	output := ContentListOutput{Results: []ContentOutput{}}
+	items, _ := list["results"].([]any)
+	for _, item := range items {
+		if content, ok := item.(map[string]any); ok {
+			output.Results = append(output.Results, c.contentOutput(content))
+		}
+	}
+	start, _ := list["start"].(float64)
+	totalSize, _ := list["totalSize"].(float64)
+	truncated, _ := list["truncated"].(bool)
+	links, _ := list["_links"].(map[string]any)
+	output.Start = int(start)
+	output.Size = len(output.Results)
+	output.TotalSize = int(totalSize)
+	output.HasMore = truncated || stringField(links, "next") != "" || output.TotalSize > output.Start+output.Size
+	return output
+}
+
+// contentOutput normalizes a content item, a search result wrapping one, or a compact result that
+// already has a flat shape.
+func (c *ConfluenceClient) contentOutput(item map[string]any) ContentOutput {
+	if content, ok := item["content"].(map[string]any); ok {
+		output := c.contentOutput(content)
+		if output.Title == "" {
+			output.Title = highlightReplacer.Replace(stringField(item, "title"))
+		}
+		if excerpt := stringField(item, "excerpt"); excerpt != "" {
+			output.Excerpt = truncateText(highlightReplacer.Replace(strings.Join(strings.Fields(excerpt), " ")), excerptLength)
+		}
+		if output.URL == "" {
+			output.URL = c.absoluteURL(stringField(item, "url"))
+		}
+		if modified := stringField(item, "lastModified"); modified != "" {
+			output.LastModified = modified
+		}
+		if output.Space == "" {
+			// Without an expanded space, the space of a search result is named by its container link.
+			container, _ := item["resultGlobalContainer"].(map[string]any)
+			if key, ok := strings.CutPrefix(stringField(container, "displayUrl"), "/display/"); ok && !strings.Contains(key, "/") {
+				output.Space = key
+			}
+		}
+		return output
+	}
+
+	output := ContentOutput{
+		ID:           stringField(item, "id"),
+		Type:         stringField(item, "type"),
+		Status:       stringField(item, "status"),
+		Title:        stringField(item, "title"),
+		Excerpt:      stringField(item, "excerpt"),
+		URL:          c.absoluteURL(stringField(item, "url")),
+		LastModified: cmp.Or(stringField(item, "lastModified"), stringField(item, "modified")),
+	}
+	switch space := item["space"].(type) {
+	case map[string]any:
+		output.Space = stringField(space, "key")
+	case string:
+		output.Space = space
+	}
+	switch version := item["version"].(type) {
+	case float64:
+		output.Version = int(version)
+	case map[string]any:
+		number, _ := version["number"].(float64)
+		output.Version = int(number)
+		output.LastModified = cmp.Or(output.LastModified, stringField(version, "when"))
+	}
+	if output.URL == "" {
+		links, _ := item["_links"].(map[string]any)
+		output.URL = cmp.Or(c.webURL(stringField(links, "webui")), c.contentWebURL(item))
+	}
+	if output.Excerpt == "" {
+		body, _ := item["body"].(map[string]any)
+		storage, _ := body["storage"].(map[string]any)
+		if value := stringField(storage, "value"); value != "" {
+			output.Excerpt = truncateText(storageToText(value), excerptLength)
+		}
+	}
+	return output
+}
+
+// absoluteURL returns link as an absolute URL, resolving a relative web UI link against the site.
+func (c *ConfluenceClient) absoluteURL(link string) string {
+	if strings.HasPrefix(link, "/") {
+		return c.webURL(link)
+	}
+	return link
+}
+
+// stringField returns the string value of a key of a decoded JSON object, or "" if it has none.
+func stringField(object map[string]any, key string) string {
+	value, _ := object[key].(string)
+	return value
+}
+
+// executeRequest performs an authenticated HTTP request and returns the response.
+// The caller is responsible for closing the response body.
+func (c *ConfluenceClient) executeRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	return c.executeRequestAt(ctx, c.config.BaseURL, method, path, query, body)
+}
+
+// executeRequestAt performs an authenticated HTTP request against a path below baseURL.
+// It is used for the few endpoints that live outside of the REST API base path.
+func (c *ConfluenceClient) executeRequestAt(ctx context.Context, baseURL, method, path string, query url.Values, body any) (*http.Response, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	u = u.JoinPath(path)
+
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		jsonBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+
+	var referenceKey string
+	if c.references != nil {
+		if method == http.MethodGet && isReferenceLookup(path, query) {
+			referenceKey = c.referenceKey(ctx, u)
+			if entry, ok := c.references.get(referenceKey); ok {
+				slog.DebugContext(ctx, "confluence request served from cache", "method", method, "url", redactURL(u), "requestId", requestID)
+				return &http.Response{
+					Status:        "200 OK",
+					StatusCode:    http.StatusOK,
+					Header:        entry.header.Clone(),
+					Body:          io.NopCloser(bytes.NewReader(entry.body)),
+					ContentLength: int64(len(entry.body)),
+					Request:       req,
+				}, nil
+			}
+		} else if method != http.MethodGet && invalidatesReferences(path) {
+			defer c.references.clear()
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		slog.DebugContext(ctx, "confluence request failed", "method", method, "url", redactURL(u), "requestId", requestID,
+			"duration", time.Since(start), "error", err)
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	slog.DebugContext(ctx, "confluence request", "method", method, "url", redactURL(u), "status", resp.StatusCode,
+		"requestId", requestID, "duration", time.Since(start))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		slog.WarnContext(ctx, "rate limited by Confluence", "method", method, "url", redactURL(u),
+			"retryAfter", resp.Header.Get("Retry-After"), "requestId", requestID)
+	}
+	if err := decompressResponse(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+
+	if referenceKey != "" && resp.StatusCode == http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		c.references.put(referenceKey, resp.Header.Clone(), body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return resp, nil
+}
+
+// APIError is returned when Confluence responds with an error status code. Message, Reason, and
+// ValidationErrors come from Confluence's JSON error envelope, when the body is one.
+type APIError struct {
+	StatusCode       int
+	Body             string
+	Message          string
+	Reason           string
+	ValidationErrors []string
+}
+
+// newAPIError returns the error for a response with status code and body, parsing the error
+// envelope of the REST API:
+//
+//	{"statusCode": 409, "message": "...", "reason": "Conflict", "data": {"errors": [{"message": {"translation": "..."}}]}}
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode, Body: string(body)}
+	var envelope struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+		Data    struct {
+			Errors []struct {
+				FieldName string          `json:"fieldName"`
+				Message   json.RawMessage `json:"message"`
+			} `json:"errors"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return apiErr
+	}
+	apiErr.Message, apiErr.Reason = envelope.Message, envelope.Reason
+	for _, item := range envelope.Data.Errors {
+		// The message is either a string or a translatable message object.
+		var text string
+		if err := json.Unmarshal(item.Message, &text); err != nil {
+			var translatable struct {
+				Key         string `json:"key"`
+				Translation string `json:"translation"`
+			}
+			_ = json.Unmarshal(item.Message, &translatable)
+			text = cmp.Or(translatable.Translation, translatable.Key)
+		}
+		if text == "" {
+			continue
+		}
+		if item.FieldName != "" {
+			text = item.FieldName + ": " + text
+		}
+		apiErr.ValidationErrors = append(apiErr.ValidationErrors, text)
+	}
+	return apiErr
+}
+
+// Code returns a machine-readable classification of the error, such as not_found or version_conflict.
+func (e *APIError) Code() string {
+	switch {
+	case e.StatusCode == http.StatusConflict && strings.Contains(strings.ToLower(e.Message+e.Body), "version"):
+		return "version_conflict"
+	case e.StatusCode == http.StatusBadRequest && len(e.ValidationErrors) > 0:
+		return "validation_failed"
+	case e.StatusCode == http.StatusBadRequest:
+		return "bad_request"
+	case e.StatusCode == http.StatusUnauthorized:
+		return "unauthorized"
+	case e.StatusCode == http.StatusForbidden:
+		return "forbidden"
+	case e.StatusCode == http.StatusNotFound:
+		return "not_found"
+	case e.StatusCode == http.StatusConflict:
+		return "conflict"
+	case e.StatusCode == http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case e.StatusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case e.StatusCode >= 500:
+		return "server_error"
+	}
+	return "client_error"
+}
+
+func (e *APIError) Error() string {
+	message := cmp.Or(e.Message, e.Reason)
+	if message == "" {
+		message = strings.TrimSpace(e.Body)
+		if len(message) > maxErrorMessageBytes {
+			message = message[:maxErrorMessageBytes] + "..."
+		}
+	}
+	for _, validation := range e.ValidationErrors {
+		if validation != message {
+			message += "; " + validation
+		}
+	}
+	return fmt.Sprintf("API error (status %d): %s (code: %s)", e.StatusCode, message, e.Code())
+}
+
+// isStatus reports whether err is an APIError with one of the given status codes.
+func isStatus(err error, codes ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return slices.Contains(codes, apiErr.StatusCode)
+}
+
+// apiErrorMessage returns the message of a Confluence error response, or the raw body when there is none.
+func apiErrorMessage(apiErr *APIError) string {
+	if apiErr.Message != "" {
+		return apiErr.Message
+	}
+	return apiErr.Body
+}
+
+// doRequest performs an authenticated HTTP request and returns the body as bytes.
+// It handles basic error checking and limits the response size.
+func (c *ConfluenceClient) doRequest(ctx context.Context, method, path string, query url.Values, body any) ([]byte, error) {
+	return c.doRequestAt(ctx, c.config.BaseURL, method, path, query, body)
+}
+
+// doRequestAt performs an authenticated HTTP request against a path below baseURL and returns the body as bytes.
+func (c *ConfluenceClient) doRequestAt(ctx context.Context, baseURL, method, path string, query url.Values, body any) ([]byte, error) {
+	resp, err := c.executeRequestAt(ctx, baseURL, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, newAPIError(resp.StatusCode, respBytes)
+	}
+
+	return respBytes, nil
+}
+
+// getJSON is a helper to perform a GET request and unmarshal the result into a target object efficiently.
+func (c *ConfluenceClient) getJSON(ctx context.Context, path string, query url.Values, target any) error {
+	return c.getJSONAt(ctx, c.config.BaseURL, path, query, target)
+}
+
+// getJSONAt performs a GET request against a path below baseURL and unmarshals the result into target.
+func (c *ConfluenceClient) getJSONAt(ctx context.Context, baseURL, path string, query url.Values, target any) error {
+	resp, err := c.executeRequestAt(ctx, baseURL, "GET", path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 400 {
+		respBytes, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return newAPIError(resp.StatusCode, respBytes)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return nil
+}
+
+// SpaceRef represents a reference to a Confluence space in API responses/requests.
+type SpaceRef struct {
+	Key string `json:"key" `
+}
+
+// BodyStorage represents the storage format of the body content.
+type BodyStorage struct {
+	Value          string `json:"value"`
+	Representation string `json:"representation"`
+}
+
+// Body represents the body of a Confluence page, typically containing storage format.
+type Body struct {
+	Storage *BodyStorage `json:"storage,omitempty"`
+}
+
+// Version represents the version information of a Confluence page.
+type Version struct {
+	Number  int    `json:"number"`
+	Message string `json:"message,omitempty"`
+}
+
+// Ancestor represents an ancestor page of a Confluence page.
+type Ancestor struct {
+	ID string `json:"id"`
 }
 
 // ConfluencePage represents a Confluence page or blogpost structure.
@@ -205,332 +2019,10110 @@ type ConfluencePage struct {
 	Body      *Body      `json:"body,omitempty"`
 	Version   *Version   `json:"version,omitempty"`
 	Ancestors []Ancestor `json:"ancestors,omitempty"`
+	Metadata  *Metadata  `json:"metadata,omitempty"`
+}
+
+// Metadata holds expanded content metadata such as labels.
+type Metadata struct {
+	Labels *struct {
+		Results []Label `json:"results"`
+	} `json:"labels,omitempty"`
+}
+
+// Container represents the content that a comment belongs to.
+type Container struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// Comment represents a Confluence comment. Replies reference their parent comment through Ancestors.
+type Comment struct {
+	ID         string             `json:"id,omitempty"`
+	Type       string             `json:"type"`
+	Container  *Container         `json:"container"`
+	Body       *Body              `json:"body"`
+	Ancestors  []Ancestor         `json:"ancestors,omitempty"`
+	Extensions *CommentExtensions `json:"extensions,omitempty"`
+}
+
+// CommentExtensions holds the location of a comment and, for inline comments, the anchored text.
+type CommentExtensions struct {
+	Location         string            `json:"location"`
+	InlineProperties *InlineProperties `json:"inlineProperties,omitempty"`
+}
+
+// InlineProperties links an inline comment to the marker wrapping its selection in the page body.
+type InlineProperties struct {
+	OriginalSelection string `json:"originalSelection"`
+	MarkerRef         string `json:"markerRef"`
+}
+
+// Label represents a Confluence content label.
+type Label struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+// SearchResponse represents a page of results from the Confluence search endpoint.
+type SearchResponse struct {
+	Results   []json.RawMessage `json:"results"`
+	Start     int               `json:"start"`
+	Limit     int               `json:"limit"`
+	Size      int               `json:"size"`
+	TotalSize int               `json:"totalSize,omitempty"`
+	Links     struct {
+		Next string `json:"next"`
+	} `json:"_links"`
+}
+
+// SearchAllResult is the aggregated result of an auto-paginated search.
+type SearchAllResult struct {
+	CQL       string            `json:"cql"`
+	Results   []json.RawMessage `json:"results"`
+	Size      int               `json:"size"`
+	TotalSize int               `json:"totalSize,omitempty"`
+	Truncated bool              `json:"truncated"`
+}
+
+// Links holds the relative links returned with Confluence entities.
+type Links struct {
+	WebUI  string `json:"webui,omitempty"`
+	TinyUI string `json:"tinyui,omitempty"`
+}
+
+// ContentSummary is the minimal view of a content item used when walking page hierarchies.
+type ContentSummary struct {
+	ID    string `json:"id"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title"`
+	Links Links  `json:"_links"`
+}
+
+// PageNode is a page in a descendant tree.
+type PageNode struct {
+	ID       string      `json:"id"`
+	Title    string      `json:"title"`
+	URL      string      `json:"url,omitempty"`
+	Children []*PageNode `json:"children,omitempty"`
+}
+
+// PageTree is the result of a descendant tree walk.
+type PageTree struct {
+	Root      *PageNode `json:"root"`
+	Depth     int       `json:"depth"`
+	Count     int       `json:"count"`
+	Truncated bool      `json:"truncated"`
+}
+
+// Space represents a Confluence space in API responses.
+type Space struct {
+	ID       int             `json:"id,omitempty"`
+	Key      string          `json:"key"`
+	Name     string          `json:"name,omitempty"`
+	Homepage *ContentSummary `json:"homepage,omitempty"`
+}
+
+// CopyDestination identifies where a single page copy is placed.
+type CopyDestination struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// CopyPageRequest is the payload of the single page copy endpoint.
+type CopyPageRequest struct {
+	CopyAttachments bool            `json:"copyAttachments"`
+	CopyLabels      bool            `json:"copyLabels"`
+	CopyProperties  bool            `json:"copyProperties"`
+	Destination     CopyDestination `json:"destination"`
+	PageTitle       string          `json:"pageTitle,omitempty"`
+}
+
+// CopyTitleOptions controls how titles of copied pages are derived.
+type CopyTitleOptions struct {
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// CopyPageHierarchyRequest is the payload of the page hierarchy copy endpoint.
+type CopyPageHierarchyRequest struct {
+	CopyAttachments   bool              `json:"copyAttachments"`
+	CopyLabels        bool              `json:"copyLabels"`
+	CopyProperties    bool              `json:"copyProperties"`
+	OriginalPageID    string            `json:"originalPageId"`
+	DestinationPageID string            `json:"destinationPageId"`
+	TitleOptions      *CopyTitleOptions `json:"titleOptions,omitempty"`
+}
+
+// CopyOptions holds the settings shared by the native and client-side page copy.
+type CopyOptions struct {
+	Title           string
+	TitlePrefix     string
+	IncludeChildren bool
+	CopyLabels      bool
+	CopyAttachments bool
+}
+
+// CopiedPage records a page created by the client-side copy.
+type CopiedPage struct {
+	SourceID string `json:"sourceId"`
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+}
+
+// Attachment is the subset of attachment metadata needed to copy an attachment.
+type Attachment struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Links struct {
+		Download string `json:"download"`
+	} `json:"_links"`
+}
+
+// VersionRestoreParams identifies the version to restore and the message recorded for the restore.
+type VersionRestoreParams struct {
+	VersionNumber int    `json:"versionNumber"`
+	Message       string `json:"message"`
+}
+
+// VersionRestoreRequest is the payload of the version restore operation.
+type VersionRestoreRequest struct {
+	OperationKey string               `json:"operationKey"`
+	Params       VersionRestoreParams `json:"params"`
+}
+
+// ContentRef identifies content referenced by a Confluence URL, either by ID or by space key and title.
+type ContentRef struct {
+	ID         string
+	SpaceKey   string
+	Title      string
+	Type       string
+	PostingDay string
+}
+
+// OperationRestrictions is the API representation of the restrictions for a single operation.
+type OperationRestrictions struct {
+	Restrictions struct {
+		User struct {
+			Results []struct {
+				Username string `json:"username"`
+			} `json:"results"`
+		} `json:"user"`
+		Group struct {
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+		} `json:"group"`
+	} `json:"restrictions"`
+}
+
+// RestrictionSet lists the users and groups restricted for one operation.
+type RestrictionSet struct {
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+}
+
+// RestrictionChange describes a single restriction added or removed by confluence_set_restrictions.
+type RestrictionChange struct {
+	Action    string `json:"action"`
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+}
+
+// Watcher is a user watching a page or space, as reported by the watcher listing.
+type Watcher struct {
+	Name     string `json:"name"`
+	FullName string `json:"fullName,omitempty"`
+	UserKey  string `json:"userKey,omitempty"`
+}
+
+// WatcherList holds the users watching a page directly and through its space.
+type WatcherList struct {
+	PageWatchers  []Watcher `json:"pageWatchers"`
+	SpaceWatchers []Watcher `json:"spaceWatchers"`
+}
+
+// Property is a JSON value stored under a key on content or a space.
+type Property struct {
+	Key     string   `json:"key"`
+	Value   any      `json:"value"`
+	Version *Version `json:"version,omitempty"`
+}
+
+// SpaceDescription holds the plain text description of a space.
+type SpaceDescription struct {
+	Plain *BodyStorage `json:"plain"`
+}
+
+// SpacePayload is the request body used to create or update a space.
+type SpacePayload struct {
+	Key         string            `json:"key,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Description *SpaceDescription `json:"description,omitempty"`
+	Homepage    *Ancestor         `json:"homepage,omitempty"`
+}
+
+// LongTask is a reference to a long-running task returned by asynchronous operations.
+type LongTask struct {
+	ID    string `json:"id"`
+	Links struct {
+		Status string `json:"status,omitempty"`
+	} `json:"links"`
+}
+
+// LongTaskStatus is the progress report of a long-running task.
+type LongTaskStatus struct {
+	ID                 string          `json:"id"`
+	Name               json.RawMessage `json:"name,omitempty"`
+	ElapsedTime        int64           `json:"elapsedTime"`
+	PercentageComplete int             `json:"percentageComplete"`
+	Successful         bool            `json:"successful"`
+	Finished           bool            `json:"finished"`
+	Messages           json.RawMessage `json:"messages,omitempty"`
+}
+
+// SpacePermission lists the users and groups holding one permission in a space.
+type SpacePermission struct {
+	Permission string   `json:"permission"`
+	Users      []string `json:"users"`
+	Groups     []string `json:"groups"`
+	Anonymous  bool     `json:"anonymous,omitempty"`
+}
+
+// SpacePermissionReport is the normalized result of confluence_get_space_permissions.
+type SpacePermissionReport struct {
+	SpaceKey    string            `json:"spaceKey"`
+	Source      string            `json:"source"`
+	Permissions []SpacePermission `json:"permissions"`
+}
+
+// restSpacePermission is a permission entry from the REST space "permissions" expansion.
+type restSpacePermission struct {
+	Operation struct {
+		Operation  string `json:"operation"`
+		TargetType string `json:"targetType"`
+	} `json:"operation"`
+	AnonymousAccess bool `json:"anonymousAccess"`
+	Subjects        struct {
+		User struct {
+			Results []struct {
+				Username string `json:"username"`
+			} `json:"results"`
+		} `json:"user"`
+		Group struct {
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+		} `json:"group"`
+	} `json:"subjects"`
+}
+
+// rpcSpacePermissionSet is a permission set returned by the JSON-RPC getSpacePermissionSets method.
+type rpcSpacePermissionSet struct {
+	Type             string `json:"type"`
+	SpacePermissions []struct {
+		GroupName string `json:"groupName"`
+		UserName  string `json:"userName"`
+	} `json:"spacePermissions"`
+}
+
+// BlogPostEntry is a compact blog post summary returned by confluence_list_blogposts.
+type BlogPostEntry struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author,omitempty"`
+	Date    string `json:"date,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+}
+
+// ContentTemplate represents a page template or blueprint template.
+type ContentTemplate struct {
+	TemplateID   string    `json:"templateId,omitempty"`
+	Name         string    `json:"name"`
+	TemplateType string    `json:"templateType,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	Space        *SpaceRef `json:"space,omitempty"`
+	Body         *Body     `json:"body,omitempty"`
+	Labels       []Label   `json:"labels,omitempty"`
+}
+
+// RecentEntry is a compact summary of recently modified content returned by confluence_recently_updated.
+type RecentEntry struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Space      string `json:"space,omitempty"`
+	ModifiedBy string `json:"modifiedBy,omitempty"`
+	Modified   string `json:"modified,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// InlineTask is an inline task (action item) found in a page body.
+type InlineTask struct {
+	ContentID    string `json:"contentId"`
+	ContentTitle string `json:"contentTitle,omitempty"`
+	TaskID       string `json:"taskId,omitempty"`
+	Status       string `json:"status"`
+	Text         string `json:"text"`
+	AssigneeKey  string `json:"assigneeKey,omitempty"`
+	Assignee     string `json:"assignee,omitempty"`
+	DueDate      string `json:"dueDate,omitempty"`
+}
+
+// ServerInfo describes the Confluence instance the server is connected to.
+type ServerInfo struct {
+	Version      string          `json:"version"`
+	BuildNumber  string          `json:"buildNumber"`
+	BaseURL      string          `json:"baseUrl"`
+	Cluster      json.RawMessage `json:"cluster,omitempty"`
+	ClusterError string          `json:"clusterError,omitempty"`
+}
+
+// HealthReport is the result of probing an instance: who the token authenticates as, the version of
+// the instance, and which optional REST APIs it provides.
+type HealthReport struct {
+	BaseURL      string          `json:"baseUrl"`
+	Username     string          `json:"username"`
+	DisplayName  string          `json:"displayName"`
+	Version      string          `json:"version,omitempty"`
+	BuildNumber  string          `json:"buildNumber,omitempty"`
+	VersionError string          `json:"versionError,omitempty"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
+// FetchResult is the outcome of fetching one piece of content in a bulk fetch.
+type FetchResult struct {
+	ID      string          `json:"id"`
+	Content json.RawMessage `json:"content,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// BulkLabelReport is the result of adding and removing labels on the content a CQL query matches.
+type BulkLabelReport struct {
+	CQL     string `json:"cql"`
+	DryRun  bool   `json:"dryRun,omitempty"`
+	Matched int    `json:"matched"`
+	Changed int    `json:"changed"`
+	Failed  int    `json:"failed"`
+	// Truncated is set when the query matched more content than maxResults, which was left alone.
+	Truncated bool              `json:"truncated,omitempty"`
+	Results   []BulkLabelResult `json:"results"`
+}
+
+// BulkLabelResult is the change of the labels of one piece of content in a bulk label change. Content
+// whose labels are already as asked is left out of the report.
+type BulkLabelResult struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// BulkMoveReport is the result of moving pages, in order, under a new parent.
+type BulkMoveReport struct {
+	TargetID string `json:"targetId"`
+	DryRun   bool   `json:"dryRun,omitempty"`
+	Moved    int    `json:"moved"`
+	// Results has the pages moved, or to be moved on a dry run, followed by the one whose move failed.
+	Results []BulkMoveResult `json:"results"`
+	// NotMoved lists the pages after a failed move, which were left where they were.
+	NotMoved []string `json:"notMoved,omitempty"`
+	// Skipped lists content a CQL query matched that is not a page and so cannot have a parent.
+	Skipped []string `json:"skipped,omitempty"`
+	// Truncated is set when the query matched more content than maxResults, which was left alone.
+	Truncated bool `json:"truncated,omitempty"`
+	// Rollback is set when a move failed: the confluence_move_content arguments that put the pages
+	// already moved back where they were, to be called in order.
+	Rollback []MoveArgs `json:"rollback,omitempty"`
+}
+
+// BulkMoveResult is the move of one page in a bulk move, with where the page was before.
+type BulkMoveResult struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	FromParentID string `json:"fromParentId,omitempty"`
+	FromSpaceKey string `json:"fromSpaceKey,omitempty"`
+	// FromPreviousSiblingID and FromNextSiblingID are the pages right before and after it under its
+	// former parent, if any.
+	FromPreviousSiblingID string `json:"fromPreviousSiblingId,omitempty"`
+	FromNextSiblingID     string `json:"fromNextSiblingId,omitempty"`
+	Error                 string `json:"error,omitempty"`
+}
+
+// MoveArgs are the arguments of a confluence_move_content call.
+type MoveArgs struct {
+	ContentID      string `json:"contentId"`
+	TargetID       string `json:"targetId,omitempty"`
+	TargetSpaceKey string `json:"targetSpaceKey,omitempty"`
+	Position       string `json:"position"`
+}
+
+// BatchReport is the result of a batch of operations.
+type BatchReport struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Skipped counts the operations after a failure that were not run because the batch stops at the
+	// first failure unless continueOnError is set.
+	Skipped int           `json:"skipped"`
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one operation of a batch.
+type BatchResult struct {
+	Operation int    `json:"operation"`
+	Op        string `json:"op"`
+	// Status is succeeded, failed, or skipped.
+	Status string `json:"status"`
+	// ID is the ID of the content the operation created or changed.
+	ID string `json:"id,omitempty"`
+	// Result is the result of an operation whose result is not the content, such as its labels.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// TreePage is a page of a tree of pages to create, with the pages to create under it.
+type TreePage struct {
+	Title    string     `json:"title"`
+	Content  string     `json:"content,omitempty"`
+	Children []TreePage `json:"children,omitempty"`
+}
+
+// CreateTreeReport is the result of creating a tree of pages.
+type CreateTreeReport struct {
+	// Created lists the pages created, parents before their children.
+	Created []CreatedTreePage `json:"created"`
+	// Failed is the page whose creation failed, which stopped the others.
+	Failed *CreatedTreePage `json:"failed,omitempty"`
+	// Deleted lists the pages created before a failure that were deleted again.
+	Deleted []string `json:"deleted,omitempty"`
+	// Leftover lists the pages created before a failure that are still there, because they were to
+	// be reported rather than deleted or because deleting them failed.
+	Leftover []CreatedTreePage `json:"leftover,omitempty"`
+}
+
+// CreatedTreePage is a page of a tree of pages created, or the one whose creation failed.
+type CreatedTreePage struct {
+	ID       string `json:"id,omitempty"`
+	Title    string `json:"title"`
+	ParentID string `json:"parentId,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ContentOutput is the structured output of a content item: the fields every content and search
+// tool returns in the same place whatever the shape of its text result.
+type ContentOutput struct {
+	ID           string `json:"id"`
+	Type         string `json:"type,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Title        string `json:"title"`
+	Space        string `json:"space,omitempty" jsonschema:"description=Key of the space or its name when the key is not known"`
+	Version      int    `json:"version,omitempty"`
+	URL          string `json:"url,omitempty" jsonschema:"description=Absolute web URL of the content"`
+	Excerpt      string `json:"excerpt,omitempty" jsonschema:"description=Plain text excerpt of the body or the search match"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// ContentListOutput is the structured output of a list or search of content.
+type ContentListOutput struct {
+	Results    []ContentOutput `json:"results"`
+	Start      int             `json:"start,omitempty"`
+	Size       int             `json:"size"`
+	TotalSize  int             `json:"totalSize,omitempty"`
+	HasMore    bool            `json:"hasMore" jsonschema:"description=Whether there are more results than returned"`
+	NextCursor string          `json:"nextCursor,omitempty" jsonschema:"description=Cursor to pass to the same tool for the next results"`
+}
+
+// BulkFetchOutput is the structured output of a bulk fetch.
+type BulkFetchOutput struct {
+	Results []BulkFetchItem `json:"results"`
+}
+
+// BulkFetchItem is the structured output of one piece of content in a bulk fetch.
+type BulkFetchItem struct {
+	ID      string         `json:"id"`
+	Content *ContentOutput `json:"content,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// ResultSummary is a search or list result in the summary result format.
+type ResultSummary struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	Type         string `json:"type"`
+	Space        string `json:"space,omitempty"`
+	URL          string `json:"url,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Excerpt      string `json:"excerpt,omitempty"`
+}
+
+// SearchHit is a compact search result with its highlighted excerpt and relevance information.
+type SearchHit struct {
+	Rank         int      `json:"rank"`
+	Score        *float64 `json:"score,omitempty"`
+	ID           string   `json:"id,omitempty"`
+	Type         string   `json:"type"`
+	Title        string   `json:"title"`
+	Space        string   `json:"space,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Excerpt      string   `json:"excerpt,omitempty"`
+	LastModified string   `json:"lastModified,omitempty"`
+}
+
+// SearchFacet holds the results of one content type in a site search.
+type SearchFacet struct {
+	Type      string      `json:"type"`
+	TotalSize int         `json:"totalSize"`
+	Results   []SearchHit `json:"results"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// MacroDefinition describes a macro in the catalog of confluence_list_macros.
+type MacroDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Body is "none", "plain-text", or "rich-text" (storage format).
+	Body       string           `json:"body"`
+	Parameters []MacroParameter `json:"parameters"`
+}
+
+// MacroParameter describes a parameter of a catalog macro.
+type MacroParameter struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Required    bool     `json:"required,omitempty"`
+	Values      []string `json:"values,omitempty"`
+}
+
+// ExtractedTable is a table of a page body as rows of cell text.
+type ExtractedTable struct {
+	Index       int          `json:"index"`
+	Headers     []string     `json:"headers,omitempty"`
+	Rows        [][]string   `json:"rows"`
+	MergedCells []MergedCell `json:"mergedCells,omitempty"`
+}
+
+// MergedCell records a cell spanning several rows or columns. Row counts from the first row of the
+// table, which is the header row when the table has headers.
+type MergedCell struct {
+	Row     int `json:"row"`
+	Column  int `json:"column"`
+	RowSpan int `json:"rowSpan"`
+	ColSpan int `json:"colSpan"`
+}
+
+// ContentChunk is a section of a page body starting at a heading.
+type ContentChunk struct {
+	Anchor  string   `json:"anchor"`
+	Heading string   `json:"heading,omitempty"`
+	Level   int      `json:"level,omitempty"`
+	Path    []string `json:"path,omitempty"`
+	Tokens  int      `json:"tokens"`
+	Content string   `json:"content"`
+}
+
+// getArguments helper extracts the "arguments" dictionary from an MCP tool request.
+func getArguments(req mcp.CallToolRequest) (map[string]any, error) {
+	if req.Params.Arguments == nil {
+		return make(map[string]any), nil
+	}
+	args, ok := req.Params.Arguments.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("arguments are not a JSON object")
+	}
+	return args, nil
+}
+
+// getContentID extracts the required "contentId" argument and rejects values that could escape the content path.
+func getContentID(args map[string]any) (string, error) {
+	contentID, ok := args["contentId"].(string)
+	if !ok || contentID == "" {
+		return "", fmt.Errorf("contentId must be a string and is required")
+	}
+	if !isSafePathSegment(contentID) {
+		return "", fmt.Errorf("invalid contentId format")
+	}
+	return contentID, nil
+}
+
+// getSpaceKey extracts the required "spaceKey" argument and rejects values that could escape the space path.
+func getSpaceKey(args map[string]any) (string, error) {
+	spaceKey, ok := args["spaceKey"].(string)
+	if !ok || spaceKey == "" {
+		return "", fmt.Errorf("spaceKey is required")
+	}
+	if !isSafePathSegment(spaceKey) {
+		return "", fmt.Errorf("invalid spaceKey format")
+	}
+	return spaceKey, nil
+}
+
+// isSafePathSegment reports whether a value can be used as a single URL path segment without escaping its parent path.
+func isSafePathSegment(value string) bool {
+	return !strings.Contains(value, "/") && !strings.Contains(value, "..")
+}
+
+// getStringList extracts a list of non-empty strings from an argument given either as a JSON array or a comma-separated string.
+func getStringList(args map[string]any, key string) []string {
+	var raw []string
+	switch v := args[key].(type) {
+	case []any:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				raw = append(raw, str)
+			}
+		}
+	case []string:
+		raw = v
+	case string:
+		raw = strings.Split(v, ",")
+	}
+
+	var values []string
+	for _, str := range raw {
+		if str = strings.TrimSpace(str); str != "" {
+			values = append(values, str)
+		}
+	}
+	return values
+}
+
+// quoteCQL quotes a value for safe use as a CQL string literal.
+func quoteCQL(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// dateRangeCQL builds CQL clauses restricting a date field to the "from" and "to" arguments (YYYY-MM-DD).
+// Each clause is prefixed with " AND " so the result can be appended to an existing query.
+func dateRangeCQL(args map[string]any, field string) (string, error) {
+	var clauses string
+	for _, bound := range []struct{ arg, op string }{{"from", ">="}, {"to", "<="}} {
+		value, ok := args[bound.arg].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", fmt.Errorf("%s must be a date in YYYY-MM-DD format", bound.arg)
+		}
+		clauses += " AND " + field + " " + bound.op + " " + quoteCQL(value)
+	}
+	return clauses, nil
+}
+
+// cqlInList builds a CQL clause matching field against any of the values, using "=" for a single value.
+func cqlInList(field string, values []string) string {
+	if len(values) == 1 {
+		return field + " = " + quoteCQL(values[0])
+	}
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, quoteCQL(value))
+	}
+	return field + " in (" + strings.Join(quoted, ", ") + ")"
+}
+
+// getMaxResults reads the "maxResults" argument, applying the default and the hard cap.
+func getMaxResults(args map[string]any) int {
+	maxResults := defaultMaxResults
+	if v, ok := args["maxResults"].(float64); ok && v > 0 {
+		maxResults = int(v)
+	}
+	if maxResults > maxFetchResults {
+		maxResults = maxFetchResults
+	}
+	return maxResults
+}
+
+// searchAll walks the search endpoint page by page until the results are exhausted or maxResults is reached.
+func (c *ConfluenceClient) searchAll(ctx context.Context, cql, expand string, maxResults int) (*SearchAllResult, error) {
+	return c.searchAllWithParams(ctx, cql, expand, maxResults, nil)
+}
+
+// searchAllWithParams is searchAll with additional query parameters (such as excerpt) sent with every page request.
+func (c *ConfluenceClient) searchAllWithParams(ctx context.Context, cql, expand string, maxResults int, params url.Values) (*SearchAllResult, error) {
+	result := &SearchAllResult{CQL: cql, Results: []json.RawMessage{}}
+	start, size := 0, 0
+	more := true
+	for more && len(result.Results) < maxResults {
+		limit := min(searchPageSize, maxResults-len(result.Results))
+
+		query := url.Values{}
+		for key, values := range params {
+			query[key] = values
+		}
+		query.Set("cql", cql)
+		query.Set("start", fmt.Sprintf("%d", start))
+		query.Set("limit", fmt.Sprintf("%d", limit))
+		if expand != "" {
+			query.Set("expand", expand)
+		}
+
+		var page SearchResponse
+		if err := c.getJSON(ctx, "/search", query, &page); err != nil {
+			return nil, err
+		}
+
+		result.TotalSize = page.TotalSize
+		for _, item := range page.Results {
+			if size += len(item); size > maxFetchBytes {
+				// Stop before the aggregate grows too large to hand back in a single tool result.
+				result.Size = len(result.Results)
+				result.Truncated = true
+				return result, nil
+			}
+			result.Results = append(result.Results, item)
+		}
+		total := min(maxResults, page.TotalSize)
+		if total < len(result.Results) {
+			total = 0
+		}
+		reportProgress(ctx, float64(len(result.Results)), float64(total), fmt.Sprintf("Fetched %d results", len(result.Results)))
+		// The server may return fewer results than the limit, so a short page does not end the search;
+		// only the next link and the total size tell whether more follow.
+		more = len(page.Results) > 0 && (page.Links.Next != "" || start+len(page.Results) < page.TotalSize)
+		start += len(page.Results)
+	}
+
+	result.Size = len(result.Results)
+	result.Truncated = more
+	return result, nil
+}
+
+// storageToText strips markup from a storage format body and returns whitespace-normalized plain text.
+func storageToText(storage string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range storage {
+		switch {
+		case r == '<':
+			inTag = true
+			b.WriteByte(' ')
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+// truncateText shortens text to at most maxLen characters, marking the cut with an ellipsis.
+func truncateText(text string, maxLen int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxLen])) + "…"
+}
+
+// addExcerpts replaces the storage body of each result in a paginated response with a short plain text excerpt.
+func addExcerpts(resp []byte) ([]byte, error) {
+	var envelope map[string]any
+	if err := json.Unmarshal(resp, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	results, _ := envelope["results"].([]any)
+	for _, item := range results {
+		content, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		body, _ := content["body"].(map[string]any)
+		storage, _ := body["storage"].(map[string]any)
+		value, _ := storage["value"].(string)
+		content["excerpt"] = truncateText(storageToText(value), excerptLength)
+		delete(content, "body")
+	}
+
+	return json.Marshal(envelope)
+}
+
+var (
+	// templateDeclarationsPattern matches the variable declarations block at the start of a template body.
+	templateDeclarationsPattern = regexp.MustCompile(`(?s)<at:declarations>.*?</at:declarations>`)
+
+	// mentionPattern matches an @username reference at the start of the text or after whitespace, "(" or a tag.
+	mentionPattern = regexp.MustCompile(`(^|[\s(>])@([A-Za-z0-9._-]*[A-Za-z0-9_-])`)
+
+	// taskPattern matches an inline task, capturing its inner markup.
+	taskPattern = regexp.MustCompile(`(?s)<ac:task>(.*?)</ac:task>`)
+
+	// taskFieldPatterns capture the ID, status, and body of an inline task.
+	taskIDPattern     = regexp.MustCompile(`(?s)<ac:task-id>(.*?)</ac:task-id>`)
+	taskStatusPattern = regexp.MustCompile(`(?s)<ac:task-status>(.*?)</ac:task-status>`)
+	taskBodyPattern   = regexp.MustCompile(`(?s)<ac:task-body>(.*?)</ac:task-body>`)
+
+	// taskAssigneePattern and taskDueDatePattern capture the first mentioned user and date in a task body.
+	taskAssigneePattern = regexp.MustCompile(`<ri:user\s+ri:userkey="([^"]+)"`)
+	taskDueDatePattern  = regexp.MustCompile(`<time\s+datetime="([^"]+)"`)
+
+	// markdownFencePattern matches the opening line of a fenced code block, capturing the fence and language.
+	markdownFencePattern = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+
+	// markdownHeadingPattern matches an ATX heading, capturing the level markers and the text.
+	markdownHeadingPattern = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+
+	// markdownRulePattern matches a horizontal rule.
+	markdownRulePattern = regexp.MustCompile(`^\s{0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+
+	// markdownTableSeparatorPattern matches the delimiter row under a pipe table header.
+	markdownTableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(?:\|\s*:?-+:?\s*)*\|?\s*$`)
+
+	// markdownQuotePattern matches the marker of a block quote line.
+	markdownQuotePattern = regexp.MustCompile(`^\s{0,3}> ?`)
+
+	// markdownListItemPattern matches a list item, capturing its indentation, marker, and text.
+	markdownListItemPattern = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+
+	// markdownAutolinkPattern matches an autolink such as <https://example.com>.
+	markdownAutolinkPattern = regexp.MustCompile(`^<((?:https?|mailto):[^\s>]+)>`)
+
+	// cdataPattern matches a CDATA section.
+	cdataPattern = regexp.MustCompile(`(?s)<!\[CDATA\[.*?\]\]>`)
+
+	// ampersandPattern matches an ampersand together with the character or entity reference it starts, if any.
+	ampersandPattern = regexp.MustCompile(`&(?:#[0-9]+;|#[xX][0-9a-fA-F]+;|[A-Za-z][A-Za-z0-9]*;)?`)
+
+	// headerNamePattern matches a valid HTTP header name.
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+	// referenceCQLPattern matches a CQL query that searches for spaces or users.
+	referenceCQLPattern = regexp.MustCompile(`^type\s*=\s*(?:space|user)\b`)
+
+	// secretNamePattern matches the names of query parameters and log attributes holding secrets.
+	secretNamePattern = regexp.MustCompile(`(?i)token|password|secret|authorization|jwt`)
+
+	// readToolPattern matches the names of the tools that only read from Confluence.
+	readToolPattern = regexp.MustCompile(`^confluence_(?:get|list|search|find|site_search|recently_updated|extract_tables|convert_body)`)
+
+	// templateVariablePattern matches a template variable placeholder, capturing its name.
+	templateVariablePattern = regexp.MustCompile(`(?s)<at:var\s+at:name="([^"]+)"[^>]*?(?:/>|>.*?</at:var>)`)
+
+	// macroTagPattern matches a start, end, or empty tag of a structured macro, capturing the slash of
+	// an end tag, the attributes, and the slash of an empty tag.
+	macroTagPattern = regexp.MustCompile(`<(/?)ac:structured-macro\b([^>]*?)(/?)>`)
+
+	// macroNamePattern matches the name attribute of a structured macro, capturing the name.
+	macroNamePattern = regexp.MustCompile(`\bac:name="([^"]*)"`)
+
+	// batchReferencePattern matches a batch argument that refers to the content of an earlier
+	// operation, capturing the number of the operation.
+	batchReferencePattern = regexp.MustCompile(`^\$([1-9][0-9]*)$`)
+)
+
+// applyTemplateVariables replaces the variable placeholders of a template body with escaped values.
+// It returns an error naming every variable that has no value.
+func applyTemplateVariables(body string, values map[string]string) (string, error) {
+	body = templateDeclarationsPattern.ReplaceAllString(body, "")
+
+	var missing []string
+	body = templateVariablePattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		name := templateVariablePattern.FindStringSubmatch(placeholder)[1]
+		value, ok := values[name]
+		if !ok {
+			if !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return placeholder
+		}
+		return html.EscapeString(value)
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing values for template variables: %s", strings.Join(missing, ", "))
+	}
+	return body, nil
+}
+
+// extractTasks returns the inline tasks in a storage format body. The first mentioned user of a task
+// is reported as its assignee and the first date as its due date, matching how Confluence treats them.
+func extractTasks(storage string) []InlineTask {
+	var tasks []InlineTask
+	for _, match := range taskPattern.FindAllStringSubmatch(storage, -1) {
+		task := InlineTask{Status: "incomplete"}
+		if m := taskIDPattern.FindStringSubmatch(match[1]); m != nil {
+			task.TaskID = strings.TrimSpace(m[1])
+		}
+		if m := taskStatusPattern.FindStringSubmatch(match[1]); m != nil {
+			task.Status = strings.TrimSpace(m[1])
+		}
+		if m := taskBodyPattern.FindStringSubmatch(match[1]); m != nil {
+			task.Text = storageToText(m[1])
+			if a := taskAssigneePattern.FindStringSubmatch(m[1]); a != nil {
+				task.AssigneeKey = a[1]
+			}
+			if d := taskDueDatePattern.FindStringSubmatch(m[1]); d != nil {
+				task.DueDate = d[1]
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// markdownToStorage converts Markdown to Confluence storage format. It supports headings, paragraphs,
+// emphasis, inline code, links, images, ordered and unordered lists, block quotes, horizontal rules,
+// fenced code blocks (as code macros), and pipe tables. Images with a relative source are treated as
+// attachments of the page.
+func markdownToStorage(markdown string) string {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	return markdownBlocks(lines)
+}
+
+// markdownBlocks converts a sequence of Markdown lines to storage format block elements.
+func markdownBlocks(lines []string) string {
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case markdownFencePattern.MatchString(line):
+			m := markdownFencePattern.FindStringSubmatch(line)
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			b.WriteString(codeMacro(m[2], strings.Join(code, "\n")))
+		case markdownHeadingPattern.MatchString(line):
+			m := markdownHeadingPattern.FindStringSubmatch(line)
+			fmt.Fprintf(&b, "<h%d>%s</h%d>", len(m[1]), markdownInline(m[2]), len(m[1]))
+			i++
+		case markdownRulePattern.MatchString(line):
+			b.WriteString("<hr />")
+			i++
+		case i+1 < len(lines) && strings.Contains(line, "|") && markdownTableSeparatorPattern.MatchString(lines[i+1]):
+			b.WriteString("<table><tbody><tr>")
+			for _, cell := range splitTableRow(line) {
+				b.WriteString("<th>" + markdownInline(cell) + "</th>")
+			}
+			b.WriteString("</tr>")
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+				b.WriteString("<tr>")
+				for _, cell := range splitTableRow(lines[i]) {
+					b.WriteString("<td>" + markdownInline(cell) + "</td>")
+				}
+				b.WriteString("</tr>")
+			}
+			b.WriteString("</tbody></table>")
+		case markdownQuotePattern.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && markdownQuotePattern.MatchString(lines[i]); i++ {
+				quoted = append(quoted, markdownQuotePattern.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>" + markdownBlocks(quoted) + "</blockquote>")
+		case markdownListItemPattern.MatchString(line):
+			var list string
+			list, i = markdownList(lines, i)
+			b.WriteString(list)
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsMarkdownBlock(lines, i); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			if len(para) == 0 {
+				para = append(para, strings.TrimSpace(lines[i]))
+				i++
+			}
+			b.WriteString("<p>" + markdownInline(strings.Join(para, " ")) + "</p>")
+		}
+	}
+	return b.String()
+}
+
+// startsMarkdownBlock reports whether the line at index i starts a block other than a paragraph.
+func startsMarkdownBlock(lines []string, i int) bool {
+	line := lines[i]
+	return markdownFencePattern.MatchString(line) || markdownHeadingPattern.MatchString(line) ||
+		markdownRulePattern.MatchString(line) || markdownQuotePattern.MatchString(line) ||
+		markdownListItemPattern.MatchString(line) ||
+		(i+1 < len(lines) && strings.Contains(line, "|") && markdownTableSeparatorPattern.MatchString(lines[i+1]))
+}
+
+// markdownList converts the list starting at index start, including nested lists and other blocks
+// indented under its items. It returns the storage format list and the index of the first line after it.
+func markdownList(lines []string, start int) (string, int) {
+	first := markdownListItemPattern.FindStringSubmatch(lines[start])
+	indent := indentWidth(first[1])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + tag + ">")
+	i := start
+	for i < len(lines) {
+		if strings.TrimSpace(lines[i]) == "" {
+			next := nextNonBlank(lines, i)
+			if next == len(lines) || !isListContinuation(lines[next], indent, ordered) {
+				break
+			}
+			i = next
+			continue
+		}
+		m := markdownListItemPattern.FindStringSubmatch(lines[i])
+		if m == nil || indentWidth(m[1]) != indent || (m[2][0] >= '0' && m[2][0] <= '9') != ordered {
+			break
+		}
+
+		var children []string
+		for i++; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "" {
+				next := nextNonBlank(lines, i)
+				if next == len(lines) || indentWidth(lines[next]) <= indent {
+					break
+				}
+			} else if indentWidth(lines[i]) <= indent {
+				break
+			}
+			children = append(children, lines[i])
+		}
+
+		b.WriteString("<li>" + markdownInline(m[3]) + markdownBlocks(dedent(children)) + "</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return b.String(), i
+}
+
+// isListContinuation reports whether a line following a blank line continues a list at the given indentation.
+func isListContinuation(line string, indent int, ordered bool) bool {
+	if indentWidth(line) > indent {
+		return true
+	}
+	m := markdownListItemPattern.FindStringSubmatch(line)
+	return m != nil && indentWidth(m[1]) == indent && (m[2][0] >= '0' && m[2][0] <= '9') == ordered
+}
+
+// nextNonBlank returns the index of the first non-blank line at or after i, or len(lines) if there is none.
+func nextNonBlank(lines []string, i int) int {
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	return i
+}
+
+// indentWidth returns the width of the leading whitespace of a line, counting a tab as four spaces.
+func indentWidth(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// dedent removes the indentation shared by all non-blank lines.
+func dedent(lines []string) []string {
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if w := indentWidth(line); common < 0 || w < common {
+			common = w
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = strings.TrimLeft(strings.ReplaceAll(line, "\t", "    "), " ")
+		if w := indentWidth(line); w > common {
+			out[i] = strings.Repeat(" ", w-common) + out[i]
+		}
+	}
+	return out
+}
+
+// splitTableRow splits a pipe table row into trimmed cells, honouring escaped pipes.
+func splitTableRow(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, `\|`) {
+		row = row[:len(row)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case row[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(row[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// codeMacro returns a code block macro for the given language and source.
+func codeMacro(language, code string) string {
+	var b strings.Builder
+	b.WriteString(`<ac:structured-macro ac:name="code">`)
+	if language != "" {
+		b.WriteString(`<ac:parameter ac:name="language">` + html.EscapeString(language) + `</ac:parameter>`)
+	}
+	b.WriteString(`<ac:plain-text-body><![CDATA[` + strings.ReplaceAll(code, "]]>", "]]]]><![CDATA[>") + `]]></ac:plain-text-body>`)
+	b.WriteString(`</ac:structured-macro>`)
+	return b.String()
+}
+
+// markdownInline converts the inline Markdown of a single block: code spans, images, links,
+// autolinks, strong, emphasis, and strikethrough. All other text is escaped.
+func markdownInline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_{}[]()#+-.!|~<>", rune(rest[1])):
+			b.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+		case rest[0] == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(rest[ticks:ticks+end])) + "</code>")
+				i += 2*ticks + end
+				continue
+			}
+		case strings.HasPrefix(rest, "!["):
+			if label, target, n, ok := parseMarkdownLink(rest[1:]); ok {
+				b.WriteString(markdownImage(label, target))
+				i += 1 + n
+				continue
+			}
+		case rest[0] == '[':
+			if label, target, n, ok := parseMarkdownLink(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(target) + `">` + markdownInline(label) + "</a>")
+				i += n
+				continue
+			}
+		case rest[0] == '<':
+			if m := markdownAutolinkPattern.FindStringSubmatch(rest); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+		}
+
+		if tag, delim := markdownEmphasis(text, i); tag != "" {
+			if end := strings.Index(text[i+len(delim):], delim); end > 0 {
+				inner := text[i+len(delim) : i+len(delim)+end]
+				b.WriteString("<" + tag + ">" + markdownInline(inner) + "</" + tag + ">")
+				i += 2*len(delim) + end
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return b.String()
+}
+
+// markdownEmphasis returns the storage format tag and delimiter of an emphasis run starting at index i.
+// Underscores only open emphasis at the start of a word, so identifiers such as snake_case are left alone.
+func markdownEmphasis(text string, i int) (string, string) {
+	rest := text[i:]
+	if rest[0] == '_' && i > 0 && isWordByte(text[i-1]) {
+		return "", ""
+	}
+	switch {
+	case strings.HasPrefix(rest, "**"), strings.HasPrefix(rest, "__"):
+		return "strong", rest[:2]
+	case strings.HasPrefix(rest, "~~"):
+		return "del", "~~"
+	case rest[0] == '*', rest[0] == '_':
+		if len(rest) > 1 && rest[1] != ' ' {
+			return "em", rest[:1]
+		}
+	}
+	return "", ""
+}
+
+// isWordByte reports whether c is an ASCII letter, digit, or underscore.
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseMarkdownLink parses a "[label](target "title")" link at the start of text, returning the label,
+// the target without its title, and the number of bytes consumed.
+func parseMarkdownLink(text string) (string, string, int, bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(text) || text[i+1] != '(' {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(text[i+2:], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			target := strings.TrimSpace(text[i+2 : i+2+end])
+			if fields := strings.Fields(target); len(fields) > 0 {
+				target = strings.Trim(fields[0], "<>")
+			}
+			return text[1:i], target, i + 3 + end, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// markdownImage returns an image element for a Markdown image. Sources with a URL scheme are linked
+// as external images; anything else is treated as the filename of an attachment on the page.
+func markdownImage(alt, src string) string {
+	var b strings.Builder
+	b.WriteString("<ac:image")
+	if alt != "" {
+		b.WriteString(` ac:alt="` + html.EscapeString(alt) + `"`)
+	}
+	b.WriteString(">")
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" {
+		b.WriteString(`<ri:url ri:value="` + html.EscapeString(src) + `" />`)
+	} else {
+		b.WriteString(`<ri:attachment ri:filename="` + html.EscapeString(src) + `" />`)
+	}
+	b.WriteString("</ac:image>")
+	return b.String()
+}
+
+// storageContent reads the "content" argument and converts it to storage format according to the
+// "contentFormat" argument, which is "storage" (the default), "markdown", or "wiki". Markdown is
+// converted locally; wiki markup is converted by the server in the context of contentID, if given.
+// Storage format input is validated first, escaping bare ampersands if "autoEscape" is set.
+func (c *ConfluenceClient) storageContent(ctx context.Context, args map[string]any, contentID string) (string, error) {
+	content, _ := args["content"].(string)
+	format, _ := args["contentFormat"].(string)
+	switch format {
+	case "", "storage":
+		if autoEscape, _ := args["autoEscape"].(bool); autoEscape {
+			content = escapeBareAmpersands(content)
+		}
+		if err := validateStorage(content); err != nil {
+			return "", err
+		}
+		return content, nil
+	case "markdown":
+		return markdownToStorage(content), nil
+	case "wiki":
+		if content == "" {
+			return "", nil
+		}
+		storage, err := c.convertBody(ctx, content, "wiki", "storage", contentID)
+		if err != nil {
+			return "", fmt.Errorf("error converting wiki markup: %w", err)
+		}
+		return storage, nil
+	default:
+		return "", fmt.Errorf("contentFormat must be 'storage', 'markdown', or 'wiki'")
+	}
+}
+
+// storageNamespaces lists the namespace prefixes Confluence accepts in storage format: ac for
+// macros and links, ri for resource identifiers, and at for template variables.
+var storageNamespaces = []string{"ac", "ri", "at"}
+
+// validateStorage checks that a storage format body is well-formed XML that uses only known
+// namespace prefixes, so malformed content is rejected with its position instead of a server error.
+// HTML named entities such as &nbsp; are accepted, as Confluence accepts them.
+func validateStorage(storage string) error {
+	const wrapper = "<root>"
+	decoder := xml.NewDecoder(strings.NewReader(wrapper + storage + "</root>"))
+	decoder.Entity = xml.HTMLEntity
+
+	var open []string
+	position := func() string {
+		line, column := decoder.InputPos()
+		if line == 1 {
+			column -= len(wrapper)
+		}
+		where := fmt.Sprintf("line %d, column %d", line, column)
+		if len(open) > 1 {
+			where += fmt.Sprintf(" in <%s>", open[len(open)-1])
+		}
+		return where
+	}
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				err = errors.New(syntaxErr.Msg)
+				if strings.HasSuffix(syntaxErr.Msg, "closed by </root>") && len(open) > 1 {
+					err = fmt.Errorf("element <%s> is never closed", open[len(open)-1])
+				}
+			}
+			return fmt.Errorf("invalid storage format at %s: %v", position(), err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := qualifiedName(t.Name)
+			if t.Name.Space != "" && !slices.Contains(storageNamespaces, t.Name.Space) {
+				return fmt.Errorf("invalid storage format at %s: unknown namespace prefix %q in element <%s>", position(), t.Name.Space, name)
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space != "" && attr.Name.Space != "xmlns" && !strings.Contains(attr.Name.Space, "/") &&
+					!slices.Contains(storageNamespaces, attr.Name.Space) {
+					return fmt.Errorf("invalid storage format at %s: unknown namespace prefix %q in attribute %s of <%s>",
+						position(), attr.Name.Space, qualifiedName(attr.Name), name)
+				}
+			}
+			open = append(open, name)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		}
+	}
+}
+
+// escapeBareAmpersands escapes ampersands that do not start a character or entity reference,
+// leaving CDATA sections untouched.
+func escapeBareAmpersands(storage string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range cdataPattern.FindAllStringIndex(storage, -1) {
+		b.WriteString(escapeAmpersandsIn(storage[last:loc[0]]))
+		b.WriteString(storage[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(escapeAmpersandsIn(storage[last:]))
+	return b.String()
+}
+
+// escapeAmpersandsIn escapes the bare ampersands of markup that contains no CDATA sections.
+func escapeAmpersandsIn(markup string) string {
+	return ampersandPattern.ReplaceAllStringFunc(markup, func(match string) string {
+		if match == "&" {
+			return "&amp;"
+		}
+		return match
+	})
+}
+
+// macroCatalog describes the macros the server can generate markup for.
+var macroCatalog = []MacroDefinition{
+	{
+		Name:        "code",
+		Description: "A code block with syntax highlighting",
+		Body:        "plain-text",
+		Parameters: []MacroParameter{
+			{Name: "language", Description: "The language used for highlighting, such as java, go, or sql"},
+			{Name: "title", Description: "A title shown above the code"},
+			{Name: "linenumbers", Description: "Whether to show line numbers", Values: []string{"true", "false"}},
+			{Name: "collapse", Description: "Whether the block starts collapsed", Values: []string{"true", "false"}},
+			{Name: "theme", Description: "The colour theme, such as Default, Midnight, or RDark"},
+		},
+	},
+	{
+		Name:        "toc",
+		Description: "A table of contents built from the headings of the page",
+		Body:        "none",
+		Parameters: []MacroParameter{
+			{Name: "minLevel", Description: "The highest heading level included (default: 1)"},
+			{Name: "maxLevel", Description: "The lowest heading level included (default: 7)"},
+			{Name: "type", Description: "The layout of the table of contents", Values: []string{"list", "flat"}},
+			{Name: "style", Description: "The list style, such as disc, circle, or none"},
+			{Name: "outline", Description: "Whether to number the headings", Values: []string{"true", "false"}},
+		},
+	},
+	{
+		Name:        "jira",
+		Description: "A single Jira issue, or a table of the issues matched by a JQL query",
+		Body:        "none",
+		Parameters: []MacroParameter{
+			{Name: "key", Description: "The key of a single issue; either key or jqlQuery is required"},
+			{Name: "jqlQuery", Description: "A JQL query selecting the issues to list; either key or jqlQuery is required"},
+			{Name: "server", Description: "The name of the Jira application link (default: the primary link)"},
+			{Name: "serverId", Description: "The ID of the Jira application link"},
+			{Name: "columns", Description: "Comma-separated issue fields shown in the table"},
+			{Name: "maximumIssues", Description: "The maximum number of issues listed (default: 20)"},
+		},
+	},
+	{
+		Name:        "expand",
+		Description: "A section that is collapsed until the reader expands it",
+		Body:        "rich-text",
+		Parameters: []MacroParameter{
+			{Name: "title", Description: "The text of the expand link (default: Click here to expand...)"},
+		},
+	},
+	{
+		Name:        "include",
+		Description: "The content of another page, included in place",
+		Body:        "none",
+		Parameters: []MacroParameter{
+			{Name: "title", Description: "The title of the page to include", Required: true},
+			{Name: "spaceKey", Description: "The key of the space of the page (default: the current space)"},
+		},
+	},
+	{
+		Name:        "status",
+		Description: "A coloured status lozenge",
+		Body:        "none",
+		Parameters: []MacroParameter{
+			{Name: "title", Description: "The text of the lozenge", Required: true},
+			{Name: "colour", Description: "The colour of the lozenge (default: Grey)", Values: []string{"Grey", "Red", "Yellow", "Green", "Blue"}},
+			{Name: "subtle", Description: "Whether to use the outlined style", Values: []string{"true", "false"}},
+		},
+	},
+}
+
+// buildMacro returns the storage format markup of a catalog macro. Parameters are checked against
+// the macro's definition and written in catalog order; body is used as plain text or, for macros with
+// a rich text body, as storage format, which must be valid.
+func buildMacro(name string, params map[string]string, body string) (string, error) {
+	i := slices.IndexFunc(macroCatalog, func(d MacroDefinition) bool { return d.Name == name })
+	if i < 0 {
+		return "", fmt.Errorf("unknown macro %q", name)
+	}
+	def := macroCatalog[i]
+
+	for param := range params {
+		if !slices.ContainsFunc(def.Parameters, func(p MacroParameter) bool { return p.Name == param }) {
+			return "", fmt.Errorf("unknown parameter %q for macro %s", param, name)
+		}
+	}
+	for _, p := range def.Parameters {
+		value, ok := params[p.Name]
+		if p.Required && value == "" {
+			return "", fmt.Errorf("parameter %q is required for macro %s", p.Name, name)
+		}
+		if ok && len(p.Values) > 0 && !slices.Contains(p.Values, value) {
+			return "", fmt.Errorf("parameter %q of macro %s must be one of %s", p.Name, name, strings.Join(p.Values, ", "))
+		}
+	}
+	if name == "jira" && params["key"] == "" && params["jqlQuery"] == "" {
+		return "", fmt.Errorf("either key or jqlQuery is required for macro jira")
+	}
+	if def.Body == "none" && body != "" {
+		return "", fmt.Errorf("macro %s does not take a body", name)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<ac:structured-macro ac:name="` + name + `" ac:schema-version="1">`)
+	if name == "include" {
+		// The included page is the macro's default parameter, written as a page link.
+		b.WriteString(`<ac:parameter ac:name=""><ac:link><ri:page ri:content-title="` + html.EscapeString(params["title"]) + `"`)
+		if spaceKey := params["spaceKey"]; spaceKey != "" {
+			b.WriteString(` ri:space-key="` + html.EscapeString(spaceKey) + `"`)
+		}
+		b.WriteString(` /></ac:link></ac:parameter>`)
+	} else {
+		for _, p := range def.Parameters {
+			if value, ok := params[p.Name]; ok {
+				b.WriteString(`<ac:parameter ac:name="` + p.Name + `">` + html.EscapeString(value) + `</ac:parameter>`)
+			}
+		}
+	}
+	switch def.Body {
+	case "plain-text":
+		b.WriteString(`<ac:plain-text-body><![CDATA[` + strings.ReplaceAll(body, "]]>", "]]]]><![CDATA[>") + `]]></ac:plain-text-body>`)
+	case "rich-text":
+		if err := validateStorage(body); err != nil {
+			return "", fmt.Errorf("invalid body: %w", err)
+		}
+		b.WriteString(`<ac:rich-text-body>` + body + `</ac:rich-text-body>`)
+	}
+	b.WriteString(`</ac:structured-macro>`)
+	return b.String(), nil
+}
+
+// storageNode is an element or text node of a parsed storage format body.
+type storageNode struct {
+	// Name is the element name including any namespace prefix, such as "ac:structured-macro".
+	// It is empty for text nodes.
+	Name     string
+	Attrs    map[string]string
+	Text     string
+	Children []*storageNode
+	// Start and End are the byte offsets of the node in the body, and ContentEnd is the offset of the
+	// end tag of an element.
+	Start, ContentEnd, End int
+}
+
+// storageVoidElements lists the HTML elements that never have content and may appear unclosed.
+// It is narrower than xml.HTMLAutoClose, which matches on local names and would close ac:link.
+var storageVoidElements = []string{"br", "hr", "img", "col", "area", "wbr"}
+
+// parseStorage parses a storage format body into a tree under a synthetic root element. Parsing is
+// lenient: HTML entities are recognised and unclosed elements are closed automatically.
+func parseStorage(storage string) (*storageNode, error) {
+	const wrapper = "<root>"
+	decoder := xml.NewDecoder(strings.NewReader(wrapper + storage + "</root>"))
+	decoder.Strict = false
+	decoder.AutoClose = storageVoidElements
+	decoder.Entity = xml.HTMLEntity
+
+	document := &storageNode{}
+	stack := []*storageNode{document}
+	for {
+		start := int(decoder.InputOffset()) - len(wrapper)
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse storage format: %w", err)
+		}
+		end := int(decoder.InputOffset()) - len(wrapper)
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &storageNode{Name: qualifiedName(t.Name), Attrs: map[string]string{}, Start: start}
+			for _, attr := range t.Attr {
+				node.Attrs[qualifiedName(attr.Name)] = attr.Value
+			}
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				parent.ContentEnd, parent.End = start, end
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &storageNode{Text: string(t), Start: start, End: end})
+		}
+	}
+	return document.Children[0], nil
+}
+
+// qualifiedName joins the prefix and local part of an element or attribute name.
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// child returns the first child element with the given name, or nil.
+func (n *storageNode) child(name string) *storageNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// macroParameter returns the value of a named parameter of a structured macro.
+func (n *storageNode) macroParameter(name string) string {
+	for _, c := range n.Children {
+		if c.Name == "ac:parameter" && c.Attrs["ac:name"] == name {
+			return c.textContent()
+		}
+	}
+	return ""
+}
+
+// textContent returns the concatenated text of the node and its descendants.
+func (n *storageNode) textContent() string {
+	if n.Name == "" {
+		return n.Text
+	}
+	var b strings.Builder
+	for _, c := range n.Children {
+		b.WriteString(c.textContent())
+	}
+	return b.String()
+}
+
+// replaceMacros puts the result of replace in place of every outermost structured macro of a storage
+// format body, passing it the name and markup of the macro. A macro that is not closed is kept.
+func replaceMacros(storage string, replace func(name, markup string) string) string {
+	var b strings.Builder
+	last, start, depth := 0, 0, 0
+	name := ""
+	for _, m := range macroTagPattern.FindAllStringSubmatchIndex(storage, -1) {
+		closing, empty := m[3] > m[2], m[7] > m[6]
+		switch {
+		case closing:
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth > 0 {
+				continue
+			}
+		case depth > 0:
+			if !empty {
+				depth++
+			}
+			continue
+		default:
+			start, name = m[0], ""
+			if match := macroNamePattern.FindStringSubmatch(storage[m[4]:m[5]]); match != nil {
+				name = match[1]
+			}
+			if !empty {
+				depth = 1
+				continue
+			}
+		}
+		b.WriteString(storage[last:start])
+		b.WriteString(replace(name, storage[start:m[1]]))
+		last = m[1]
+	}
+	b.WriteString(storage[last:])
+	return b.String()
+}
+
+// stripMacros replaces every structured macro of a storage format body with an empty one of the same
+// name, leaving out its parameters and body.
+func stripMacros(storage string) string {
+	return replaceMacros(storage, func(name, markup string) string {
+		return `<ac:structured-macro ac:name="` + html.EscapeString(name) + `" />`
+	})
+}
+
+// macroRichTextBody returns the markup inside the rich text body of a structured macro, or false when
+// it has none.
+func macroRichTextBody(markup string) (string, bool) {
+	start := strings.Index(markup, "<ac:rich-text-body>")
+	end := strings.LastIndex(markup, "</ac:rich-text-body>")
+	if start < 0 || end < start {
+		return "", false
+	}
+	return markup[start+len("<ac:rich-text-body>") : end], true
+}
+
+// storageToMarkdown converts a storage format body to Markdown. Headings, paragraphs, emphasis,
+// links, images, lists, task lists, tables, block quotes, and code macros are converted; panels
+// such as info and note become block quotes, and macros without a readable body are dropped.
+func storageToMarkdown(storage string) (string, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return "", err
+	}
+	return markdownFromBlocks(root.Children, "\n\n"), nil
+}
+
+// markdownFromBlocks renders a sequence of nodes as Markdown blocks joined by sep. Runs of inline
+// nodes between block elements are rendered as paragraphs.
+func markdownFromBlocks(nodes []*storageNode, sep string) string {
+	var blocks []string
+	var inline []*storageNode
+	flush := func() {
+		lines := strings.Split(markdownFromInline(inline), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline = nil
+	}
+
+	for _, n := range nodes {
+		block, ok := markdownBlock(n)
+		if !ok {
+			inline = append(inline, n)
+			continue
+		}
+		flush()
+		if block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	flush()
+	return strings.Join(blocks, sep)
+}
+
+// markdownBlock renders n as a Markdown block, reporting false if n is inline content.
+func markdownBlock(n *storageNode) (string, bool) {
+	switch n.Name {
+	case "p":
+		return markdownFromBlocks(n.Children, "\n\n"), true
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.Join(strings.Fields(markdownFromInline(n.Children)), " ")
+		return strings.Repeat("#", int(n.Name[1]-'0')) + " " + text, true
+	case "ul", "ol":
+		return markdownFromList(n), true
+	case "ac:task-list":
+		var items []string
+		for _, task := range n.Children {
+			if task.Name != "ac:task" {
+				continue
+			}
+			box := "[ ]"
+			if status := task.child("ac:task-status"); status != nil && strings.TrimSpace(status.textContent()) == "complete" {
+				box = "[x]"
+			}
+			var text string
+			if body := task.child("ac:task-body"); body != nil {
+				text = strings.Join(strings.Fields(markdownFromInline(body.Children)), " ")
+			}
+			items = append(items, "- "+box+" "+text)
+		}
+		return strings.Join(items, "\n"), true
+	case "table":
+		return markdownFromTable(n), true
+	case "pre":
+		return markdownFence("", n.textContent()), true
+	case "blockquote":
+		return markdownQuote(markdownFromBlocks(n.Children, "\n\n")), true
+	case "hr":
+		return "---", true
+	case "ac:structured-macro":
+		return markdownFromMacro(n)
+	case "div", "section", "ac:layout", "ac:layout-section", "ac:layout-cell", "ac:rich-text-body":
+		return markdownFromBlocks(n.Children, "\n\n"), true
+	}
+	return "", false
+}
+
+// markdownFromMacro renders a structured macro as a Markdown block, reporting false for inline macros.
+func markdownFromMacro(n *storageNode) (string, bool) {
+	name := n.Attrs["ac:name"]
+	switch name {
+	case "status", "jira", "anchor":
+		return "", false
+	case "code", "noformat":
+		var code string
+		if body := n.child("ac:plain-text-body"); body != nil {
+			code = body.textContent()
+		}
+		return markdownFence(n.macroParameter("language"), code), true
+	}
+
+	body := n.child("ac:rich-text-body")
+	if body == nil {
+		return "", true
+	}
+	content := markdownFromBlocks(body.Children, "\n\n")
+	title := n.macroParameter("title")
+	switch name {
+	case "info", "tip", "note", "warning", "panel":
+		if title == "" {
+			title = strings.ToUpper(name[:1]) + name[1:]
+		}
+		return markdownQuote("**" + title + "**\n\n" + content), true
+	case "expand":
+		if title != "" {
+			return "**" + title + "**\n\n" + content, true
+		}
+	}
+	return content, true
+}
+
+// markdownFromList renders an ordered or unordered list, indenting nested blocks under their items.
+func markdownFromList(n *storageNode) string {
+	var items []string
+	for _, li := range n.Children {
+		if li.Name != "li" {
+			continue
+		}
+		marker := "- "
+		if n.Name == "ol" {
+			marker = fmt.Sprintf("%d. ", len(items)+1)
+		}
+		lines := strings.Split(markdownFromBlocks(li.Children, "\n"), "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = strings.Repeat(" ", len(marker)) + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+// markdownFromTable renders a table as a pipe table, using its first row as the header. Merged
+// cells are not expanded, so rows with spanning cells are padded at the end.
+func markdownFromTable(n *storageNode) string {
+	var rows [][]string
+	columns := 0
+	var walk func(*storageNode)
+	walk = func(node *storageNode) {
+		for _, c := range node.Children {
+			switch c.Name {
+			case "thead", "tbody", "tfoot":
+				walk(c)
+			case "tr":
+				var row []string
+				for _, cell := range c.Children {
+					if cell.Name == "th" || cell.Name == "td" {
+						text := strings.Join(strings.Fields(markdownFromBlocks(cell.Children, " ")), " ")
+						row = append(row, strings.ReplaceAll(text, "|", `\|`))
+					}
+				}
+				columns = max(columns, len(row))
+				rows = append(rows, row)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// markdownFromInline renders inline nodes as Markdown text, collapsing whitespace.
+func markdownFromInline(nodes []*storageNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n.Name {
+		case "":
+			b.WriteString(collapseSpace(n.Text))
+		case "strong", "b":
+			b.WriteString(wrapMarkdown("**", markdownFromInline(n.Children)))
+		case "em", "i":
+			b.WriteString(wrapMarkdown("*", markdownFromInline(n.Children)))
+		case "del", "s":
+			b.WriteString(wrapMarkdown("~~", markdownFromInline(n.Children)))
+		case "code", "tt":
+			b.WriteString(wrapMarkdown("`", n.textContent()))
+		case "br":
+			b.WriteString("\n")
+		case "a":
+			text := strings.TrimSpace(markdownFromInline(n.Children))
+			if text == "" {
+				text = n.Attrs["href"]
+			}
+			b.WriteString("[" + text + "](" + n.Attrs["href"] + ")")
+		case "ac:link":
+			b.WriteString(markdownFromLink(n))
+		case "ac:image":
+			src := ""
+			if u := n.child("ri:url"); u != nil {
+				src = u.Attrs["ri:value"]
+			} else if a := n.child("ri:attachment"); a != nil {
+				src = a.Attrs["ri:filename"]
+			}
+			b.WriteString("![" + n.Attrs["ac:alt"] + "](" + src + ")")
+		case "time":
+			b.WriteString(n.Attrs["datetime"])
+		case "ac:structured-macro":
+			switch n.Attrs["ac:name"] {
+			case "status":
+				b.WriteString("[" + n.macroParameter("title") + "]")
+			case "jira":
+				b.WriteString(n.macroParameter("key"))
+			}
+		case "ac:parameter", "ac:emoticon", "ac:placeholder":
+		default:
+			if block, ok := markdownBlock(n); ok {
+				b.WriteString("\n" + block + "\n")
+			} else {
+				b.WriteString(markdownFromInline(n.Children))
+			}
+		}
+	}
+	return b.String()
+}
+
+// markdownFromLink renders a link to a page, attachment, or user using its link body or the target's name.
+func markdownFromLink(n *storageNode) string {
+	if body := n.child("ac:plain-text-link-body"); body != nil {
+		return body.textContent()
+	}
+	if body := n.child("ac:link-body"); body != nil {
+		return markdownFromInline(body.Children)
+	}
+	switch {
+	case n.child("ri:page") != nil:
+		return n.child("ri:page").Attrs["ri:content-title"]
+	case n.child("ri:blog-post") != nil:
+		return n.child("ri:blog-post").Attrs["ri:content-title"]
+	case n.child("ri:attachment") != nil:
+		return n.child("ri:attachment").Attrs["ri:filename"]
+	case n.child("ri:user") != nil:
+		user := n.child("ri:user")
+		if username := user.Attrs["ri:username"]; username != "" {
+			return "@" + username
+		}
+		return "@" + user.Attrs["ri:userkey"]
+	}
+	return n.Attrs["ac:anchor"]
+}
+
+// storageToPlainText extracts the readable text of a storage format body, one line per block.
+// Markup, macro parameters, and placeholders are dropped and whitespace is normalized.
+func storageToPlainText(storage string) (string, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return "", err
+	}
+	return root.plainText(), nil
+}
+
+// plainText returns the readable text of the node, one line per block, as storageToPlainText does.
+func (n *storageNode) plainText() string {
+	var b strings.Builder
+	var walk func(*storageNode)
+	walk = func(node *storageNode) {
+		switch node.Name {
+		case "":
+			b.WriteString(node.Text)
+			return
+		case "ac:parameter", "ac:placeholder", "ac:task-id", "ac:task-status":
+			return
+		case "br", "hr":
+			b.WriteString("\n")
+			return
+		case "td", "th":
+			b.WriteString(" ")
+		}
+		block := !slices.Contains(plainTextInlineElements, node.Name)
+		if block {
+			b.WriteString("\n")
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+		if block {
+			b.WriteString("\n")
+		}
+	}
+	walk(n)
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// plainTextInlineElements lists the elements whose text flows into the surrounding line in plain text extraction.
+var plainTextInlineElements = []string{
+	"a", "b", "strong", "i", "em", "u", "s", "del", "code", "tt", "span", "sub", "sup", "small", "time",
+	"td", "th", "ac:link", "ac:link-body", "ac:plain-text-link-body", "ac:image", "ac:emoticon",
+	"ri:page", "ri:attachment", "ri:user", "ri:url", "ac:task-body",
+}
+
+// extractTables returns the tables of a storage format body in document order, including tables
+// nested in other tables. Merged cells are expanded so every row has a value for every column,
+// and each merge is reported with the position of its top-left cell.
+func extractTables(storage string) ([]ExtractedTable, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []ExtractedTable
+	var find func(*storageNode)
+	find = func(n *storageNode) {
+		for _, c := range n.Children {
+			if c.Name == "table" {
+				table := tableFromNode(c)
+				table.Index = len(tables)
+				tables = append(tables, table)
+			}
+			find(c)
+		}
+	}
+	find(root)
+	return tables, nil
+}
+
+// tableFromNode builds the grid of a table element. A first row made only of header cells is
+// returned as the headers.
+func tableFromNode(n *storageNode) ExtractedTable {
+	var rows []*storageNode
+	for _, c := range n.Children {
+		switch c.Name {
+		case "tr":
+			rows = append(rows, c)
+		case "thead", "tbody", "tfoot":
+			for _, r := range c.Children {
+				if r.Name == "tr" {
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+
+	table := ExtractedTable{}
+	grid := make([][]string, len(rows))
+	filled := make([][]bool, len(rows))
+	headerRow := len(rows) > 0
+	columns := 0
+	for r, row := range rows {
+		col := 0
+		for _, cell := range row.Children {
+			if cell.Name != "td" && cell.Name != "th" {
+				continue
+			}
+			if r == 0 && cell.Name != "th" {
+				headerRow = false
+			}
+			for col < len(filled[r]) && filled[r][col] {
+				col++
+			}
+			rowSpan := max(1, atoiOr(cell.Attrs["rowspan"], 1))
+			colSpan := max(1, atoiOr(cell.Attrs["colspan"], 1))
+			if rowSpan > 1 || colSpan > 1 {
+				table.MergedCells = append(table.MergedCells, MergedCell{Row: r, Column: col, RowSpan: rowSpan, ColSpan: colSpan})
+			}
+
+			text := cell.plainText()
+			for dr := 0; dr < rowSpan && r+dr < len(rows); dr++ {
+				for len(grid[r+dr]) < col+colSpan {
+					grid[r+dr] = append(grid[r+dr], "")
+					filled[r+dr] = append(filled[r+dr], false)
+				}
+				for dc := 0; dc < colSpan; dc++ {
+					grid[r+dr][col+dc] = text
+					filled[r+dr][col+dc] = true
+				}
+			}
+			col += colSpan
+		}
+		columns = max(columns, len(grid[r]))
+	}
+	for r := range grid {
+		for len(grid[r]) < columns {
+			grid[r] = append(grid[r], "")
+		}
+	}
+
+	if headerRow {
+		table.Headers = grid[0]
+		grid = grid[1:]
+	}
+	table.Rows = grid
+	if table.Rows == nil {
+		table.Rows = [][]string{}
+	}
+	return table
+}
+
+// atoiOr parses s as an integer, returning fallback if it is not one.
+func atoiOr(s string, fallback int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// tablesToCSV renders tables as CSV, with the headers as the first record of each table and a
+// blank line between tables.
+func tablesToCSV(tables []ExtractedTable) (string, error) {
+	var b strings.Builder
+	for i, table := range tables {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		w := csv.NewWriter(&b)
+		if table.Headers != nil {
+			if err := w.Write(table.Headers); err != nil {
+				return "", err
+			}
+		}
+		if err := w.WriteAll(table.Rows); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// collapseSpace replaces each run of whitespace in s with a single space.
+func collapseSpace(s string) string {
+	if strings.TrimSpace(s) == "" {
+		if s == "" {
+			return ""
+		}
+		return " "
+	}
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// wrapMarkdown surrounds text with an inline delimiter, keeping surrounding whitespace outside it.
+func wrapMarkdown(delim, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + delim + trimmed + delim + text[start+len(trimmed):]
+}
+
+// markdownFence returns a fenced code block, lengthening the fence if the code contains one.
+func markdownFence(language, code string) string {
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + strings.Trim(code, "\n") + "\n" + fence
+}
+
+// markdownQuote prefixes every line of text with a block quote marker.
+func markdownQuote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chunkStorage splits a storage format body into sections at its headings, including headings
+// inside layouts. Content before the first heading forms an introduction section. Anchors follow
+// Confluence's heading anchors, the title and heading text without spaces joined by a hyphen, with
+// a numeric suffix for repeated headings. Sections are rendered as Markdown, or as plain text if
+// plain is set.
+func chunkStorage(title, storage string, plain bool) ([]ContentChunk, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, _ := storageBlocks(root)
+	pagePrefix := anchorText(title)
+	headingAnchor := headingAnchors(pagePrefix)
+	var chunks []ContentChunk
+	var path []string
+	var levels []int
+	current := ContentChunk{Anchor: pagePrefix}
+	var body []*storageNode
+	emit := func() {
+		container := &storageNode{Name: "div", Children: body}
+		if plain {
+			current.Content = container.plainText()
+		} else {
+			current.Content = markdownFromBlocks(body, "\n\n")
+		}
+		if current.Heading != "" || strings.TrimSpace(current.Content) != "" {
+			current.Tokens = estimateTokens(current.Content)
+			chunks = append(chunks, current)
+		}
+	}
+
+	for _, n := range nodes {
+		if !n.isHeading() {
+			body = append(body, n)
+			continue
+		}
+		emit()
+
+		level := int(n.Name[1] - '0')
+		heading := strings.Join(strings.Fields(n.textContent()), " ")
+		for len(levels) > 0 && levels[len(levels)-1] >= level {
+			levels = levels[:len(levels)-1]
+			path = path[:len(path)-1]
+		}
+		levels = append(levels, level)
+		path = append(path, heading)
+
+		current = ContentChunk{Anchor: headingAnchor(heading), Heading: heading, Level: level, Path: slices.Clone(path)}
+		body = []*storageNode{n}
+	}
+	emit()
+	return chunks, nil
+}
+
+// storageBlocks returns the top-level blocks of a parsed storage format body, descending into the
+// containers and layouts that hold headings, along with the element each block is in.
+func storageBlocks(root *storageNode) ([]*storageNode, map[*storageNode]*storageNode) {
+	var blocks []*storageNode
+	parents := map[*storageNode]*storageNode{}
+	var flatten func(*storageNode)
+	flatten = func(n *storageNode) {
+		for _, c := range n.Children {
+			parents[c] = n
+			if slices.Contains([]string{"div", "section", "ac:layout", "ac:layout-section", "ac:layout-cell"}, c.Name) && containsHeading(c) {
+				flatten(c)
+				continue
+			}
+			blocks = append(blocks, c)
+		}
+	}
+	flatten(root)
+	return blocks, parents
+}
+
+// headingAnchors returns a function that gives the anchors of the headings of a page in order, made
+// from the page prefix and the heading text and numbered when a heading repeats.
+func headingAnchors(pagePrefix string) func(heading string) string {
+	seen := map[string]int{}
+	return func(heading string) string {
+		anchor := pagePrefix + "-" + anchorText(heading)
+		if count := seen[anchor]; count > 0 {
+			seen[anchor]++
+			return fmt.Sprintf("%s.%d", anchor, count)
+		}
+		seen[anchor] = 1
+		return anchor
+	}
+}
+
+// storageSection is the part of a storage format body that starts at a heading.
+type storageSection struct {
+	Anchor  string
+	Heading string
+	// Start is the offset of the heading, BodyStart of what follows it, and End of the end of the section.
+	Start, BodyStart, End int
+}
+
+// headingSections returns the sections of a storage format body, with the anchors of chunkStorage.
+// A section ends at the next heading of the same or a higher level, or at the end of the element it
+// is in, so that the section can be replaced without breaking the markup around it.
+func headingSections(title, storage string) ([]storageSection, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	root.ContentEnd = len(storage)
+	blocks, parents := storageBlocks(root)
+	headingAnchor := headingAnchors(anchorText(title))
+
+	var sections []storageSection
+	for i, n := range blocks {
+		if !n.isHeading() {
+			continue
+		}
+		heading := strings.Join(strings.Fields(n.textContent()), " ")
+		section := storageSection{Anchor: headingAnchor(heading), Heading: heading, Start: n.Start, BodyStart: n.End, End: parents[n].ContentEnd}
+		for _, next := range blocks[i+1:] {
+			if !next.isHeading() || next.Name[1] > n.Name[1] {
+				continue
+			}
+			// The section ends before the next heading, or before the block of its element that holds it.
+			for parents[next] != nil && parents[next] != parents[n] {
+				next = parents[next]
+			}
+			if parents[next] != nil {
+				section.End = next.Start
+			}
+			break
+		}
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+// isHeading reports whether n is a heading element, h1 to h6.
+func (n *storageNode) isHeading() bool {
+	return len(n.Name) == 2 && n.Name[0] == 'h' && n.Name[1] >= '1' && n.Name[1] <= '6'
+}
+
+// containsHeading reports whether a heading element appears anywhere under n.
+func containsHeading(n *storageNode) bool {
+	for _, c := range n.Children {
+		if c.isHeading() || containsHeading(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// anchorText removes the whitespace and URL-unsafe punctuation from text, as Confluence does when
+// generating heading anchors.
+func anchorText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || strings.ContainsRune(`"#%&'()+,/:;<=>?@[\]^{|}`+"`", r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// estimateTokens returns a rough token count for text, assuming four characters per token.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// chunkContent replaces the storage body of a content response with its sections, as returned by
+// chunkStorage. If section is set, only the section with that anchor or heading is kept.
+func chunkContent(resp []byte, section string, plain bool) ([]byte, error) {
+	var content map[string]any
+	if err := json.Unmarshal(resp, &content); err != nil {
+		return nil, fmt.Errorf("failed to decode content: %w", err)
+	}
+	body, _ := content["body"].(map[string]any)
+	storage, _ := body["storage"].(map[string]any)
+	value, _ := storage["value"].(string)
+	title, _ := content["title"].(string)
+
+	chunks, err := chunkStorage(title, value, plain)
+	if err != nil {
+		return nil, err
+	}
+	if section != "" {
+		i := slices.IndexFunc(chunks, func(c ContentChunk) bool { return c.Anchor == section || c.Heading == section })
+		if i < 0 {
+			anchors := make([]string, len(chunks))
+			for j, c := range chunks {
+				anchors[j] = c.Anchor
+			}
+			return nil, fmt.Errorf("section %q not found; available sections: %s", section, strings.Join(anchors, ", "))
+		}
+		chunks = chunks[i : i+1]
+	}
+
+	delete(content, "body")
+	content["chunks"] = chunks
+	return json.Marshal(content)
+}
+
+// convertStorageBody replaces the storage body of a content response with the given representation,
+// produced by convert, so callers receive the converted body alongside the content's metadata.
+func convertStorageBody(resp []byte, representation string, convert func(string) (string, error)) ([]byte, error) {
+	var content map[string]any
+	if err := json.Unmarshal(resp, &content); err != nil {
+		return nil, fmt.Errorf("failed to decode content: %w", err)
+	}
+	body, _ := content["body"].(map[string]any)
+	storage, _ := body["storage"].(map[string]any)
+	value, _ := storage["value"].(string)
+
+	converted, err := convert(value)
+	if err != nil {
+		return nil, err
+	}
+	content["body"] = map[string]any{
+		representation: map[string]any{"value": converted, "representation": representation},
+	}
+	return json.Marshal(content)
+}
+
+// ensureExpand adds a property to an expansion string if not already present.
+func ensureExpand(current, required string) string {
+	if current == "" {
+		return required
+	}
+	parts := strings.Split(current, ",")
+	for _, p := range parts {
+		if strings.TrimSpace(p) == required {
+			return current
+		}
+	}
+	return current + "," + required
+}
+
+// newQueryWithCommonArgs helper creates a url.Values object and populates it with common pagination and expansion parameters.
+func newQueryWithCommonArgs(args map[string]any) url.Values {
+	query := url.Values{}
+	if limit, ok := args["limit"].(float64); ok {
+		query.Set("limit", fmt.Sprintf("%d", int(limit)))
+	} else {
+		query.Set("limit", fmt.Sprintf("%d", defaultLimit))
+	}
+	if start, ok := args["start"].(float64); ok {
+		query.Set("start", fmt.Sprintf("%d", int(start)))
+	}
+	if expand, ok := args["expand"].(string); ok && expand != "" {
+		query.Set("expand", expand)
+	}
+	return query
+}
+
+// newMarkerRef generates a random UUID used to tie an inline comment to its marker in the page body.
+func newMarkerRef() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate marker reference: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// storageTextEscaper escapes text the way storage format keeps it, where only &, <, and > are
+// escaped and quotes and apostrophes are left as they are.
+var storageTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// hiddenStorageElements are the storage format elements whose content is not page text: macro
+// parameters and plain text macro bodies, which an inline comment marker cannot go into.
+var hiddenStorageElements = []string{"ac:parameter", "ac:plain-text-body"}
+
+// insertInlineMarker wraps the matchIndex-th occurrence of selection in the storage body with an inline comment marker.
+// Only text outside of tags is searched, so a selection must not span formatting boundaries. Macro
+// parameters, plain text macro bodies, and CDATA sections are skipped.
+func insertInlineMarker(storage, selection string, matchIndex int, markerRef string) (string, error) {
+	needle := storageTextEscaper.Replace(selection)
+	count := 0
+	pos := 0
+	for pos < len(storage) {
+		if strings.HasPrefix(storage[pos:], "<![CDATA[") {
+			end := strings.Index(storage[pos:], "]]>")
+			if end < 0 {
+				break
+			}
+			pos += end + len("]]>")
+			continue
+		}
+		if storage[pos] == '<' {
+			end := strings.IndexByte(storage[pos:], '>')
+			if end < 0 {
+				break
+			}
+			tag := storage[pos : pos+end+1]
+			pos += end + 1
+			for _, name := range hiddenStorageElements {
+				open := "<" + name
+				if strings.HasPrefix(tag, open) && strings.ContainsRune(" \t\n>", rune(tag[len(open)])) && !strings.HasSuffix(tag, "/>") {
+					pos = endOfStorageElement(storage, pos, name)
+					break
+				}
+			}
+			continue
+		}
+
+		segEnd := len(storage)
+		if next := strings.IndexByte(storage[pos:], '<'); next >= 0 {
+			segEnd = pos + next
+		}
+		segment := storage[pos:segEnd]
+
+		offset := 0
+		for {
+			i := strings.Index(segment[offset:], needle)
+			if i < 0 {
+				break
+			}
+			if count == matchIndex {
+				at := pos + offset + i
+				marker := fmt.Sprintf(`<ac:inline-comment-marker ac:ref="%s">%s</ac:inline-comment-marker>`, markerRef, needle)
+				return storage[:at] + marker + storage[at+len(needle):], nil
+			}
+			count++
+			offset += i + len(needle)
+		}
+		pos = segEnd
+	}
+
+	return "", fmt.Errorf("selection not found in page text (occurrence %d of %d)", matchIndex+1, count)
+}
+
+// endOfStorageElement returns the position after the closing tag of the name element whose content
+// starts at pos, or the end of storage when it is not closed. A closing tag inside a CDATA section
+// is part of the text.
+func endOfStorageElement(storage string, pos int, name string) int {
+	closing := "</" + name + ">"
+	for pos < len(storage) {
+		if strings.HasPrefix(storage[pos:], "<![CDATA[") {
+			end := strings.Index(storage[pos:], "]]>")
+			if end < 0 {
+				return len(storage)
+			}
+			pos += end + len("]]>")
+			continue
+		}
+		if strings.HasPrefix(storage[pos:], closing) {
+			return pos + len(closing)
+		}
+		pos++
+	}
+	return len(storage)
+}
+
+// errMarkerNotFound is returned by removeInlineMarker when the body has no marker with the reference.
+var errMarkerNotFound = errors.New("inline comment marker not found")
+
+// removeInlineMarker unwraps the inline comment marker with markerRef in the storage body, keeping
+// the text it marked.
+func removeInlineMarker(storage, markerRef string) (string, error) {
+	open := fmt.Sprintf(`<ac:inline-comment-marker ac:ref="%s">`, markerRef)
+	start := strings.Index(storage, open)
+	if start < 0 {
+		return "", errMarkerNotFound
+	}
+	const closing = "</ac:inline-comment-marker>"
+	end := strings.Index(storage[start:], closing)
+	if end < 0 {
+		return "", errMarkerNotFound
+	}
+	end += start
+	return storage[:start] + storage[start+len(open):end] + storage[end+len(closing):], nil
+}
+
+// siteURL returns the base URL of the Confluence web UI, i.e. the API base URL without the /rest/api suffix.
+func (c *ConfluenceClient) siteURL() string {
+	base := strings.TrimSuffix(c.config.BaseURL, "/")
+	if i := strings.Index(base, "/rest/api"); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// webURL turns a relative web UI link from an API response into an absolute URL.
+func (c *ConfluenceClient) webURL(link string) string {
+	if link == "" {
+		return ""
+	}
+	return c.siteURL() + link
+}
+
+// contentWebURL returns the absolute web UI link of a decoded content object from its web UI or short
+// link, or for a page or blog post without links from its ID. It returns "" for other objects.
+func (c *ConfluenceClient) contentWebURL(object map[string]any) string {
+	contentType := stringField(object, "type")
+	if !slices.Contains([]string{"page", "blogpost", "comment", "attachment"}, contentType) {
+		return ""
+	}
+	links, _ := object["_links"].(map[string]any)
+	if link := cmp.Or(stringField(links, "webui"), stringField(links, "tinyui")); link != "" {
+		return c.webURL(link)
+	}
+	if id := stringField(object, "id"); id != "" && (contentType == "page" || contentType == "blogpost") {
+		return c.siteURL() + "/pages/viewpage.action?pageId=" + url.QueryEscape(id)
+	}
+	return ""
+}
+
+// addWebURLs sets the webUrl of the content objects in a decoded JSON value that have none, and
+// reports whether it set any.
+func (c *ConfluenceClient) addWebURLs(value any) bool {
+	added := false
+	switch value := value.(type) {
+	case map[string]any:
+		for _, field := range value {
+			added = c.addWebURLs(field) || added
+		}
+		if _, ok := value["webUrl"]; !ok {
+			if link := c.contentWebURL(value); link != "" {
+				value["webUrl"] = link
+				added = true
+			}
+		}
+	case []any:
+		for _, item := range value {
+			added = c.addWebURLs(item) || added
+		}
+	}
+	return added
+}
+
+// listChildPages returns all child pages of a page, following result pages.
+func (c *ConfluenceClient) listChildPages(ctx context.Context, contentID string) ([]ContentSummary, error) {
+	var children []ContentSummary
+	start := 0
+	for {
+		query := url.Values{}
+		query.Set("start", fmt.Sprintf("%d", start))
+		query.Set("limit", fmt.Sprintf("%d", searchPageSize))
+
+		var page struct {
+			Results []ContentSummary `json:"results"`
+		}
+		if err := c.getJSON(ctx, "/content/"+contentID+"/child/page", query, &page); err != nil {
+			return nil, err
+		}
+
+		children = append(children, page.Results...)
+		if len(page.Results) < searchPageSize {
+			return children, nil
+		}
+		start += len(page.Results)
+	}
+}
+
+// getDescendantTree walks the page hierarchy below contentID breadth-first up to maxDepth levels and maxNodes pages.
+func (c *ConfluenceClient) getDescendantTree(ctx context.Context, contentID string, maxDepth, maxNodes int) (*PageTree, error) {
+	var root ContentSummary
+	if err := c.getJSON(ctx, "/content/"+contentID, nil, &root); err != nil {
+		return nil, err
+	}
+
+	tree := &PageTree{
+		Root:  &PageNode{ID: root.ID, Title: root.Title, URL: c.webURL(root.Links.WebUI)},
+		Depth: maxDepth,
+	}
+
+	level := []*PageNode{tree.Root}
+	for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+		var next []*PageNode
+		for _, node := range level {
+			children, err := c.listChildPages(ctx, node.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list children of %s: %w", node.ID, err)
+			}
+			for _, child := range children {
+				if tree.Count >= maxNodes {
+					tree.Truncated = true
+					return tree, nil
+				}
+				childNode := &PageNode{ID: child.ID, Title: child.Title, URL: c.webURL(child.Links.WebUI)}
+				node.Children = append(node.Children, childNode)
+				next = append(next, childNode)
+				tree.Count++
+			}
+		}
+		level = next
+	}
+
+	return tree, nil
+}
+
+// listAttachments returns all attachments of a content item, following result pages.
+func (c *ConfluenceClient) listAttachments(ctx context.Context, contentID string) ([]Attachment, error) {
+	var attachments []Attachment
+	start := 0
+	for {
+		query := url.Values{}
+		query.Set("start", fmt.Sprintf("%d", start))
+		query.Set("limit", fmt.Sprintf("%d", searchPageSize))
+
+		var page struct {
+			Results []Attachment `json:"results"`
+		}
+		if err := c.getJSON(ctx, "/content/"+contentID+"/child/attachment", query, &page); err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, page.Results...)
+		if len(page.Results) < searchPageSize {
+			return attachments, nil
+		}
+		start += len(page.Results)
+	}
+}
+
+// copyAttachment downloads an attachment and uploads it to another content item.
+func (c *ConfluenceClient) copyAttachment(ctx context.Context, attachment Attachment, targetID string) error {
+	downloadReq, err := http.NewRequestWithContext(ctx, "GET", c.siteURL()+attachment.Links.Download, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %w", err)
+	}
+	if err := c.authorize(downloadReq); err != nil {
+		return err
+	}
+
+	downloadResp, err := c.httpClient.Do(downloadReq)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer func() {
+		_ = downloadResp.Body.Close()
+	}()
+	if downloadResp.StatusCode >= 400 {
+		respBytes, _ := io.ReadAll(io.LimitReader(downloadResp.Body, maxErrorBodyBytes))
+		return newAPIError(downloadResp.StatusCode, respBytes)
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", attachment.Title)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, downloadResp.Body); err != nil {
+		return fmt.Errorf("failed to read attachment: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish form: %w", err)
+	}
+
+	u, err := url.Parse(c.config.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL: %w", err)
+	}
+	uploadReq, err := http.NewRequestWithContext(ctx, "POST", u.JoinPath("/content/"+targetID+"/child/attachment").String(), &buf)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	if err := c.authorize(uploadReq); err != nil {
+		return err
+	}
+	uploadReq.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadReq.Header.Set("Accept", "application/json")
+	uploadReq.Header.Set("X-Atlassian-Token", "no-check")
+
+	uploadResp, err := c.httpClient.Do(uploadReq)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer func() {
+		_ = uploadResp.Body.Close()
+	}()
+	if uploadResp.StatusCode >= 400 {
+		respBytes, _ := io.ReadAll(io.LimitReader(uploadResp.Body, maxErrorBodyBytes))
+		return newAPIError(uploadResp.StatusCode, respBytes)
+	}
+	return nil
+}
+
+// copyPageClientSide recreates a page (and optionally its subtree) under parentID in spaceKey.
+// Pages created before an error are still returned so the caller can report them. The children are
+// listed before the copy is created, so that a copy placed inside the subtree is not copied again.
+func (c *ConfluenceClient) copyPageClientSide(ctx context.Context, sourceID, parentID, spaceKey string, opts CopyOptions, isRoot bool) ([]CopiedPage, error) {
+	query := url.Values{}
+	query.Set("expand", "body.storage,space,metadata.labels")
+	var source ConfluencePage
+	if err := c.getJSON(ctx, "/content/"+sourceID, query, &source); err != nil {
+		return nil, fmt.Errorf("failed to retrieve page %s: %w", sourceID, err)
+	}
+
+	var children []ContentSummary
+	if opts.IncludeChildren {
+		var err error
+		if children, err = c.listChildPages(ctx, sourceID); err != nil {
+			return nil, fmt.Errorf("failed to list children of page %s: %w", sourceID, err)
+		}
+	}
+
+	title := opts.TitlePrefix + source.Title
+	if isRoot && opts.Title != "" {
+		title = opts.Title
+	}
+
+	payload := ConfluencePage{
+		Type:  "page",
+		Title: title,
+		Space: &SpaceRef{Key: spaceKey},
+		Body:  source.Body,
+	}
+	if parentID != "" {
+		payload.Ancestors = []Ancestor{{ID: parentID}}
+	}
+
+	resp, err := c.doRequest(ctx, "POST", "/content", nil, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create copy of page %s: %w", sourceID, err)
+	}
+	var created ConfluencePage
+	if err := json.Unmarshal(resp, &created); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	copied := []CopiedPage{{SourceID: sourceID, ID: created.ID, Title: created.Title}}
+	reportStep(ctx, fmt.Sprintf("Copied %q", created.Title))
+
+	if opts.CopyLabels && source.Metadata != nil && source.Metadata.Labels != nil && len(source.Metadata.Labels.Results) > 0 {
+		if _, err := c.doRequest(ctx, "POST", "/content/"+created.ID+"/label", nil, source.Metadata.Labels.Results); err != nil {
+			return copied, fmt.Errorf("failed to copy labels of page %s: %w", sourceID, err)
+		}
+	}
+
+	if opts.CopyAttachments {
+		attachments, err := c.listAttachments(ctx, sourceID)
+		if err != nil {
+			return copied, fmt.Errorf("failed to list attachments of page %s: %w", sourceID, err)
+		}
+		for _, attachment := range attachments {
+			if err := c.copyAttachment(ctx, attachment, created.ID); err != nil {
+				return copied, fmt.Errorf("failed to copy attachment %s of page %s: %w", attachment.Title, sourceID, err)
+			}
+		}
+	}
+
+	for _, child := range children {
+		childCopies, err := c.copyPageClientSide(ctx, child.ID, created.ID, spaceKey, opts, false)
+		copied = append(copied, childCopies...)
+		if err != nil {
+			return copied, err
+		}
+	}
+
+	return copied, nil
+}
+
+// decodeTinyLink converts the identifier of a /x/ tiny link into a content ID.
+// Tiny links are the little-endian bytes of the ID in URL-safe base64 with trailing zero bytes trimmed.
+func decodeTinyLink(tiny string) (string, error) {
+	encoded := strings.NewReplacer("-", "/", "_", "+").Replace(tiny)
+	if encoded == "" || len(encoded) > 11 {
+		return "", fmt.Errorf("invalid tiny link %q", tiny)
+	}
+	encoded += strings.Repeat("A", 11-len(encoded)) + "="
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid tiny link %q: %w", tiny, err)
+	}
+	return strconv.FormatUint(binary.LittleEndian.Uint64(decoded), 10), nil
+}
+
+// parseConfluenceURL extracts a content reference from a page URL, viewpage.action link, or /x/ tiny link.
+func parseConfluenceURL(raw string) (*ContentRef, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if pageID := u.Query().Get("pageId"); pageID != "" {
+		return &ContentRef{ID: pageID}, nil
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, segment := range segments {
+		rest := segments[i+1:]
+		switch {
+		case segment == "x" && len(rest) == 1:
+			id, err := decodeTinyLink(rest[0])
+			if err != nil {
+				return nil, err
+			}
+			return &ContentRef{ID: id}, nil
+		case segment == "pages" && len(rest) >= 1 && isNumeric(rest[0]):
+			return &ContentRef{ID: rest[0]}, nil
+		case segment == "display" && len(rest) == 2:
+			title, err := url.QueryUnescape(rest[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid page title in URL: %w", err)
+			}
+			return &ContentRef{SpaceKey: rest[0], Title: title, Type: "page"}, nil
+		case segment == "display" && len(rest) == 5:
+			// Blog posts are addressed as /display/SPACE/yyyy/mm/dd/Title.
+			title, err := url.QueryUnescape(rest[4])
+			if err != nil {
+				return nil, fmt.Errorf("invalid blog post title in URL: %w", err)
+			}
+			return &ContentRef{
+				SpaceKey:   rest[0],
+				Title:      title,
+				Type:       "blogpost",
+				PostingDay: strings.Join(rest[1:4], "-"),
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unrecognized Confluence URL: %s", raw)
+}
+
+// isNumeric reports whether value consists only of ASCII digits.
+func isNumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// getRestrictionSets fetches the current read and update restrictions of a content item.
+func (c *ConfluenceClient) getRestrictionSets(ctx context.Context, contentID string) (map[string]*RestrictionSet, error) {
+	query := url.Values{}
+	query.Set("expand", "restrictions.user,restrictions.group")
+	var byOperation map[string]OperationRestrictions
+	if err := c.getJSON(ctx, "/content/"+contentID+"/restriction/byOperation", query, &byOperation); err != nil {
+		return nil, err
+	}
+
+	sets := map[string]*RestrictionSet{}
+	for _, operation := range []string{"read", "update"} {
+		set := &RestrictionSet{Users: []string{}, Groups: []string{}}
+		for _, user := range byOperation[operation].Restrictions.User.Results {
+			set.Users = append(set.Users, user.Username)
+		}
+		for _, group := range byOperation[operation].Restrictions.Group.Results {
+			set.Groups = append(set.Groups, group.Name)
+		}
+		sets[operation] = set
+	}
+	return sets, nil
+}
+
+// applyRestrictionChanges returns a copy of sets with the changes applied.
+func applyRestrictionChanges(sets map[string]*RestrictionSet, changes []RestrictionChange) map[string]*RestrictionSet {
+	result := map[string]*RestrictionSet{}
+	for operation, set := range sets {
+		result[operation] = &RestrictionSet{Users: slices.Clone(set.Users), Groups: slices.Clone(set.Groups)}
+	}
+
+	for _, change := range changes {
+		set, ok := result[change.Operation]
+		if !ok {
+			set = &RestrictionSet{Users: []string{}, Groups: []string{}}
+			result[change.Operation] = set
+		}
+		names := &set.Users
+		if change.Kind == "group" {
+			names = &set.Groups
+		}
+		if change.Action == "add" {
+			if !slices.Contains(*names, change.Name) {
+				*names = append(*names, change.Name)
+			}
+		} else {
+			*names = slices.DeleteFunc(*names, func(name string) bool { return name == change.Name })
+		}
+	}
+	return result
+}
+
+// getPropertyKey extracts the required "key" argument naming a property.
+func getPropertyKey(args map[string]any) (string, error) {
+	key, ok := args["key"].(string)
+	if !ok || key == "" {
+		return "", fmt.Errorf("key is required")
+	}
+	if !isSafePathSegment(key) {
+		return "", fmt.Errorf("invalid key format")
+	}
+	return key, nil
+}
+
+// setProperty creates or updates the property key below basePath (e.g. /content/123/property).
+// When version is zero an existing property is updated to its next version.
+func (c *ConfluenceClient) setProperty(ctx context.Context, basePath, key string, value any, version int) ([]byte, error) {
+	var current Property
+	err := c.getJSON(ctx, basePath+"/"+key, nil, &current)
+	if isStatus(err, http.StatusNotFound) {
+		return c.doRequest(ctx, "POST", basePath, nil, Property{Key: key, Value: value})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		if current.Version == nil {
+			return nil, fmt.Errorf("could not determine current property version from API response")
+		}
+		version = current.Version.Number + 1
+	}
+
+	return c.doRequest(ctx, "PUT", basePath+"/"+key, nil, Property{
+		Key:     key,
+		Value:   value,
+		Version: &Version{Number: version},
+	})
+}
+
+// waitForLongTask polls a long-running task with exponential backoff until it finishes or longTaskTimeout elapses.
+func (c *ConfluenceClient) waitForLongTask(ctx context.Context, taskID string) (*LongTaskStatus, error) {
+	if !isSafePathSegment(taskID) {
+		return nil, fmt.Errorf("invalid task ID format")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, longTaskTimeout)
+	defer cancel()
+
+	interval := longTaskPollInterval
+	for {
+		var status LongTaskStatus
+		if err := c.getJSON(ctx, "/longtask/"+taskID, nil, &status); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("task %s did not finish in time: %w", taskID, ctx.Err())
+			}
+			return nil, err
+		}
+		if status.Finished || status.PercentageComplete >= 100 {
+			return &status, nil
+		}
+		reportProgress(ctx, float64(status.PercentageComplete), 100, fmt.Sprintf("Task %s is %d%% complete", taskID, status.PercentageComplete))
+
+		select {
+		case <-ctx.Done():
+			return &status, fmt.Errorf("task %s did not finish in time: %w", taskID, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, longTaskMaxPollInterval)
+	}
+}
+
+// resolveMentions replaces @username references in text with user link markup, resolving each username to its
+// user key. It returns the converted text with the usernames that were linked and those that could not be resolved.
+func (c *ConfluenceClient) resolveMentions(ctx context.Context, text string) (string, []string, []string, error) {
+	keys := map[string]string{}
+	var mentioned, unresolved []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := match[2]
+		if _, seen := keys[username]; seen {
+			continue
+		}
+
+		query := url.Values{}
+		query.Set("username", username)
+		var user struct {
+			UserKey string `json:"userKey"`
+		}
+		err := c.getJSON(ctx, "/user", query, &user)
+		switch {
+		case err == nil && user.UserKey != "":
+			keys[username] = user.UserKey
+			mentioned = append(mentioned, username)
+		case err == nil || isStatus(err, http.StatusNotFound):
+			keys[username] = ""
+			unresolved = append(unresolved, username)
+		default:
+			return "", nil, nil, fmt.Errorf("failed to resolve user %s: %w", username, err)
+		}
+	}
+
+	converted := mentionPattern.ReplaceAllStringFunc(text, func(m string) string {
+		parts := mentionPattern.FindStringSubmatch(m)
+		key := keys[parts[2]]
+		if key == "" {
+			return m
+		}
+		return parts[1] + `<ac:link><ri:user ri:userkey="` + html.EscapeString(key) + `" /></ac:link>`
+	})
+	return converted, mentioned, unresolved, nil
+}
+
+// followLongTask waits for the long-running task referenced by an API response that started one.
+func (c *ConfluenceClient) followLongTask(ctx context.Context, resp []byte) (*LongTaskStatus, error) {
+	var task LongTask
+	if err := json.Unmarshal(resp, &task); err != nil || task.ID == "" {
+		return nil, fmt.Errorf("could not determine long-running task from response: %s", resp)
+	}
+	return c.waitForLongTask(ctx, task.ID)
+}
+
+// likesPath returns the path of the likes plugin resource for a content item, relative to the site URL.
+// Data Center has no likes endpoints in the core REST API.
+func likesPath(contentID string) string {
+	return "/rest/likes/1.0/content/" + contentID + "/likes"
+}
+
+// countLikes returns the number of likes a content item has.
+func (c *ConfluenceClient) countLikes(ctx context.Context, contentID string) (int, error) {
+	resp, err := c.doRequestAt(ctx, c.siteURL(), "GET", likesPath(contentID), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	var likes struct {
+		Likes []json.RawMessage `json:"likes"`
+	}
+	if err := json.Unmarshal(resp, &likes); err != nil {
+		return 0, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return len(likes.Likes), nil
+}
+
+// getServerInfo reports the version and build of the instance from the application links manifest, which every
+// Data Center version serves, and the cluster state from the upgrade API where the token is allowed to read it.
+func (c *ConfluenceClient) getServerInfo(ctx context.Context) (*ServerInfo, error) {
+	var manifest struct {
+		Version     string          `json:"version"`
+		BuildNumber json.RawMessage `json:"buildNumber"`
+		URL         string          `json:"url"`
+	}
+	if err := c.getJSONAt(ctx, c.siteURL(), "/rest/applinks/1.0/manifest", nil, &manifest); err != nil {
+		return nil, err
+	}
+
+	info := &ServerInfo{
+		Version:     manifest.Version,
+		BuildNumber: strings.Trim(string(manifest.BuildNumber), `"`),
+		BaseURL:     manifest.URL,
+	}
+	if info.BaseURL == "" {
+		info.BaseURL = c.siteURL()
+	}
+
+	// Reading the cluster state needs administrator rights, so a failure is reported rather than returned.
+	cluster, err := c.doRequestAt(ctx, c.siteURL(), "GET", "/rest/zdu/cluster", nil, nil)
+	if err != nil {
+		info.ClusterError = err.Error()
+	} else {
+		info.Cluster = cluster
+	}
+	return info, nil
+}
+
+// healthCapabilities lists the optional REST APIs the health check looks for. Each is probed with a
+// GET request to a path that an instance providing the API answers with anything but 404; for
+// POST-only APIs that is 405.
+var healthCapabilities = []struct {
+	name string
+	path string
+}{
+	{"audit", "/audit"},
+	{"copy", "/content/0/copy"},
+	{"pageHierarchyCopy", "/content/0/pagehierarchy/copy"},
+	{"bodyConversion", "/contentbody/convert/storage"},
+}
+
+// checkHealth verifies that the instance is reachable and accepts the token, then reports its
+// version and capabilities. The error of an unreachable instance or rejected token says what to fix.
+func (c *ConfluenceClient) checkHealth(ctx context.Context) (*HealthReport, error) {
+	report := &HealthReport{BaseURL: c.config.BaseURL, Capabilities: map[string]bool{}}
+
+	credentials, hint := "API token", "check that the personal access token is valid and has not expired"
+	switch {
+	case c.config.Kerberos != nil:
+		credentials, hint = "Kerberos ticket", "check the keytab or credentials cache, and that the service principal of Confluence is correct"
+	case c.config.Token == "":
+		credentials, hint = "username and password", "check them, and that failed logins have not locked the account behind a CAPTCHA"
+	}
+
+	var user struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"displayName"`
+	}
+	if err := c.getJSON(ctx, "/user/current", nil, &user); err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("cannot reach Confluence at %s: %v; check the base URL and that this host can connect to it", c.siteURL(), err)
+		}
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			return nil, fmt.Errorf("Confluence at %s rejected the %s (HTTP 401); %s", c.siteURL(), credentials, hint)
+		case http.StatusForbidden:
+			return nil, fmt.Errorf("Confluence at %s denied the %s access to the REST API (HTTP 403); check that the user is active and allowed to use Confluence", c.siteURL(), credentials)
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("no Confluence REST API found at %s (HTTP 404); check the base URL, including any context path such as /confluence", c.config.BaseURL)
+		}
+		return nil, fmt.Errorf("checking the %s at %s: %w", credentials, c.siteURL(), err)
+	}
+	// Anonymous access makes /user/current succeed without naming a user.
+	if user.Username == "" {
+		return nil, fmt.Errorf("Confluence at %s treats the %s as anonymous; %s", c.siteURL(), credentials, hint)
+	}
+	report.Username = user.Username
+	report.DisplayName = user.DisplayName
+
+	info, err := c.getServerInfo(ctx)
+	if err != nil {
+		report.VersionError = err.Error()
+	} else {
+		report.Version = info.Version
+		report.BuildNumber = info.BuildNumber
+	}
+
+	for _, capability := range healthCapabilities {
+		resp, err := c.executeRequestAt(ctx, c.config.BaseURL, "GET", capability.path, url.Values{"limit": {"1"}}, nil)
+		if err != nil {
+			continue
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		report.Capabilities[capability.name] = resp.StatusCode != http.StatusNotFound
+	}
+	return report, nil
+}
+
+// getMany fetches the content with each of ids using at most bulkFetchWorkers concurrent requests.
+// The results are in the order of ids; a failure is reported in its result rather than aborting the others.
+func (c *ConfluenceClient) getMany(ctx context.Context, ids []string, query url.Values) []FetchResult {
+	results := make([]FetchResult, len(ids))
+	indexes := make(chan int)
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for range min(bulkFetchWorkers, len(ids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].ID = ids[i]
+				resp, err := c.doRequest(ctx, "GET", "/content/"+ids[i], query, nil)
+				if err != nil {
+					results[i].Error = err.Error()
+				} else {
+					results[i].Content = resp
+				}
+				n := done.Add(1)
+				reportProgress(ctx, float64(n), float64(len(ids)), fmt.Sprintf("Fetched %d of %d", n, len(ids)))
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// bulkLabel adds and removes labels on the content a CQL query matches, up to maxResults pieces, and
+// reports the changes. Labels a piece of content already has are not added again, and labels it does
+// not have are not removed. With dryRun, the changes are reported without being made.
+func (c *ConfluenceClient) bulkLabel(ctx context.Context, cql string, add, remove []string, maxResults int, dryRun bool) (*BulkLabelReport, error) {
+	found, err := c.searchAll(ctx, cql, "content.metadata.labels", maxResults)
+	if err != nil {
+		return nil, err
+	}
+	report := &BulkLabelReport{CQL: cql, DryRun: dryRun, Truncated: found.Truncated, Results: []BulkLabelResult{}}
+	var changes []BulkLabelResult
+	for _, raw := range found.Results {
+		var item struct {
+			Content struct {
+				ID       string    `json:"id"`
+				Title    string    `json:"title"`
+				Metadata *Metadata `json:"metadata"`
+			} `json:"content"`
+		}
+		if json.Unmarshal(raw, &item) != nil || item.Content.ID == "" {
+			continue
+		}
+		report.Matched++
+		var labels []string
+		if item.Content.Metadata != nil && item.Content.Metadata.Labels != nil {
+			for _, label := range item.Content.Metadata.Labels.Results {
+				labels = append(labels, strings.ToLower(label.Name))
+			}
+		}
+		change := BulkLabelResult{ID: item.Content.ID, Title: item.Content.Title}
+		for _, name := range add {
+			if !slices.Contains(labels, strings.ToLower(name)) {
+				change.Added = append(change.Added, name)
+			}
+		}
+		for _, name := range remove {
+			if slices.Contains(labels, strings.ToLower(name)) {
+				change.Removed = append(change.Removed, name)
+			}
+		}
+		if len(change.Added) > 0 || len(change.Removed) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	if dryRun {
+		report.Changed = len(changes)
+		report.Results = append(report.Results, changes...)
+		return report, nil
+	}
+
+	indexes := make(chan int)
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for range min(bulkFetchWorkers, len(changes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				changes[i].Error = c.relabel(ctx, changes[i].ID, changes[i].Added, changes[i].Removed)
+				n := done.Add(1)
+				reportProgress(ctx, float64(n), float64(len(changes)), fmt.Sprintf("Labelled %d of %d", n, len(changes)))
+			}
+		}()
+	}
+	for i := range changes {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, change := range changes {
+		if change.Error != "" {
+			report.Failed++
+		} else {
+			report.Changed++
+		}
+	}
+	report.Results = append(report.Results, changes...)
+	return report, nil
+}
+
+// bulkMove moves pages, in the order given, to be the last children of targetID, so that they keep
+// that order under their new parent. previous and next map each page to the pages right before and
+// after it under its former parent. The moves are made one at a time and stop at the first that
+// fails; the report then has the moves that undo the ones already made.
+func (c *ConfluenceClient) bulkMove(ctx context.Context, pages []ConfluencePage, previous, next map[string]string, targetID string, dryRun bool) *BulkMoveReport {
+	report := &BulkMoveReport{TargetID: targetID, DryRun: dryRun, Results: []BulkMoveResult{}}
+	for i, page := range pages {
+		result := BulkMoveResult{ID: page.ID, Title: page.Title}
+		if len(page.Ancestors) > 0 {
+			result.FromParentID = page.Ancestors[len(page.Ancestors)-1].ID
+		}
+		if page.Space != nil {
+			result.FromSpaceKey = page.Space.Key
+		}
+		result.FromPreviousSiblingID = previous[page.ID]
+		result.FromNextSiblingID = next[page.ID]
+		if !dryRun {
+			if _, err := c.doRequest(ctx, "PUT", "/content/"+page.ID+"/move/append/"+targetID, nil, nil); err != nil {
+				result.Error = fmt.Sprintf("error moving content: %v", err)
+				report.Results = append(report.Results, result)
+				for _, rest := range pages[i+1:] {
+					report.NotMoved = append(report.NotMoved, rest.ID)
+				}
+				break
+			}
+			report.Moved++
+			reportProgress(ctx, float64(i+1), float64(len(pages)), fmt.Sprintf("Moved %d of %d", i+1, len(pages)))
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if n := len(report.Results); n > 0 && report.Results[n-1].Error != "" {
+		report.Rollback = rollbackMoves(report.Results[:n-1])
+	}
+	return report
+}
+
+// rollbackMoves returns the moves that put moved pages back where they were, in the order to make
+// them. A page goes back below its former previous sibling or above its former next sibling once
+// that sibling is in place, which the pages not moved are from the start. A page whose former
+// siblings were all moved goes back as the last child of its former parent, before the others.
+func rollbackMoves(moved []BulkMoveResult) []MoveArgs {
+	pending := map[string]bool{}
+	for _, result := range moved {
+		pending[result.ID] = true
+	}
+	placed := func(id string) bool { return id != "" && !pending[id] }
+
+	var undos []MoveArgs
+	for len(pending) > 0 {
+		progress := false
+		for _, result := range moved {
+			if !pending[result.ID] {
+				continue
+			}
+			undo := MoveArgs{ContentID: result.ID}
+			switch {
+			case placed(result.FromPreviousSiblingID):
+				undo.TargetID, undo.Position = result.FromPreviousSiblingID, "below"
+			case placed(result.FromNextSiblingID):
+				undo.TargetID, undo.Position = result.FromNextSiblingID, "above"
+			case result.FromPreviousSiblingID == "" && result.FromNextSiblingID == "":
+				undo.TargetID, undo.Position = result.FromParentID, "append"
+				if undo.TargetID == "" {
+					// A top level page can only be moved back under the homepage of its space.
+					undo.TargetSpaceKey = result.FromSpaceKey
+				}
+			default:
+				continue
+			}
+			undos = append(undos, undo)
+			delete(pending, result.ID)
+			progress = true
+		}
+		if progress {
+			continue
+		}
+		// The pages left are runs of former siblings that were all moved; the first of each run
+		// was a first child and its parent has no children left, so it can be appended.
+		first := slices.IndexFunc(moved, func(result BulkMoveResult) bool {
+			return pending[result.ID] && result.FromPreviousSiblingID == ""
+		})
+		if first < 0 {
+			first = slices.IndexFunc(moved, func(result BulkMoveResult) bool { return pending[result.ID] })
+		}
+		undos = append(undos, MoveArgs{ContentID: moved[first].ID, TargetID: moved[first].FromParentID, Position: "append"})
+		delete(pending, moved[first].ID)
+	}
+	return undos
+}
+
+// formerSiblings maps each page that has a parent to the children of that parent right before and
+// right after it, for the pages that are not a first or a last child.
+func (c *ConfluenceClient) formerSiblings(ctx context.Context, pages []ConfluencePage) (previous, next map[string]string, err error) {
+	previous, next = map[string]string{}, map[string]string{}
+	children := map[string][]ContentSummary{}
+	for _, page := range pages {
+		if len(page.Ancestors) == 0 {
+			continue
+		}
+		parentID := page.Ancestors[len(page.Ancestors)-1].ID
+		siblings, ok := children[parentID]
+		if !ok {
+			var err error
+			if siblings, err = c.listChildPages(ctx, parentID); err != nil {
+				return nil, nil, fmt.Errorf("failed to list the children of %s: %w", parentID, err)
+			}
+			children[parentID] = siblings
+		}
+		i := slices.IndexFunc(siblings, func(sibling ContentSummary) bool { return sibling.ID == page.ID })
+		if i > 0 {
+			previous[page.ID] = siblings[i-1].ID
+		}
+		if i >= 0 && i < len(siblings)-1 {
+			next[page.ID] = siblings[i+1].ID
+		}
+	}
+	return previous, next, nil
+}
+
+// createTree creates pages, and the pages under each, in spaceKey under parentID, or at the top of
+// the space when parentID is empty. The pages are created one at a time, parents before their
+// children, and the creation stops at the first that fails; when cleanUp is set, the pages created
+// until then are deleted again, children before their parents, so that none of the tree is left.
+func (c *ConfluenceClient) createTree(ctx context.Context, spaceKey, parentID string, pages []TreePage, cleanUp bool) *CreateTreeReport {
+	report := &CreateTreeReport{Created: []CreatedTreePage{}}
+	total := countTreePages(pages)
+	var create func(parentID string, pages []TreePage) bool
+	create = func(parentID string, pages []TreePage) bool {
+		for _, page := range pages {
+			payload := ConfluencePage{
+				Type:  "page",
+				Title: page.Title,
+				Space: &SpaceRef{Key: spaceKey},
+				Body:  &Body{Storage: &BodyStorage{Value: page.Content, Representation: "storage"}},
+			}
+			if parentID != "" {
+				payload.Ancestors = []Ancestor{{ID: parentID}}
+			}
+			result := CreatedTreePage{Title: page.Title, ParentID: parentID}
+			resp, err := c.doRequest(ctx, "POST", "/content", nil, payload)
+			if err == nil {
+				var created ConfluencePage
+				if err = json.Unmarshal(resp, &created); err == nil && created.ID == "" {
+					err = fmt.Errorf("no ID in response")
+				}
+				result.ID = created.ID
+			}
+			if err != nil {
+				result.ID, result.Error = "", fmt.Sprintf("error creating content: %v", err)
+				report.Failed = &result
+				return false
+			}
+			report.Created = append(report.Created, result)
+			reportProgress(ctx, float64(len(report.Created)), float64(total), fmt.Sprintf("Created %d of %d pages", len(report.Created), total))
+			if !create(result.ID, page.Children) {
+				return false
+			}
+		}
+		return true
+	}
+	if create(parentID, pages) || !cleanUp {
+		if report.Failed != nil {
+			report.Leftover = report.Created
+		}
+		return report
+	}
+
+	// The clean-up goes on when the call is cancelled, as that is often what made the creation fail.
+	cleanUpCtx := context.WithoutCancel(ctx)
+	for _, page := range slices.Backward(report.Created) {
+		if _, err := c.doRequest(cleanUpCtx, "DELETE", "/content/"+page.ID, nil, nil); err != nil {
+			page.Error = fmt.Sprintf("error deleting content: %v", err)
+			report.Leftover = append(report.Leftover, page)
+			continue
+		}
+		report.Deleted = append(report.Deleted, page.ID)
+	}
+	return report
+}
+
+// countTreePages returns the number of pages of a tree of pages.
+func countTreePages(pages []TreePage) int {
+	n := len(pages)
+	for _, page := range pages {
+		n += countTreePages(page.Children)
+	}
+	return n
+}
+
+// relabel adds and removes labels of one piece of content, and returns the error that stopped it, if any.
+func (c *ConfluenceClient) relabel(ctx context.Context, contentID string, add, remove []string) string {
+	if len(add) > 0 {
+		payload := make([]Label, 0, len(add))
+		for _, name := range add {
+			payload = append(payload, Label{Prefix: "global", Name: name})
+		}
+		if _, err := c.doRequest(ctx, "POST", "/content/"+contentID+"/label", nil, payload); err != nil {
+			return fmt.Sprintf("error adding labels: %v", err)
+		}
+	}
+	for _, name := range remove {
+		query := url.Values{}
+		query.Set("name", name)
+		if _, err := c.doRequest(ctx, "DELETE", "/content/"+contentID+"/label", query, nil); err != nil {
+			return fmt.Sprintf("error removing label %q: %v", name, err)
+		}
+	}
+	return ""
+}
+
+// completeSpaceKeys returns the keys of the spaces whose key starts with prefix or whose name
+// contains it, ignoring case.
+func (c *ConfluenceClient) completeSpaceKeys(ctx context.Context, prefix string) ([]string, error) {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", completionSpaceLimit))
+	var page struct {
+		Results []struct {
+			Key  string `json:"key"`
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+	if err := c.getJSON(ctx, "/space", query, &page); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, space := range page.Results {
+		if strings.HasPrefix(strings.ToLower(space.Key), strings.ToLower(prefix)) || strings.Contains(strings.ToLower(space.Name), strings.ToLower(prefix)) {
+			keys = append(keys, space.Key)
+		}
+	}
+	return matchCompletions(keys, ""), nil
+}
+
+// completeLabels returns the labels starting with prefix of the most recently modified pages and
+// blog posts, in the space with spaceKey when it is given.
+func (c *ConfluenceClient) completeLabels(ctx context.Context, spaceKey, prefix string) ([]string, error) {
+	cql := "type in (page, blogpost)"
+	if spaceKey != "" {
+		cql += " AND space = " + quoteCQL(spaceKey)
+	}
+	query := url.Values{}
+	query.Set("cql", cql+" ORDER BY lastmodified DESC")
+	query.Set("limit", fmt.Sprintf("%d", completionLabelSources))
+	query.Set("expand", "content.metadata.labels")
+	var page struct {
+		Results []struct {
+			Content struct {
+				Metadata struct {
+					Labels struct {
+						Results []Label `json:"results"`
+					} `json:"labels"`
+				} `json:"metadata"`
+			} `json:"content"`
+		} `json:"results"`
+	}
+	if err := c.getJSON(ctx, "/search", query, &page); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, result := range page.Results {
+		for _, label := range result.Content.Metadata.Labels.Results {
+			labels = append(labels, label.Name)
+		}
+	}
+	return matchCompletions(labels, prefix), nil
+}
+
+// completeTemplateNames returns the names of the page templates that contain text, ignoring case:
+// those of the space with spaceKey when it is given, or else the global ones.
+func (c *ConfluenceClient) completeTemplateNames(ctx context.Context, spaceKey, text string) ([]string, error) {
+	if spaceKey != "" && !isSafePathSegment(spaceKey) {
+		return nil, fmt.Errorf("invalid spaceKey format")
+	}
+	templates, err := c.listPageTemplates(ctx, spaceKey, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, template := range templates {
+		if strings.Contains(strings.ToLower(template.Name), strings.ToLower(text)) {
+			names = append(names, template.Name)
+		}
+	}
+	return matchCompletions(names, ""), nil
+}
+
+// listPageTemplates returns the page templates of the space with spaceKey, or the global ones when
+// it is empty.
+func (c *ConfluenceClient) listPageTemplates(ctx context.Context, spaceKey, expand string) ([]ContentTemplate, error) {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", completionSpaceLimit))
+	if spaceKey != "" {
+		query.Set("spaceKey", spaceKey)
+	}
+	if expand != "" {
+		query.Set("expand", expand)
+	}
+	var page struct {
+		Results []ContentTemplate `json:"results"`
+	}
+	if err := c.getJSON(ctx, "/template/page", query, &page); err != nil {
+		return nil, err
+	}
+	return page.Results, nil
+}
+
+// describeSpaceRemoval summarizes what archiving or deleting a space affects, for the user to confirm.
+// When the space cannot be looked up, the summary names only its key.
+func (c *ConfluenceClient) describeSpaceRemoval(ctx context.Context, spaceKey string, archive bool) string {
+	name := spaceKey
+	var space Space
+	if err := c.getJSON(ctx, "/space/"+spaceKey, nil, &space); err == nil && space.Name != "" {
+		name = fmt.Sprintf("%s (%s)", space.Name, spaceKey)
+	}
+	if archive {
+		return fmt.Sprintf("The space %s will be archived.", name)
+	}
+
+	query := url.Values{}
+	query.Set("cql", "space = "+quoteCQL(spaceKey)+" AND type in (page, blogpost)")
+	query.Set("limit", "1")
+	var page SearchResponse
+	if err := c.getJSON(ctx, "/search", query, &page); err != nil {
+		return fmt.Sprintf("The space %s will be deleted permanently with all of its content.", name)
+	}
+	return fmt.Sprintf("The space %s will be deleted permanently with its %d pages and blog posts.", name, page.TotalSize)
+}
+
+// expandIncludes replaces the include and excerpt-include macros of a storage format body with the
+// body or the excerpt of the pages they name, which are expanded in turn up to maxIncludeDepth levels
+// deep. Macros that name no space look in spaceKey. seen lists the pages being expanded, as
+// space/title, so that a page including itself is not expanded again. Macros whose page cannot be
+// read are kept.
+func (c *ConfluenceClient) expandIncludes(ctx context.Context, storage, spaceKey string, seen []string) string {
+	if len(seen) > maxIncludeDepth {
+		return storage
+	}
+	return replaceMacros(storage, func(name, markup string) string {
+		if name != "include" && name != "excerpt-include" {
+			return markup
+		}
+		node, err := parseStorage(markup)
+		if err != nil || len(node.Children) == 0 {
+			return markup
+		}
+		var page *storageNode
+		for _, param := range node.Children[0].Children {
+			if link := param.child("ac:link"); param.Name == "ac:parameter" && link != nil {
+				page = link.child("ri:page")
+			}
+		}
+		if page == nil || page.Attrs["ri:content-title"] == "" {
+			return markup
+		}
+		space := cmp.Or(page.Attrs["ri:space-key"], spaceKey)
+		key := space + "/" + page.Attrs["ri:content-title"]
+		if slices.Contains(seen, key) {
+			return markup
+		}
+
+		query := url.Values{}
+		query.Set("spaceKey", space)
+		query.Set("title", page.Attrs["ri:content-title"])
+		query.Set("type", "page")
+		query.Set("expand", "body.storage")
+		var found SearchResponse
+		var included struct {
+			Body struct {
+				Storage struct {
+					Value string `json:"value"`
+				} `json:"storage"`
+			} `json:"body"`
+		}
+		if err := c.getJSON(ctx, "/content", query, &found); err != nil || len(found.Results) == 0 ||
+			json.Unmarshal(found.Results[0], &included) != nil {
+			slog.DebugContext(ctx, "included page not expanded", "macro", name, "page", key, "error", err)
+			return markup
+		}
+
+		body := included.Body.Storage.Value
+		if name == "excerpt-include" {
+			body = ""
+			replaceMacros(included.Body.Storage.Value, func(name, markup string) string {
+				if excerpt, ok := macroRichTextBody(markup); ok && name == "excerpt" && body == "" {
+					body = excerpt
+				}
+				return markup
+			})
+		}
+		return c.expandIncludes(ctx, body, space, append(slices.Clip(seen), key))
+	})
+}
+
+// contentEdit is a change of a piece of content that editContent applies to its current version.
+type contentEdit struct {
+	// Title is the new title, or "" to keep the current one.
+	Title string
+	// Body returns the new storage body from the current content, or "" to keep the current body.
+	Body func(current *ConfluencePage) (string, error)
+	// Version is the number of the new version, or 0 for the one after the current version.
+	Version        int
+	VersionComment string
+	// Retries is how often the edit is applied again to a newer version after a version conflict.
+	// Conflicts are not retried when Version is set.
+	Retries int
+	// FailIfChanged stops the retries when someone else changed the body since it was first read.
+	FailIfChanged bool
+}
+
+// editContent reads a piece of content with query and saves it with the edit applied, returning the
+// response of the update.
+func (c *ConfluenceClient) editContent(ctx context.Context, contentID string, query url.Values, edit contentEdit) ([]byte, error) {
+	query.Set("expand", "body.storage,version,space")
+	var initial *ConfluencePage
+
+	// A version conflict means someone saved the page between our read and our write. Unless the
+	// caller pinned the version, the page is read again and the update retried on top of it.
+	for attempt := 0; ; attempt++ {
+		var currentData ConfluencePage
+		if err := c.getJSON(ctx, "/content/"+contentID, query, &currentData); err != nil {
+			return nil, fmt.Errorf("failed to retrieve current content: %v", err)
+		}
+		newVersion := edit.Version
+		if newVersion == 0 {
+			if currentData.Version == nil {
+				return nil, fmt.Errorf("could not determine current version from API response")
+			}
+			newVersion = currentData.Version.Number + 1
+		}
+
+		if initial == nil {
+			initial = &currentData
+		} else if edit.FailIfChanged && storageBody(&currentData) != storageBody(initial) {
+			return nil, fmt.Errorf("content %s was changed by someone else since it was read (now at version %d); read it again before updating",
+				contentID, newVersion-1)
+		}
+
+		payload := ConfluencePage{
+			ID:    contentID,
+			Type:  currentData.Type,
+			Title: cmp.Or(edit.Title, currentData.Title),
+			Space: currentData.Space,
+			Version: &Version{
+				Number:  newVersion,
+				Message: edit.VersionComment,
+			},
+		}
+
+		body := ""
+		if edit.Body != nil {
+			var err error
+			if body, err = edit.Body(&currentData); err != nil {
+				return nil, err
+			}
+		}
+		if body != "" {
+			payload.Body = &Body{
+				Storage: &BodyStorage{
+					Value:          body,
+					Representation: "storage",
+				},
+			}
+		} else if currentData.Body != nil {
+			payload.Body = currentData.Body
+		}
+
+		resp, err := c.doRequest(ctx, "PUT", "/content/"+contentID, nil, payload)
+		var apiErr *APIError
+		if err != nil && edit.Version == 0 && attempt < edit.Retries && errors.As(err, &apiErr) && apiErr.Code() == "version_conflict" {
+			slog.InfoContext(ctx, "version conflict on update, retrying", "contentId", contentID, "version", newVersion, "attempt", attempt+1)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error updating content: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+// bodyRepresentations lists the representations accepted by the content body conversion endpoint.
+var bodyRepresentations = []string{"storage", "view", "editor", "export_view", "styled_view", "wiki"}
+
+// convertBody converts a content body between representations with the server-side converter.
+// contentID, when given, is the page used as context for rendering macros and relative links.
+func (c *ConfluenceClient) convertBody(ctx context.Context, value, from, to, contentID string) (string, error) {
+	var query url.Values
+	if contentID != "" {
+		query = url.Values{}
+		query.Set("pageIdContext", contentID)
+	}
+
+	resp, err := c.doRequest(ctx, "POST", "/contentbody/convert/"+to, query, BodyStorage{Value: value, Representation: from})
+	if err != nil {
+		return "", err
+	}
+
+	var converted BodyStorage
+	if err := json.Unmarshal(resp, &converted); err != nil {
+		return "", fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return converted.Value, nil
+}
+
+// jsonRPCPath is the JSON-RPC endpoint for operations the REST API does not cover, relative to the site URL.
+const jsonRPCPath = "/rpc/json-rpc/confluenceservice-v2"
+
+// getSpacePermissions reports the permissions of a space, preferring the REST "permissions" expansion and
+// falling back to the JSON-RPC API on versions where the expansion is unavailable or empty.
+func (c *ConfluenceClient) getSpacePermissions(ctx context.Context, spaceKey string) (*SpacePermissionReport, error) {
+	report := &SpacePermissionReport{SpaceKey: spaceKey, Permissions: []SpacePermission{}}
+
+	query := url.Values{}
+	query.Set("expand", "permissions")
+	var space struct {
+		Permissions []restSpacePermission `json:"permissions"`
+	}
+	restErr := c.getJSON(ctx, "/space/"+spaceKey, query, &space)
+	if restErr == nil && len(space.Permissions) > 0 {
+		report.Source = "rest"
+		for _, p := range space.Permissions {
+			permission := SpacePermission{
+				Permission: p.Operation.Operation + ":" + p.Operation.TargetType,
+				Users:      []string{},
+				Groups:     []string{},
+				Anonymous:  p.AnonymousAccess,
+			}
+			for _, user := range p.Subjects.User.Results {
+				permission.Users = append(permission.Users, user.Username)
+			}
+			for _, group := range p.Subjects.Group.Results {
+				permission.Groups = append(permission.Groups, group.Name)
+			}
+			report.Permissions = append(report.Permissions, permission)
+		}
+		return report, nil
+	}
+	if restErr != nil && !isStatus(restErr, http.StatusBadRequest, http.StatusNotFound) {
+		return nil, restErr
+	}
+
+	resp, err := c.doRequestAt(ctx, c.siteURL(), "POST", jsonRPCPath+"/getSpacePermissionSets", nil, []string{spaceKey})
+	if err != nil {
+		return nil, fmt.Errorf("JSON-RPC fallback failed: %w", err)
+	}
+	var sets []rpcSpacePermissionSet
+	if err := json.Unmarshal(resp, &sets); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	report.Source = "json-rpc"
+	for _, set := range sets {
+		permission := SpacePermission{Permission: set.Type, Users: []string{}, Groups: []string{}}
+		for _, p := range set.SpacePermissions {
+			switch {
+			case p.UserName != "":
+				permission.Users = append(permission.Users, p.UserName)
+			case p.GroupName != "":
+				permission.Groups = append(permission.Groups, p.GroupName)
+			default:
+				permission.Anonymous = true
+			}
+		}
+		report.Permissions = append(report.Permissions, permission)
+	}
+	return report, nil
+}
+
+// spacePermissionTypes lists the space permissions accepted by the JSON-RPC permission methods.
+var spacePermissionTypes = []string{
+	"VIEWSPACE", "EDITSPACE", "EXPORTPAGE", "SETPAGEPERMISSIONS", "REMOVEPAGE", "EDITBLOG", "REMOVEBLOG",
+	"COMMENT", "REMOVECOMMENT", "CREATEATTACHMENT", "REMOVEATTACHMENT", "REMOVEMAIL", "EXPORTSPACE",
+	"SETSPACEPERMISSIONS", "REMOVEOWNCONTENT",
+}
+
+// changeSpacePermission grants or revokes a single space permission for a user, a group or anonymous users
+// through the JSON-RPC API, as Data Center has no REST endpoint for modifying space permissions.
+func (c *ConfluenceClient) changeSpacePermission(ctx context.Context, grant bool, permission, entity, spaceKey string) error {
+	var method string
+	var params []string
+	switch {
+	case entity == "" && grant:
+		method, params = "addAnonymousPermissionToSpace", []string{permission, spaceKey}
+	case entity == "":
+		method, params = "removeAnonymousPermissionFromSpace", []string{permission, spaceKey}
+	case grant:
+		method, params = "addPermissionToSpace", []string{permission, entity, spaceKey}
+	default:
+		method, params = "removePermissionFromSpace", []string{permission, entity, spaceKey}
+	}
+
+	resp, err := c.doRequestAt(ctx, c.siteURL(), "POST", jsonRPCPath+"/"+method, nil, params)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	if err := json.Unmarshal(resp, &ok); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%s returned false", method)
+	}
+	return nil
+}
+
+// spaceExportTypes maps the export formats accepted by confluence_export_space to JSON-RPC export types.
+var spaceExportTypes = map[string]string{"xml": "TYPE_XML", "html": "TYPE_HTML"}
+
+// exportSpace runs a space export through the JSON-RPC API and returns the URL of the resulting archive.
+// The RPC call only returns once the export task has finished, so it is allowed up to longTaskTimeout
+// instead of the client's usual request timeout.
+func (c *ConfluenceClient) exportSpace(ctx context.Context, spaceKey, exportType string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, longTaskTimeout)
+	defer cancel()
+
+	exporter := c.withHTTPClient(&http.Client{Transport: c.httpClient.Transport})
+
+	stop := reportWhileWaiting(ctx, fmt.Sprintf("Exporting space %s", spaceKey))
+	resp, err := exporter.doRequestAt(ctx, c.siteURL(), "POST", jsonRPCPath+"/exportSpace", nil, []string{spaceKey, exportType})
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("export of space %s did not finish in time: %w", spaceKey, ctx.Err())
+		}
+		return "", err
+	}
+
+	var downloadURL string
+	if err := json.Unmarshal(resp, &downloadURL); err != nil {
+		return "", fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if downloadURL == "" {
+		return "", fmt.Errorf("export returned no download URL")
+	}
+	return downloadURL, nil
+}
+
+// highlightReplacer turns the highlight markers of search excerpts into Markdown emphasis.
+var highlightReplacer = strings.NewReplacer("@@@hl@@@", "**", "@@@endhl@@@", "**")
+
+// compactSearchResults converts raw search results into SearchHits ranked from firstRank.
+func (c *ConfluenceClient) compactSearchResults(results []json.RawMessage, firstRank int) ([]SearchHit, error) {
+	hits := make([]SearchHit, 0, len(results))
+	for i, raw := range results {
+		var item struct {
+			Content struct {
+				ID    string `json:"id"`
+				Type  string `json:"type"`
+				Title string `json:"title"`
+			} `json:"content"`
+			Title      string   `json:"title"`
+			Excerpt    string   `json:"excerpt"`
+			URL        string   `json:"url"`
+			EntityType string   `json:"entityType"`
+			Score      *float64 `json:"score"`
+			Container  struct {
+				Title string `json:"title"`
+			} `json:"resultGlobalContainer"`
+			LastModified string `json:"lastModified"`
+		}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("failed to decode search result: %w", err)
+		}
+
+		hit := SearchHit{
+			Rank:         firstRank + i,
+			Score:        item.Score,
+			ID:           item.Content.ID,
+			Type:         item.Content.Type,
+			Title:        highlightReplacer.Replace(item.Title),
+			Space:        item.Container.Title,
+			URL:          c.webURL(item.URL),
+			Excerpt:      truncateText(highlightReplacer.Replace(strings.Join(strings.Fields(item.Excerpt), " ")), excerptLength),
+			LastModified: item.LastModified,
+		}
+		if hit.Type == "" {
+			hit.Type = item.EntityType
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// siteSearchTypes lists the facets of a site search in display order.
+var siteSearchTypes = []string{"page", "blogpost", "attachment", "comment", "space", "user"}
+
+// siteSearchCQL returns the CQL that finds text of one site search facet.
+func siteSearchCQL(facet, text string) string {
+	switch facet {
+	case "space":
+		return "type = space AND space.title ~ " + quoteCQL(text)
+	case "user":
+		return "type = user AND user.fullname ~ " + quoteCQL(text)
+	default:
+		return "type = " + facet + " AND text ~ " + quoteCQL(text)
+	}
+}
+
+// handleGetContent returns a tool handler for retrieving Confluence content by ID.
+func handleGetContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		outputFormat, _ := args["outputFormat"].(string)
+		if outputFormat != "" && !slices.Contains([]string{"storage", "view", "markdown", "text"}, outputFormat) {
+			return mcp.NewToolResultError("outputFormat must be 'storage', 'view', 'markdown', or 'text'"), nil
+		}
+		maxChars := defaultTextBudget
+		if v, ok := args["maxChars"].(float64); ok {
+			if v < 1 {
+				return mcp.NewToolResultError("maxChars must be a positive number"), nil
+			}
+			maxChars = int(v)
+		}
+		chunked, _ := args["chunked"].(bool)
+		section, _ := args["section"].(string)
+		if section != "" {
+			chunked = true
+		}
+		if chunked && outputFormat == "view" {
+			return mcp.NewToolResultError("chunked retrieval is not available for the view format"), nil
+		}
+		macros, _ := args["macros"].(string)
+		if macros != "" && !slices.Contains([]string{"keep", "strip", "expand"}, macros) {
+			return mcp.NewToolResultError("macros must be 'keep', 'strip', or 'expand'"), nil
+		}
+		if macros != "" && macros != "keep" && outputFormat == "view" {
+			return mcp.NewToolResultError("macros can only be stripped or expanded in the storage format; the view format has them rendered"), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		if macros == "expand" {
+			// Included pages that name no space are in the space of the page.
+			query.Set("expand", ensureExpand(query.Get("expand"), "space"))
+		}
+		if outputFormat == "view" {
+			// The view representation is rendered server-side, with macros such as Jira issues,
+			// excerpts, and includes evaluated.
+			query.Set("expand", ensureExpand(query.Get("expand"), "body.view"))
+		} else {
+			query.Set("expand", ensureExpand(query.Get("expand"), "body.storage"))
+		}
+		if v, ok := args["version"].(float64); ok {
+			if v < 1 {
+				return mcp.NewToolResultError("version must be a positive number"), nil
+			}
+			query.Set("status", "historical")
+			query.Set("version", fmt.Sprintf("%d", int(v)))
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting content: %v", err)), nil
+		}
+
+		if includeLikes, _ := args["includeLikes"].(bool); includeLikes {
+			count, err := client.countLikes(ctx, contentID)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error getting likes: %v", err)), nil
+			}
+			var content map[string]any
+			if err := json.Unmarshal(resp, &content); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to decode content: %v", err)), nil
+			}
+			content["likeCount"] = count
+			if resp, err = json.Marshal(content); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to encode content: %v", err)), nil
+			}
+		}
+
+		if macros == "strip" || macros == "expand" {
+			var page struct {
+				Title string `json:"title"`
+				Space struct {
+					Key string `json:"key"`
+				} `json:"space"`
+			}
+			_ = json.Unmarshal(resp, &page)
+			resp, err = convertStorageBody(resp, "storage", func(storage string) (string, error) {
+				if macros == "strip" {
+					return stripMacros(storage), nil
+				}
+				return client.expandIncludes(ctx, storage, page.Space.Key, []string{page.Space.Key + "/" + page.Title}), nil
+			})
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error converting content: %v", err)), nil
+			}
+		}
+
+		switch {
+		case chunked:
+			resp, err = chunkContent(resp, section, outputFormat == "text")
+		case outputFormat == "markdown":
+			resp, err = convertStorageBody(resp, "markdown", storageToMarkdown)
+		case outputFormat == "text":
+			resp, err = convertStorageBody(resp, "text", func(storage string) (string, error) {
+				text, err := storageToPlainText(storage)
+				return truncateText(text, maxChars), err
+			})
+		}
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error converting content: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleSearchContent returns a tool handler for searching Confluence content using CQL.
+func handleSearchContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		cql, ok := args["cql"].(string)
+		if !ok || cql == "" {
+			return mcp.NewToolResultError("cql must be a string and is required"), nil
+		}
+
+		compact, _ := args["compact"].(bool)
+		excerpt, _ := args["excerpt"].(string)
+		if excerpt == "" && compact {
+			excerpt = "highlight"
+		}
+		if excerpt != "" && excerpt != "highlight" && excerpt != "indexed" && excerpt != "none" {
+			return mcp.NewToolResultError("excerpt must be 'highlight', 'indexed', or 'none'"), nil
+		}
+
+		if fetchAll, _ := args["fetchAll"].(bool); fetchAll {
+			params := url.Values{}
+			if excerpt != "" {
+				params.Set("excerpt", excerpt)
+			}
+			expand, _ := args["expand"].(string)
+			result, err := client.searchAllWithParams(ctx, cql, expand, getMaxResults(args), params)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+			}
+			var out []byte
+			if compact {
+				hits, err := client.compactSearchResults(result.Results, 1)
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
+				out, err = json.Marshal(map[string]any{
+					"cql":       result.CQL,
+					"results":   hits,
+					"size":      result.Size,
+					"totalSize": result.TotalSize,
+					"truncated": result.Truncated,
+				})
+			} else {
+				out, err = json.Marshal(result)
+			}
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+			}
+			return mcp.NewToolResultText(string(out)), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		query.Set("cql", cql)
+		if excerpt != "" {
+			query.Set("excerpt", excerpt)
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+		}
+
+		if compact {
+			var page SearchResponse
+			if err := json.Unmarshal(resp, &page); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to decode search results: %v", err)), nil
+			}
+			hits, err := client.compactSearchResults(page.Results, page.Start+1)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if resp, err = json.Marshal(map[string]any{
+				"results":   hits,
+				"start":     page.Start,
+				"size":      page.Size,
+				"totalSize": page.TotalSize,
+			}); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+			}
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleCreateContent returns a tool handler for creating new content (page or blogpost) in Confluence.
+func handleCreateContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		title, ok := args["title"].(string)
+		if !ok || title == "" {
+			return mcp.NewToolResultError("title is required"), nil
+		}
+		spaceKey, ok := args["spaceKey"].(string)
+		if !ok || spaceKey == "" {
+			return mcp.NewToolResultError("spaceKey is required"), nil
+		}
+		if content, _ := args["content"].(string); content == "" {
+			return mcp.NewToolResultError("content is required"), nil
+		}
+		contentStr, err := client.storageContent(ctx, args, "")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		typeStr, ok := args["type"].(string)
+		if !ok || typeStr == "" {
+			typeStr = "page"
+		}
+
+		parentID, _ := args["parentId"].(string)
+
+		payload := ConfluencePage{
+			Type:  typeStr,
+			Title: title,
+			Space: &SpaceRef{Key: spaceKey},
+			Body: &Body{
+				Storage: &BodyStorage{
+					Value:          contentStr,
+					Representation: "storage",
+				},
+			},
+		}
+
+		if parentID != "" {
+			payload.Ancestors = []Ancestor{{ID: parentID}}
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content", nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error creating content: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleUpdateContent returns a tool handler for updating existing content in Confluence.
+func handleUpdateContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		explicitVersion, _ := args["version"].(float64)
+		retries, err := conflictRetries(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		failIfChanged, _ := args["failIfChanged"].(bool)
+
+		editMode, _ := args["editMode"].(string)
+		if editMode != "" && !slices.Contains([]string{"replace", "append", "prepend"}, editMode) {
+			return mcp.NewToolResultError("editMode must be 'replace', 'append', or 'prepend'"), nil
+		}
+
+		title, _ := args["title"].(string)
+		contentStr, err := client.storageContent(ctx, args, contentID)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if contentStr == "" && (editMode == "append" || editMode == "prepend") {
+			return mcp.NewToolResultError("content is required to append or prepend"), nil
+		}
+		versionComment, _ := args["versionComment"].(string)
+
+		query := newQueryWithCommonArgs(args)
+		resp, err := client.editContent(ctx, contentID, query, contentEdit{
+			Title: title,
+			// Appended and prepended content is added to the body of the version the update is based on.
+			Body: func(current *ConfluencePage) (string, error) {
+				switch editMode {
+				case "append":
+					return storageBody(current) + contentStr, nil
+				case "prepend":
+					return contentStr + storageBody(current), nil
+				}
+				return contentStr, nil
+			},
+			Version:        int(explicitVersion),
+			VersionComment: versionComment,
+			Retries:        retries,
+			FailIfChanged:  failIfChanged,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// conflictRetries returns the maxRetries argument of an update, or defaultConflictRetries.
+func conflictRetries(args map[string]any) (int, error) {
+	v, ok := args["maxRetries"].(float64)
+	if !ok {
+		return defaultConflictRetries, nil
+	}
+	if v < 0 || v > maxConflictRetries {
+		return 0, fmt.Errorf("maxRetries must be between 0 and %d", maxConflictRetries)
+	}
+	return int(v), nil
+}
+
+// handleUpdateSection returns a tool handler for replacing, appending to, or prepending to the
+// section of a page under one heading, leaving the rest of the page as it is.
+func handleUpdateSection(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		section, _ := args["section"].(string)
+		if section == "" {
+			return mcp.NewToolResultError("section is required"), nil
+		}
+		editMode, _ := args["editMode"].(string)
+		if editMode == "" {
+			editMode = "replace"
+		}
+		if !slices.Contains([]string{"replace", "append", "prepend"}, editMode) {
+			return mcp.NewToolResultError("editMode must be 'replace', 'append', or 'prepend'"), nil
+		}
+		retries, err := conflictRetries(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		failIfChanged, _ := args["failIfChanged"].(bool)
+
+		contentStr, err := client.storageContent(ctx, args, contentID)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if contentStr == "" && editMode != "replace" {
+			return mcp.NewToolResultError("content is required to append or prepend"), nil
+		}
+		versionComment, _ := args["versionComment"].(string)
+
+		resp, err := client.editContent(ctx, contentID, url.Values{}, contentEdit{
+			Body: func(current *ConfluencePage) (string, error) {
+				return editSection(current.Title, storageBody(current), section, editMode, contentStr)
+			},
+			VersionComment: versionComment,
+			Retries:        retries,
+			FailIfChanged:  failIfChanged,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// editSection returns a storage format body with the section with the given anchor or heading
+// replaced by content, or with content added at its end or right after its heading.
+func editSection(title, storage, section, editMode, content string) (string, error) {
+	sections, err := headingSections(title, storage)
+	if err != nil {
+		return "", err
+	}
+	i := slices.IndexFunc(sections, func(s storageSection) bool { return s.Anchor == section || s.Heading == section })
+	if i < 0 {
+		if len(sections) == 0 {
+			return "", fmt.Errorf("section %q not found; the page has no headings", section)
+		}
+		anchors := make([]string, len(sections))
+		for j, s := range sections {
+			anchors[j] = s.Anchor
+		}
+		return "", fmt.Errorf("section %q not found; available sections: %s", section, strings.Join(anchors, ", "))
+	}
+
+	found := sections[i]
+	switch editMode {
+	case "append":
+		return storage[:found.End] + content + storage[found.End:], nil
+	case "prepend":
+		return storage[:found.BodyStart] + content + storage[found.BodyStart:], nil
+	}
+	return storage[:found.BodyStart] + content + storage[found.End:], nil
+}
+
+// storageBody returns the storage format body of content, or an empty string when it has none.
+func storageBody(content *ConfluencePage) string {
+	if content.Body == nil || content.Body.Storage == nil {
+		return ""
+	}
+	return content.Body.Storage.Value
+}
+
+// handleListSpaces returns a tool handler for listing/searching Confluence spaces.
+func handleListSpaces(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		searchText, _ := args["searchText"].(string)
+		var cql string
+		if searchText == "" {
+			cql = "type=space"
+		} else {
+			cql = "type=space AND title ~ " + quoteCQL(searchText)
+		}
+		query := newQueryWithCommonArgs(args)
+		query.Set("cql", cql)
+
+		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error listing spaces: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetComments returns a tool handler for listing the footer and inline comments on a piece of content.
+func handleGetComments(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		expand := ensureExpand(query.Get("expand"), "body.storage")
+		expand = ensureExpand(expand, "extensions.inlineProperties")
+		expand = ensureExpand(expand, "extensions.resolution")
+		query.Set("expand", expand)
+		query.Set("depth", "all")
+		query.Add("location", "footer")
+		query.Add("location", "inline")
+		if includeResolved, _ := args["includeResolved"].(bool); includeResolved {
+			query.Add("location", "resolved")
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID+"/child/comment", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting comments: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleAddComment returns a tool handler for posting a footer comment, or a reply to an existing comment.
+func handleAddComment(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		contentStr, ok := args["content"].(string)
+		if !ok || contentStr == "" {
+			return mcp.NewToolResultError("content is required"), nil
+		}
+
+		containerType, ok := args["containerType"].(string)
+		if !ok || containerType == "" {
+			containerType = "page"
+		}
+
+		parentCommentID, _ := args["parentCommentId"].(string)
+
+		payload := Comment{
+			Type:      "comment",
+			Container: &Container{ID: contentID, Type: containerType},
+			Body: &Body{
+				Storage: &BodyStorage{
+					Value:          contentStr,
+					Representation: "storage",
+				},
+			},
+		}
+
+		if parentCommentID != "" {
+			payload.Ancestors = []Ancestor{{ID: parentCommentID}}
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content", nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error adding comment: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleAddInlineComment returns a tool handler for creating an inline comment anchored to a text selection.
+// The selection is wrapped in an inline comment marker in the page body before the comment is created.
+func handleAddInlineComment(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		contentStr, ok := args["content"].(string)
+		if !ok || contentStr == "" {
+			return mcp.NewToolResultError("content is required"), nil
+		}
+		selection, ok := args["selection"].(string)
+		if !ok || selection == "" {
+			return mcp.NewToolResultError("selection is required"), nil
+		}
+
+		matchIndex := 0
+		if v, ok := args["matchIndex"].(float64); ok {
+			if v < 0 {
+				return mcp.NewToolResultError("matchIndex must not be negative"), nil
+			}
+			matchIndex = int(v)
+		}
+
+		markerRef, err := newMarkerRef()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		// The marker is placed in the current body, read again when someone saves the page meanwhile.
+		pageType := ""
+		_, err = client.editContent(ctx, contentID, url.Values{}, contentEdit{
+			Body: func(current *ConfluencePage) (string, error) {
+				if current.Body == nil || current.Body.Storage == nil {
+					return "", fmt.Errorf("could not determine current body from API response")
+				}
+				pageType = current.Type
+				return insertInlineMarker(current.Body.Storage.Value, selection, matchIndex, markerRef)
+			},
+			Retries: defaultConflictRetries,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		payload := Comment{
+			Type:      "comment",
+			Container: &Container{ID: contentID, Type: pageType},
+			Body: &Body{
+				Storage: &BodyStorage{
+					Value:          contentStr,
+					Representation: "storage",
+				},
+			},
+			Extensions: &CommentExtensions{
+				Location: "inline",
+				InlineProperties: &InlineProperties{
+					OriginalSelection: selection,
+					MarkerRef:         markerRef,
+				},
+			},
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content", nil, payload)
+		if err != nil {
+			// Take the marker out again, so that the page is not left with a marker of no comment. Only
+			// this marker is removed, keeping whatever else was saved since it was placed.
+			_, restoreErr := client.editContent(ctx, contentID, url.Values{}, contentEdit{
+				Body: func(current *ConfluencePage) (string, error) {
+					return removeInlineMarker(storageBody(current), markerRef)
+				},
+				Retries: defaultConflictRetries,
+			})
+			if restoreErr != nil && !errors.Is(restoreErr, errMarkerNotFound) {
+				return mcp.NewToolResultError(fmt.Sprintf("error adding inline comment: %v; the inline comment marker could not be removed again: %v", err, restoreErr)), nil
+			}
+			return mcp.NewToolResultError(fmt.Sprintf("error adding inline comment: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetLabels returns a tool handler for listing the labels on a piece of content.
+func handleGetLabels(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		if prefix, ok := args["prefix"].(string); ok && prefix != "" {
+			query.Set("prefix", prefix)
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID+"/label", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting labels: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleAddLabels returns a tool handler for adding one or more labels to a piece of content.
+func handleAddLabels(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		names := getStringList(args, "labels")
+		if len(names) == 0 {
+			return mcp.NewToolResultError("labels is required"), nil
+		}
+
+		payload := make([]Label, 0, len(names))
+		for _, name := range names {
+			payload = append(payload, Label{Prefix: "global", Name: name})
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content/"+contentID+"/label", nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error adding labels: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleRemoveLabel returns a tool handler for removing a label from a piece of content.
+func handleRemoveLabel(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		label, ok := args["label"].(string)
+		if !ok || label == "" {
+			return mcp.NewToolResultError("label is required"), nil
+		}
+
+		// The query parameter form also supports labels containing slashes.
+		query := url.Values{}
+		query.Set("name", label)
+
+		if _, err := client.doRequest(ctx, "DELETE", "/content/"+contentID+"/label", query, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error removing label: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("label %q removed from content %s", label, contentID)), nil
+	}
+}
+
+// handleFindByLabel returns a tool handler for finding content by label without hand-written CQL.
+func handleFindByLabel(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		labels := getStringList(args, "labels")
+		if len(labels) == 0 {
+			return mcp.NewToolResultError("labels is required"), nil
+		}
+
+		quoted := make([]string, 0, len(labels))
+		for _, label := range labels {
+			quoted = append(quoted, quoteCQL(label))
+		}
+		cql := "label in (" + strings.Join(quoted, ", ") + ")"
+
+		if spaceKey, ok := args["spaceKey"].(string); ok && spaceKey != "" {
+			cql += " AND space = " + quoteCQL(spaceKey)
+		}
+		if typeStr, ok := args["type"].(string); ok && typeStr != "" {
+			cql += " AND type = " + quoteCQL(typeStr)
+		}
+
+		expand, _ := args["expand"].(string)
+		result, err := client.searchAll(ctx, cql, expand, getMaxResults(args))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error finding content by label: %v", err)), nil
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleGetChildren returns a tool handler for listing the child pages of a page.
+func handleGetChildren(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		includeExcerpt, _ := args["includeExcerpt"].(bool)
+		if includeExcerpt {
+			query.Set("expand", ensureExpand(query.Get("expand"), "body.storage"))
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID+"/child/page", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting child pages: %v", err)), nil
+		}
+
+		if includeExcerpt {
+			if resp, err = addExcerpts(resp); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error building excerpts: %v", err)), nil
+			}
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetDescendants returns a tool handler for retrieving the page tree below a page as nested JSON.
+func handleGetDescendants(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		depth := defaultTreeDepth
+		if v, ok := args["depth"].(float64); ok {
+			if v < 1 {
+				return mcp.NewToolResultError("depth must be at least 1"), nil
+			}
+			depth = int(v)
+		}
+
+		maxNodes := maxTreeNodes
+		if v, ok := args["maxPages"].(float64); ok && v > 0 && int(v) < maxTreeNodes {
+			maxNodes = int(v)
+		}
+
+		tree, err := client.getDescendantTree(ctx, contentID, depth, maxNodes)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting descendants: %v", err)), nil
+		}
+
+		out, err := json.Marshal(tree)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode page tree: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleMoveContent returns a tool handler for moving a page within its space or to another space.
+func handleMoveContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		position, ok := args["position"].(string)
+		if !ok || position == "" {
+			position = "append"
+		}
+		if position != "append" && position != "above" && position != "below" {
+			return mcp.NewToolResultError("position must be one of append, above, or below"), nil
+		}
+
+		targetID, _ := args["targetId"].(string)
+		targetSpaceKey, _ := args["targetSpaceKey"].(string)
+		if targetID == "" && targetSpaceKey == "" {
+			return mcp.NewToolResultError("targetId or targetSpaceKey is required"), nil
+		}
+
+		if targetID == "" {
+			// Moving to another space places the page under that space's homepage.
+			if position != "append" {
+				return mcp.NewToolResultError("position must be append when moving to a space"), nil
+			}
+			if !isSafePathSegment(targetSpaceKey) {
+				return mcp.NewToolResultError("invalid targetSpaceKey format"), nil
+			}
+			query := url.Values{}
+			query.Set("expand", "homepage")
+			var space Space
+			if err := client.getJSON(ctx, "/space/"+targetSpaceKey, query, &space); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve target space: %v", err)), nil
+			}
+			if space.Homepage == nil || space.Homepage.ID == "" {
+				return mcp.NewToolResultError(fmt.Sprintf("space %s has no homepage to move the page under", targetSpaceKey)), nil
+			}
+			targetID = space.Homepage.ID
+		}
+
+		if !isSafePathSegment(targetID) {
+			return mcp.NewToolResultError("invalid targetId format"), nil
+		}
+
+		resp, err := client.doRequest(ctx, "PUT", "/content/"+contentID+"/move/"+position+"/"+targetID, nil, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error moving content: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleCopyContent returns a tool handler for copying a page, optionally with its children, to a target parent or space.
+// The native copy endpoints are used when available, falling back to recreating the pages client-side.
+func handleCopyContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		targetParentID, _ := args["targetParentId"].(string)
+		targetSpaceKey, _ := args["targetSpaceKey"].(string)
+		if targetParentID == "" && targetSpaceKey == "" {
+			return mcp.NewToolResultError("targetParentId or targetSpaceKey is required"), nil
+		}
+		if !isSafePathSegment(targetParentID) || !isSafePathSegment(targetSpaceKey) {
+			return mcp.NewToolResultError("invalid target format"), nil
+		}
+
+		opts := CopyOptions{CopyLabels: true, CopyAttachments: true}
+		opts.Title, _ = args["title"].(string)
+		opts.TitlePrefix, _ = args["titlePrefix"].(string)
+		opts.IncludeChildren, _ = args["includeChildren"].(bool)
+		if v, ok := args["copyLabels"].(bool); ok {
+			opts.CopyLabels = v
+		}
+		if v, ok := args["copyAttachments"].(bool); ok {
+			opts.CopyAttachments = v
+		}
+
+		homepageTarget := opts.IncludeChildren && targetParentID == ""
+		if homepageTarget {
+			// The hierarchy copy needs a destination page, so copy under the space homepage.
+			query := url.Values{}
+			query.Set("expand", "homepage")
+			var space Space
+			if err := client.getJSON(ctx, "/space/"+targetSpaceKey, query, &space); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve target space: %v", err)), nil
+			}
+			if space.Homepage == nil || space.Homepage.ID == "" {
+				return mcp.NewToolResultError(fmt.Sprintf("space %s has no homepage to copy the pages under", targetSpaceKey)), nil
+			}
+			targetParentID = space.Homepage.ID
+		}
+		if opts.IncludeChildren {
+			// A subtree copied into itself would contain its own copy. A space homepage has no
+			// ancestors, so only an explicit target parent needs looking up.
+			inside := targetParentID == contentID
+			if !inside && !homepageTarget {
+				query := url.Values{}
+				query.Set("expand", "ancestors")
+				var target ConfluencePage
+				if err := client.getJSON(ctx, "/content/"+targetParentID, query, &target); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve target parent: %v", err)), nil
+				}
+				inside = slices.ContainsFunc(target.Ancestors, func(ancestor Ancestor) bool { return ancestor.ID == contentID })
+			}
+			if inside {
+				return mcp.NewToolResultError("the target parent must not be the page being copied or one of its descendants"), nil
+			}
+		}
+
+		var resp []byte
+		if opts.IncludeChildren {
+			payload := CopyPageHierarchyRequest{
+				CopyAttachments:   opts.CopyAttachments,
+				CopyLabels:        opts.CopyLabels,
+				CopyProperties:    true,
+				OriginalPageID:    contentID,
+				DestinationPageID: targetParentID,
+			}
+			if opts.TitlePrefix != "" {
+				payload.TitleOptions = &CopyTitleOptions{Prefix: opts.TitlePrefix}
+			}
+			resp, err = client.doRequest(ctx, "POST", "/content/"+contentID+"/pagehierarchy/copy", nil, payload)
+		} else {
+			payload := CopyPageRequest{
+				CopyAttachments: opts.CopyAttachments,
+				CopyLabels:      opts.CopyLabels,
+				CopyProperties:  true,
+				PageTitle:       opts.Title,
+			}
+			if opts.Title == "" && opts.TitlePrefix != "" {
+				var source ConfluencePage
+				if err := client.getJSON(ctx, "/content/"+contentID, nil, &source); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve source content: %v", err)), nil
+				}
+				payload.PageTitle = opts.TitlePrefix + source.Title
+			}
+			if targetParentID != "" {
+				payload.Destination = CopyDestination{Type: "parent_page", Value: targetParentID}
+			} else {
+				payload.Destination = CopyDestination{Type: "space", Value: targetSpaceKey}
+			}
+			resp, err = client.doRequest(ctx, "POST", "/content/"+contentID+"/copy", nil, payload)
+		}
+		if err == nil {
+			if wait, _ := args["wait"].(bool); wait && opts.IncludeChildren {
+				status, err := client.followLongTask(ctx, resp)
+				if err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("error waiting for copy: %v", err)), nil
+				}
+				if resp, err = json.Marshal(status); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to encode task status: %v", err)), nil
+				}
+			}
+			return mcp.NewToolResultText(string(resp)), nil
+		}
+		if !isStatus(err, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented) {
+			return mcp.NewToolResultError(fmt.Sprintf("error copying content: %v", err)), nil
+		}
+
+		// Older Data Center versions lack the copy endpoints, so recreate the pages instead.
+		if targetSpaceKey == "" {
+			query := url.Values{}
+			query.Set("expand", "space")
+			var parent ConfluencePage
+			if err := client.getJSON(ctx, "/content/"+targetParentID, query, &parent); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve target parent: %v", err)), nil
+			}
+			if parent.Space == nil {
+				return mcp.NewToolResultError("could not determine the space of the target parent"), nil
+			}
+			targetSpaceKey = parent.Space.Key
+		}
+
+		copied, err := client.copyPageClientSide(ctx, contentID, targetParentID, targetSpaceKey, opts, true)
+		out := map[string]any{"method": "client", "copied": copied}
+		if err != nil {
+			out["error"] = err.Error()
+		}
+		outBytes, marshalErr := json.Marshal(out)
+		if marshalErr != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode copy result: %v", marshalErr)), nil
+		}
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error copying content: %s", outBytes)), nil
+		}
+
+		return mcp.NewToolResultText(string(outBytes)), nil
+	}
+}
+
+// handleGetHistory returns a tool handler for listing the version history of a piece of content.
+func handleGetHistory(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		history, err := client.doRequest(ctx, "GET", "/content/"+contentID+"/history", nil, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting history: %v", err)), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		query.Set("expand", ensureExpand(query.Get("expand"), "by"))
+		versions, err := client.doRequest(ctx, "GET", "/content/"+contentID+"/version", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting versions: %v", err)), nil
+		}
+
+		out, err := json.Marshal(map[string]json.RawMessage{
+			"history":  history,
+			"versions": versions,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode history: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleRestoreVersion returns a tool handler for reverting content to a previous version.
+func handleRestoreVersion(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		version, ok := args["version"].(float64)
+		if !ok || version < 1 {
+			return mcp.NewToolResultError("version must be a positive number and is required"), nil
+		}
+		versionComment, ok := args["versionComment"].(string)
+		if !ok || strings.TrimSpace(versionComment) == "" {
+			return mcp.NewToolResultError("versionComment is required"), nil
+		}
+
+		payload := VersionRestoreRequest{
+			OperationKey: "restore",
+			Params: VersionRestoreParams{
+				VersionNumber: int(version),
+				Message:       versionComment,
+			},
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content/"+contentID+"/version", nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error restoring version: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetPageByTitle returns a tool handler for resolving a page by its space key and exact title.
+func handleGetPageByTitle(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, ok := args["spaceKey"].(string)
+		if !ok || spaceKey == "" {
+			return mcp.NewToolResultError("spaceKey is required"), nil
+		}
+		title, ok := args["title"].(string)
+		if !ok || title == "" {
+			return mcp.NewToolResultError("title is required"), nil
+		}
+		typeStr, ok := args["type"].(string)
+		if !ok || typeStr == "" {
+			typeStr = "page"
+		}
+
+		query := url.Values{}
+		query.Set("spaceKey", spaceKey)
+		query.Set("title", title)
+		query.Set("type", typeStr)
+		expand, _ := args["expand"].(string)
+		query.Set("expand", ensureExpand(expand, "body.storage"))
+
+		var page SearchResponse
+		if err := client.getJSON(ctx, "/content", query, &page); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting page by title: %v", err)), nil
+		}
+		if len(page.Results) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("no %s titled %q found in space %s", typeStr, title, spaceKey)), nil
+		}
+
+		return mcp.NewToolResultText(string(page.Results[0])), nil
+	}
+}
+
+// handleResolveURL returns a tool handler for resolving a Confluence URL or tiny link to its content.
+func handleResolveURL(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		rawURL, ok := args["url"].(string)
+		if !ok || rawURL == "" {
+			return mcp.NewToolResultError("url is required"), nil
+		}
+
+		ref, err := parseConfluenceURL(rawURL)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := url.Values{}
+		expand, _ := args["expand"].(string)
+		query.Set("expand", ensureExpand(ensureExpand(expand, "space"), "version"))
+
+		if ref.ID != "" {
+			if !isSafePathSegment(ref.ID) {
+				return mcp.NewToolResultError("invalid content ID in URL"), nil
+			}
+			resp, err := client.doRequest(ctx, "GET", "/content/"+ref.ID, query, nil)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error resolving URL: %v", err)), nil
+			}
+			return mcp.NewToolResultText(string(resp)), nil
+		}
+
+		query.Set("spaceKey", ref.SpaceKey)
+		query.Set("title", ref.Title)
+		query.Set("type", ref.Type)
+		if ref.PostingDay != "" {
+			query.Set("postingDay", ref.PostingDay)
+		}
+
+		var page SearchResponse
+		if err := client.getJSON(ctx, "/content", query, &page); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error resolving URL: %v", err)), nil
+		}
+		if len(page.Results) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("no %s titled %q found in space %s", ref.Type, ref.Title, ref.SpaceKey)), nil
+		}
+
+		return mcp.NewToolResultText(string(page.Results[0])), nil
+	}
+}
+
+// handleGetRestrictions returns a tool handler for reporting the users and groups that may view or edit content.
+func handleGetRestrictions(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		path := "/content/" + contentID + "/restriction/byOperation"
+		if operation, ok := args["operation"].(string); ok && operation != "" {
+			if operation != "read" && operation != "update" {
+				return mcp.NewToolResultError("operation must be read or update"), nil
+			}
+			path += "/" + operation
+		}
+
+		query := url.Values{}
+		query.Set("expand", "restrictions.user,restrictions.group")
+
+		resp, err := client.doRequest(ctx, "GET", path, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting restrictions: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleSetRestrictions returns a tool handler for adding or removing view and edit restrictions on content.
+func handleSetRestrictions(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		action, _ := args["action"].(string)
+		if action != "add" && action != "remove" {
+			return mcp.NewToolResultError("action must be add or remove"), nil
+		}
+		operation, _ := args["operation"].(string)
+		if operation != "read" && operation != "update" {
+			return mcp.NewToolResultError("operation must be read or update"), nil
+		}
+
+		var changes []RestrictionChange
+		for _, user := range getStringList(args, "users") {
+			changes = append(changes, RestrictionChange{Action: action, Operation: operation, Kind: "user", Name: user})
+		}
+		for _, group := range getStringList(args, "groups") {
+			if !isSafePathSegment(group) {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid group name %q", group)), nil
+			}
+			changes = append(changes, RestrictionChange{Action: action, Operation: operation, Kind: "group", Name: group})
+		}
+		if len(changes) == 0 {
+			return mcp.NewToolResultError("at least one of users or groups is required"), nil
+		}
+
+		current, err := client.getRestrictionSets(ctx, contentID)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting restrictions: %v", err)), nil
+		}
+
+		out := map[string]any{
+			"current": current,
+			"changes": changes,
+		}
+
+		if dryRun, _ := args["dryRun"].(bool); dryRun {
+			out["dryRun"] = true
+			out["resulting"] = applyRestrictionChanges(current, changes)
+		} else {
+			method := "PUT"
+			if action == "remove" {
+				method = "DELETE"
+			}
+			for _, change := range changes {
+				path := "/content/" + contentID + "/restriction/byOperation/" + operation
+				var query url.Values
+				if change.Kind == "user" {
+					path += "/user"
+					query = url.Values{}
+					query.Set("userName", change.Name)
+				} else {
+					path += "/group/" + change.Name
+				}
+				if _, err := client.doRequest(ctx, method, path, query, nil); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("error applying %s %s restriction for %s %s: %v", change.Action, operation, change.Kind, change.Name, err)), nil
+				}
+			}
+
+			resulting, err := client.getRestrictionSets(ctx, contentID)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("restrictions applied, but error reading them back: %v", err)), nil
+			}
+			out["resulting"] = resulting
+		}
+
+		outBytes, err := json.Marshal(out)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode restrictions: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(outBytes)), nil
+	}
+}
+
+// handleWatchContent returns a tool handler that adds (watch is true) or removes a content watch for a user.
+func handleWatchContent(client *ConfluenceClient, watch bool) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		// Without a username the watch applies to the user the token belongs to.
+		var query url.Values
+		if username, ok := args["username"].(string); ok && username != "" {
+			query = url.Values{}
+			query.Set("username", username)
+		}
+
+		method, verb := "POST", "watching"
+		if !watch {
+			method, verb = "DELETE", "no longer watching"
+		}
+
+		if _, err := client.doRequest(ctx, method, "/user/watch/content/"+contentID, query, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating watch: %v", err)), nil
+		}
+
+		who := "current user"
+		if query != nil {
+			who = query.Get("username")
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("%s is %s content %s", who, verb, contentID)), nil
+	}
+}
+
+// handleGetWatchers returns a tool handler for listing the users watching a page and its space.
+// Data Center has no REST endpoint for this, so the JSON action used by the watchers dialog is queried.
+func handleGetWatchers(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := url.Values{}
+		query.Set("pageId", contentID)
+
+		var watchers WatcherList
+		if err := client.getJSONAt(ctx, client.siteURL(), "/json/listwatchers.action", query, &watchers); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting watchers: %v", err)), nil
+		}
+		if watchers.PageWatchers == nil {
+			watchers.PageWatchers = []Watcher{}
+		}
+		if watchers.SpaceWatchers == nil {
+			watchers.SpaceWatchers = []Watcher{}
+		}
+
+		out, err := json.Marshal(watchers)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode watchers: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleGetContentProperty returns a tool handler for reading one or all properties of a piece of content.
+func handleGetContentProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		path := "/content/" + contentID + "/property"
+		query := newQueryWithCommonArgs(args)
+		if _, ok := args["key"]; ok {
+			key, err := getPropertyKey(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			path += "/" + key
+			query = nil
+		}
+
+		resp, err := client.doRequest(ctx, "GET", path, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting content property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleSetContentProperty returns a tool handler for creating or updating a content property.
+func handleSetContentProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		key, err := getPropertyKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		value, ok := args["value"]
+		if !ok || value == nil {
+			return mcp.NewToolResultError("value is required"), nil
+		}
+
+		var version int
+		if v, ok := args["version"].(float64); ok {
+			version = int(v)
+		}
+
+		resp, err := client.setProperty(ctx, "/content/"+contentID+"/property", key, value, version)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error setting content property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleDeleteContentProperty returns a tool handler for deleting a content property.
+func handleDeleteContentProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		key, err := getPropertyKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		if result := confirmDestruction(ctx, args, fmt.Sprintf("delete property %q of content %s", key, contentID), func() string {
+			return fmt.Sprintf("The property %q of content %s will be deleted.", key, contentID)
+		}); result != nil {
+			return result, nil
+		}
+
+		if _, err := client.doRequest(ctx, "DELETE", "/content/"+contentID+"/property/"+key, nil, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error deleting content property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("property %q deleted from content %s", key, contentID)), nil
+	}
+}
+
+// handleGetSpaceProperty returns a tool handler for reading one or all properties of a space.
+func handleGetSpaceProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		path := "/space/" + spaceKey + "/property"
+		query := newQueryWithCommonArgs(args)
+		if _, ok := args["key"]; ok {
+			key, err := getPropertyKey(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			path += "/" + key
+			query = nil
+		}
+
+		resp, err := client.doRequest(ctx, "GET", path, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting space property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleSetSpaceProperty returns a tool handler for creating or updating a space property.
+func handleSetSpaceProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		key, err := getPropertyKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		value, ok := args["value"]
+		if !ok || value == nil {
+			return mcp.NewToolResultError("value is required"), nil
+		}
+
+		var version int
+		if v, ok := args["version"].(float64); ok {
+			version = int(v)
+		}
+
+		resp, err := client.setProperty(ctx, "/space/"+spaceKey+"/property", key, value, version)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error setting space property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleDeleteSpaceProperty returns a tool handler for deleting a space property.
+func handleDeleteSpaceProperty(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		key, err := getPropertyKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		if result := confirmDestruction(ctx, args, fmt.Sprintf("delete property %q of space %s", key, spaceKey), func() string {
+			return fmt.Sprintf("The property %q of space %s will be deleted.", key, spaceKey)
+		}); result != nil {
+			return result, nil
+		}
+
+		if _, err := client.doRequest(ctx, "DELETE", "/space/"+spaceKey+"/property/"+key, nil, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error deleting space property: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("property %q deleted from space %s", key, spaceKey)), nil
+	}
+}
+
+// handleCreateSpace returns a tool handler for creating a new space.
+func handleCreateSpace(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		name, ok := args["name"].(string)
+		if !ok || name == "" {
+			return mcp.NewToolResultError("name is required"), nil
+		}
+
+		payload := SpacePayload{Key: spaceKey, Name: name}
+		if description, ok := args["description"].(string); ok && description != "" {
+			payload.Description = &SpaceDescription{
+				Plain: &BodyStorage{Value: description, Representation: "plain"},
+			}
+		}
+
+		// Private spaces are only visible to their creator until permissions are granted.
+		path := "/space"
+		if private, _ := args["private"].(bool); private {
+			path = "/space/_private"
+		}
+
+		resp, err := client.doRequest(ctx, "POST", path, nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error creating space: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleDeleteSpace returns a tool handler for archiving or permanently deleting a space.
+func handleDeleteSpace(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		archive, _ := args["archive"].(bool)
+		action := "delete"
+		if archive {
+			action = "archive"
+		}
+		if result := confirmDestruction(ctx, args, action+" a space", func() string {
+			return client.describeSpaceRemoval(ctx, spaceKey, archive)
+		}); result != nil {
+			return result, nil
+		}
+
+		if archive {
+			var space Space
+			if err := client.getJSON(ctx, "/space/"+spaceKey, nil, &space); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve space: %v", err)), nil
+			}
+			payload := map[string]string{"key": spaceKey, "name": space.Name, "status": "archived"}
+			resp, err := client.doRequest(ctx, "PUT", "/space/"+spaceKey, nil, payload)
+			if isStatus(err, http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented) {
+				return mcp.NewToolResultError(fmt.Sprintf("archiving spaces is not supported by this Confluence instance: %v", err)), nil
+			}
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error archiving space: %v", err)), nil
+			}
+			// Versions without space archiving accept the update but ignore the status.
+			var updated struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(resp, &updated); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to decode JSON: %v", err)), nil
+			}
+			if updated.Status != "archived" {
+				return mcp.NewToolResultError(fmt.Sprintf("archiving spaces is not supported by this Confluence instance: the space status is %q after the update", updated.Status)), nil
+			}
+			return mcp.NewToolResultText(string(resp)), nil
+		}
+
+		resp, err := client.doRequest(ctx, "DELETE", "/space/"+spaceKey, nil, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error deleting space: %v", err)), nil
+		}
+
+		if wait, _ := args["wait"].(bool); !wait {
+			return mcp.NewToolResultText(string(resp)), nil
+		}
+
+		status, err := client.followLongTask(ctx, resp)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error waiting for space deletion: %v", err)), nil
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode task status: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleUpdateSpace returns a tool handler for renaming a space and editing its description or homepage.
+func handleUpdateSpace(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		name, _ := args["name"].(string)
+		description, hasDescription := args["description"].(string)
+		homepageID, _ := args["homepageId"].(string)
+		if name == "" && !hasDescription && homepageID == "" {
+			return mcp.NewToolResultError("at least one of name, description, or homepageId is required"), nil
+		}
+
+		// The space name is mandatory in updates, so keep the current one unless it is being changed.
+		if name == "" {
+			var current Space
+			if err := client.getJSON(ctx, "/space/"+spaceKey, nil, &current); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve current space: %v", err)), nil
+			}
+			name = current.Name
+		}
+
+		payload := SpacePayload{Key: spaceKey, Name: name}
+		if hasDescription {
+			payload.Description = &SpaceDescription{
+				Plain: &BodyStorage{Value: description, Representation: "plain"},
+			}
+		}
+		if homepageID != "" {
+			payload.Homepage = &Ancestor{ID: homepageID}
+		}
+
+		resp, err := client.doRequest(ctx, "PUT", "/space/"+spaceKey, nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating space: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetSpacePermissions returns a tool handler for reporting which users and groups hold which permissions in a space.
+func handleGetSpacePermissions(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		report, err := client.getSpacePermissions(ctx, spaceKey)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting space permissions: %v", err)), nil
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode space permissions: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleChangeSpacePermission returns a tool handler for granting or revoking a space permission.
+func handleChangeSpacePermission(client *ConfluenceClient, grant bool) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		permission, _ := args["permission"].(string)
+		permission = strings.ToUpper(strings.TrimSpace(permission))
+		if !slices.Contains(spacePermissionTypes, permission) {
+			return mcp.NewToolResultError(fmt.Sprintf("permission must be one of %s", strings.Join(spacePermissionTypes, ", "))), nil
+		}
+
+		user, _ := args["user"].(string)
+		group, _ := args["group"].(string)
+		anonymous, _ := args["anonymous"].(bool)
+		set := 0
+		for _, given := range []bool{user != "", group != "", anonymous} {
+			if given {
+				set++
+			}
+		}
+		if set != 1 {
+			return mcp.NewToolResultError("exactly one of user, group or anonymous must be provided"), nil
+		}
+
+		entity, who := user, "user "+user
+		if group != "" {
+			entity, who = group, "group "+group
+		} else if anonymous {
+			who = "anonymous users"
+		}
+
+		if err := client.changeSpacePermission(ctx, grant, permission, entity, spaceKey); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating space permission: %v", err)), nil
+		}
+
+		if grant {
+			return mcp.NewToolResultText(fmt.Sprintf("Granted %s on space %s to %s", permission, spaceKey, who)), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Revoked %s on space %s from %s", permission, spaceKey, who)), nil
+	}
+}
+
+// handleGetSpaceContent returns a tool handler for listing the pages or blog posts of a space in space order.
+func handleGetSpaceContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentType := "page"
+		if t, ok := args["type"].(string); ok && t != "" {
+			contentType = t
+		}
+		if contentType != "page" && contentType != "blogpost" {
+			return mcp.NewToolResultError("type must be 'page' or 'blogpost'"), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		if depth, ok := args["depth"].(string); ok && depth != "" {
+			if depth != "root" && depth != "all" {
+				return mcp.NewToolResultError("depth must be 'root' or 'all'"), nil
+			}
+			query.Set("depth", depth)
+		}
+		includeExcerpt, _ := args["includeExcerpt"].(bool)
+		if includeExcerpt {
+			query.Set("expand", ensureExpand(query.Get("expand"), "body.storage"))
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/space/"+spaceKey+"/content/"+contentType, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting space content: %v", err)), nil
+		}
+
+		if includeExcerpt {
+			if resp, err = addExcerpts(resp); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error building excerpts: %v", err)), nil
+			}
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetSpaceHomepage returns a tool handler for retrieving the homepage of a space with its body.
+func handleGetSpaceHomepage(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := url.Values{}
+		query.Set("expand", "homepage")
+		var space Space
+		if err := client.getJSON(ctx, "/space/"+spaceKey, query, &space); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting space: %v", err)), nil
+		}
+		if space.Homepage == nil || space.Homepage.ID == "" {
+			return mcp.NewToolResultError(fmt.Sprintf("space %s has no homepage", spaceKey)), nil
+		}
+
+		query = url.Values{}
+		expand, _ := args["expand"].(string)
+		query.Set("expand", ensureExpand(ensureExpand(expand, "body.storage"), "version"))
+
+		resp, err := client.doRequest(ctx, "GET", "/content/"+space.Homepage.ID, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting homepage: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleListBlogposts returns a tool handler for listing the blog posts of a space by date range and author.
+func handleListBlogposts(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		dateRange, err := dateRangeCQL(args, "created")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		cql := "type = blogpost AND space = " + quoteCQL(spaceKey) + dateRange
+		if author, ok := args["author"].(string); ok && author != "" {
+			cql += " AND creator = " + quoteCQL(author)
+		}
+		cql += " ORDER BY created DESC"
+
+		result, err := client.searchAll(ctx, cql, "content.history,content.body.storage", getMaxResults(args))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error listing blog posts: %v", err)), nil
+		}
+
+		posts := make([]BlogPostEntry, 0, len(result.Results))
+		for _, raw := range result.Results {
+			var item struct {
+				Content struct {
+					ID      string `json:"id"`
+					Title   string `json:"title"`
+					History struct {
+						CreatedBy struct {
+							Username    string `json:"username"`
+							DisplayName string `json:"displayName"`
+						} `json:"createdBy"`
+						CreatedDate string `json:"createdDate"`
+					} `json:"history"`
+					Body struct {
+						Storage BodyStorage `json:"storage"`
+					} `json:"body"`
+					Links Links `json:"_links"`
+				} `json:"content"`
+				Excerpt string `json:"excerpt"`
+			}
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to decode blog post: %v", err)), nil
+			}
+
+			author := item.Content.History.CreatedBy.DisplayName
+			if author == "" {
+				author = item.Content.History.CreatedBy.Username
+			}
+			excerpt := storageToText(item.Content.Body.Storage.Value)
+			if excerpt == "" {
+				excerpt = storageToText(item.Excerpt)
+			}
+			posts = append(posts, BlogPostEntry{
+				ID:      item.Content.ID,
+				Title:   item.Content.Title,
+				Author:  author,
+				Date:    item.Content.History.CreatedDate,
+				URL:     client.webURL(item.Content.Links.WebUI),
+				Excerpt: truncateText(excerpt, excerptLength),
+			})
+		}
+
+		out, err := json.Marshal(map[string]any{
+			"cql":       result.CQL,
+			"results":   posts,
+			"size":      len(posts),
+			"truncated": result.Truncated,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleListTemplates returns a tool handler for listing the page templates or blueprints of a space or the site.
+func handleListTemplates(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		templateType := "page"
+		if t, ok := args["type"].(string); ok && t != "" {
+			templateType = t
+		}
+		if templateType != "page" && templateType != "blueprint" {
+			return mcp.NewToolResultError("type must be 'page' or 'blueprint'"), nil
+		}
+
+		// Without a space key the global templates are listed.
+		query := newQueryWithCommonArgs(args)
+		if _, ok := args["spaceKey"]; ok {
+			spaceKey, err := getSpaceKey(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			query.Set("spaceKey", spaceKey)
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/template/"+templateType, query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error listing templates: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleCreateFromTemplate returns a tool handler for creating a page from a page or blueprint template.
+func handleCreateFromTemplate(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		templateID, ok := args["templateId"].(string)
+		if !ok || !isNumeric(templateID) {
+			return mcp.NewToolResultError("templateId must be a numeric string and is required"), nil
+		}
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		title, ok := args["title"].(string)
+		if !ok || title == "" {
+			return mcp.NewToolResultError("title is required"), nil
+		}
+
+		values := map[string]string{}
+		if raw, ok := args["variables"].(map[string]any); ok {
+			for name, value := range raw {
+				if str, ok := value.(string); ok {
+					values[name] = str
+				} else {
+					values[name] = fmt.Sprint(value)
+				}
+			}
+		}
+
+		query := url.Values{}
+		query.Set("expand", "body")
+		var template ContentTemplate
+		if err := client.getJSON(ctx, "/template/"+templateID, query, &template); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting template: %v", err)), nil
+		}
+		if template.Body == nil || template.Body.Storage == nil {
+			return mcp.NewToolResultError(fmt.Sprintf("template %s has no storage format body", templateID)), nil
+		}
+
+		body, err := applyTemplateVariables(template.Body.Storage.Value, values)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		payload := ConfluencePage{
+			Type:  "page",
+			Title: title,
+			Space: &SpaceRef{Key: spaceKey},
+			Body: &Body{
+				Storage: &BodyStorage{
+					Value:          body,
+					Representation: "storage",
+				},
+			},
+		}
+		if parentID, _ := args["parentId"].(string); parentID != "" {
+			payload.Ancestors = []Ancestor{{ID: parentID}}
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/content", nil, payload)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error creating content: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleCreateTemplate returns a tool handler for creating a page template in a space or globally.
+func handleCreateTemplate(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		name, ok := args["name"].(string)
+		if !ok || name == "" {
+			return mcp.NewToolResultError("name is required"), nil
+		}
+		content, ok := args["content"].(string)
+		if !ok || content == "" {
+			return mcp.NewToolResultError("content is required"), nil
+		}
+
+		template := ContentTemplate{
+			Name:         name,
+			TemplateType: "page",
+			Body:         &Body{Storage: &BodyStorage{Value: content, Representation: "storage"}},
+		}
+		template.Description, _ = args["description"].(string)
+		// Without a space key the template is created as a global template.
+		if _, ok := args["spaceKey"]; ok {
+			spaceKey, err := getSpaceKey(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			template.Space = &SpaceRef{Key: spaceKey}
+		}
+		for _, label := range getStringList(args, "labels") {
+			template.Labels = append(template.Labels, Label{Prefix: "global", Name: label})
+		}
+
+		resp, err := client.doRequest(ctx, "POST", "/template", nil, template)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error creating template: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleUpdateTemplate returns a tool handler for updating the name, description, or body of a page template.
+func handleUpdateTemplate(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		templateID, ok := args["templateId"].(string)
+		if !ok || !isNumeric(templateID) {
+			return mcp.NewToolResultError("templateId must be a numeric string and is required"), nil
+		}
+
+		query := url.Values{}
+		query.Set("expand", "body")
+		var template ContentTemplate
+		if err := client.getJSON(ctx, "/template/"+templateID, query, &template); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve current template: %v", err)), nil
+		}
+
+		// PUT replaces the template, so unchanged fields are carried over from the current version.
+		template.TemplateID = templateID
+		if name, ok := args["name"].(string); ok && name != "" {
+			template.Name = name
+		}
+		if description, ok := args["description"].(string); ok {
+			template.Description = description
+		}
+		if content, ok := args["content"].(string); ok && content != "" {
+			template.Body = &Body{Storage: &BodyStorage{Value: content, Representation: "storage"}}
+		}
+
+		resp, err := client.doRequest(ctx, "PUT", "/template", nil, template)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating template: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleSearchUsers returns a tool handler for finding users by a partial full name using CQL.
+func handleSearchUsers(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		name, ok := args["query"].(string)
+		if !ok || strings.TrimSpace(name) == "" {
+			return mcp.NewToolResultError("query is required"), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		query.Set("cql", "type = user AND user.fullname ~ "+quoteCQL(strings.TrimSpace(name)))
+
+		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error searching users: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetCurrentUser returns a tool handler for retrieving the user the configured token belongs to.
+func handleGetCurrentUser(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var query url.Values
+		if expand, ok := args["expand"].(string); ok && expand != "" {
+			query = url.Values{}
+			query.Set("expand", expand)
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/user/current", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting current user: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleListGroups returns a tool handler for listing the user groups of the instance.
+func handleListGroups(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/group", newQueryWithCommonArgs(args), nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error listing groups: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetGroupMembers returns a tool handler for listing the members of a group.
+func handleGetGroupMembers(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		groupName, ok := args["groupName"].(string)
+		if !ok || groupName == "" {
+			return mcp.NewToolResultError("groupName is required"), nil
+		}
+
+		// Group names may contain spaces and other characters that need escaping in the path.
+		resp, err := client.doRequest(ctx, "GET", "/group/"+url.PathEscape(groupName)+"/member", newQueryWithCommonArgs(args), nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting group members: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetUserGroups returns a tool handler for listing the groups a user belongs to.
+func handleGetUserGroups(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		username, ok := args["username"].(string)
+		if !ok || username == "" {
+			return mcp.NewToolResultError("username is required"), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		query.Set("username", username)
+
+		resp, err := client.doRequest(ctx, "GET", "/user/memberof", query, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting user groups: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleGetUserContent returns a tool handler for listing content a user created or contributed to within a time window.
+func handleGetUserContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		username, ok := args["username"].(string)
+		if !ok || username == "" {
+			return mcp.NewToolResultError("username is required"), nil
+		}
+
+		var cql string
+		switch role, _ := args["role"].(string); role {
+		case "creator":
+			cql = "creator = " + quoteCQL(username)
+		case "contributor":
+			cql = "contributor = " + quoteCQL(username)
+		case "", "any":
+			cql = "(creator = " + quoteCQL(username) + " OR contributor = " + quoteCQL(username) + ")"
+		default:
+			return mcp.NewToolResultError("role must be 'creator', 'contributor', or 'any'"), nil
+		}
+
+		dateRange, err := dateRangeCQL(args, "lastmodified")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		cql += dateRange
+
+		if spaceKey, ok := args["spaceKey"].(string); ok && spaceKey != "" {
+			cql += " AND space = " + quoteCQL(spaceKey)
+		}
+		if typeStr, ok := args["type"].(string); ok && typeStr != "" {
+			cql += " AND type = " + quoteCQL(typeStr)
+		} else {
+			cql += " AND type in (page, blogpost)"
+		}
+		cql += " ORDER BY lastmodified DESC"
+
+		expand, _ := args["expand"].(string)
+		result, err := client.searchAll(ctx, cql, expand, getMaxResults(args))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting user content: %v", err)), nil
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleConvertMentions returns a tool handler for turning @username references into user mention markup.
+func handleConvertMentions(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		text, ok := args["content"].(string)
+		if !ok || text == "" {
+			return mcp.NewToolResultError("content is required"), nil
+		}
+
+		converted, mentioned, unresolved, err := client.resolveMentions(ctx, text)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error converting mentions: %v", err)), nil
+		}
+
+		out, err := json.Marshal(map[string]any{
+			"content":    converted,
+			"mentioned":  mentioned,
+			"unresolved": unresolved,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode result: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleExportWord returns a tool handler for exporting a page as a Word document.
+// The document is returned as an embedded resource.
+func handleExportWord(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := url.Values{}
+		query.Set("pageId", contentID)
+		stop := reportWhileWaiting(ctx, fmt.Sprintf("Exporting content %s", contentID))
+		doc, err := client.doRequestAt(ctx, client.siteURL(), "GET", "/exportword", query, nil)
+		stop()
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error exporting page: %v", err)), nil
+		}
+
+		return mcp.NewToolResultResource(
+			fmt.Sprintf("Exported content %s as a Word document (%d bytes)", contentID, len(doc)),
+			mcp.BlobResourceContents{
+				URI:      client.siteURL() + "/exportword?" + query.Encode(),
+				MIMEType: "application/msword",
+				Blob:     base64.StdEncoding.EncodeToString(doc),
+			},
+		), nil
+	}
+}
+
+// handleExportSpace returns a tool handler for exporting a whole space as an XML or HTML archive.
+func handleExportSpace(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spaceKey, err := getSpaceKey(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		format := "xml"
+		if f, ok := args["format"].(string); ok && f != "" {
+			format = strings.ToLower(f)
+		}
+		exportType, ok := spaceExportTypes[format]
+		if !ok {
+			return mcp.NewToolResultError("format must be 'xml' or 'html'"), nil
+		}
+
+		downloadURL, err := client.exportSpace(ctx, spaceKey, exportType)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error exporting space: %v", err)), nil
+		}
+
+		out, err := json.Marshal(map[string]string{
+			"spaceKey":    spaceKey,
+			"format":      format,
+			"downloadUrl": downloadURL,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode result: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleLikeContent returns a tool handler for liking or unliking content as the current user.
+func handleLikeContent(client *ConfluenceClient, like bool) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		method, verb := "POST", "Liked"
+		if !like {
+			method, verb = "DELETE", "Unliked"
+		}
+
+		if _, err := client.doRequestAt(ctx, client.siteURL(), method, likesPath(contentID), nil, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating like: %v", err)), nil
+		}
+
+		count, err := client.countLikes(ctx, contentID)
+		if err != nil {
+			return mcp.NewToolResultText(fmt.Sprintf("%s content %s", verb, contentID)), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("%s content %s (%d likes)", verb, contentID, count)), nil
+	}
+}
+
+// favouritesPath is the relation API path linking the current user to their favourite content.
+const favouritesPath = "/relation/favourite/from/user/current/to/content"
+
+// handleFavouriteContent returns a tool handler for adding content to or removing it from the current user's favourites.
+func handleFavouriteContent(client *ConfluenceClient, add bool) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		method, verb := "PUT", "Added content %s to favourites"
+		if !add {
+			method, verb = "DELETE", "Removed content %s from favourites"
+		}
+
+		if _, err := client.doRequest(ctx, method, favouritesPath+"/"+contentID, nil, nil); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error updating favourites: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf(verb, contentID)), nil
+	}
+}
+
+// handleListFavourites returns a tool handler for listing the current user's favourite content.
+func handleListFavourites(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		resp, err := client.doRequest(ctx, "GET", favouritesPath, newQueryWithCommonArgs(args), nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error listing favourites: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleRecentlyUpdated returns a tool handler for listing content modified within the last hours or days.
+func handleRecentlyUpdated(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		hours := 24
+		if h, ok := args["hours"].(float64); ok {
+			hours = int(h)
+		}
+		if d, ok := args["days"].(float64); ok {
+			hours = int(d * 24)
+		}
+		if hours < 1 {
+			return mcp.NewToolResultError("the time window must be at least one hour"), nil
+		}
+
+		cql := fmt.Sprintf(`lastmodified >= now("-%dh")`, hours)
+		if spaces := getStringList(args, "spaces"); len(spaces) > 0 {
+			quoted := make([]string, 0, len(spaces))
+			for _, space := range spaces {
+				quoted = append(quoted, quoteCQL(space))
+			}
+			cql += " AND space in (" + strings.Join(quoted, ", ") + ")"
+		}
+		if contributors := getStringList(args, "contributors"); len(contributors) > 0 {
+			quoted := make([]string, 0, len(contributors))
+			for _, contributor := range contributors {
+				quoted = append(quoted, quoteCQL(contributor))
+			}
+			cql += " AND contributor in (" + strings.Join(quoted, ", ") + ")"
+		}
+		if typeStr, ok := args["type"].(string); ok && typeStr != "" {
+			cql += " AND type = " + quoteCQL(typeStr)
+		} else {
+			cql += " AND type in (page, blogpost)"
+		}
+		cql += " ORDER BY lastmodified DESC"
+
+		result, err := client.searchAll(ctx, cql, "content.space,content.version", getMaxResults(args))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting recently updated content: %v", err)), nil
+		}
+
+		entries := make([]RecentEntry, 0, len(result.Results))
+		for _, raw := range result.Results {
+			var item struct {
+				Content struct {
+					ID    string `json:"id"`
+					Type  string `json:"type"`
+					Title string `json:"title"`
+					Space struct {
+						Key string `json:"key"`
+					} `json:"space"`
+					Version struct {
+						Number int    `json:"number"`
+						When   string `json:"when"`
+						By     struct {
+							Username    string `json:"username"`
+							DisplayName string `json:"displayName"`
+						} `json:"by"`
+					} `json:"version"`
+					Links Links `json:"_links"`
+				} `json:"content"`
+			}
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to decode result: %v", err)), nil
+			}
+
+			modifiedBy := item.Content.Version.By.DisplayName
+			if modifiedBy == "" {
+				modifiedBy = item.Content.Version.By.Username
+			}
+			entries = append(entries, RecentEntry{
+				ID:         item.Content.ID,
+				Type:       item.Content.Type,
+				Title:      item.Content.Title,
+				Space:      item.Content.Space.Key,
+				ModifiedBy: modifiedBy,
+				Modified:   item.Content.Version.When,
+				Version:    item.Content.Version.Number,
+				URL:        client.webURL(item.Content.Links.WebUI),
+			})
+		}
+
+		out, err := json.Marshal(map[string]any{
+			"cql":       result.CQL,
+			"results":   entries,
+			"size":      len(entries),
+			"truncated": result.Truncated,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleGetTasks returns a tool handler for collecting the inline tasks of one page or of every page matching a CQL query.
+func handleGetTasks(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		status, _ := args["status"].(string)
+		switch status {
+		case "":
+			status = "all"
+		case "all", "incomplete", "complete":
+		default:
+			return mcp.NewToolResultError("status must be 'incomplete', 'complete', or 'all'"), nil
+		}
+
+		var pages []ConfluencePage
+		cql, _ := args["cql"].(string)
+		switch {
+		case args["contentId"] != nil:
+			contentID, err := getContentID(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			query := url.Values{}
+			query.Set("expand", "body.storage")
+			var page ConfluencePage
+			if err := client.getJSON(ctx, "/content/"+contentID, query, &page); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error getting content: %v", err)), nil
+			}
+			pages = append(pages, page)
+		case cql != "":
+			result, err := client.searchAll(ctx, cql, "content.body.storage", getMaxResults(args))
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+			}
+			for _, raw := range result.Results {
+				var item struct {
+					Content ConfluencePage `json:"content"`
+				}
+				if err := json.Unmarshal(raw, &item); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to decode result: %v", err)), nil
+				}
+				pages = append(pages, item.Content)
+			}
+		default:
+			return mcp.NewToolResultError("either contentId or cql is required"), nil
+		}
+
+		tasks := []InlineTask{}
+		usernames := map[string]string{}
+		for _, page := range pages {
+			if page.Body == nil || page.Body.Storage == nil {
+				continue
+			}
+			for _, task := range extractTasks(page.Body.Storage.Value) {
+				if status != "all" && task.Status != status {
+					continue
+				}
+				task.ContentID, task.ContentTitle = page.ID, page.Title
+				if task.AssigneeKey != "" {
+					if _, ok := usernames[task.AssigneeKey]; !ok {
+						// Unresolvable keys (e.g. deleted users) are reported without a username.
+						query := url.Values{}
+						query.Set("key", task.AssigneeKey)
+						var user struct {
+							Username string `json:"username"`
+						}
+						_ = client.getJSON(ctx, "/user", query, &user)
+						usernames[task.AssigneeKey] = user.Username
+					}
+					task.Assignee = usernames[task.AssigneeKey]
+				}
+				tasks = append(tasks, task)
+			}
+		}
+
+		out, err := json.Marshal(map[string]any{"tasks": tasks, "size": len(tasks)})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode tasks: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleServerInfo returns a tool handler for reporting the version, build, base URL, and cluster state of the instance.
+func handleServerInfo(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		info, err := client.getServerInfo(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting server info: %v", err)), nil
+		}
+
+		out, err := json.Marshal(info)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode server info: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleHealth returns a tool handler for checking the connection to the instance and reporting its capabilities.
+func handleHealth(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		report, err := client.checkHealth(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("health check failed: %v", err)), nil
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode health report: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleCacheClear returns a tool handler for dropping the cached reference data and responses.
+func handleCacheClear(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(fmt.Sprintf("Cleared %d cached entries", client.clearCaches())), nil
+	}
+}
+
+// handleGetMany returns a tool handler for fetching several pieces of content at once.
+func handleGetMany(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var ids []string
+		for _, id := range getStringList(args, "contentIds") {
+			if !isSafePathSegment(id) {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid contentId format: %s", id)), nil
+			}
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return mcp.NewToolResultError("contentIds is required"), nil
+		}
+		if len(ids) > maxBulkFetch {
+			return mcp.NewToolResultError(fmt.Sprintf("at most %d contentIds can be fetched at once", maxBulkFetch)), nil
+		}
+
+		query := newQueryWithCommonArgs(args)
+		if query.Get("expand") == "" {
+			query.Set("expand", "body.storage,version,space")
+		}
+
+		out, err := json.Marshal(map[string]any{"results": client.getMany(ctx, ids, query)})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// confirmDestruction has the user confirm a destructive operation before it runs. When the client
+// can ask the user (elicitation), it shows them the summary of what will be affected, which it only
+// then computes; otherwise the call has to set the confirm argument. It returns the result to end
+// the call with when the operation is not confirmed, or nil to go ahead.
+func confirmDestruction(ctx context.Context, args map[string]any, action string, summarize func() string) *mcp.CallToolResult {
+	server := mcpserver.ServerFromContext(ctx)
+	session, ok := mcpserver.ClientSessionFromContext(ctx).(mcpserver.SessionWithClientInfo)
+	_, canElicit := session.(mcpserver.SessionWithElicitation)
+	if server == nil || !ok || !canElicit || session.GetClientCapabilities().Elicitation == nil {
+		if confirm, _ := args["confirm"].(bool); !confirm {
+			return mcp.NewToolResultError(fmt.Sprintf("confirm must be set to true to %s", action))
+		}
+		return nil
+	}
+
+	summary := summarize()
+	result, err := server.RequestElicitation(ctx, mcp.ElicitationRequest{Params: mcp.ElicitationParams{
+		Message: summary + " Do you want to continue?",
+		RequestedSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"confirm": map[string]any{"type": "boolean", "title": "Confirm", "description": "Check to " + action},
+			},
+			"required": []string{"confirm"},
+		},
+	}})
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to ask for confirmation: %v", err))
+	}
+	content, _ := result.Content.(map[string]any)
+	if result.Action != mcp.ElicitationResponseActionAccept || content["confirm"] != true {
+		return mcp.NewToolResultError(fmt.Sprintf("the user did not confirm to %s; nothing was changed", action))
+	}
+	return nil
+}
+
+// handleBulkLabel returns a tool handler for adding and removing labels on all the content a CQL query matches.
+func handleBulkLabel(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		cql, ok := args["cql"].(string)
+		if !ok || cql == "" {
+			return mcp.NewToolResultError("cql must be a string and is required"), nil
+		}
+		add, remove := getStringList(args, "add"), getStringList(args, "remove")
+		if len(add) == 0 && len(remove) == 0 {
+			return mcp.NewToolResultError("add or remove is required"), nil
+		}
+		if i := slices.IndexFunc(add, func(name string) bool {
+			return slices.ContainsFunc(remove, func(other string) bool { return strings.EqualFold(name, other) })
+		}); i >= 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("label %q cannot be both added and removed", add[i])), nil
+		}
+		dryRun, _ := args["dryRun"].(bool)
+
+		report, err := client.bulkLabel(ctx, cql, add, remove, getMaxResults(args), dryRun)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+		}
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode report: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleBulkMove returns a tool handler for moving several pages, chosen by ID or by a CQL query,
+// under a new parent page or space while keeping their order.
+func handleBulkMove(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var ids []string
+		for _, id := range getStringList(args, "contentIds") {
+			if !isSafePathSegment(id) {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid contentId format: %s", id)), nil
+			}
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		cql, _ := args["cql"].(string)
+		if (len(ids) == 0) == (cql == "") {
+			return mcp.NewToolResultError("exactly one of contentIds or cql is required"), nil
+		}
+		if len(ids) > maxBulkFetch {
+			return mcp.NewToolResultError(fmt.Sprintf("at most %d contentIds can be moved at once", maxBulkFetch)), nil
+		}
+
+		targetID, _ := args["targetId"].(string)
+		targetSpaceKey, _ := args["targetSpaceKey"].(string)
+		if targetID == "" && targetSpaceKey == "" {
+			return mcp.NewToolResultError("targetId or targetSpaceKey is required"), nil
+		}
+		if targetID == "" {
+			if !isSafePathSegment(targetSpaceKey) {
+				return mcp.NewToolResultError("invalid targetSpaceKey format"), nil
+			}
+			query := url.Values{}
+			query.Set("expand", "homepage")
+			var space Space
+			if err := client.getJSON(ctx, "/space/"+targetSpaceKey, query, &space); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve target space: %v", err)), nil
+			}
+			if space.Homepage == nil || space.Homepage.ID == "" {
+				return mcp.NewToolResultError(fmt.Sprintf("space %s has no homepage to move the pages under", targetSpaceKey)), nil
+			}
+			targetID = space.Homepage.ID
+		}
+		if !isSafePathSegment(targetID) {
+			return mcp.NewToolResultError("invalid targetId format"), nil
+		}
+		dryRun, _ := args["dryRun"].(bool)
+
+		// Every page is read before any is moved, both to record where it was and so that an
+		// unknown ID fails the call without moving the others.
+		var pages []ConfluencePage
+		var skipped []string
+		truncated := false
+		if cql != "" {
+			found, err := client.searchAll(ctx, cql, "content.ancestors,content.space", getMaxResults(args))
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+			}
+			truncated = found.Truncated
+			for _, raw := range found.Results {
+				var item struct {
+					Content ConfluencePage `json:"content"`
+				}
+				if json.Unmarshal(raw, &item) != nil || item.Content.ID == "" {
+					continue
+				}
+				if item.Content.Type != "page" {
+					skipped = append(skipped, item.Content.ID)
+					continue
+				}
+				pages = append(pages, item.Content)
+			}
+		} else {
+			query := url.Values{}
+			query.Set("expand", "ancestors,space")
+			for _, id := range ids {
+				var page ConfluencePage
+				if err := client.getJSON(ctx, "/content/"+id, query, &page); err != nil {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve content %s: %v", id, err)), nil
+				}
+				if page.Type != "page" {
+					return mcp.NewToolResultError(fmt.Sprintf("content %s is a %s; only pages can be moved under a parent", id, page.Type)), nil
+				}
+				pages = append(pages, page)
+			}
+		}
+		if slices.ContainsFunc(pages, func(page ConfluencePage) bool { return page.ID == targetID }) {
+			return mcp.NewToolResultError(fmt.Sprintf("page %s cannot be moved under itself", targetID)), nil
+		}
+
+		previous, next, err := client.formerSiblings(ctx, pages)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		report := client.bulkMove(ctx, pages, previous, next, targetID, dryRun)
+		report.Skipped = skipped
+		report.Truncated = truncated
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode report: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// batchOperations are the operations of confluence_batch, each with the tool it does the work of.
+var batchOperations = map[string]struct {
+	tool    string
+	handler func(*ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+}{
+	"create": {"confluence_create_content", handleCreateContent},
+	"update": {"confluence_update_content", handleUpdateContent},
+	"label":  {"confluence_add_labels", handleAddLabels},
+	"move":   {"confluence_move_content", handleMoveContent},
+}
+
+// resolveBatchReferences returns a copy of args with every string argument of the form $N replaced
+// by the ID of the content of operation N, which has to be among the results so far and have one.
+func resolveBatchReferences(args map[string]any, results []BatchResult) (map[string]any, error) {
+	resolved := make(map[string]any, len(args))
+	for key, value := range args {
+		if str, ok := value.(string); ok {
+			if match := batchReferencePattern.FindStringSubmatch(str); match != nil {
+				n, _ := strconv.Atoi(match[1])
+				if n > len(results) {
+					return nil, fmt.Errorf("%s refers to operation %d, which does not come before this one", key, n)
+				}
+				if results[n-1].ID == "" {
+					return nil, fmt.Errorf("%s refers to operation %d, which has no content ID", key, n)
+				}
+				value = results[n-1].ID
+			}
+		}
+		resolved[key] = value
+	}
+	return resolved, nil
+}
+
+// handleBatch returns a tool handler for running a list of create, update, label, and move operations
+// in one call, in order, stopping at the first failure unless continueOnError is set.
+func handleBatch(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		items, _ := args["operations"].([]any)
+		if len(items) == 0 {
+			return mcp.NewToolResultError("operations is required"), nil
+		}
+		if len(items) > maxBatchOperations {
+			return mcp.NewToolResultError(fmt.Sprintf("a batch can have at most %d operations", maxBatchOperations)), nil
+		}
+		type operation struct {
+			op   string
+			args map[string]any
+		}
+		operations := make([]operation, 0, len(items))
+		server := mcpserver.ServerFromContext(ctx)
+		for i, item := range items {
+			fields, _ := item.(map[string]any)
+			op, _ := fields["op"].(string)
+			kind, ok := batchOperations[op]
+			if !ok {
+				return mcp.NewToolResultError(fmt.Sprintf("operation %d: op must be one of create, update, label, or move", i+1)), nil
+			}
+			// An operation cannot get around a tool filter that leaves its tool out.
+			if server != nil && server.GetTool(kind.tool) == nil {
+				return mcp.NewToolResultError(fmt.Sprintf("operation %d: %s is not enabled", i+1, kind.tool)), nil
+			}
+			opArgs, ok := fields["arguments"].(map[string]any)
+			if !ok {
+				return mcp.NewToolResultError(fmt.Sprintf("operation %d: arguments must be an object", i+1)), nil
+			}
+			operations = append(operations, operation{op: op, args: opArgs})
+		}
+		continueOnError, _ := args["continueOnError"].(bool)
+
+		report := &BatchReport{Results: []BatchResult{}}
+		for i, operation := range operations {
+			result := BatchResult{Operation: i + 1, Op: operation.op, Status: "skipped"}
+			if report.Failed > 0 && !continueOnError {
+				report.Skipped++
+				report.Results = append(report.Results, result)
+				continue
+			}
+
+			kind := batchOperations[operation.op]
+			opArgs, err := resolveBatchReferences(operation.args, report.Results)
+			var out *mcp.CallToolResult
+			if err == nil {
+				out, err = kind.handler(client)(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Name: kind.tool, Arguments: opArgs}})
+			}
+			var text string
+			if out != nil && len(out.Content) > 0 {
+				if content, ok := out.Content[0].(mcp.TextContent); ok {
+					text = content.Text
+				}
+			}
+			switch {
+			case err != nil:
+				result.Status, result.Error = "failed", err.Error()
+			case out.IsError:
+				result.Status, result.Error = "failed", text
+			default:
+				result.Status = "succeeded"
+				var content struct {
+					ID string `json:"id"`
+				}
+				if json.Unmarshal([]byte(text), &content) == nil && content.ID != "" {
+					result.ID = content.ID
+				} else {
+					result.ID, _ = opArgs["contentId"].(string)
+					if json.Valid([]byte(text)) {
+						result.Result = json.RawMessage(text)
+					}
+				}
+			}
+			if result.Status == "failed" {
+				report.Failed++
+			} else {
+				report.Succeeded++
+			}
+			report.Results = append(report.Results, result)
+			reportProgress(ctx, float64(i+1), float64(len(operations)), fmt.Sprintf("Ran %d of %d operations", i+1, len(operations)))
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode report: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleCreateTree returns a tool handler for creating a tree of pages under a parent page in one
+// call, deleting the pages already created, or reporting them, when one of them cannot be created.
+func handleCreateTree(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var pages []TreePage
+		if data, err := json.Marshal(args["pages"]); err != nil || json.Unmarshal(data, &pages) != nil {
+			return mcp.NewToolResultError("pages must be an array of objects with title, content, and children"), nil
+		}
+		if len(pages) == 0 {
+			return mcp.NewToolResultError("pages is required"), nil
+		}
+		if n := countTreePages(pages); n > maxTreePages {
+			return mcp.NewToolResultError(fmt.Sprintf("a tree can have at most %d pages, got %d", maxTreePages, n)), nil
+		}
+
+		onFailure, _ := args["onFailure"].(string)
+		if onFailure == "" {
+			onFailure = "delete"
+		}
+		if onFailure != "delete" && onFailure != "report" {
+			return mcp.NewToolResultError("onFailure must be 'delete' or 'report'"), nil
+		}
+
+		parentID, _ := args["parentId"].(string)
+		spaceKey, _ := args["spaceKey"].(string)
+		if parentID == "" && spaceKey == "" {
+			return mcp.NewToolResultError("parentId or spaceKey is required"), nil
+		}
+		if !isSafePathSegment(parentID) {
+			return mcp.NewToolResultError("invalid parentId format"), nil
+		}
+		if spaceKey == "" {
+			query := url.Values{}
+			query.Set("expand", "space")
+			var parent ConfluencePage
+			if err := client.getJSON(ctx, "/content/"+parentID, query, &parent); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve parent: %v", err)), nil
+			}
+			if parent.Space == nil || parent.Space.Key == "" {
+				return mcp.NewToolResultError(fmt.Sprintf("content %s has no space", parentID)), nil
+			}
+			spaceKey = parent.Space.Key
+		}
+
+		// Every title is checked and every body converted before the first page is created, so that
+		// mistakes in the input fail the call without creating anything. Titles have to be unique
+		// within a space.
+		titles := map[string]bool{}
+		var prepare func(pages []TreePage) error
+		prepare = func(pages []TreePage) error {
+			for i := range pages {
+				page := &pages[i]
+				if page.Title = strings.TrimSpace(page.Title); page.Title == "" {
+					return fmt.Errorf("every page needs a title")
+				}
+				if titles[strings.ToLower(page.Title)] {
+					return fmt.Errorf("the title %q is used more than once", page.Title)
+				}
+				titles[strings.ToLower(page.Title)] = true
+				body, err := client.storageContent(ctx, map[string]any{
+					"content":       page.Content,
+					"contentFormat": args["contentFormat"],
+					"autoEscape":    args["autoEscape"],
+				}, "")
+				if err != nil {
+					return fmt.Errorf("page %q: %w", page.Title, err)
+				}
+				page.Content = body
+				if err := prepare(page.Children); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := prepare(pages); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		report := client.createTree(ctx, spaceKey, parentID, pages, onFailure == "delete")
+		out, err := json.Marshal(report)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode report: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleGetTaskStatus returns a tool handler for checking, or waiting for, a long-running task.
+func handleGetTaskStatus(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		taskID, ok := args["taskId"].(string)
+		if !ok || taskID == "" {
+			return mcp.NewToolResultError("taskId is required"), nil
+		}
+		if !isSafePathSegment(taskID) {
+			return mcp.NewToolResultError("invalid taskId format"), nil
+		}
+
+		if wait, _ := args["wait"].(bool); wait {
+			status, err := client.waitForLongTask(ctx, taskID)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("error waiting for task: %v", err)), nil
+			}
+			out, err := json.Marshal(status)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to encode task status: %v", err)), nil
+			}
+			return mcp.NewToolResultText(string(out)), nil
+		}
+
+		resp, err := client.doRequest(ctx, "GET", "/longtask/"+taskID, nil, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting task status: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(resp)), nil
+	}
+}
+
+// handleValidateCQL returns a tool handler for checking a CQL query without fetching results.
+// An invalid query is reported as a normal result carrying Confluence's parse error, not as a tool error.
+func handleValidateCQL(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		cql, ok := args["cql"].(string)
+		if !ok || cql == "" {
+			return mcp.NewToolResultError("cql must be a string and is required"), nil
+		}
+
+		query := url.Values{}
+		query.Set("cql", cql)
+		query.Set("limit", "0")
+
+		result := map[string]any{"cql": cql}
+		var page SearchResponse
+		err = client.getJSON(ctx, "/search", query, &page)
+		var apiErr *APIError
+		switch {
+		case err == nil:
+			result["valid"] = true
+			result["totalSize"] = page.TotalSize
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest:
+			result["valid"] = false
+			result["message"] = apiErrorMessage(apiErr)
+		default:
+			return mcp.NewToolResultError(fmt.Sprintf("error validating cql: %v", err)), nil
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode result: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleFind returns a tool handler for searching with structured criteria that are assembled into escaped CQL.
+func handleFind(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var clauses []string
+		for _, list := range []struct{ arg, field string }{
+			{"spaceKey", "space"},
+			{"type", "type"},
+			{"label", "label"},
+			{"creator", "creator"},
+		} {
+			if values := getStringList(args, list.arg); len(values) > 0 {
+				clauses = append(clauses, cqlInList(list.field, values))
+			}
+		}
+		for _, contains := range []struct{ arg, field string }{
+			{"titleContains", "title"},
+			{"textContains", "text"},
+		} {
+			if value, ok := args[contains.arg].(string); ok && value != "" {
+				clauses = append(clauses, contains.field+" ~ "+quoteCQL(value))
+			}
+		}
+		for _, bound := range []struct{ arg, op string }{
+			{"modifiedAfter", ">="},
+			{"modifiedBefore", "<="},
+		} {
+			value, ok := args[bound.arg].(string)
+			if !ok || value == "" {
+				continue
+			}
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("%s must be a date in YYYY-MM-DD format", bound.arg)), nil
+			}
+			clauses = append(clauses, "lastmodified "+bound.op+" "+quoteCQL(value))
+		}
+		if len(clauses) == 0 {
+			return mcp.NewToolResultError("at least one search criterion is required"), nil
+		}
+
+		cql := strings.Join(clauses, " AND ")
+		if _, ok := args["textContains"]; !ok {
+			// Without a text query there is no relevance ranking, so show the newest content first.
+			cql += " ORDER BY lastmodified DESC"
+		}
+
+		expand, _ := args["expand"].(string)
+		result, err := client.searchAll(ctx, cql, expand, getMaxResults(args))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleSiteSearch returns a tool handler for searching all kinds of content at once, grouped by type.
+func handleSiteSearch(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		text, ok := args["query"].(string)
+		if !ok || strings.TrimSpace(text) == "" {
+			return mcp.NewToolResultError("query is required"), nil
+		}
+
+		types := getStringList(args, "types")
+		if len(types) == 0 {
+			types = siteSearchTypes
+		}
+		for _, t := range types {
+			if !slices.Contains(siteSearchTypes, t) {
+				return mcp.NewToolResultError(fmt.Sprintf("types must be among %s", strings.Join(siteSearchTypes, ", "))), nil
+			}
+		}
+
+		limit := 5
+		if l, ok := args["limit"].(float64); ok && l > 0 {
+			limit = int(l)
+		}
+		spaceKey, _ := args["spaceKey"].(string)
+
+		// One search per facet gives each type its own count, like the facets of the search screen.
+		// A failing facet is reported in place so the other facets are still returned.
+		facets := make([]SearchFacet, 0, len(types))
+		for _, t := range types {
+			cql := siteSearchCQL(t, text)
+			if spaceKey != "" && t != "user" {
+				cql += " AND space = " + quoteCQL(spaceKey)
+			}
+			query := url.Values{}
+			query.Set("cql", cql)
+			query.Set("limit", fmt.Sprintf("%d", limit))
+			query.Set("excerpt", "highlight")
+
+			facet := SearchFacet{Type: t, Results: []SearchHit{}}
+			var page SearchResponse
+			if err := client.getJSON(ctx, "/search", query, &page); err != nil {
+				facet.Error = err.Error()
+			} else if hits, err := client.compactSearchResults(page.Results, 1); err != nil {
+				facet.Error = err.Error()
+			} else {
+				facet.Results, facet.TotalSize = hits, max(page.TotalSize, len(hits))
+			}
+			facets = append(facets, facet)
+		}
+
+		out, err := json.Marshal(map[string]any{"query": text, "facets": facets})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode results: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleConvertBody returns a tool handler for converting a content body between representations.
+func handleConvertBody(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		value, ok := args["value"].(string)
+		if !ok || value == "" {
+			return mcp.NewToolResultError("value is required"), nil
+		}
+		from, _ := args["from"].(string)
+		to, _ := args["to"].(string)
+		if !slices.Contains(bodyRepresentations, from) || !slices.Contains(bodyRepresentations[:len(bodyRepresentations)-1], to) {
+			return mcp.NewToolResultError(fmt.Sprintf("from must be one of %s and to one of %s",
+				strings.Join(bodyRepresentations, ", "), strings.Join(bodyRepresentations[:len(bodyRepresentations)-1], ", "))), nil
+		}
+
+		var contentID string
+		if _, ok := args["contentId"]; ok {
+			if contentID, err = getContentID(args); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+
+		converted, err := client.convertBody(ctx, value, from, to, contentID)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error converting body: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(converted), nil
+	}
+}
+
+// handleListMacros returns a tool handler for listing the macros that confluence_build_macro can generate.
+func handleListMacros() func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		out, err := json.Marshal(macroCatalog)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode macros: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// handleBuildMacro returns a tool handler for generating the storage format markup of a macro.
+func handleBuildMacro() func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		name, ok := args["name"].(string)
+		if !ok || name == "" {
+			return mcp.NewToolResultError("name is required"), nil
+		}
+		params := map[string]string{}
+		if raw, ok := args["parameters"].(map[string]any); ok {
+			for key, value := range raw {
+				if str, ok := value.(string); ok {
+					params[key] = str
+				} else {
+					params[key] = fmt.Sprint(value)
+				}
+			}
+		}
+		body, _ := args["body"].(string)
+
+		markup, err := buildMacro(name, params, body)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return mcp.NewToolResultText(markup), nil
+	}
+}
+
+// handleExtractTables returns a tool handler for extracting the tables of a page as structured data.
+func handleExtractTables(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		contentID, err := getContentID(args)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		format, _ := args["format"].(string)
+		if format != "" && format != "json" && format != "csv" {
+			return mcp.NewToolResultError("format must be 'json' or 'csv'"), nil
+		}
+
+		query := url.Values{}
+		query.Set("expand", "body.storage")
+		var content ConfluencePage
+		if err := client.getJSON(ctx, "/content/"+contentID, query, &content); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error getting content: %v", err)), nil
+		}
+		var storage string
+		if content.Body != nil && content.Body.Storage != nil {
+			storage = content.Body.Storage.Value
+		}
+
+		tables, err := extractTables(storage)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error extracting tables: %v", err)), nil
+		}
+		if v, ok := args["tableIndex"].(float64); ok {
+			if int(v) < 0 || int(v) >= len(tables) {
+				return mcp.NewToolResultError(fmt.Sprintf("tableIndex %d is out of range: the content has %d tables", int(v), len(tables))), nil
+			}
+			tables = tables[int(v) : int(v)+1]
+		}
+
+		if format == "csv" {
+			out, err := tablesToCSV(tables)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to encode tables: %v", err)), nil
+			}
+			return mcp.NewToolResultText(out), nil
+		}
+
+		if tables == nil {
+			tables = []ExtractedTable{}
+		}
+		out, err := json.Marshal(tables)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encode tables: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	}
+}
+
+// setupServer configures the MCP server and returns it.
+func setupServer(registry *ClientRegistry, config ToolsConfig) *mcpserver.MCPServer {
+	s := mcpserver.NewMCPServer(
+		"atlassian-confluence-dc-go-mcp",
+		version,
+		mcpserver.WithToolCapabilities(true),
+		mcpserver.WithResourceCapabilities(true, false),
+		mcpserver.WithLogging(),
+		mcpserver.WithToolHandlerMiddleware(correlate),
+		mcpserver.WithToolHandlerMiddleware(trackProgress),
+	)
+
+	s.AddResourceTemplate(mcp.NewResourceTemplate(contentURITemplate, "Confluence content",
+		mcp.WithTemplateDescription(fmt.Sprintf("A page or blog post with its storage format body and version, by instance (%s) and content ID. Subscribe to be notified when it changes",
+			strings.Join(registry.names, ", "))),
+		mcp.WithTemplateMIMEType("application/json"),
+	), readContentResource(registry))
+	s.AddResourceTemplate(mcp.NewResourceTemplate(spaceURITemplate, "Confluence space",
+		mcp.WithTemplateDescription("A space with its description and home page, by instance and space key"),
+		mcp.WithTemplateMIMEType("application/json"),
+	), readSpaceResource(registry))
+	s.AddResourceTemplate(mcp.NewResourceTemplate(labelURITemplate, "Confluence label",
+		mcp.WithTemplateDescription("The content most recently modified with a label, by instance and label"),
+		mcp.WithTemplateMIMEType("application/json"),
+	), readLabelResource(registry))
+	s.AddResourceTemplate(mcp.NewResourceTemplate(templateURITemplate, "Confluence template",
+		mcp.WithTemplateDescription("A page template of a space with its body, by instance, space key, and template name"),
+		mcp.WithTemplateMIMEType("application/json"),
+	), readTemplateResource(registry))
+
+	impersonation := slices.ContainsFunc(registry.names, func(name string) bool {
+		config := registry.clients[name].config
+		return config.ImpersonationHeader != "" && len(config.ImpersonationAllowedUsers) > 0
+	})
+
+	if len(registry.names) == 1 {
+		registerTools(withCursorArgs(withImpersonation(impersonation, withFieldSelection(withCompaction(config.Compact, withTruncation(config.MaxResultBytes, config.wrap(s.AddTool)))))), registry.clients[registry.names[0]])
+		return s
+	}
+
+	// With several instances, the tools are registered once per instance and every tool gets an
+	// instance argument that selects which registration handles the call.
+	var order []string
+	tools := map[string]mcp.Tool{}
+	handlers := map[string]map[string]mcpserver.ToolHandlerFunc{}
+	for _, name := range registry.names {
+		registerTools(config.wrap(func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+			if _, ok := tools[tool.Name]; !ok {
+				order = append(order, tool.Name)
+				tools[tool.Name] = tool
+				handlers[tool.Name] = map[string]mcpserver.ToolHandlerFunc{}
+			}
+			handlers[tool.Name][name] = handler
+		}), registry.clients[name])
+	}
+	for _, toolName := range order {
+		tool := tools[toolName]
+		mcp.WithString("instance", mcp.Description(fmt.Sprintf("The Confluence instance to use: %s (default: %s)",
+			strings.Join(registry.names, ", "), registry.names[0])))(&tool)
+		withCursorArgs(withImpersonation(impersonation, withFieldSelection(withCompaction(config.Compact, withTruncation(config.MaxResultBytes, s.AddTool)))))(tool, dispatchInstance(registry, toolName, handlers[toolName]))
+	}
+	return s
+}
+
+// impersonatedUserKey is the context key of the user a tool call acts for.
+type impersonatedUserKey struct{}
+
+// withImpersonation returns a tool registration function that, when enabled, gives every tool an
+// impersonateUser argument and passes its value to the requests of the call before calling add.
+func withImpersonation(enabled bool, add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	if !enabled {
+		return add
+	}
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		mcp.WithString("impersonateUser", mcp.Description("The username of the person this call acts for, so that Confluence attributes the changes to them; one of the users the server allows (default: the configured user, if any)"))(&tool)
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if user, ok := req.GetArguments()["impersonateUser"].(string); ok && user != "" {
+				ctx = context.WithValue(ctx, impersonatedUserKey{}, user)
+			}
+			return handler(ctx, req)
+		})
+	}
+}
+
+// withTruncation returns a tool registration function that gives the read tools maxBytes, maxTokens,
+// and offset arguments and cuts the text of their results to at most limit bytes, or the smaller cap
+// of the call. A cut result ends with a note giving the offset to call the tool again with for the
+// rest. Tools that change something are left alone, as calling them again would repeat the change.
+func withTruncation(limit int, add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		if !readToolPattern.MatchString(tool.Name) {
+			add(tool, handler)
+			return
+		}
+		mcp.WithNumber("maxBytes", mcp.Description("Maximum number of bytes of the result to return; longer results are cut and name the offset to continue from"))(&tool)
+		mcp.WithNumber("maxTokens", mcp.Description("Maximum number of tokens of the result to return, estimated at four bytes each"))(&tool)
+		mcp.WithNumber("offset", mcp.Description("Byte offset to continue a cut result from, as given by its note (default: 0)"))(&tool)
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := req.GetArguments()
+			capBytes := limit
+			if v, ok := args["maxBytes"].(float64); ok && v >= 1 && (capBytes <= 0 || int(v) < capBytes) {
+				capBytes = int(v)
+			}
+			if v, ok := args["maxTokens"].(float64); ok && v >= 1 && (capBytes <= 0 || int(v)*4 < capBytes) {
+				capBytes = int(v) * 4
+			}
+			offset := 0
+			if v, ok := args["offset"].(float64); ok {
+				if v < 0 {
+					return mcp.NewToolResultError("offset must not be negative"), nil
+				}
+				offset = int(v)
+			}
+
+			result, err := handler(ctx, req)
+			if err != nil || result == nil || result.IsError || len(result.Content) == 0 {
+				return result, err
+			}
+			content, ok := result.Content[0].(mcp.TextContent)
+			if !ok || offset == 0 && (capBytes <= 0 || len(content.Text) <= capBytes) {
+				return result, nil
+			}
+			if offset >= len(content.Text) {
+				return mcp.NewToolResultError(fmt.Sprintf("offset %d is beyond the end of the result (%d bytes)", offset, len(content.Text))), nil
+			}
+
+			start, end := cutText(content.Text, offset, capBytes)
+			slog.InfoContext(ctx, "result cut", "tool", tool.Name, "start", start, "end", end, "size", len(content.Text), "limit", capBytes)
+			note := fmt.Sprintf("[End of result: bytes %d-%d of %d]", start, end, len(content.Text))
+			if end < len(content.Text) {
+				note = fmt.Sprintf("[Result cut: bytes %d-%d of %d. Call the tool again with the same arguments and offset %d for the rest]", start, end, len(content.Text), end)
+			}
+			if _, ok := result.StructuredContent.(objectOutput); ok {
+				// Without a known shape, the structured output of a cut result is the part of its text.
+				output := objectOutput{"text": content.Text[start:end]}
+				if end < len(content.Text) {
+					output["nextOffset"] = end
+				}
+				result.StructuredContent = output
+			}
+			content.Text = content.Text[start:end]
+			result.Content[0] = content
+			result.Content = slices.Insert(result.Content, 1, mcp.Content(mcp.NewTextContent(note)))
+			return result, nil
+		})
+	}
+}
+
+// cutText returns the bounds of the part of text that starts at offset and spans at most limit bytes,
+// or the rest of text when limit is not positive. The bounds fall on character boundaries, and the
+// part ends after a line break when there is one in its second half, so the same text is always cut
+// at the same places.
+func cutText(text string, offset, limit int) (int, int) {
+	start := offset
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	if limit <= 0 || start+limit >= len(text) {
+		return start, len(text)
+	}
+	end := start + limit
+	for end > start && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	if i := strings.LastIndexByte(text[start:end], '\n'); i >= (end-start)/2 {
+		end = start + i + 1
+	}
+	return start, end
+}
+
+// boilerplateKeys are the fields of Confluence responses that compact results leave out: the links
+// to REST resources, the names of the fields that could be expanded, and the avatars of users.
+var boilerplateKeys = map[string]bool{"_links": true, "_expandable": true, "profilePicture": true}
+
+// withCompaction returns a tool registration function that gives every tool a compact argument and
+// strips the boilerplate of Confluence responses from the JSON results of the calls that set it, or
+// of every call that does not turn it off when enabled. It runs before truncation, so that cut
+// results are cut from the compact text.
+func withCompaction(enabled bool, add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		// confluence_search_content has a compact argument of its own, which also turns this on.
+		if _, ok := tool.InputSchema.Properties["compact"]; !ok {
+			mcp.WithBoolean("compact", mcp.Description(fmt.Sprintf("Whether to leave links, expandable field names, avatars, and empty fields out of the result (default: %t)", enabled)))(&tool)
+		}
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			compact := enabled
+			if v, ok := req.GetArguments()["compact"].(bool); ok {
+				compact = v
+			}
+			result, err := handler(ctx, req)
+			if !compact || err != nil || result == nil || result.IsError || len(result.Content) == 0 {
+				return result, err
+			}
+			if content, ok := result.Content[0].(mcp.TextContent); ok {
+				if text, ok := compactJSON(content.Text); ok {
+					content.Text = text
+					result.Content[0] = content
+				}
+			}
+			if output, ok := result.StructuredContent.(objectOutput); ok {
+				stripBoilerplate(map[string]any(output))
+			}
+			return result, nil
+		})
+	}
+}
+
+// compactJSON returns the JSON text without the boilerplate of Confluence responses, or false when
+// text is not JSON.
+func compactJSON(text string) (string, bool) {
+	value, ok := decodeResultJSON(text)
+	if !ok {
+		return "", false
+	}
+	return encodeResultJSON(stripBoilerplate(value))
+}
+
+// decodeResultJSON decodes the JSON text of a tool result, keeping the text of numbers, or returns
+// false when text is not JSON.
+func decodeResultJSON(text string) (any, bool) {
+	if !json.Valid([]byte(text)) {
+		return nil, false
+	}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// encodeResultJSON encodes value as the JSON text of a tool result, without escaping the markup in
+// its strings.
+func encodeResultJSON(value any) (string, bool) {
+	var out strings.Builder
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(out.String(), "\n"), true
+}
+
+// stripBoilerplate removes the boilerplate fields from the objects in value, along with the fields
+// that are null or left empty, and returns value.
+func stripBoilerplate(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if boilerplateKeys[key] || field == nil {
+				delete(value, key)
+				continue
+			}
+			if object, ok := stripBoilerplate(field).(map[string]any); ok && len(object) == 0 {
+				delete(value, key)
+			}
+		}
+	case []any:
+		for _, item := range value {
+			stripBoilerplate(item)
+		}
+	}
+	return value
+}
+
+// withFieldSelection returns a tool registration function that gives every tool a fields argument,
+// a comma-separated list of dot paths, and keeps only the fields at those paths in the JSON results
+// of the calls that set it. The nextCursor of a result is always kept, so that it can still be paged.
+func withFieldSelection(add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		mcp.WithString("fields", mcp.Description("Comma-separated dot paths of the fields to return, such as 'id,title,version.number,body.storage.value'; a path through a list selects the field of each item (default: every field)"))(&tool)
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			fields, _ := req.GetArguments()["fields"].(string)
+			paths := parseFieldPaths(fields)
+			result, err := handler(ctx, req)
+			if len(paths) == 0 || err != nil || result == nil || result.IsError || len(result.Content) == 0 {
+				return result, err
+			}
+			paths = append(paths, []string{"nextCursor"})
+			if content, ok := result.Content[0].(mcp.TextContent); ok {
+				if value, ok := decodeResultJSON(content.Text); ok {
+					if text, ok := encodeResultJSON(selectFields(value, paths)); ok {
+						content.Text = text
+						result.Content[0] = content
+					}
+				}
+			}
+			if output, ok := result.StructuredContent.(objectOutput); ok {
+				result.StructuredContent = objectOutput(selectFields(map[string]any(output), paths).(map[string]any))
+			}
+			return result, nil
+		})
+	}
+}
+
+// parseFieldPaths splits a comma-separated list of dot paths into their names.
+func parseFieldPaths(fields string) [][]string {
+	var paths [][]string
+	for _, field := range strings.Split(fields, ",") {
+		var path []string
+		for _, name := range strings.Split(strings.TrimSpace(field), ".") {
+			if name = strings.TrimSpace(name); name != "" {
+				path = append(path, name)
+			}
+		}
+		if len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// selectFields returns the fields of value at the given paths. A list passes the paths on to its
+// items, and a path that goes past a field that is neither an object nor a list selects nothing.
+func selectFields(value any, paths [][]string) any {
+	switch value := value.(type) {
+	case []any:
+		selected := make([]any, 0, len(value))
+		for _, item := range value {
+			selected = append(selected, selectFields(item, paths))
+		}
+		return selected
+	case map[string]any:
+		whole := map[string]bool{}
+		nested := map[string][][]string{}
+		for _, path := range paths {
+			if len(path) == 1 {
+				whole[path[0]] = true
+			} else {
+				nested[path[0]] = append(nested[path[0]], path[1:])
+			}
+		}
+		selected := map[string]any{}
+		for key, field := range value {
+			if whole[key] {
+				selected[key] = field
+				continue
+			}
+			if paths, ok := nested[key]; ok {
+				switch field.(type) {
+				case map[string]any, []any:
+					selected[key] = selectFields(field, paths)
+				}
+			}
+		}
+		return selected
+	}
+	return value
+}
+
+// pageCursor is the state of a paginated tool call that a cursor carries to the next call.
+type pageCursor struct {
+	Tool string         `json:"tool"`
+	Args map[string]any `json:"args"`
+}
+
+// withCursors returns a tool registration function that lets the tools that take a start argument
+// be paged with cursors. Their results name a nextCursor when there are more, which encodes the
+// arguments of the call with the start of the next results; withCursorArgs turns a call with a
+// cursor argument back into those arguments.
+func withCursors(add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		if _, ok := tool.InputSchema.Properties["start"]; !ok {
+			add(tool, handler)
+			return
+		}
+		mcp.WithString("cursor", mcp.Description("The nextCursor of a previous result, to get the results that follow it; the other arguments are taken from the cursor"))(&tool)
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := req.GetArguments()
+			result, err := handler(ctx, req)
+			if err != nil || result == nil || result.IsError || len(result.Content) == 0 {
+				return result, err
+			}
+			content, ok := result.Content[0].(mcp.TextContent)
+			if !ok {
+				return result, nil
+			}
+			next, ok := nextStart(content.Text)
+			if !ok {
+				return result, nil
+			}
+
+			state := pageCursor{Tool: tool.Name, Args: map[string]any{}}
+			for name, value := range args {
+				if name != "cursor" && name != "offset" {
+					state.Args[name] = value
+				}
+			}
+			state.Args["start"] = float64(next)
+			cursor, err := encodeCursor(state)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			text := strings.TrimRightFunc(content.Text, unicode.IsSpace)
+			quoted, _ := json.Marshal(cursor)
+			content.Text = text[:len(text)-1] + `,"nextCursor":` + string(quoted) + "}"
+			result.Content[0] = content
+			switch output := result.StructuredContent.(type) {
+			case ContentListOutput:
+				output.NextCursor = cursor
+				result.StructuredContent = output
+			case objectOutput:
+				output["nextCursor"] = cursor
+			}
+			return result, nil
+		})
+	}
+}
+
+// withCursorArgs returns a tool registration function that replaces the arguments of a call with a
+// cursor argument by those the cursor encodes, for the tools withCursors gave a cursor argument. It
+// goes before the wrappers that read arguments, such as the instance and impersonateUser, so that a
+// cursor continues with every argument of the call that made it.
+func withCursorArgs(add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		if _, ok := tool.InputSchema.Properties["cursor"]; !ok {
+			add(tool, handler)
+			return
+		}
+		add(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if cursor, _ := req.GetArguments()["cursor"].(string); cursor != "" {
+				state, err := decodeCursor(cursor)
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
+				if state.Tool != tool.Name {
+					return mcp.NewToolResultError(fmt.Sprintf("the cursor is for %s, not %s", state.Tool, tool.Name)), nil
+				}
+				req.Params.Arguments = state.Args
+			}
+			return handler(ctx, req)
+		})
+	}
+}
+
+// nextStart returns the start of the results that follow a paginated JSON result, and false when
+// the result is not paginated or has no more results.
+func nextStart(text string) (int, bool) {
+	var page struct {
+		Start     *int `json:"start"`
+		Size      int  `json:"size"`
+		TotalSize int  `json:"totalSize"`
+		Links     struct {
+			Next string `json:"next"`
+		} `json:"_links"`
+	}
+	if err := json.Unmarshal([]byte(text), &page); err != nil || page.Start == nil || page.Size == 0 {
+		return 0, false
+	}
+	next := *page.Start + page.Size
+	return next, page.Links.Next != "" || page.TotalSize > next
+}
+
+// encodeCursor encodes the state of a paginated call as an opaque cursor.
+func encodeCursor(state pageCursor) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes a cursor made by encodeCursor.
+func decodeCursor(cursor string) (pageCursor, error) {
+	var state pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &state) != nil || state.Tool == "" || state.Args == nil {
+		return pageCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return state, nil
+}
+
+// allows reports whether the filter lets a tool through.
+func (f ToolFilter) allows(name string) bool {
+	matches := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		})
+	}
+	return (len(f.Include) == 0 || matches(f.Include)) && !matches(f.Exclude)
+}
+
+// wrap returns a tool registration function that passes only the tools the filter allows to add.
+func (f ToolFilter) wrap(add func(mcp.Tool, mcpserver.ToolHandlerFunc)) func(mcp.Tool, mcpserver.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler mcpserver.ToolHandlerFunc) {
+		if f.allows(tool.Name) {
+			add(tool, handler)
+		}
+	}
+}
+
+// reloadTokens reloads the tokens of the named instances, or of all instances when names is empty,
+// logging each change and failure. A failed reload keeps the previous token.
+func (r *ClientRegistry) reloadTokens(names ...string) {
+	if len(names) == 0 {
+		names = r.names
+	}
+	for _, name := range names {
+		changed, err := r.clients[name].reloadToken()
+		switch {
+		case err != nil:
+			slog.Error("failed to reload the token, keeping the previous one", "instance", name, "error", err)
+		case changed:
+			slog.Info("reloaded the token", "instance", name)
+		}
+	}
+}
+
+// watchCredentials reloads the tokens read from files or commands when the process receives SIGHUP,
+// and a token read from a file whenever that file changes, checking every interval, until ctx is done.
+func watchCredentials(ctx context.Context, registry *ClientRegistry, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// modTimes records when each token file was last seen to change.
+	modTimes := map[string]time.Time{}
+	changedFiles := func() []string {
+		var changed []string
+		for _, name := range registry.names {
+			filename := registry.clients[name].config.TokenFile
+			if filename == "" {
+				continue
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+			if last, ok := modTimes[name]; ok && !info.ModTime().Equal(last) {
+				changed = append(changed, name)
+			}
+			modTimes[name] = info.ModTime()
+		}
+		return changed
+	}
+	changedFiles()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("received SIGHUP, reloading tokens")
+			registry.reloadTokens()
+		case <-ticker.C:
+			if changed := changedFiles(); len(changed) > 0 {
+				registry.reloadTokens(changed...)
+			}
+		}
+	}
+}
+
+// sessionTokenHeader is the HTTP header in which a client of a network transport can send its own
+// Confluence token.
+const sessionTokenHeader = "X-Confluence-Token"
+
+// sessionCredentials holds the tokens MCP sessions supplied for themselves, by session ID and then
+// by instance. The empty instance name stands for every instance.
+type sessionCredentials struct {
+	mu     sync.RWMutex
+	tokens map[string]map[string]string
+}
+
+// sessionTokensKey is the context key of the tokens of the session a tool call belongs to.
+type sessionTokensKey struct{}
+
+// sessionToken returns the token the session of ctx supplied for instance, if any.
+func sessionToken(ctx context.Context, instance string) string {
+	tokens, _ := ctx.Value(sessionTokensKey{}).(map[string]string)
+	if token, ok := tokens[instance]; ok {
+		return token
+	}
+	return tokens[""]
+}
+
+// set stores the token of a session for instance. An empty token removes it.
+func (sc *sessionCredentials) set(sessionID, instance, token string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if token == "" {
+		delete(sc.tokens[sessionID], instance)
+		return
+	}
+	if sc.tokens[sessionID] == nil {
+		sc.tokens[sessionID] = map[string]string{}
+	}
+	sc.tokens[sessionID][instance] = token
+}
+
+// middleware makes the tokens of the calling session available to the requests of a tool call.
+// A token sent in the sessionTokenHeader of the call applies where the session stored none.
+func (sc *sessionCredentials) middleware(next mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		tokens := map[string]string{}
+		if token := req.Header.Get(sessionTokenHeader); token != "" {
+			tokens[""] = token
+		}
+		if session := mcpserver.ClientSessionFromContext(ctx); session != nil {
+			sc.mu.RLock()
+			maps.Copy(tokens, sc.tokens[session.SessionID()])
+			sc.mu.RUnlock()
+		}
+		if len(tokens) > 0 {
+			ctx = context.WithValue(ctx, sessionTokensKey{}, tokens)
+		}
+		return next(ctx, req)
+	}
+}
+
+// enableSessionCredentials lets the sessions of s use their own tokens: sent in the
+// sessionTokenHeader when initializing the session or with each call, or set with the
+// confluence_set_credentials tool. A session's tokens are forgotten when it ends.
+func enableSessionCredentials(s *mcpserver.MCPServer, registry *ClientRegistry) {
+	store := &sessionCredentials{tokens: map[string]map[string]string{}}
+
+	hooks := hooksOf(s)
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		session := mcpserver.ClientSessionFromContext(ctx)
+		if token := message.Header.Get(sessionTokenHeader); token != "" && session != nil {
+			store.set(session.SessionID(), "", token)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session mcpserver.ClientSession) {
+		store.mu.Lock()
+		delete(store.tokens, session.SessionID())
+		store.mu.Unlock()
+	})
+	mcpserver.WithToolHandlerMiddleware(store.middleware)(s)
+
+	options := []mcp.ToolOption{
+		mcp.WithDescription("Set the Confluence personal access token this MCP session acts with, so that its tool calls are performed as the token's user instead of the server's shared account. The token is checked first and kept only until the session ends"),
+		mcp.WithString("token", mcp.Required(), mcp.Description("The personal access token to use; empty to go back to the server's account")),
+	}
+	if len(registry.names) > 1 {
+		options = append(options, mcp.WithString("instance", mcp.Description("The instance to use the token for (default: every instance)"), mcp.Enum(registry.names...)))
+	}
+	s.AddTool(mcp.NewTool("confluence_set_credentials", options...), handleSetCredentials(registry, store))
+}
+
+// handleSetCredentials returns a tool handler for setting the token of the calling session.
+func handleSetCredentials(registry *ClientRegistry, store *sessionCredentials) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		session := mcpserver.ClientSessionFromContext(ctx)
+		if session == nil {
+			return mcp.NewToolResultError("no MCP session to set the credentials for"), nil
+		}
+		token, _ := args["token"].(string)
+		instance, _ := args["instance"].(string)
+		if instance != "" && !slices.Contains(registry.names, instance) {
+			return mcp.NewToolResultError(fmt.Sprintf("unknown instance %q", instance)), nil
+		}
+
+		if token == "" {
+			store.set(session.SessionID(), instance, "")
+			return mcp.NewToolResultText("Removed the session's token; tool calls use the server's account again"), nil
+		}
+
+		// Check the token against the instance it is for, or the default instance.
+		name := instance
+		if name == "" {
+			name = registry.names[0]
+		}
+		var user struct {
+			Username string `json:"username"`
+		}
+		checkCtx := context.WithValue(ctx, sessionTokensKey{}, map[string]string{"": token})
+		if err := registry.clients[name].getJSON(checkCtx, "/user/current", nil, &user); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("error checking token: %v", err)), nil
+		}
+		if user.Username == "" {
+			return mcp.NewToolResultError("Confluence treats the token as anonymous"), nil
+		}
+
+		store.set(session.SessionID(), instance, token)
+		return mcp.NewToolResultText(fmt.Sprintf("Tool calls of this session now act as %s", user.Username)), nil
+	}
+}
+
+// dispatchInstance returns a tool handler that passes each call to the handler of the instance
+// named by the "instance" argument, or of the default instance.
+func dispatchInstance(registry *ClientRegistry, toolName string, handlers map[string]mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, err := getArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		instance, _ := args["instance"].(string)
+		if instance == "" {
+			instance = registry.names[0]
+		}
+		if _, ok := registry.clients[instance]; !ok {
+			return mcp.NewToolResultError(fmt.Sprintf("unknown instance %q: must be one of %s", instance, strings.Join(registry.names, ", "))), nil
+		}
+		handler, ok := handlers[instance]
+		if !ok {
+			return mcp.NewToolResultError(fmt.Sprintf("tool %s is not available on instance %s", toolName, instance)), nil
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Tool annotations, telling clients which tools change Confluence and whether calling one again with
+// the same arguments has any further effect.
+var (
+	// readOnlyTool marks a tool that only reads.
+	readOnlyTool = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+		ReadOnlyHint: mcp.ToBoolPtr(true), DestructiveHint: mcp.ToBoolPtr(false), IdempotentHint: mcp.ToBoolPtr(true), OpenWorldHint: mcp.ToBoolPtr(true),
+	})
+	// additiveTool marks a tool that creates something new on every call.
+	additiveTool = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+		ReadOnlyHint: mcp.ToBoolPtr(false), DestructiveHint: mcp.ToBoolPtr(false), IdempotentHint: mcp.ToBoolPtr(false), OpenWorldHint: mcp.ToBoolPtr(true),
+	})
+	// settingTool marks a tool that adds to the state of something without removing anything.
+	settingTool = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+		ReadOnlyHint: mcp.ToBoolPtr(false), DestructiveHint: mcp.ToBoolPtr(false), IdempotentHint: mcp.ToBoolPtr(true), OpenWorldHint: mcp.ToBoolPtr(true),
+	})
+	// removingTool marks a tool that removes or replaces something.
+	removingTool = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+		ReadOnlyHint: mcp.ToBoolPtr(false), DestructiveHint: mcp.ToBoolPtr(true), IdempotentHint: mcp.ToBoolPtr(true), OpenWorldHint: mcp.ToBoolPtr(true),
+	})
+	// versioningTool marks a tool that replaces the body of content with a new version on every call.
+	versioningTool = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+		ReadOnlyHint: mcp.ToBoolPtr(false), DestructiveHint: mcp.ToBoolPtr(true), IdempotentHint: mcp.ToBoolPtr(false), OpenWorldHint: mcp.ToBoolPtr(true),
+	})
+)
+
+// registerTools registers the tools of a Confluence instance with add.
+func registerTools(add func(mcp.Tool, mcpserver.ToolHandlerFunc), client *ConfluenceClient) {
+	add = withStructuredOutput(client, withCursors(withWebURLs(client, withResultFormats(add))))
+
+	add(mcp.NewTool("confluence_get_content",
+		mcp.WithDescription("Get Confluence content by ID from the Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("Confluence Data Center content ID")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+		mcp.WithNumber("version", mcp.Description("Retrieve this historical version instead of the current one (optional)")),
+		mcp.WithBoolean("includeLikes", mcp.Description("Add the number of likes as likeCount (default: false)")),
+		mcp.WithString("outputFormat", mcp.Description("The format of the returned body: 'storage' (default), 'view' (rendered HTML with macros evaluated), 'markdown', or 'text'")),
+		mcp.WithString("macros", mcp.Description("What to do with the macros of the body: 'keep' (default), 'strip' to leave out their parameters and bodies, or 'expand' to put the body of the pages named by include macros, and the excerpt of those named by excerpt-include macros, in their place")),
+		mcp.WithNumber("maxChars", mcp.Description("Maximum number of characters of text returned when outputFormat is text (default: 20000)")),
+		mcp.WithBoolean("chunked", mcp.Description("Return the body split into sections at its headings, with anchors and token estimates, instead of a single body (default: false)")),
+		mcp.WithString("section", mcp.Description("Return only the section with this anchor or heading text (implies chunked)")),
+	), handleGetContent(client))
+
+	add(mcp.NewTool("confluence_get_many",
+		mcp.WithDescription("Get several pieces of Confluence content by ID at once from the Confluence Data Center edition instance, fetched concurrently; each ID gets its own result or error"),
+		readOnlyTool,
+		mcp.WithArray("contentIds", mcp.Required(), mcp.WithStringItems(), mcp.Description("The content IDs to fetch (at most 100)")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand (default: body.storage,version,space)")),
+	), handleGetMany(client))
+
+	add(mcp.NewTool("confluence_search_content",
+		mcp.WithDescription("Search for content in Confluence Data Center edition instance using CQL"),
+		readOnlyTool,
+		mcp.WithString("cql", mcp.Required(), mcp.Description("Confluence Query Language (CQL) search string for Confluence Data Center")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of results to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the results to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+		mcp.WithBoolean("fetchAll", mcp.Description("Walk all result pages and return them together, ignoring limit and start (default: false)")),
+		mcp.WithNumber("maxResults", mcp.Description("With fetchAll, the maximum number of results to collect (default: 100, max: 1000)")),
+		mcp.WithString("excerpt", mcp.Description("Excerpt strategy: 'highlight', 'indexed', or 'none' (default: Confluence's default, or highlight when compact)")),
+		mcp.WithBoolean("compact", mcp.Description("Return compact results with rank, title, space, URL, and highlighted excerpt instead of the raw response (default: false)")),
+	), handleSearchContent(client))
+
+	add(mcp.NewTool("confluence_create_content",
+		mcp.WithDescription("Create new content in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("title", mcp.Required(), mcp.Description("The title of the new content")),
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space where content will be created")),
+		mcp.WithString("content", mcp.Required(), mcp.Description("The content of the page in Confluence storage format, or in the format given by contentFormat")),
+		mcp.WithString("contentFormat", mcp.Description("The format of content: 'storage' (default), 'markdown', or 'wiki' (Confluence wiki markup)")),
+		mcp.WithBoolean("autoEscape", mcp.Description("Escape bare ampersands in storage format content before validating it (default: false)")),
+		mcp.WithString("type", mcp.Description("The type of content (page or blogpost)")),
+		mcp.WithString("parentId", mcp.Description("The ID of the parent content (optional)")),
+	), handleCreateContent(client))
+
+	add(mcp.NewTool("confluence_create_tree",
+		mcp.WithDescription("Create a tree of pages, each with its title, body, and child pages, under a parent page in Confluence Data Center edition instance, deleting the pages already created if one cannot be created"),
+		additiveTool,
+		mcp.WithArray("pages", mcp.Required(), mcp.Items(map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"title":    map[string]any{"type": "string", "description": "The title of the page, unique within the space"},
+				"content":  map[string]any{"type": "string", "description": "The body of the page in the format given by contentFormat"},
+				"children": map[string]any{"type": "array", "items": map[string]any{"type": "object"}, "description": "The pages to create under this page, each of the same shape"},
+			},
+			"required": []string{"title"},
+		}), mcp.Description("The pages to create under the parent, in order, each with its child pages (at most 100 pages in all)")),
+		mcp.WithString("parentId", mcp.Description("The ID of the page to create the tree under")),
+		mcp.WithString("spaceKey", mcp.Description("The key of the space to create the pages in; the top of the space when no parentId is given, or the space of the parent by default")),
+		mcp.WithString("contentFormat", mcp.Description("The format of the page bodies: 'storage' (default), 'markdown', or 'wiki' (Confluence wiki markup)")),
+		mcp.WithBoolean("autoEscape", mcp.Description("Escape bare ampersands in storage format bodies before validating them (default: false)")),
+		mcp.WithString("onFailure", mcp.Enum("delete", "report"), mcp.Description("What to do with the pages already created when one cannot be created: delete them, or report them to clean up (default: delete)")),
+	), handleCreateTree(client))
+
+	add(mcp.NewTool("confluence_update_content",
+		mcp.WithDescription("Update existing content in Confluence Data Center edition instance"),
+		versioningTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to update")),
+		mcp.WithNumber("version", mcp.Description("The new version number (optional, defaults to current version + 1)")),
+		mcp.WithString("title", mcp.Description("New title for the content")),
+		mcp.WithString("content", mcp.Description("New content in storage format, or in the format given by contentFormat")),
+		mcp.WithString("editMode", mcp.Description("'replace' to replace the body with content, or 'append' or 'prepend' to add content at the end or the start of the current body, without sending it back (default: replace)")),
+		mcp.WithString("contentFormat", mcp.Description("The format of content: 'storage' (default), 'markdown', or 'wiki' (Confluence wiki markup)")),
+		mcp.WithBoolean("autoEscape", mcp.Description("Escape bare ampersands in storage format content before validating it (default: false)")),
+		mcp.WithString("versionComment", mcp.Description("A comment for the new version")),
+		mcp.WithNumber("maxRetries", mcp.Description("How often to read the content again and retry when someone else saved it meanwhile; not done when version is given (default: 3, max: 10)")),
+		mcp.WithBoolean("failIfChanged", mcp.Description("Fail instead of retrying when someone else changed the body meanwhile, so that their changes are not overwritten (default: false)")),
+	), handleUpdateContent(client))
+
+	add(mcp.NewTool("confluence_update_section",
+		mcp.WithDescription("Replace, append to, or prepend to the section under one heading of a page in Confluence Data Center edition instance, leaving the rest of the page as it is"),
+		versioningTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page to update")),
+		mcp.WithString("section", mcp.Required(), mcp.Description("The anchor or heading text of the section, as given by confluence_get_content with chunked")),
+		mcp.WithString("content", mcp.Description("The content in storage format, or in the format given by contentFormat; required to append or prepend")),
+		mcp.WithString("editMode", mcp.Description("'replace' to replace what is under the heading, keeping the heading, 'append' to add content at the end of the section, or 'prepend' to add it right after the heading (default: replace)")),
+		mcp.WithString("contentFormat", mcp.Description("The format of content: 'storage' (default), 'markdown', or 'wiki' (Confluence wiki markup)")),
+		mcp.WithBoolean("autoEscape", mcp.Description("Escape bare ampersands in storage format content before validating it (default: false)")),
+		mcp.WithString("versionComment", mcp.Description("A comment for the new version")),
+		mcp.WithNumber("maxRetries", mcp.Description("How often to read the page again and retry when someone else saved it meanwhile (default: 3, max: 10)")),
+		mcp.WithBoolean("failIfChanged", mcp.Description("Fail instead of retrying when someone else changed the body meanwhile (default: false)")),
+	), handleUpdateSection(client))
+
+	add(mcp.NewTool("confluence_list_spaces",
+		mcp.WithDescription("List and search for spaces in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("searchText", mcp.Description("Text to search for in space names or descriptions (optional, returns all spaces if omitted)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of spaces to return")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the results to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleListSpaces(client))
+
+	add(mcp.NewTool("confluence_get_comments",
+		mcp.WithDescription("Get footer and inline comments for content in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content whose comments to retrieve")),
+		mcp.WithBoolean("includeResolved", mcp.Description("Include resolved inline comments (default: false)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of comments to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the comments to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleGetComments(client))
+
+	add(mcp.NewTool("confluence_add_comment",
+		mcp.WithDescription("Add a footer comment, or a reply to an existing comment, on content in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page or blog post to comment on")),
+		mcp.WithString("content", mcp.Required(), mcp.Description("The comment body in Confluence storage format")),
+		mcp.WithString("parentCommentId", mcp.Description("The ID of the comment to reply to (optional)")),
+		mcp.WithString("containerType", mcp.Description("The type of the commented content (page or blogpost, default: page)")),
+	), handleAddComment(client))
+
+	add(mcp.NewTool("confluence_add_inline_comment",
+		mcp.WithDescription("Add an inline comment anchored to a text selection in a page in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page or blog post to comment on")),
+		mcp.WithString("content", mcp.Required(), mcp.Description("The comment body in Confluence storage format")),
+		mcp.WithString("selection", mcp.Required(), mcp.Description("The exact page text to anchor the comment to; it must not span formatting boundaries")),
+		mcp.WithNumber("matchIndex", mcp.Description("Zero-based occurrence of the selection to anchor to when it appears more than once (default: 0)")),
+	), handleAddInlineComment(client))
+
+	add(mcp.NewTool("confluence_get_labels",
+		mcp.WithDescription("Get the labels on content in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content whose labels to retrieve")),
+		mcp.WithString("prefix", mcp.Description("Only return labels with this prefix (global, my, team)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of labels to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the labels to return")),
+	), handleGetLabels(client))
+
+	add(mcp.NewTool("confluence_add_labels",
+		mcp.WithDescription("Add labels to content in Confluence Data Center edition instance"),
+		settingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to label")),
+		mcp.WithArray("labels", mcp.Required(), mcp.WithStringItems(), mcp.Description("The label names to add")),
+	), handleAddLabels(client))
+
+	add(mcp.NewTool("confluence_bulk_label",
+		mcp.WithDescription("Add and remove labels on all the content a CQL query matches in Confluence Data Center edition instance, reporting what changed"),
+		removingTool,
+		mcp.WithString("cql", mcp.Required(), mcp.Description("Confluence Query Language (CQL) query for the content to label, such as 'space = DOC and label = old-name'")),
+		mcp.WithArray("add", mcp.WithStringItems(), mcp.Description("The label names to add")),
+		mcp.WithArray("remove", mcp.WithStringItems(), mcp.Description("The label names to remove")),
+		mcp.WithBoolean("dryRun", mcp.Description("Report the changes without making them (default: false)")),
+		mcp.WithNumber("maxResults", mcp.Description("The maximum number of pieces of content to change (default: 100, max: 1000)")),
+	), handleBulkLabel(client))
+
+	add(mcp.NewTool("confluence_remove_label",
+		mcp.WithDescription("Remove a label from content in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to remove the label from")),
+		mcp.WithString("label", mcp.Required(), mcp.Description("The name of the label to remove")),
+	), handleRemoveLabel(client))
+
+	add(mcp.NewTool("confluence_find_by_label",
+		mcp.WithDescription("Find content with any of the given labels in Confluence Data Center edition instance, following all result pages"),
+		readOnlyTool,
+		mcp.WithArray("labels", mcp.Required(), mcp.WithStringItems(), mcp.Description("The label names to match (content with any of them is returned)")),
+		mcp.WithString("spaceKey", mcp.Description("Only return content from this space (optional)")),
+		mcp.WithString("type", mcp.Description("Only return content of this type, e.g. page or blogpost (optional)")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of results to collect across pages (default: 100, max: 1000)")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleFindByLabel(client))
+
+	add(mcp.NewTool("confluence_get_children",
+		mcp.WithDescription("Get the child pages of a page in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the parent page")),
+		mcp.WithBoolean("includeExcerpt", mcp.Description("Include a short plain text excerpt of each child's body (default: false)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of child pages to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the child pages to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleGetChildren(client))
+
+	add(mcp.NewTool("confluence_get_descendants",
+		mcp.WithDescription("Get the page tree below a page in Confluence Data Center edition instance as nested JSON with IDs, titles, and URLs"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the root page")),
+		mcp.WithNumber("depth", mcp.Description("Maximum number of levels below the root to return (default: 3)")),
+		mcp.WithNumber("maxPages", mcp.Description("Maximum number of descendant pages to return (default and max: 500)")),
+	), handleGetDescendants(client))
+
+	add(mcp.NewTool("confluence_move_content",
+		mcp.WithDescription("Move a page under a new parent, next to a sibling, or to another space in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page to move")),
+		mcp.WithString("targetId", mcp.Description("The ID of the page to move relative to")),
+		mcp.WithString("position", mcp.Enum("append", "above", "below"), mcp.Description("append makes the page the last child of the target; above and below place it as a sibling before or after the target (default: append)")),
+		mcp.WithString("targetSpaceKey", mcp.Description("Move the page under the homepage of this space when no targetId is given")),
+	), handleMoveContent(client))
+
+	add(mcp.NewTool("confluence_bulk_move",
+		mcp.WithDescription("Move several pages, chosen by ID or by a CQL query, under a new parent page or space in Confluence Data Center edition instance, keeping their order"),
+		removingTool,
+		mcp.WithArray("contentIds", mcp.WithStringItems(), mcp.Description("The IDs of the pages to move, in the order they should have under the new parent (at most 100)")),
+		mcp.WithString("cql", mcp.Description("Confluence Query Language (CQL) query for the pages to move instead of contentIds; add an order by clause to choose their order")),
+		mcp.WithString("targetId", mcp.Description("The ID of the page to move the pages under")),
+		mcp.WithString("targetSpaceKey", mcp.Description("Move the pages under the homepage of this space when no targetId is given")),
+		mcp.WithBoolean("dryRun", mcp.Description("Report the pages and where they are now without moving them (default: false)")),
+		mcp.WithNumber("maxResults", mcp.Description("The maximum number of pages a cql query moves (default: 100, max: 1000)")),
+	), handleBulkMove(client))
+
+	add(mcp.NewTool("confluence_batch",
+		mcp.WithDescription("Run a list of create, update, label, and move operations in Confluence Data Center edition instance in one call, in order, reporting the result of each"),
+		versioningTool,
+		mcp.WithArray("operations", mcp.Required(), mcp.Items(map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"op": map[string]any{
+					"type":        "string",
+					"enum":        []string{"create", "update", "label", "move"},
+					"description": "The operation: create, update, label, or move, which take the arguments of confluence_create_content, confluence_update_content, confluence_add_labels, and confluence_move_content",
+				},
+				"arguments": map[string]any{
+					"type":        "object",
+					"description": "The arguments of the operation; a string argument $N stands for the ID of the content of operation N, such as the page it created",
+				},
+			},
+			"required": []string{"op", "arguments"},
+		}), mcp.Description("The operations to run, in order (at most 50)")),
+		mcp.WithBoolean("continueOnError", mcp.Description("Run the remaining operations after one fails instead of skipping them (default: false)")),
+	), handleBatch(client))
+
+	add(mcp.NewTool("confluence_copy_content",
+		mcp.WithDescription("Copy a page, optionally with all of its children, to a target parent page or space in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page to copy")),
+		mcp.WithString("targetParentId", mcp.Description("The ID of the page to place the copy under")),
+		mcp.WithString("targetSpaceKey", mcp.Description("The key of the space to copy into when no targetParentId is given")),
+		mcp.WithBoolean("includeChildren", mcp.Description("Copy the whole subtree below the page (default: false)")),
+		mcp.WithString("title", mcp.Description("Title for the copied page (single page copies only)")),
+		mcp.WithString("titlePrefix", mcp.Description("Prefix added to the titles of the copied pages")),
+		mcp.WithBoolean("copyLabels", mcp.Description("Copy labels (default: true)")),
+		mcp.WithBoolean("copyAttachments", mcp.Description("Copy attachments (default: true)")),
+		mcp.WithBoolean("wait", mcp.Description("For hierarchy copies, wait for the copy task to finish and return its final status (default: false)")),
+	), handleCopyContent(client))
+
+	add(mcp.NewTool("confluence_get_history",
+		mcp.WithDescription("Get the version history of content in Confluence Data Center edition instance, including author, date, and message of each version"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content whose history to retrieve")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of versions to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the versions to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of version properties to expand")),
+	), handleGetHistory(client))
+
+	add(mcp.NewTool("confluence_restore_version",
+		mcp.WithDescription("Restore content to a previous version in Confluence Data Center edition instance; the restore is recorded as a new version"),
+		versioningTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to restore")),
+		mcp.WithNumber("version", mcp.Required(), mcp.Description("The version number to restore")),
+		mcp.WithString("versionComment", mcp.Required(), mcp.Description("A comment explaining why the version is restored")),
+	), handleRestoreVersion(client))
+
+	add(mcp.NewTool("confluence_get_page_by_title",
+		mcp.WithDescription("Get a page by its space key and exact title from the Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space containing the page")),
+		mcp.WithString("title", mcp.Required(), mcp.Description("The exact title of the page")),
+		mcp.WithString("type", mcp.Description("The type of content (page or blogpost, default: page)")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleGetPageByTitle(client))
+
+	add(mcp.NewTool("confluence_resolve_url",
+		mcp.WithDescription("Resolve a Confluence Data Center page URL, viewpage.action link, or /x/ tiny link to its content ID and metadata"),
+		readOnlyTool,
+		mcp.WithString("url", mcp.Required(), mcp.Description("The Confluence URL to resolve")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleResolveURL(client))
+
+	add(mcp.NewTool("confluence_get_restrictions",
+		mcp.WithDescription("Get the view and edit restrictions on content in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content whose restrictions to retrieve")),
+		mcp.WithString("operation", mcp.Enum("read", "update"), mcp.Description("Only return restrictions for this operation (optional)")),
+	), handleGetRestrictions(client))
+
+	add(mcp.NewTool("confluence_set_restrictions",
+		mcp.WithDescription("Add or remove view or edit restrictions for users and groups on content in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to change restrictions on")),
+		mcp.WithString("action", mcp.Required(), mcp.Enum("add", "remove"), mcp.Description("Whether to add or remove the restrictions")),
+		mcp.WithString("operation", mcp.Required(), mcp.Enum("read", "update"), mcp.Description("The restricted operation: read (view) or update (edit)")),
+		mcp.WithArray("users", mcp.WithStringItems(), mcp.Description("Usernames to add or remove")),
+		mcp.WithArray("groups", mcp.WithStringItems(), mcp.Description("Group names to add or remove")),
+		mcp.WithBoolean("dryRun", mcp.Description("Preview the resulting restrictions without applying them (default: false)")),
+	), handleSetRestrictions(client))
+
+	add(mcp.NewTool("confluence_watch_content",
+		mcp.WithDescription("Watch content in Confluence Data Center edition instance so the user is notified of changes"),
+		settingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to watch")),
+		mcp.WithString("username", mcp.Description("The user to add as a watcher (default: the current user)")),
+	), handleWatchContent(client, true))
+
+	add(mcp.NewTool("confluence_unwatch_content",
+		mcp.WithDescription("Stop watching content in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to stop watching")),
+		mcp.WithString("username", mcp.Description("The user to remove as a watcher (default: the current user)")),
+	), handleWatchContent(client, false))
+
+	add(mcp.NewTool("confluence_get_watchers",
+		mcp.WithDescription("Get the users watching a page and its space in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page whose watchers to retrieve")),
+	), handleGetWatchers(client))
+
+	add(mcp.NewTool("confluence_get_content_property",
+		mcp.WithDescription("Get a content property, or list all properties of content, in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content")),
+		mcp.WithString("key", mcp.Description("The property key (optional, lists all properties if omitted)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of properties to return when listing (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the properties to return when listing")),
+	), handleGetContentProperty(client))
+
+	add(mcp.NewTool("confluence_set_content_property",
+		mcp.WithDescription("Create or update a content property in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content")),
+		mcp.WithString("key", mcp.Required(), mcp.Description("The property key")),
+		mcp.WithAny("value", mcp.Required(), mcp.Description("The property value (any JSON value)")),
+		mcp.WithNumber("version", mcp.Description("The new property version number (optional, defaults to current version + 1)")),
+	), handleSetContentProperty(client))
+
+	add(mcp.NewTool("confluence_delete_content_property",
+		mcp.WithDescription("Delete a content property in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content")),
+		mcp.WithString("key", mcp.Required(), mcp.Description("The property key")),
+		mcp.WithBoolean("confirm", mcp.Description("Must be true to confirm the deletion when the client cannot ask the user to confirm it")),
+	), handleDeleteContentProperty(client))
+
+	add(mcp.NewTool("confluence_get_space_property",
+		mcp.WithDescription("Get a space property, or list all properties of a space, in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("key", mcp.Description("The property key (optional, lists all properties if omitted)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of properties to return when listing (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the properties to return when listing")),
+	), handleGetSpaceProperty(client))
+
+	add(mcp.NewTool("confluence_set_space_property",
+		mcp.WithDescription("Create or update a space property in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("key", mcp.Required(), mcp.Description("The property key")),
+		mcp.WithAny("value", mcp.Required(), mcp.Description("The property value (any JSON value)")),
+		mcp.WithNumber("version", mcp.Description("The new property version number (optional, defaults to current version + 1)")),
+	), handleSetSpaceProperty(client))
+
+	add(mcp.NewTool("confluence_delete_space_property",
+		mcp.WithDescription("Delete a space property in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("key", mcp.Required(), mcp.Description("The property key")),
+		mcp.WithBoolean("confirm", mcp.Description("Must be true to confirm the deletion when the client cannot ask the user to confirm it")),
+	), handleDeleteSpaceProperty(client))
+
+	add(mcp.NewTool("confluence_create_space",
+		mcp.WithDescription("Create a new space in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the new space")),
+		mcp.WithString("name", mcp.Required(), mcp.Description("The name of the new space")),
+		mcp.WithString("description", mcp.Description("A plain text description of the space")),
+		mcp.WithBoolean("private", mcp.Description("Create a private space visible only to the creator (default: false)")),
+	), handleCreateSpace(client))
+
+	add(mcp.NewTool("confluence_delete_space",
+		mcp.WithDescription("Archive or permanently delete a space in Confluence Data Center edition instance; deletion runs as a long-running task"),
+		removingTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithBoolean("confirm", mcp.Description("Must be true to confirm the operation when the client cannot ask the user to confirm it")),
+		mcp.WithBoolean("archive", mcp.Description("Archive the space instead of deleting it, when supported (default: false)")),
+		mcp.WithBoolean("wait", mcp.Description("Wait for the deletion task to finish and return its final status (default: false)")),
+	), handleDeleteSpace(client))
+
+	add(mcp.NewTool("confluence_update_space",
+		mcp.WithDescription("Rename a space or change its description or homepage in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("name", mcp.Description("The new name of the space")),
+		mcp.WithString("description", mcp.Description("The new plain text description of the space")),
+		mcp.WithString("homepageId", mcp.Description("The ID of the page to use as the space homepage")),
+	), handleUpdateSpace(client))
+
+	add(mcp.NewTool("confluence_get_space_permissions",
+		mcp.WithDescription("Get which users and groups hold which permissions in a space in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+	), handleGetSpacePermissions(client))
+
+	add(mcp.NewTool("confluence_get_space_content",
+		mcp.WithDescription("List the pages or blog posts of a space in Confluence Data Center edition instance, in space order"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("type", mcp.Description("The type of content to list: 'page' or 'blogpost' (default: page)")),
+		mcp.WithString("depth", mcp.Description("'root' for top-level pages only, 'all' for every page in the space (default: all)")),
+		mcp.WithBoolean("includeExcerpt", mcp.Description("Include a short plain text excerpt of each item's body (default: false)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of items to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the items to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleGetSpaceContent(client))
+
+	add(mcp.NewTool("confluence_get_space_homepage",
+		mcp.WithDescription("Get the homepage of a space, including its body, from Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of additional properties to expand")),
+	), handleGetSpaceHomepage(client))
+
+	add(mcp.NewTool("confluence_list_blogposts",
+		mcp.WithDescription("List the blog posts of a space in Confluence Data Center edition instance, newest first, with title, author, date, URL, and excerpt"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+		mcp.WithString("from", mcp.Description("Only include posts created on or after this date (YYYY-MM-DD)")),
+		mcp.WithString("to", mcp.Description("Only include posts created on or before this date (YYYY-MM-DD)")),
+		mcp.WithString("author", mcp.Description("Only include posts created by this username")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of posts to return (default: 100, max: 1000)")),
+	), handleListBlogposts(client))
+
+	add(mcp.NewTool("confluence_list_templates",
+		mcp.WithDescription("List the page templates or blueprints available in a space, or the global ones, in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("spaceKey", mcp.Description("The key of the space (omit for global templates)")),
+		mcp.WithString("type", mcp.Description("The kind of template to list: 'page' or 'blueprint' (default: page)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of templates to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the templates to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand (e.g. body)")),
+	), handleListTemplates(client))
+
+	add(mcp.NewTool("confluence_create_from_template",
+		mcp.WithDescription("Create a page from a page template or blueprint template in Confluence Data Center edition instance, filling in the template variables"),
+		additiveTool,
+		mcp.WithString("templateId", mcp.Required(), mcp.Description("The ID of the template (see confluence_list_templates)")),
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space to create the page in")),
+		mcp.WithString("title", mcp.Required(), mcp.Description("The title of the new page")),
+		mcp.WithString("parentId", mcp.Description("The ID of the parent page")),
+		mcp.WithObject("variables", mcp.Description("Values for the template variables, keyed by variable name")),
+	), handleCreateFromTemplate(client))
+
+	add(mcp.NewTool("confluence_create_template",
+		mcp.WithDescription("Create a page template in a space, or a global template, in Confluence Data Center edition instance"),
+		additiveTool,
+		mcp.WithString("name", mcp.Required(), mcp.Description("The name of the template")),
+		mcp.WithString("content", mcp.Required(), mcp.Description("The template body in storage format; use <at:var at:name=\"...\" /> for variables")),
+		mcp.WithString("spaceKey", mcp.Description("The key of the space (omit for a global template)")),
+		mcp.WithString("description", mcp.Description("A short description of the template")),
+		mcp.WithArray("labels", mcp.WithStringItems(), mcp.Description("Labels added to pages created from the template")),
+	), handleCreateTemplate(client))
+
+	add(mcp.NewTool("confluence_update_template",
+		mcp.WithDescription("Update the name, description, or body of a page template in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("templateId", mcp.Required(), mcp.Description("The ID of the template")),
+		mcp.WithString("name", mcp.Description("The new name of the template")),
+		mcp.WithString("description", mcp.Description("The new description of the template")),
+		mcp.WithString("content", mcp.Description("The new template body in storage format")),
+	), handleUpdateTemplate(client))
+
+	add(mcp.NewTool("confluence_search_users",
+		mcp.WithDescription("Search for users by full name in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("query", mcp.Required(), mcp.Description("The full name, or part of it, to search for")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of users to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the users to return")),
+	), handleSearchUsers(client))
+
+	add(mcp.NewTool("confluence_get_current_user",
+		mcp.WithDescription("Get the user the configured token authenticates as in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand (e.g. details.personal)")),
+	), handleGetCurrentUser(client))
+
+	add(mcp.NewTool("confluence_list_groups",
+		mcp.WithDescription("List the user groups of Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithNumber("limit", mcp.Description("Maximum number of groups to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the groups to return")),
+	), handleListGroups(client))
+
+	add(mcp.NewTool("confluence_get_group_members",
+		mcp.WithDescription("List the members of a group in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("groupName", mcp.Required(), mcp.Description("The name of the group")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of members to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the members to return")),
+	), handleGetGroupMembers(client))
+
+	add(mcp.NewTool("confluence_get_user_groups",
+		mcp.WithDescription("List the groups a user belongs to in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("username", mcp.Required(), mcp.Description("The username of the user")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of groups to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the groups to return")),
+	), handleGetUserGroups(client))
+
+	add(mcp.NewTool("confluence_get_user_content",
+		mcp.WithDescription("List pages and blog posts a user created or contributed to in Confluence Data Center edition instance, most recently modified first"),
+		readOnlyTool,
+		mcp.WithString("username", mcp.Required(), mcp.Description("The username of the user")),
+		mcp.WithString("role", mcp.Description("'creator', 'contributor', or 'any' (default: any)")),
+		mcp.WithString("from", mcp.Description("Only include content last modified on or after this date (YYYY-MM-DD)")),
+		mcp.WithString("to", mcp.Description("Only include content last modified on or before this date (YYYY-MM-DD)")),
+		mcp.WithString("spaceKey", mcp.Description("Restrict results to a space")),
+		mcp.WithString("type", mcp.Description("Restrict results to a content type (default: page and blogpost)")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of results to return (default: 100, max: 1000)")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleGetUserContent(client))
+
+	add(mcp.NewTool("confluence_convert_mentions",
+		mcp.WithDescription("Convert @username references in text or storage format into user mentions that notify the users in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("content", mcp.Required(), mcp.Description("The text or storage format containing @username references")),
+	), handleConvertMentions(client))
+
+	add(mcp.NewTool("confluence_export_word",
+		mcp.WithDescription("Export a page from Confluence Data Center edition instance as a Word document"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the page to export")),
+	), handleExportWord(client))
+
+	add(mcp.NewTool("confluence_export_space",
+		mcp.WithDescription("Export a whole space from Confluence Data Center edition instance as an XML or HTML archive and return its download URL"),
+		additiveTool,
+		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space to export")),
+		mcp.WithString("format", mcp.Description("The export format: 'xml' (for backup and import) or 'html' (default: xml)")),
+	), handleExportSpace(client))
+
+	add(mcp.NewTool("confluence_like_content",
+		mcp.WithDescription("Like a page, blog post, or comment as the current user in Confluence Data Center edition instance"),
+		settingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to like")),
+	), handleLikeContent(client, true))
+
+	add(mcp.NewTool("confluence_unlike_content",
+		mcp.WithDescription("Remove the current user's like from a page, blog post, or comment in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to unlike")),
+	), handleLikeContent(client, false))
+
+	add(mcp.NewTool("confluence_add_favourite",
+		mcp.WithDescription("Add a page or blog post to the current user's favourites (saved for later) in Confluence Data Center edition instance"),
+		settingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to add")),
+	), handleFavouriteContent(client, true))
+
+	add(mcp.NewTool("confluence_remove_favourite",
+		mcp.WithDescription("Remove a page or blog post from the current user's favourites in Confluence Data Center edition instance"),
+		removingTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to remove")),
+	), handleFavouriteContent(client, false))
+
+	add(mcp.NewTool("confluence_list_favourites",
+		mcp.WithDescription("List the current user's favourite content in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithNumber("limit", mcp.Description("Maximum number of items to return (default: 25)")),
+		mcp.WithNumber("start", mcp.Description("The starting index of the items to return")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleListFavourites(client))
+
+	add(mcp.NewTool("confluence_recently_updated",
+		mcp.WithDescription("List pages and blog posts modified recently in Confluence Data Center edition instance, newest first, as compact entries"),
+		readOnlyTool,
+		mcp.WithNumber("hours", mcp.Description("Include content modified in the last N hours (default: 24)")),
+		mcp.WithNumber("days", mcp.Description("Include content modified in the last N days (overrides hours)")),
+		mcp.WithArray("spaces", mcp.WithStringItems(), mcp.Description("Restrict results to these space keys")),
+		mcp.WithArray("contributors", mcp.WithStringItems(), mcp.Description("Restrict results to content these usernames contributed to")),
+		mcp.WithString("type", mcp.Description("Restrict results to a content type (default: page and blogpost)")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of results to return (default: 100, max: 1000)")),
+	), handleRecentlyUpdated(client))
+
+	add(mcp.NewTool("confluence_get_tasks",
+		mcp.WithDescription("Collect the inline tasks (action items) of a page, or of every page matching a CQL query, from Confluence Data Center edition instance with status, assignee, and due date"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Description("The ID of the page to read tasks from")),
+		mcp.WithString("cql", mcp.Description("CQL query selecting the pages to read tasks from (used when contentId is not given)")),
+		mcp.WithString("status", mcp.Description("'incomplete', 'complete', or 'all' (default: all)")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of pages to read when using cql (default: 100, max: 1000)")),
+	), handleGetTasks(client))
+
+	add(mcp.NewTool("confluence_server_info",
+		mcp.WithDescription("Get the version, build number, base URL, and cluster status of Confluence Data Center edition instance"),
+		readOnlyTool,
+	), handleServerInfo(client))
+
+	add(mcp.NewTool("confluence_health",
+		mcp.WithDescription("Check that Confluence Data Center edition instance is reachable and accepts the configured token, and report the authenticated user, the Confluence version, and which optional APIs (audit, page copy, page hierarchy copy, body conversion) the instance provides"),
+		readOnlyTool,
+	), handleHealth(client))
+
+	add(mcp.NewTool("confluence_cache_clear",
+		mcp.WithDescription("Drop the spaces, users, templates, and responses cached from Confluence Data Center edition instance, so that the next calls see changes made outside of this server"),
+		settingTool,
+		mcp.WithOpenWorldHintAnnotation(false),
+	), handleCacheClear(client))
+
+	add(mcp.NewTool("confluence_get_task_status",
+		mcp.WithDescription("Get the progress of a long-running task (space deletion, page hierarchy copy, etc.) in Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("taskId", mcp.Required(), mcp.Description("The ID of the long-running task")),
+		mcp.WithBoolean("wait", mcp.Description("Poll until the task finishes and return its final status (default: false)")),
+	), handleGetTaskStatus(client))
+
+	add(mcp.NewTool("confluence_validate_cql",
+		mcp.WithDescription("Check a CQL query against Confluence Data Center edition instance without fetching results, returning whether it is valid with an estimated result count, or Confluence's error message"),
+		readOnlyTool,
+		mcp.WithString("cql", mcp.Required(), mcp.Description("The CQL query to check")),
+	), handleValidateCQL(client))
+
+	add(mcp.NewTool("confluence_find",
+		mcp.WithDescription("Search Confluence Data Center edition instance with structured criteria instead of raw CQL; all given criteria must match"),
+		readOnlyTool,
+		mcp.WithArray("spaceKey", mcp.WithStringItems(), mcp.Description("Space keys to search in")),
+		mcp.WithArray("type", mcp.WithStringItems(), mcp.Description("Content types to include (e.g. page, blogpost, attachment, comment)")),
+		mcp.WithString("titleContains", mcp.Description("Words the title must contain")),
+		mcp.WithString("textContains", mcp.Description("Words the title, body, or labels must contain")),
+		mcp.WithArray("label", mcp.WithStringItems(), mcp.Description("Labels of which the content must have at least one")),
+		mcp.WithArray("creator", mcp.WithStringItems(), mcp.Description("Usernames of which one must have created the content")),
+		mcp.WithString("modifiedAfter", mcp.Description("Only include content last modified on or after this date (YYYY-MM-DD)")),
+		mcp.WithString("modifiedBefore", mcp.Description("Only include content last modified on or before this date (YYYY-MM-DD)")),
+		mcp.WithNumber("maxResults", mcp.Description("Maximum number of results to return (default: 100, max: 1000)")),
+		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
+	), handleFind(client))
+
+	add(mcp.NewTool("confluence_site_search",
+		mcp.WithDescription("Search pages, blog posts, attachments, comments, spaces, and users of Confluence Data Center edition instance at once, with results grouped by type and a count per type"),
+		readOnlyTool,
+		mcp.WithString("query", mcp.Required(), mcp.Description("The words to search for")),
+		mcp.WithArray("types", mcp.WithStringItems(), mcp.Description("Restrict the search to these types (page, blogpost, attachment, comment, space, user)")),
+		mcp.WithString("spaceKey", mcp.Description("Restrict content results to a space")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of results per type (default: 5)")),
+	), handleSiteSearch(client))
+
+	add(mcp.NewTool("confluence_convert_body",
+		mcp.WithDescription("Convert a content body between representations (storage, view, editor, export_view, styled_view, and from wiki markup) using Confluence Data Center edition instance"),
+		readOnlyTool,
+		mcp.WithString("value", mcp.Required(), mcp.Description("The body to convert")),
+		mcp.WithString("from", mcp.Required(), mcp.Description("The representation of value: storage, view, editor, export_view, styled_view, or wiki")),
+		mcp.WithString("to", mcp.Required(), mcp.Description("The representation to convert to: storage, view, editor, export_view, or styled_view")),
+		mcp.WithString("contentId", mcp.Description("The ID of the page used as context for rendering macros and links")),
+	), handleConvertBody(client))
+
+	add(mcp.NewTool("confluence_list_macros",
+		mcp.WithDescription("List the macros confluence_build_macro can generate storage format markup for, with their parameters"),
+		readOnlyTool,
+	), handleListMacros())
+
+	add(mcp.NewTool("confluence_build_macro",
+		mcp.WithDescription("Generate the storage format markup of a macro (code, toc, jira, expand, include, or status) from structured parameters, for use in page content"),
+		readOnlyTool,
+		mcp.WithString("name", mcp.Required(), mcp.Description("The name of the macro, as listed by confluence_list_macros")),
+		mcp.WithObject("parameters", mcp.Description("The macro parameters, keyed by parameter name")),
+		mcp.WithString("body", mcp.Description("The body of the macro: plain text for code, storage format for expand")),
+	), handleBuildMacro())
+
+	add(mcp.NewTool("confluence_extract_tables",
+		mcp.WithDescription("Extract the tables of a page in Confluence Data Center edition instance as JSON rows or CSV, with headers and merged cells"),
+		readOnlyTool,
+		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content")),
+		mcp.WithString("format", mcp.Description("The output format: 'json' (default) or 'csv'")),
+		mcp.WithNumber("tableIndex", mcp.Description("Return only the table at this zero-based position")),
+	), handleExtractTables(client))
+
+	// Operators can keep space permissions read-only for a deployment by not exposing the modifying tools.
+	if !client.config.SpacePermissionsReadOnly {
+		add(mcp.NewTool("confluence_grant_space_permission",
+			mcp.WithDescription("Grant a space permission to a user, a group or anonymous users in Confluence Data Center edition instance"),
+			settingTool,
+			mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+			mcp.WithString("permission", mcp.Required(), mcp.Description("The permission to grant (e.g. VIEWSPACE, EDITSPACE, COMMENT, SETSPACEPERMISSIONS)")),
+			mcp.WithString("user", mcp.Description("Username to grant the permission to")),
+			mcp.WithString("group", mcp.Description("Group name to grant the permission to")),
+			mcp.WithBoolean("anonymous", mcp.Description("Grant the permission to anonymous users")),
+		), handleChangeSpacePermission(client, true))
+
+		add(mcp.NewTool("confluence_revoke_space_permission",
+			mcp.WithDescription("Revoke a space permission from a user, a group or anonymous users in Confluence Data Center edition instance"),
+			removingTool,
+			mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space")),
+			mcp.WithString("permission", mcp.Required(), mcp.Description("The permission to revoke (e.g. VIEWSPACE, EDITSPACE, COMMENT, SETSPACEPERMISSIONS)")),
+			mcp.WithString("user", mcp.Description("Username to revoke the permission from")),
+			mcp.WithString("group", mcp.Description("Group name to revoke the permission from")),
+			mcp.WithBoolean("anonymous", mcp.Description("Revoke the permission from anonymous users")),
+		), handleChangeSpacePermission(client, false))
+	}
 }
 
-// getArguments helper extracts the "arguments" dictionary from an MCP tool request.
-func getArguments(req mcp.CallToolRequest) (map[string]any, error) {
-	if req.Params.Arguments == nil {
-		return make(map[string]any), nil
+type serveFunc func(*mcpserver.MCPServer, *subscriptions, *completions) error
+
+func run(file *FileConfig, serve serveFunc) error {
+	registry, err := loadRegistry(file)
+	if err != nil {
+		return fmt.Errorf("configuration error: %v", err)
 	}
-	args, ok := req.Params.Arguments.(map[string]any)
+
+	if file.StartupCheck == nil || *file.StartupCheck {
+		if err := probeInstances(registry); err != nil {
+			return fmt.Errorf("startup check failed: %v", err)
+		}
+	}
+
+	s := setupServer(registry, file.Tools)
+	if err := enableClientLogging(s, file.Logging); err != nil {
+		return fmt.Errorf("configuration error: %v", err)
+	}
+	if file.SessionCredentials {
+		enableSessionCredentials(s, registry)
+	}
+	slog.Info("starting server", "instances", registry.names, "tools", len(s.ListTools()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchCredentials(ctx, registry, tokenFilePollInterval)
+
+	sub := newSubscriptions(s, registry, file.Subscriptions.WebhookSecret)
+	go sub.watch(ctx, file.Subscriptions.PollInterval)
+
+	if err := serve(s, sub, newCompletions(registry)); err != nil {
+		return fmt.Errorf("server error: %v", err)
+	}
+	return nil
+}
+
+// probeInstances checks every instance of registry before the server starts, so that a bad URL or
+// token fails the start with an actionable message instead of failing the first tool call.
+func probeInstances(registry *ClientRegistry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startupCheckTimeout)
+	defer cancel()
+
+	for _, name := range registry.names {
+		report, err := registry.clients[name].checkHealth(ctx)
+		if err != nil {
+			return fmt.Errorf("instance %s: %v", name, err)
+		}
+		var capabilities []string
+		for capability, ok := range report.Capabilities {
+			if ok {
+				capabilities = append(capabilities, capability)
+			}
+		}
+		slices.Sort(capabilities)
+		slog.Info("connected to Confluence", "instance", name, "url", report.BaseURL, "user", report.Username,
+			"version", report.Version, "capabilities", capabilities)
+		if report.VersionError != "" {
+			slog.Warn("could not detect the Confluence version", "instance", name, "error", report.VersionError)
+		}
+	}
+	return nil
+}
+
+// newServeFunc returns the serve function for a transport: "stdio" serves a single client on
+// standard input and output, "sse" serves any number of clients over HTTP on addr. Either serves
+// until the process is interrupted or terminated, then drains the tool calls in flight.
+func newServeFunc(transport, addr, authToken string) (serveFunc, error) {
+	switch transport {
+	case "stdio":
+		return func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return serveStdio(ctx, s, sub, comp, os.Stdin, os.Stdout)
+		}, nil
+	case "sse":
+		return func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return serveSSE(ctx, s, sub, comp, addr, authToken)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q: must be stdio or sse", transport)
+	}
+}
+
+// contentURITemplate is the URI template of content resources.
+const contentURITemplate = "confluence://{instance}/content/{contentId}"
+
+// URI templates of the reference resources, whose arguments clients can complete.
+const (
+	spaceURITemplate    = "confluence://{instance}/space/{spaceKey}"
+	labelURITemplate    = "confluence://{instance}/label/{label}"
+	templateURITemplate = "confluence://{instance}/space/{spaceKey}/template/{templateName}"
+)
+
+// resourcePath returns the client of the instance a resource URI names and the unescaped segments
+// of the rest of its path.
+func (r *ClientRegistry) resourcePath(uri string) (*ConfluenceClient, []string, error) {
+	rest, ok := strings.CutPrefix(uri, "confluence://")
+	instance, path, found := strings.Cut(rest, "/")
+	if !ok || !found {
+		return nil, nil, fmt.Errorf("invalid resource URI %q", uri)
+	}
+	client, ok := r.clients[instance]
 	if !ok {
-		return nil, fmt.Errorf("arguments are not a JSON object")
+		return nil, nil, fmt.Errorf("unknown instance %q: must be one of %s", instance, strings.Join(r.names, ", "))
 	}
-	return args, nil
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil || unescaped == "" {
+			return nil, nil, fmt.Errorf("invalid resource URI %q", uri)
+		}
+		segments[i] = unescaped
+	}
+	return client, segments, nil
 }
 
-// ensureExpand adds a property to an expansion string if not already present.
-func ensureExpand(current, required string) string {
-	if current == "" {
-		return required
+// readSpaceResource reads a space resource.
+func readSpaceResource(registry *ClientRegistry) mcpserver.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		client, path, err := registry.resourcePath(req.Params.URI)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 2 || path[0] != "space" || !isSafePathSegment(path[1]) {
+			return nil, fmt.Errorf("invalid space resource URI %q: must look like confluence://<instance>/space/<key>", req.Params.URI)
+		}
+		resp, err := client.doRequest(ctx, "GET", "/space/"+path[1], url.Values{"expand": {"description.plain,homepage"}}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error getting space: %w", err)
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "application/json", Text: string(resp)}}, nil
 	}
-	parts := strings.Split(current, ",")
-	for _, p := range parts {
-		if strings.TrimSpace(p) == required {
-			return current
+}
+
+// readLabelResource reads a label resource.
+func readLabelResource(registry *ClientRegistry) mcpserver.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		client, path, err := registry.resourcePath(req.Params.URI)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 2 || path[0] != "label" {
+			return nil, fmt.Errorf("invalid label resource URI %q: must look like confluence://<instance>/label/<label>", req.Params.URI)
+		}
+		query := url.Values{}
+		query.Set("cql", "label = "+quoteCQL(path[1])+" ORDER BY lastmodified DESC")
+		query.Set("limit", fmt.Sprintf("%d", defaultLimit))
+		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error searching content: %w", err)
 		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "application/json", Text: string(resp)}}, nil
 	}
-	return current + "," + required
 }
 
-// newQueryWithCommonArgs helper creates a url.Values object and populates it with common pagination and expansion parameters.
-func newQueryWithCommonArgs(args map[string]any) url.Values {
-	query := url.Values{}
-	if limit, ok := args["limit"].(float64); ok {
-		query.Set("limit", fmt.Sprintf("%d", int(limit)))
+// readTemplateResource reads a template resource.
+func readTemplateResource(registry *ClientRegistry) mcpserver.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		client, path, err := registry.resourcePath(req.Params.URI)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 4 || path[0] != "space" || path[2] != "template" || !isSafePathSegment(path[1]) {
+			return nil, fmt.Errorf("invalid template resource URI %q: must look like confluence://<instance>/space/<key>/template/<name>", req.Params.URI)
+		}
+		templates, err := client.listPageTemplates(ctx, path[1], "body.storage")
+		if err != nil {
+			return nil, fmt.Errorf("error listing templates: %w", err)
+		}
+		for _, template := range templates {
+			if template.Name == path[3] {
+				data, err := json.Marshal(template)
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode template: %w", err)
+				}
+				return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "application/json", Text: string(data)}}, nil
+			}
+		}
+		return nil, fmt.Errorf("no template named %q in space %s", path[3], path[1])
+	}
+}
+
+// completionRequest is a completion/complete request, which mcp-go does not handle.
+type completionRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params struct {
+		Argument struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"argument"`
+		Context struct {
+			Arguments map[string]string `json:"arguments"`
+		} `json:"context"`
+	} `json:"params"`
+}
+
+// parseCompletionRequest returns the completion request in message, or nil if it is another message.
+func parseCompletionRequest(message []byte) *completionRequest {
+	if !bytes.Contains(message, []byte(`"completion/complete"`)) {
+		return nil
+	}
+	var request completionRequest
+	if err := json.Unmarshal(message, &request); err != nil || request.Method != "completion/complete" || len(request.ID) == 0 {
+		return nil
+	}
+	return &request
+}
+
+// completions answers completion requests for the arguments of the resource templates (instance,
+// spaceKey, label, and templateName) from the Confluence instances, at the transport level. Over
+// stdio the answers are written to the output; over SSE they are written to the event stream of
+// the session, which it keeps track of.
+type completions struct {
+	registry *ClientRegistry
+
+	mu      sync.Mutex
+	streams map[string]*eventStream
+}
+
+// newCompletions returns the completions of the instances of registry.
+func newCompletions(registry *ClientRegistry) *completions {
+	return &completions{registry: registry, streams: map[string]*eventStream{}}
+}
+
+// answer returns the JSON-RPC response to a completion request.
+func (comp *completions) answer(ctx context.Context, request *completionRequest) []byte {
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+
+	values, err := comp.complete(ctx, request.Params.Argument.Name, request.Params.Argument.Value, request.Params.Context.Arguments)
+	var response map[string]any
+	if err != nil {
+		response = map[string]any{"jsonrpc": "2.0", "id": request.ID, "error": map[string]any{"code": mcp.INVALID_PARAMS, "message": err.Error()}}
 	} else {
-		query.Set("limit", fmt.Sprintf("%d", defaultLimit))
+		completion := map[string]any{"values": values, "total": len(values), "hasMore": len(values) > maxCompletionValues}
+		if len(values) > maxCompletionValues {
+			completion["values"] = values[:maxCompletionValues]
+		}
+		response = map[string]any{"jsonrpc": "2.0", "id": request.ID, "result": map[string]any{"completion": completion}}
 	}
-	if start, ok := args["start"].(float64); ok {
-		query.Set("start", fmt.Sprintf("%d", int(start)))
+	data, _ := json.Marshal(response)
+	return data
+}
+
+// complete returns the values of an argument that match value, given the arguments already chosen.
+func (comp *completions) complete(ctx context.Context, argument, value string, chosen map[string]string) ([]string, error) {
+	if argument == "instance" {
+		return matchCompletions(comp.registry.names, value), nil
 	}
-	if expand, ok := args["expand"].(string); ok && expand != "" {
-		query.Set("expand", expand)
+	instance := cmp.Or(chosen["instance"], comp.registry.names[0])
+	client, ok := comp.registry.clients[instance]
+	if !ok {
+		return nil, fmt.Errorf("unknown instance %q: must be one of %s", instance, strings.Join(comp.registry.names, ", "))
 	}
-	return query
+
+	switch argument {
+	case "spaceKey":
+		return client.completeSpaceKeys(ctx, value)
+	case "label":
+		return client.completeLabels(ctx, chosen["spaceKey"], value)
+	case "templateName":
+		return client.completeTemplateNames(ctx, chosen["spaceKey"], value)
+	}
+	return []string{}, nil
 }
 
-// handleGetContent returns a tool handler for retrieving Confluence content by ID.
-func handleGetContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args, err := getArguments(req)
+// matchCompletions returns the candidates that start with value, ignoring case, in sorted order.
+func matchCompletions(candidates []string, value string) []string {
+	matches := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(value)) && !slices.Contains(matches, candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	slices.Sort(matches)
+	return matches
+}
+
+// answerMessages returns next with the completion requests posted to the SSE message endpoint
+// answered on the event stream of their session, and next with the event streams tracked.
+func (comp *completions) answerMessages(next http.Handler) http.Handler {
+	if comp == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.URL.Query().Get("sessionId")
+		if r.Method == http.MethodGet {
+			stream := &eventStream{ResponseWriter: w, comp: comp}
+			next.ServeHTTP(stream, r)
+			stream.mu.Lock()
+			stream.closed = true
+			stream.mu.Unlock()
+			comp.mu.Lock()
+			if comp.streams[stream.sessionID] == stream {
+				delete(comp.streams, stream.sessionID)
+			}
+			comp.mu.Unlock()
+			return
+		}
+		if r.Method != http.MethodPost || sessionID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		request := parseCompletionRequest(body)
+		if request == nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+			return
+		}
+		comp.mu.Lock()
+		stream := comp.streams[sessionID]
+		comp.mu.Unlock()
+		if stream == nil {
+			http.Error(w, "Invalid session ID", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go stream.send(comp.answer(context.Background(), request))
+	})
+}
+
+// eventStream is the SSE event stream of a session, which completion answers are written to
+// between the events of mcp-go. The session ID is taken from the endpoint event that opens it.
+type eventStream struct {
+	http.ResponseWriter
+	comp *completions
+
+	mu        sync.Mutex
+	sessionID string
+	closed    bool
+}
+
+func (e *eventStream) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sessionID == "" {
+		if _, rest, ok := bytes.Cut(p, []byte("sessionId=")); ok && bytes.HasPrefix(p, []byte("event: endpoint")) {
+			end := bytes.IndexAny(rest, "&\r\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			e.sessionID = string(rest[:end])
+			e.comp.mu.Lock()
+			e.comp.streams[e.sessionID] = e
+			e.comp.mu.Unlock()
+		}
+	}
+	return e.ResponseWriter.Write(p)
+}
+
+func (e *eventStream) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// send writes a message event to the stream, unless it has ended.
+func (e *eventStream) send(message []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	fmt.Fprintf(e.ResponseWriter, "event: message\ndata: %s\n\n", message)
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// contentResource returns the client and content ID a content resource URI refers to.
+func (r *ClientRegistry) contentResource(uri string) (*ConfluenceClient, string, error) {
+	rest, ok := strings.CutPrefix(uri, "confluence://")
+	instance, contentID, found := strings.Cut(rest, "/content/")
+	if !ok || !found || contentID == "" || !isSafePathSegment(contentID) {
+		return nil, "", fmt.Errorf("invalid content resource URI %q: must look like confluence://<instance>/content/<id>", uri)
+	}
+	client, ok := r.clients[instance]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown instance %q: must be one of %s", instance, strings.Join(r.names, ", "))
+	}
+	return client, contentID, nil
+}
+
+// readContentResource returns the handler reading content resources.
+func readContentResource(registry *ClientRegistry) mcpserver.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		client, contentID, err := registry.contentResource(req.Params.URI)
+		if err != nil {
+			return nil, err
 		}
+		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID, url.Values{"expand": {"body.storage,version,space"}}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error getting content: %w", err)
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "application/json", Text: string(resp)}}, nil
+	}
+}
 
-		contentID, ok := args["contentId"].(string)
-		if !ok || contentID == "" {
-			return mcp.NewToolResultError("contentId must be a string and is required"), nil
+// subscriptions tracks the content resources that MCP sessions subscribed to and sends them
+// resources/updated notifications when the content gets a new version. mcp-go does not handle
+// resources/subscribe, so the transports pass each incoming message through rewrite.
+type subscriptions struct {
+	server        *mcpserver.MCPServer
+	registry      *ClientRegistry
+	webhookSecret string
+
+	mu sync.Mutex
+	// sessions holds the IDs of the sessions subscribed to each URI.
+	sessions map[string]map[string]bool
+	// versions holds the last version seen of each URI, or -1 when the content was not found.
+	versions map[string]int
+}
+
+// newSubscriptions returns the subscriptions of s and forgets those of sessions when they end.
+func newSubscriptions(s *mcpserver.MCPServer, registry *ClientRegistry, webhookSecret string) *subscriptions {
+	sub := &subscriptions{server: s, registry: registry, webhookSecret: webhookSecret,
+		sessions: map[string]map[string]bool{}, versions: map[string]int{}}
+	hooksOf(s).AddOnUnregisterSession(func(ctx context.Context, session mcpserver.ClientSession) {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		for uri := range sub.sessions {
+			sub.removeLocked(uri, session.SessionID())
 		}
+	})
+	return sub
+}
+
+// rewrite handles a resources/subscribe or resources/unsubscribe request of session, which it
+// replaces with a ping of the same ID: mcp-go answers that with the empty result the client
+// expects. A subscription to an invalid URI becomes a resources/read of it instead, which fails
+// with the reason. Other messages are returned unchanged.
+func (sub *subscriptions) rewrite(sessionID string, message []byte) []byte {
+	if sub == nil || !bytes.Contains(message, []byte(`"resources/`)) {
+		return message
+	}
+	var request struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || len(request.ID) == 0 {
+		return message
+	}
 
-		if strings.Contains(contentID, "/") || strings.Contains(contentID, "..") {
-			return mcp.NewToolResultError("invalid contentId format"), nil
+	method := "ping"
+	switch request.Method {
+	case "resources/subscribe":
+		if _, _, err := sub.registry.contentResource(request.Params.URI); err != nil {
+			method = "resources/read"
+			break
 		}
+		sub.mu.Lock()
+		if sub.sessions[request.Params.URI] == nil {
+			sub.sessions[request.Params.URI] = map[string]bool{}
+		}
+		sub.sessions[request.Params.URI][sessionID] = true
+		sub.mu.Unlock()
+		// The version at the time of subscribing is the one later versions are compared to.
+		go sub.check(context.Background(), request.Params.URI)
+	case "resources/unsubscribe":
+		sub.mu.Lock()
+		sub.removeLocked(request.Params.URI, sessionID)
+		sub.mu.Unlock()
+	default:
+		return message
+	}
 
-		query := newQueryWithCommonArgs(args)
-		query.Set("expand", ensureExpand(query.Get("expand"), "body.storage"))
+	rewritten, _ := json.Marshal(map[string]any{"jsonrpc": request.JSONRPC, "id": request.ID, "method": method,
+		"params": map[string]any{"uri": request.Params.URI}})
+	if bytes.HasSuffix(message, []byte("\n")) {
+		rewritten = append(rewritten, '\n')
+	}
+	return rewritten
+}
+
+// removeLocked drops the subscription of session to uri, and uri once nobody is subscribed to it.
+func (sub *subscriptions) removeLocked(uri, sessionID string) {
+	delete(sub.sessions[uri], sessionID)
+	if len(sub.sessions[uri]) == 0 {
+		delete(sub.sessions, uri)
+		delete(sub.versions, uri)
+	}
+}
 
-		resp, err := client.doRequest(ctx, "GET", "/content/"+contentID, query, nil)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("error getting content: %v", err)), nil
+// watch checks all subscribed content every interval, or defaultSubscriptionPollInterval when it
+// is not positive, until ctx is done.
+func (sub *subscriptions) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSubscriptionPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sub.mu.Lock()
+			uris := slices.Collect(maps.Keys(sub.sessions))
+			sub.mu.Unlock()
+			sub.check(ctx, uris...)
 		}
-
-		return mcp.NewToolResultText(string(resp)), nil
 	}
 }
 
-// handleSearchContent returns a tool handler for searching Confluence content using CQL.
-func handleSearchContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args, err := getArguments(req)
+// check reads the current version of each of uris and notifies the subscribed sessions of the
+// ones that changed since they were last read, including content that was deleted.
+func (sub *subscriptions) check(ctx context.Context, uris ...string) {
+	for _, uri := range uris {
+		client, contentID, err := sub.registry.contentResource(uri)
 		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
+			continue
 		}
-
-		cql, ok := args["cql"].(string)
-		if !ok || cql == "" {
-			return mcp.NewToolResultError("cql must be a string and is required"), nil
+		var content ConfluencePage
+		version := -1
+		err = client.getJSON(ctx, "/content/"+contentID, url.Values{"expand": {"version"}}, &content)
+		switch {
+		case err == nil && content.Version != nil:
+			version = content.Version.Number
+		case !isStatus(err, http.StatusNotFound):
+			slog.Warn("failed to check subscribed content", "uri", uri, "error", err)
+			continue
 		}
 
-		query := newQueryWithCommonArgs(args)
-		query.Set("cql", cql)
-
-		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("error searching content: %v", err)), nil
+		sub.mu.Lock()
+		if sub.sessions[uri] == nil {
+			sub.mu.Unlock()
+			continue
 		}
+		last, known := sub.versions[uri]
+		sub.versions[uri] = version
+		sessionIDs := slices.Collect(maps.Keys(sub.sessions[uri]))
+		sub.mu.Unlock()
 
-		return mcp.NewToolResultText(string(resp)), nil
+		if !known || last == version {
+			continue
+		}
+		for _, sessionID := range sessionIDs {
+			if err := sub.server.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri}); err != nil {
+				slog.Debug("failed to notify session of changed content", "session", sessionID, "uri", uri, "error", err)
+			}
+		}
 	}
 }
 
-// handleCreateContent returns a tool handler for creating new content (page or blogpost) in Confluence.
-func handleCreateContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args, err := getArguments(req)
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
+// handleWebhook receives the webhooks of a Confluence instance, named by the instance query
+// parameter (default: the first instance), and checks the subscribed content they name right away
+// instead of at the next poll. Deliveries must be signed with the webhook secret in X-Hub-Signature.
+func (sub *subscriptions) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(sub.webhookSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Hub-Signature")), []byte(expected)) != 1 {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
 
-		title, ok := args["title"].(string)
-		if !ok || title == "" {
-			return mcp.NewToolResultError("title is required"), nil
+	// The content of an event is under page or blog, with its ID as a number or a string.
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	instance := cmp.Or(r.URL.Query().Get("instance"), sub.registry.names[0])
+	var uris []string
+	for _, key := range []string{"page", "blog", "content"} {
+		var content struct {
+			ID json.Number `json:"id"`
 		}
-		spaceKey, ok := args["spaceKey"].(string)
-		if !ok || spaceKey == "" {
-			return mcp.NewToolResultError("spaceKey is required"), nil
+		if json.Unmarshal(event[key], &content) != nil || content.ID == "" {
+			continue
 		}
-		contentStr, ok := args["content"].(string)
-		if !ok || contentStr == "" {
-			return mcp.NewToolResultError("content is required"), nil
+		uri := "confluence://" + instance + "/content/" + content.ID.String()
+		sub.mu.Lock()
+		if sub.sessions[uri] != nil {
+			uris = append(uris, uri)
 		}
+		sub.mu.Unlock()
+	}
+	go sub.check(context.WithoutCancel(r.Context()), uris...)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		typeStr, ok := args["type"].(string)
-		if !ok || typeStr == "" {
-			typeStr = "page"
-		}
+// lockedWriter serializes the writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
 
-		parentID, _ := args["parentId"].(string)
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
 
-		payload := ConfluencePage{
-			Type:  typeStr,
-			Title: title,
-			Space: &SpaceRef{Key: spaceKey},
-			Body: &Body{
-				Storage: &BodyStorage{
-					Value:          contentStr,
-					Representation: "storage",
-				},
-			},
-		}
+// rewritingReader passes each line read from src through rewrite, which drops lines it returns nil for.
+type rewritingReader struct {
+	src     *bufio.Reader
+	rewrite func([]byte) []byte
+	buf     []byte
+	err     error
+}
 
-		if parentID != "" {
-			payload.Ancestors = []Ancestor{{ID: parentID}}
+func (r *rewritingReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var line []byte
+		line, r.err = r.src.ReadBytes('\n')
+		if len(line) > 0 {
+			r.buf = r.rewrite(line)
 		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
 
-		resp, err := client.doRequest(ctx, "POST", "/content", nil, payload)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("error creating content: %v", err)), nil
+// rewriteMessages returns next with the messages posted to the SSE message endpoint passed
+// through sub.rewrite.
+func rewriteMessages(sub *subscriptions, next http.Handler) http.Handler {
+	if sub == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Query().Get("sessionId") != "" {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed to read body", http.StatusBadRequest)
+				return
+			}
+			body = sub.rewrite(r.URL.Query().Get("sessionId"), body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		return mcp.NewToolResultText(string(resp)), nil
+// serverHooks holds the hooks of each server. mcp-go keeps a single set of hooks per server, which
+// installing another set replaces, so every feature adds its hooks to the same set.
+var serverHooks sync.Map
+
+// hooksOf returns the hooks of s, installing an empty set the first time.
+func hooksOf(s *mcpserver.MCPServer) *mcpserver.Hooks {
+	hooks, loaded := serverHooks.LoadOrStore(s, &mcpserver.Hooks{})
+	if !loaded {
+		mcpserver.WithHooks(hooks.(*mcpserver.Hooks))(s)
 	}
+	return hooks.(*mcpserver.Hooks)
 }
 
-// handleUpdateContent returns a tool handler for updating existing content in Confluence.
-func handleUpdateContent(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+// drainer tracks the tool calls in flight so that shutdown can wait for them. Once draining, it
+// rejects new calls.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	calls    sync.WaitGroup
+
+	// abort is cancelled when draining times out, cancelling the calls still in flight.
+	abort  context.Context
+	cancel context.CancelFunc
+}
+
+// newDrainer returns a drainer and installs it as tool handler middleware of s.
+func newDrainer(s *mcpserver.MCPServer) *drainer {
+	d := &drainer{}
+	d.abort, d.cancel = context.WithCancel(context.Background())
+	mcpserver.WithToolHandlerMiddleware(d.middleware)(s)
+	return d
+}
+
+// middleware counts the calls of next in flight. A call outlives the cancellation of the transport
+// that received it, so that shutting the transport down does not abort the Confluence requests of
+// the calls being drained.
+func (d *drainer) middleware(next mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args, err := getArguments(req)
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			return mcp.NewToolResultError("the server is shutting down and no longer accepts tool calls"), nil
 		}
+		d.calls.Add(1)
+		d.mu.Unlock()
+		defer d.calls.Done()
 
-		contentID, ok := args["contentId"].(string)
-		if !ok || contentID == "" {
-			return mcp.NewToolResultError("contentId is required"), nil
-		}
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		stop := context.AfterFunc(d.abort, cancel)
+		defer stop()
+		return next(ctx, req)
+	}
+}
 
-		if strings.Contains(contentID, "/") || strings.Contains(contentID, "..") {
-			return mcp.NewToolResultError("invalid contentId format"), nil
-		}
+// drain stops accepting tool calls and waits for the calls in flight until ctx is done, when it
+// cancels those still running.
+func (d *drainer) drain(ctx context.Context) {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
 
-		query := newQueryWithCommonArgs(args)
-		query.Set("expand", "body.storage,version,space")
-		var currentData ConfluencePage
-		if err := client.getJSON(ctx, "/content/"+contentID, query, &currentData); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to retrieve current content: %v", err)), nil
-		}
+	done := make(chan struct{})
+	go func() {
+		d.calls.Wait()
+		close(done)
+	}()
 
-		var newVersion int
-		if v, ok := args["version"].(float64); ok {
-			newVersion = int(v)
-		} else {
-			if currentData.Version == nil {
-				return mcp.NewToolResultError("could not determine current version from API response"), nil
+	slog.Info("shutting down, waiting for tool calls in flight")
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("tool calls did not finish in time and were cancelled")
+		d.cancel()
+	}
+}
+
+// serveStdio serves the MCP server on in and out until in is closed or ctx is done. When ctx is
+// done, it drains the tool calls in flight for up to shutdownTimeout before it stops reading.
+func serveStdio(ctx context.Context, s *mcpserver.MCPServer, sub *subscriptions, comp *completions, in io.Reader, out io.Writer) error {
+	if sub != nil || comp != nil {
+		// Completions are answered here, so the writes of mcp-go and of the answers must not interleave.
+		writer := &lockedWriter{w: out}
+		out = writer
+		in = &rewritingReader{src: bufio.NewReader(in), rewrite: func(message []byte) []byte {
+			if request := parseCompletionRequest(message); comp != nil && request != nil {
+				go func() {
+					_, _ = writer.Write(append(comp.answer(ctx, request), '\n'))
+				}()
+				return nil
 			}
-			newVersion = currentData.Version.Number + 1
+			return sub.rewrite("stdio", message)
+		}}
+	}
+	d := newDrainer(s)
+	listenCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- mcpserver.NewStdioServer(s).Listen(listenCtx, in, out)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelDrain()
+		d.drain(drainCtx)
+		cancel()
+		if err := <-errCh; !errors.Is(err, context.Canceled) {
+			return err
 		}
+		return nil
+	}
+}
 
-		title, _ := args["title"].(string)
-		contentStr, _ := args["content"].(string)
-		versionComment, _ := args["versionComment"].(string)
+// serveSSE serves the MCP server over SSE on addr until ctx is done. It then drains the tool calls
+// in flight and shuts the HTTP server down, together within shutdownTimeout.
+func serveSSE(ctx context.Context, s *mcpserver.MCPServer, sub *subscriptions, comp *completions, addr, authToken string) error {
+	d := newDrainer(s)
+	httpServer := &http.Server{Addr: addr, ReadHeaderTimeout: 10 * time.Second}
+	sseServer := mcpserver.NewSSEServer(s, mcpserver.WithHTTPServer(httpServer), mcpserver.WithKeepAlive(true))
+	mux := http.NewServeMux()
+	mux.Handle("/", requireBearerToken(authToken, comp.answerMessages(rewriteMessages(sub, sseServer))))
+	// Confluence cannot send the bearer token, so webhooks are authenticated by their signature.
+	if sub != nil && sub.webhookSecret != "" {
+		mux.HandleFunc(webhookPath, sub.handleWebhook)
+	}
+	httpServer.Handler = mux
 
-		payload := ConfluencePage{
-			ID:    contentID,
-			Type:  currentData.Type,
-			Space: currentData.Space,
-			Version: &Version{
-				Number:  newVersion,
-				Message: versionComment,
-			},
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sseServer.Start(addr)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		d.drain(shutdownCtx)
+		if err := sseServer.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown: %w", err)
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
 		}
+		return nil
+	}
+}
 
-		if title != "" {
-			payload.Title = title
-		} else {
-			payload.Title = currentData.Title
+// requireBearerToken rejects requests that do not carry token as a bearer token. An empty token
+// disables the check.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if contentStr != "" {
-			payload.Body = &Body{
-				Storage: &BodyStorage{
-					Value:          contentStr,
-					Representation: "storage",
-				},
-			}
-		} else if currentData.Body != nil {
-			payload.Body = currentData.Body
+// setupLogging directs the default logger as configured. CONFLUENCE_MCP_LOG_LEVEL and
+// CONFLUENCE_MCP_LOG_FILE override the file settings. Logs never go to standard output, which
+// carries the stdio transport.
+func setupLogging(config LoggingConfig) error {
+	if v := os.Getenv("CONFLUENCE_MCP_LOG_LEVEL"); v != "" {
+		config.Level = v
+	}
+	if v := os.Getenv("CONFLUENCE_MCP_LOG_FILE"); v != "" {
+		config.File = v
+	}
+
+	var level slog.Level
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return fmt.Errorf("invalid log level %q", config.Level)
 		}
+	}
 
-		resp, err := client.doRequest(ctx, "PUT", "/content/"+contentID, nil, payload)
+	var out io.Writer = os.Stderr
+	if config.File != "" {
+		f, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("error updating content: %v", err)), nil
+			return fmt.Errorf("failed to open log file: %w", err)
 		}
+		out = f
+	}
+	handler := slog.NewTextHandler(out, &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr})
+	slog.SetDefault(slog.New(&clientLogHandler{next: handler}))
+	return nil
+}
 
-		return mcp.NewToolResultText(string(resp)), nil
+// clientLogLevel returns the MCP log level of messages to clients for a log level.
+func clientLogLevel(level slog.Level) mcp.LoggingLevel {
+	switch {
+	case level >= slog.LevelError:
+		return mcp.LoggingLevelError
+	case level >= slog.LevelWarn:
+		return mcp.LoggingLevelWarning
+	case level >= slog.LevelInfo:
+		return mcp.LoggingLevelInfo
 	}
+	return mcp.LoggingLevelDebug
 }
 
-// handleListSpaces returns a tool handler for listing/searching Confluence spaces.
-func handleListSpaces(client *ConfluenceClient) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args, err := getArguments(req)
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
+// clientLogHandler passes records to next, and sends those logged in the context of a tool call
+// to the client of the call as MCP log messages, when the client asks for their level. Attributes
+// are redacted as in the log.
+type clientLogHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+}
 
-		searchText, _ := args["searchText"].(string)
-		var cql string
-		if searchText == "" {
-			cql = "type=space"
-		} else {
-			safeSearchText := strings.ReplaceAll(searchText, `"`, `\"`)
-			cql = fmt.Sprintf(`type=space AND title ~ "%s"`, safeSearchText)
+// clientLogSession returns the server a record logged with ctx at level is sent to a client
+// through and its MCP log level, or false when the record is not for a client.
+func clientLogSession(ctx context.Context, level slog.Level) (*mcpserver.MCPServer, mcp.LoggingLevel, bool) {
+	server := mcpserver.ServerFromContext(ctx)
+	session, ok := mcpserver.ClientSessionFromContext(ctx).(mcpserver.SessionWithLogging)
+	if server == nil || !ok || !session.Initialized() {
+		return nil, "", false
+	}
+	clientLevel := clientLogLevel(level)
+	return server, clientLevel, clientLevel.ShouldSendTo(session.GetLogLevel())
+}
+
+func (h *clientLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.next.Enabled(ctx, level) {
+		return true
+	}
+	_, _, ok := clientLogSession(ctx, level)
+	return ok
+}
+
+func (h *clientLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if server, level, ok := clientLogSession(ctx, record.Level); ok {
+		data := map[string]any{"message": record.Message}
+		add := func(attr slog.Attr) bool {
+			attr = redactAttr(nil, attr)
+			switch value := attr.Value.Resolve(); value.Kind() {
+			case slog.KindString, slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool:
+				data[attr.Key] = value.Any()
+			default:
+				data[attr.Key] = value.String()
+			}
+			return true
 		}
-		query := newQueryWithCommonArgs(args)
-		query.Set("cql", cql)
+		for _, attr := range h.attrs {
+			add(attr)
+		}
+		record.Attrs(add)
+		_ = server.SendLogMessageToClient(ctx, mcp.NewLoggingMessageNotification(level, "confluence", data))
+	}
+	if h.next.Enabled(ctx, record.Level) {
+		return h.next.Handle(ctx, record)
+	}
+	return nil
+}
 
-		resp, err := client.doRequest(ctx, "GET", "/search", query, nil)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("error listing spaces: %v", err)), nil
+func (h *clientLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &clientLogHandler{next: h.next.WithAttrs(attrs), attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *clientLogHandler) WithGroup(name string) slog.Handler {
+	return &clientLogHandler{next: h.next.WithGroup(name), attrs: h.attrs}
+}
+
+// enableClientLogging sets the MCP log level of new sessions to the configured one, which
+// CONFLUENCE_MCP_CLIENT_LOG_LEVEL overrides, until their clients set another.
+func enableClientLogging(s *mcpserver.MCPServer, config LoggingConfig) error {
+	level := mcp.LoggingLevel(cmp.Or(os.Getenv("CONFLUENCE_MCP_CLIENT_LOG_LEVEL"), config.ClientLevel, string(mcp.LoggingLevelInfo)))
+	if !level.ShouldSendTo(level) {
+		return fmt.Errorf("invalid client log level %q", level)
+	}
+	hooksOf(s).AddOnRegisterSession(func(ctx context.Context, session mcpserver.ClientSession) {
+		if logging, ok := session.(mcpserver.SessionWithLogging); ok {
+			logging.SetLogLevel(level)
 		}
+	})
+	return nil
+}
 
-		return mcp.NewToolResultText(string(resp)), nil
+// redactAttr masks the values of log attributes whose names look like secrets.
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if secretNamePattern.MatchString(attr.Key) {
+		attr.Value = slog.StringValue("REDACTED")
 	}
+	return attr
 }
 
-// setupServer configures the MCP server and returns it.
-func setupServer(client *ConfluenceClient) *mcpserver.MCPServer {
-	s := mcpserver.NewMCPServer(
-		"atlassian-confluence-dc-go-mcp",
-		"1.0.0",
-		mcpserver.WithToolCapabilities(true),
-	)
+// cliOptions are the command-line settings shared by the serve and check commands. Instance
+// settings given here take precedence over the environment and the configuration file.
+type cliOptions struct {
+	configPath string
+	baseURL    string
+	timeout    time.Duration
+	readOnly   bool
+	set        map[string]bool
+}
 
-	s.AddTool(mcp.NewTool("confluence_get_content",
-		mcp.WithDescription("Get Confluence content by ID from the Confluence Data Center edition instance"),
-		mcp.WithString("contentId", mcp.Required(), mcp.Description("Confluence Data Center content ID")),
-		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
-	), handleGetContent(client))
+// register defines the shared flags on flags.
+func (o *cliOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.configPath, "config", os.Getenv("CONFLUENCE_MCP_CONFIG"), "path of a YAML configuration file")
+	flags.StringVar(&o.baseURL, "base-url", "", "base URL of the Confluence instance")
+	flags.DurationVar(&o.timeout, "timeout", 0, "timeout of each request to Confluence")
+	flags.BoolVar(&o.readOnly, "space-permissions-read-only", false, "disable the tools that grant and revoke space permissions")
+}
 
-	s.AddTool(mcp.NewTool("confluence_search_content",
-		mcp.WithDescription("Search for content in Confluence Data Center edition instance using CQL"),
-		mcp.WithString("cql", mcp.Required(), mcp.Description("Confluence Query Language (CQL) search string for Confluence Data Center")),
-		mcp.WithNumber("limit", mcp.Description("Maximum number of results to return (default: 25)")),
-		mcp.WithNumber("start", mcp.Description("The starting index of the results to return")),
-		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
-	), handleSearchContent(client))
+// load reads the configuration file, sets up logging, and applies the instance flags. The flags
+// configure the unprefixed variables, so they apply when a single instance is configured.
+func (o *cliOptions) load(flags *flag.FlagSet) (*FileConfig, error) {
+	o.set = map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { o.set[f.Name] = true })
 
-	s.AddTool(mcp.NewTool("confluence_create_content",
-		mcp.WithDescription("Create new content in Confluence Data Center edition instance"),
-		mcp.WithString("title", mcp.Required(), mcp.Description("The title of the new content")),
-		mcp.WithString("spaceKey", mcp.Required(), mcp.Description("The key of the space where content will be created")),
-		mcp.WithString("content", mcp.Required(), mcp.Description("The content of the page in Confluence storage format")),
-		mcp.WithString("type", mcp.Description("The type of content (page or blogpost)")),
-		mcp.WithString("parentId", mcp.Description("The ID of the parent content (optional)")),
-	), handleCreateContent(client))
+	file, err := loadFileConfig(o.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("configuration error: %v", err)
+	}
+	if err := setupLogging(file.Logging); err != nil {
+		return nil, fmt.Errorf("configuration error: %v", err)
+	}
 
-	s.AddTool(mcp.NewTool("confluence_update_content",
-		mcp.WithDescription("Update existing content in Confluence Data Center edition instance"),
-		mcp.WithString("contentId", mcp.Required(), mcp.Description("The ID of the content to update")),
-		mcp.WithNumber("version", mcp.Description("The new version number (optional, defaults to current version + 1)")),
-		mcp.WithString("title", mcp.Description("New title for the content")),
-		mcp.WithString("content", mcp.Description("New content in storage format")),
-		mcp.WithString("versionComment", mcp.Description("A comment for the new version")),
-	), handleUpdateContent(client))
+	overrides := map[string]string{
+		"base-url":                    "CONFLUENCE_BASE_URL",
+		"timeout":                     "CONFLUENCE_TIMEOUT",
+		"space-permissions-read-only": "CONFLUENCE_SPACE_PERMISSIONS_READ_ONLY",
+	}
+	for name, env := range overrides {
+		if o.set[name] {
+			if err := os.Setenv(env, flags.Lookup(name).Value.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return file, nil
+}
 
-	s.AddTool(mcp.NewTool("confluence_list_spaces",
-		mcp.WithDescription("List and search for spaces in Confluence Data Center edition instance"),
-		mcp.WithString("searchText", mcp.Description("Text to search for in space names or descriptions (optional, returns all spaces if omitted)")),
-		mcp.WithNumber("limit", mcp.Description("Maximum number of spaces to return")),
-		mcp.WithNumber("start", mcp.Description("The starting index of the results to return")),
-		mcp.WithString("expand", mcp.Description("Comma-separated list of properties to expand")),
-	), handleListSpaces(client))
+// runCLI runs the command named by the first argument: serve (the default), version, or check.
+func runCLI(args []string, stdout io.Writer) error {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	return s
-}
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	var options cliOptions
+	switch command {
+	case "version":
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(stdout, "atlassian-confluence-dc-go-mcp %s\n", version)
+		return nil
+
+	case "check":
+		options.register(flags)
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		file, err := options.load(flags)
+		if err != nil {
+			return err
+		}
+		return runCheck(context.Background(), file, stdout)
+
+	case "serve":
+		options.register(flags)
+		transport := flags.String("transport", "stdio", "transport to serve MCP on: stdio or sse")
+		listen := flags.String("listen", "localhost:8080", "address the sse transport listens on")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		file, err := options.load(flags)
+		if err != nil {
+			return err
+		}
+
+		// Flags given on the command line take precedence over the configuration file.
+		if !options.set["transport"] && file.Transport != "" {
+			*transport = file.Transport
+		}
+		if !options.set["listen"] && file.Listen != "" {
+			*listen = file.Listen
+		}
+
+		// The auth token is read from the environment rather than a flag to keep it out of process listings.
+		serve, err := newServeFunc(*transport, *listen, os.Getenv("CONFLUENCE_MCP_AUTH_TOKEN"))
+		if err != nil {
+			return err
+		}
+		return run(file, serve)
 
-type serveFunc func(*mcpserver.MCPServer) error
+	default:
+		return fmt.Errorf("unknown command %q: must be serve, version, or check", command)
+	}
+}
 
-func run(serve serveFunc) error {
-	config, err := loadConfig()
+// runCheck verifies that every configured instance is reachable and accepts its token, writing a
+// line per instance to out. It fails when any instance does.
+func runCheck(ctx context.Context, file *FileConfig, out io.Writer) error {
+	registry, err := loadRegistry(file)
 	if err != nil {
 		return fmt.Errorf("configuration error: %v", err)
 	}
 
-	client := NewConfluenceClient(config)
-	s := setupServer(client)
-
-	if err := serve(s); err != nil {
-		return fmt.Errorf("server error: %v", err)
+	failed := 0
+	for _, name := range registry.names {
+		client := registry.clients[name]
+		var user struct {
+			Username    string `json:"username"`
+			DisplayName string `json:"displayName"`
+		}
+		if err := client.getJSON(ctx, "/user/current", nil, &user); err != nil {
+			failed++
+			_, _ = fmt.Fprintf(out, "%s: %s: FAILED: %v\n", name, client.config.BaseURL, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "%s: %s: OK, authenticated as %s (%s)\n", name, client.config.BaseURL, user.DisplayName, user.Username)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d instances failed the check", failed, len(registry.names))
 	}
 	return nil
 }
 
 func main() {
-	if err := run(func(s *mcpserver.MCPServer) error {
-		return mcpserver.ServeStdio(s)
-	}); err != nil {
+	if err := runCLI(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -1,13 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	mcpserver "github.com/mark3labs/mcp-go/server"
@@ -69,6 +88,82 @@ func TestLoadConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "basic auth",
+			env: map[string]string{
+				"CONFLUENCE_USERNAME": "svc-mcp",
+				"CONFLUENCE_PASSWORD": "secret",
+				"CONFLUENCE_BASE_URL": "https://example.atlassian.net",
+			},
+			wantErr: false,
+			wantURL: "https://example.atlassian.net/rest/api",
+		},
+		{
+			name: "username without password",
+			env: map[string]string{
+				"CONFLUENCE_USERNAME": "svc-mcp",
+				"CONFLUENCE_BASE_URL": "https://example.atlassian.net",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token as basic password",
+			env: map[string]string{
+				"CONFLUENCE_API_TOKEN":   "test-token",
+				"CONFLUENCE_AUTH_SCHEME": "basic",
+				"CONFLUENCE_BASE_URL":    "https://example.atlassian.net",
+			},
+			wantErr: false,
+			wantURL: "https://example.atlassian.net/rest/api",
+		},
+		{
+			name: "token in custom header",
+			env: map[string]string{
+				"CONFLUENCE_API_TOKEN":   "test-token",
+				"CONFLUENCE_AUTH_SCHEME": "header:X-Proxy-Token",
+				"CONFLUENCE_BASE_URL":    "https://example.atlassian.net",
+			},
+			wantErr: false,
+			wantURL: "https://example.atlassian.net/rest/api",
+		},
+		{
+			name: "invalid header name",
+			env: map[string]string{
+				"CONFLUENCE_API_TOKEN":   "test-token",
+				"CONFLUENCE_AUTH_SCHEME": "header:X Proxy",
+				"CONFLUENCE_BASE_URL":    "https://example.atlassian.net",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown auth scheme",
+			env: map[string]string{
+				"CONFLUENCE_API_TOKEN":   "test-token",
+				"CONFLUENCE_AUTH_SCHEME": "digest",
+				"CONFLUENCE_BASE_URL":    "https://example.atlassian.net",
+			},
+			wantErr: true,
+		},
+		{
+			name: "password with bearer scheme",
+			env: map[string]string{
+				"CONFLUENCE_USERNAME":    "svc-mcp",
+				"CONFLUENCE_PASSWORD":    "secret",
+				"CONFLUENCE_AUTH_SCHEME": "bearer",
+				"CONFLUENCE_BASE_URL":    "https://example.atlassian.net",
+			},
+			wantErr: true,
+		},
+		{
+			name: "token and username",
+			env: map[string]string{
+				"CONFLUENCE_API_TOKEN": "test-token",
+				"CONFLUENCE_USERNAME":  "svc-mcp",
+				"CONFLUENCE_PASSWORD":  "secret",
+				"CONFLUENCE_BASE_URL":  "https://example.atlassian.net",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -374,6 +469,104 @@ func TestHandleCreateContent(t *testing.T) {
 	}
 }
 
+func TestHandleCreateTree(t *testing.T) {
+	var requests []string
+	nextID := 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			_, _ = w.Write([]byte(`{"id":"5","type":"page","title":"Docs","space":{"key":"DOC"}}`))
+		case "POST":
+			var page ConfluencePage
+			_ = json.NewDecoder(r.Body).Decode(&page)
+			parent := ""
+			if len(page.Ancestors) > 0 {
+				parent = page.Ancestors[0].ID
+			}
+			requests = append(requests, fmt.Sprintf("POST %s %s under %s: %s", page.Space.Key, page.Title, parent, page.Body.Storage.Value))
+			if page.Title == "Broken" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"id":"%d","type":"page","title":%q}`, nextID, page.Title)
+			nextID++
+		case "DELETE":
+			requests = append(requests, "DELETE "+r.URL.Path)
+			if r.URL.Path == "/rest/api/content/107" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) (*mcp.CallToolResult, CreateTreeReport) {
+		t.Helper()
+		result, err := handleCreateTree(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report CreateTreeReport
+		if !result.IsError {
+			if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, report
+	}
+
+	_, report := call(map[string]any{"parentId": "5", "contentFormat": "markdown", "pages": []any{
+		map[string]any{"title": "Guide", "content": "# Guide", "children": []any{map[string]any{"title": "Install"}}},
+		map[string]any{"title": "FAQ"},
+	}})
+	want := []string{"POST DOC Guide under 5: <h1>Guide</h1>", "POST DOC Install under 100: ", "POST DOC FAQ under 5: "}
+	if !slices.Equal(requests, want) {
+		t.Errorf("expected requests %q, got %q", want, requests)
+	}
+	if got := fmt.Sprint(report.Created); report.Failed != nil || got != "[{100 Guide 5 } {101 Install 100 } {102 FAQ 5 }]" {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	failing := []any{map[string]any{"title": "Runbooks", "children": []any{map[string]any{"title": "Deploy"}, map[string]any{"title": "Broken"}}}}
+	requests = nil
+	_, report = call(map[string]any{"spaceKey": "OPS", "pages": failing})
+	if report.Failed == nil || report.Failed.Title != "Broken" || report.Failed.ParentID != "103" || fmt.Sprint(report.Deleted) != "[104 103]" || report.Leftover != nil {
+		t.Errorf("expected the created pages to be deleted, got %+v", report)
+	}
+	if n := len(requests); n != 5 || requests[3] != "DELETE /rest/api/content/104" {
+		t.Errorf("expected the children to be deleted first, got %q", requests)
+	}
+
+	requests = nil
+	_, report = call(map[string]any{"spaceKey": "OPS", "pages": failing, "onFailure": "report"})
+	if len(report.Leftover) != 2 || report.Deleted != nil || len(requests) != 3 {
+		t.Errorf("expected the created pages to be reported, got %+v after %q", report, requests)
+	}
+
+	_, report = call(map[string]any{"spaceKey": "OPS", "pages": failing})
+	if len(report.Leftover) != 1 || report.Leftover[0].ID != "107" || report.Leftover[0].Error == "" || fmt.Sprint(report.Deleted) != "[108]" {
+		t.Errorf("expected the page that could not be deleted to be left over, got %+v", report)
+	}
+
+	requests = nil
+	for _, args := range []map[string]any{
+		{"pages": []any{map[string]any{"title": "A"}}},
+		{"spaceKey": "OPS"},
+		{"spaceKey": "OPS", "pages": []any{map[string]any{"title": " "}}},
+		{"spaceKey": "OPS", "pages": []any{map[string]any{"title": "A", "children": []any{map[string]any{"title": "a"}}}}},
+		{"spaceKey": "OPS", "pages": []any{map[string]any{"title": "A", "content": "<p>open"}}},
+		{"spaceKey": "OPS", "pages": []any{map[string]any{"title": "A"}}, "onFailure": "ignore"},
+	} {
+		if result, _ := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected nothing to be created after errors, got %q", requests)
+	}
+}
+
 // TestHandleUpdateContent tests updating existing content.
 func TestHandleUpdateContent(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -582,6 +775,28 @@ func TestLoadConfigMore(t *testing.T) {
 			t.Errorf("expected https prefix, got %s", config.BaseURL)
 		}
 	})
+
+	t.Run("space permissions read-only", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_API_TOKEN", "test-token")
+		t.Setenv("CONFLUENCE_BASE_URL", "https://example.com")
+		t.Setenv("CONFLUENCE_SPACE_PERMISSIONS_READ_ONLY", "true")
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !config.SpacePermissionsReadOnly {
+			t.Error("expected space permissions to be read-only")
+		}
+	})
+
+	t.Run("invalid read-only value", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_API_TOKEN", "test-token")
+		t.Setenv("CONFLUENCE_BASE_URL", "https://example.com")
+		t.Setenv("CONFLUENCE_SPACE_PERMISSIONS_READ_ONLY", "maybe")
+		if _, err := loadConfig(); err == nil {
+			t.Error("expected error for invalid boolean")
+		}
+	})
 }
 
 // TestHandleCreateContentMore covers additional paths in handleCreateContent.
@@ -982,10 +1197,22 @@ func TestHandleUpdateContentPutError(t *testing.T) {
 // TestSetupServer tests the setupServer function.
 func TestSetupServer(t *testing.T) {
 	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
-	s := setupServer(client)
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
 	if s == nil {
 		t.Fatal("setupServer returned nil")
 	}
+	if s.GetTool("confluence_grant_space_permission") == nil {
+		t.Error("expected space permission tools to be registered")
+	}
+
+	readOnly := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t", SpacePermissionsReadOnly: true})
+	s = setupServer(singleClientRegistry(readOnly), ToolsConfig{})
+	if s.GetTool("confluence_grant_space_permission") != nil || s.GetTool("confluence_revoke_space_permission") != nil {
+		t.Error("expected space permission tools to be hidden in read-only mode")
+	}
+	if s.GetTool("confluence_get_space_permissions") == nil {
+		t.Error("expected space permission inspection to stay available in read-only mode")
+	}
 }
 
 // TestDoRequestReadError tests io.Read error in doRequest.
@@ -1008,10 +1235,15 @@ func TestDoRequestReadError(t *testing.T) {
 
 // TestRun tests the run function.
 func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"username":"jdoe","displayName":"Jane Doe","version":"9.2.1"}`))
+	}))
+	defer server.Close()
+
 	t.Run("success", func(t *testing.T) {
 		t.Setenv("CONFLUENCE_API_TOKEN", "token")
-		t.Setenv("CONFLUENCE_BASE_URL", "http://localhost")
-		err := run(func(s *mcpserver.MCPServer) error {
+		t.Setenv("CONFLUENCE_BASE_URL", server.URL)
+		err := run(&FileConfig{}, func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
 			return nil // dummy serve
 		})
 		if err != nil {
@@ -1021,7 +1253,7 @@ func TestRun(t *testing.T) {
 
 	t.Run("config error", func(t *testing.T) {
 		t.Setenv("CONFLUENCE_API_TOKEN", "") // trigger error
-		err := run(func(s *mcpserver.MCPServer) error {
+		err := run(&FileConfig{}, func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
 			return nil
 		})
 		if err == nil || !strings.Contains(strings.ToLower(err.Error()), "configuration error") {
@@ -1029,10 +1261,29 @@ func TestRun(t *testing.T) {
 		}
 	})
 
+	t.Run("startup check error", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_API_TOKEN", "token")
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		defer unreachable.Close()
+		t.Setenv("CONFLUENCE_BASE_URL", unreachable.URL)
+		err := run(&FileConfig{}, func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
+			t.Error("serve must not be called")
+			return nil
+		})
+		if err == nil || !strings.Contains(err.Error(), "startup check failed: instance default: no Confluence REST API found") {
+			t.Errorf("expected startup check error, got %v", err)
+		}
+
+		disabled := false
+		if err := run(&FileConfig{StartupCheck: &disabled}, func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error { return nil }); err != nil {
+			t.Errorf("expected no error with the startup check disabled, got %v", err)
+		}
+	})
+
 	t.Run("serve error", func(t *testing.T) {
 		t.Setenv("CONFLUENCE_API_TOKEN", "token")
-		t.Setenv("CONFLUENCE_BASE_URL", "http://localhost")
-		err := run(func(s *mcpserver.MCPServer) error {
+		t.Setenv("CONFLUENCE_BASE_URL", server.URL)
+		err := run(&FileConfig{}, func(s *mcpserver.MCPServer, sub *subscriptions, comp *completions) error {
 			return fmt.Errorf("serve failed")
 		})
 		if err == nil || !strings.Contains(strings.ToLower(err.Error()), "server error") {
@@ -1040,3 +1291,6422 @@ func TestRun(t *testing.T) {
 		}
 	})
 }
+
+// TestHandleGetComments tests listing comments on content.
+func TestHandleGetComments(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("footer and inline comments", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/rest/api/content/123/child/comment" {
+				t.Errorf("expected path /rest/api/content/123/child/comment, got %s", r.URL.Path)
+			}
+			q := r.URL.Query()
+			if !strings.Contains(q.Get("expand"), "extensions.inlineProperties") {
+				t.Errorf("expected inline properties expansion, got %s", q.Get("expand"))
+			}
+			if got := strings.Join(q["location"], ","); got != "footer,inline" {
+				t.Errorf("expected locations footer,inline, got %s", got)
+			}
+			if q.Get("start") != "25" {
+				t.Errorf("expected start 25, got %s", q.Get("start"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"results":[{"id":"900","type":"comment"}]}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		handler := handleGetComments(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "start": float64(25)},
+			},
+		}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"id":"900"`) {
+			t.Error("expected comment in result")
+		}
+	})
+
+	t.Run("include resolved", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := strings.Join(r.URL.Query()["location"], ","); got != "footer,inline,resolved" {
+				t.Errorf("expected locations footer,inline,resolved, got %s", got)
+			}
+			_, _ = w.Write([]byte(`{"results":[]}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleGetComments(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "includeResolved": true},
+			},
+		}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("invalid contentId format", func(t *testing.T) {
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+		handler := handleGetComments(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "../bad"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for bad contentId")
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleGetComments(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "error getting comments") {
+			t.Errorf("expected comments error, got %v", result.Content)
+		}
+	})
+}
+
+// TestHandleAddComment tests posting comments and replies.
+func TestHandleAddComment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reply to comment", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/rest/api/content" {
+				t.Errorf("expected POST /rest/api/content, got %s %s", r.Method, r.URL.Path)
+			}
+			var comment Comment
+			if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+				t.Fatalf("failed to decode request body: %v", err)
+			}
+			if comment.Type != "comment" {
+				t.Errorf("expected type comment, got %s", comment.Type)
+			}
+			if comment.Container == nil || comment.Container.ID != "123" || comment.Container.Type != "blogpost" {
+				t.Errorf("unexpected container: %+v", comment.Container)
+			}
+			if len(comment.Ancestors) != 1 || comment.Ancestors[0].ID != "900" {
+				t.Errorf("expected ancestor 900, got %+v", comment.Ancestors)
+			}
+			if comment.Body.Storage.Value != "<p>Agreed</p>" {
+				t.Errorf("unexpected body: %s", comment.Body.Storage.Value)
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"id":"901","type":"comment"}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		handler := handleAddComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{
+					"contentId":       "123",
+					"content":         "<p>Agreed</p>",
+					"parentCommentId": "900",
+					"containerType":   "blogpost",
+				},
+			},
+		}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("footer comment defaults to page container", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var comment Comment
+			_ = json.NewDecoder(r.Body).Decode(&comment)
+			if comment.Container.Type != "page" {
+				t.Errorf("expected page container, got %s", comment.Container.Type)
+			}
+			if len(comment.Ancestors) != 0 {
+				t.Errorf("expected no ancestors, got %+v", comment.Ancestors)
+			}
+			_, _ = w.Write([]byte(`{"id":"902"}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleAddComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "content": "<p>Hi</p>"},
+			},
+		}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("missing content", func(t *testing.T) {
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+		handler := handleAddComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "content is required") {
+			t.Error("expected content error")
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleAddComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "content": "<p>Hi</p>"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "error adding comment") {
+			t.Errorf("expected add comment error, got %v", result.Content)
+		}
+	})
+}
+
+// TestInsertInlineMarker tests anchoring inline comment markers in storage bodies.
+func TestInsertInlineMarker(t *testing.T) {
+	tests := []struct {
+		name       string
+		storage    string
+		selection  string
+		matchIndex int
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:      "first occurrence",
+			storage:   "<p>foo bar foo</p>",
+			selection: "foo",
+			want:      `<p><ac:inline-comment-marker ac:ref="ref">foo</ac:inline-comment-marker> bar foo</p>`,
+		},
+		{
+			name:       "second occurrence",
+			storage:    "<p>foo bar</p><p>foo</p>",
+			selection:  "foo",
+			matchIndex: 1,
+			want:       `<p>foo bar</p><p><ac:inline-comment-marker ac:ref="ref">foo</ac:inline-comment-marker></p>`,
+		},
+		{
+			name:      "ignores tag attributes",
+			storage:   `<a href="foo">link</a> foo`,
+			selection: "foo",
+			want:      `<a href="foo">link</a> <ac:inline-comment-marker ac:ref="ref">foo</ac:inline-comment-marker>`,
+		},
+		{
+			name:      "escaped entities",
+			storage:   "<p>R&amp;D</p>",
+			selection: "R&D",
+			want:      `<p><ac:inline-comment-marker ac:ref="ref">R&amp;D</ac:inline-comment-marker></p>`,
+		},
+		{
+			name:      "skips macro parameters and bodies",
+			storage:   `<ac:structured-macro ac:name="code"><ac:parameter ac:name="title">foo</ac:parameter><ac:plain-text-body><![CDATA[foo </ac:plain-text-body> foo]]></ac:plain-text-body></ac:structured-macro><p><![CDATA[foo]]>foo</p>`,
+			selection: "foo",
+			want:      `<ac:structured-macro ac:name="code"><ac:parameter ac:name="title">foo</ac:parameter><ac:plain-text-body><![CDATA[foo </ac:plain-text-body> foo]]></ac:plain-text-body></ac:structured-macro><p><![CDATA[foo]]><ac:inline-comment-marker ac:ref="ref">foo</ac:inline-comment-marker></p>`,
+		},
+		{
+			name:      "literal quotes",
+			storage:   `<p>Don't "restart" it</p>`,
+			selection: `Don't "restart"`,
+			want:      `<p><ac:inline-comment-marker ac:ref="ref">Don't "restart"</ac:inline-comment-marker> it</p>`,
+		},
+		{
+			name:      "not found",
+			storage:   "<p>foo</p>",
+			selection: "baz",
+			wantErr:   true,
+		},
+		{
+			name:       "occurrence out of range",
+			storage:    "<p>foo</p>",
+			selection:  "foo",
+			matchIndex: 1,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insertInlineMarker(tt.storage, tt.selection, tt.matchIndex, "ref")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("insertInlineMarker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("insertInlineMarker() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleAddInlineComment tests creating inline comments anchored to text.
+func TestHandleAddInlineComment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("anchors comment to selection", func(t *testing.T) {
+		var markerRef string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+				_ = json.NewEncoder(w).Encode(ConfluencePage{
+					ID:      "123",
+					Type:    "page",
+					Title:   "Runbook",
+					Version: &Version{Number: 4},
+					Body:    &Body{Storage: &BodyStorage{Value: "<p>Restart the service</p>"}},
+				})
+			case "PUT":
+				var page ConfluencePage
+				_ = json.NewDecoder(r.Body).Decode(&page)
+				if page.Version.Number != 5 {
+					t.Errorf("expected version 5, got %d", page.Version.Number)
+				}
+				if !strings.Contains(page.Body.Storage.Value, `<ac:inline-comment-marker ac:ref="`) {
+					t.Errorf("expected marker in body, got %s", page.Body.Storage.Value)
+				}
+				_ = json.NewEncoder(w).Encode(page)
+			case "POST":
+				var comment Comment
+				_ = json.NewDecoder(r.Body).Decode(&comment)
+				if comment.Extensions == nil || comment.Extensions.Location != "inline" {
+					t.Fatalf("expected inline extensions, got %+v", comment.Extensions)
+				}
+				if comment.Extensions.InlineProperties.OriginalSelection != "the service" {
+					t.Errorf("unexpected selection: %s", comment.Extensions.InlineProperties.OriginalSelection)
+				}
+				markerRef = comment.Extensions.InlineProperties.MarkerRef
+				_, _ = w.Write([]byte(`{"id":"950"}`))
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleAddInlineComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{
+					"contentId": "123",
+					"content":   "<p>Which one?</p>",
+					"selection": "the service",
+				},
+			},
+		}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if len(markerRef) != 36 {
+			t.Errorf("expected UUID marker reference, got %q", markerRef)
+		}
+	})
+
+	t.Run("removes the marker when the comment fails", func(t *testing.T) {
+		var saved []string
+		current := ConfluencePage{
+			ID:      "123",
+			Type:    "page",
+			Title:   "Runbook",
+			Version: &Version{Number: 4},
+			Body:    &Body{Storage: &BodyStorage{Value: "<p>Restart the service</p>"}},
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+				_ = json.NewEncoder(w).Encode(current)
+			case "PUT":
+				var page ConfluencePage
+				_ = json.NewDecoder(r.Body).Decode(&page)
+				saved = append(saved, fmt.Sprintf("%d %s", page.Version.Number, page.Body.Storage.Value))
+				current = page
+				_ = json.NewEncoder(w).Encode(page)
+			case "POST":
+				// Someone else edits the page before the comment fails.
+				current.Version = &Version{Number: current.Version.Number + 1}
+				current.Body = &Body{Storage: &BodyStorage{Value: current.Body.Storage.Value + "<p>Other</p>"}}
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "content": "c", "selection": "the service"}}}
+		result, _ := handleAddInlineComment(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected the failed comment to be reported")
+		}
+		if len(saved) != 2 || !strings.Contains(saved[0], "inline-comment-marker") || saved[1] != "7 <p>Restart the service</p><p>Other</p>" {
+			t.Errorf("expected the marker to be removed again, got %q", saved)
+		}
+	})
+
+	t.Run("selection not found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				t.Errorf("unexpected %s request", r.Method)
+			}
+			_ = json.NewEncoder(w).Encode(ConfluencePage{
+				ID:      "123",
+				Version: &Version{Number: 1},
+				Body:    &Body{Storage: &BodyStorage{Value: "<p>Nothing here</p>"}},
+			})
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		handler := handleAddInlineComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "content": "c", "selection": "missing"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "selection not found") {
+			t.Errorf("expected selection error, got %v", result.Content)
+		}
+	})
+
+	t.Run("missing selection", func(t *testing.T) {
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+		handler := handleAddInlineComment(client)
+		req := mcp.CallToolRequest{
+			Params: mcp.CallToolParams{
+				Arguments: map[string]any{"contentId": "123", "content": "c"},
+			},
+		}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "selection is required") {
+			t.Error("expected selection error")
+		}
+	})
+}
+
+// TestGetStringList tests extracting string lists from arguments.
+func TestGetStringList(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string
+	}{
+		{"array", map[string]any{"labels": []any{"a", " b ", ""}}, []string{"a", "b"}},
+		{"comma separated", map[string]any{"labels": "a, b,,c"}, []string{"a", "b", "c"}},
+		{"missing", map[string]any{}, nil},
+		{"wrong type", map[string]any{"labels": float64(1)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getStringList(tt.args, "labels")
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("getStringList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleLabels tests reading, adding, and removing labels.
+func TestHandleLabels(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/content/123/label" {
+			t.Errorf("expected path /rest/api/content/123/label, got %s", r.URL.Path)
+		}
+		switch r.Method {
+		case "GET":
+			if r.URL.Query().Get("prefix") != "global" {
+				t.Errorf("expected prefix global, got %s", r.URL.Query().Get("prefix"))
+			}
+			_, _ = w.Write([]byte(`{"results":[{"prefix":"global","name":"runbook"}]}`))
+		case "POST":
+			var labels []Label
+			_ = json.NewDecoder(r.Body).Decode(&labels)
+			if len(labels) != 2 || labels[0].Name != "runbook" || labels[1].Prefix != "global" {
+				t.Errorf("unexpected labels: %+v", labels)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"results": labels})
+		case "DELETE":
+			if r.URL.Query().Get("name") != "old/label" {
+				t.Errorf("expected name old/label, got %s", r.URL.Query().Get("name"))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	t.Run("get labels", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "prefix": "global"}}}
+		result, err := handleGetLabels(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, "runbook") {
+			t.Error("expected label in result")
+		}
+	})
+
+	t.Run("add labels", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "labels": []any{"runbook", "ops"}}}}
+		result, err := handleAddLabels(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("add labels missing labels", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, _ := handleAddLabels(client)(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "labels is required") {
+			t.Error("expected labels error")
+		}
+	})
+
+	t.Run("remove label", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "label": "old/label"}}}
+		result, err := handleRemoveLabel(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, "removed") {
+			t.Errorf("unexpected result: %v", result.Content)
+		}
+	})
+
+	t.Run("remove label missing label", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, _ := handleRemoveLabel(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected label error")
+		}
+	})
+}
+
+// TestQuoteCQL tests quoting of CQL string literals.
+func TestQuoteCQL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", `"plain"`},
+		{`a "quoted" word`, `"a \"quoted\" word"`},
+		{`back\slash`, `"back\\slash"`},
+	}
+
+	for _, tt := range tests {
+		if got := quoteCQL(tt.value); got != tt.want {
+			t.Errorf("quoteCQL(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestSearchAll tests walking search result pages.
+func TestSearchAll(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		start := r.URL.Query().Get("start")
+		limit := r.URL.Query().Get("limit")
+		switch start {
+		case "0":
+			if limit != "50" {
+				t.Errorf("expected limit 50, got %s", limit)
+			}
+			results := make([]string, 50)
+			for i := range results {
+				results[i] = fmt.Sprintf(`{"id":"%d"}`, i)
+			}
+			_, _ = fmt.Fprintf(w, `{"results":[%s],"size":50,"totalSize":52}`, strings.Join(results, ","))
+		case "50":
+			_, _ = w.Write([]byte(`{"results":[{"id":"50"},{"id":"51"}],"size":2,"totalSize":52}`))
+		default:
+			t.Errorf("unexpected start %s", start)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+
+	t.Run("exhausts results", func(t *testing.T) {
+		calls = 0
+		result, err := client.searchAll(ctx, "label = x", "", 100)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != 52 || calls != 2 || result.Truncated {
+			t.Errorf("unexpected result: size=%d calls=%d truncated=%v", result.Size, calls, result.Truncated)
+		}
+	})
+
+	t.Run("stops at maxResults", func(t *testing.T) {
+		calls = 0
+		result, err := client.searchAll(ctx, "label = x", "", 50)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != 50 || calls != 1 || !result.Truncated {
+			t.Errorf("unexpected result: size=%d calls=%d truncated=%v", result.Size, calls, result.Truncated)
+		}
+	})
+
+	t.Run("continues after short pages", func(t *testing.T) {
+		var starts []string
+		capped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The server returns at most 20 results a page, and a next link only on the first.
+			start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			starts = append(starts, r.URL.Query().Get("start"))
+			var results []string
+			for i := start; i < min(start+min(limit, 20), 45); i++ {
+				results = append(results, fmt.Sprintf(`{"id":"%d"}`, i))
+			}
+			next := ""
+			if start == 0 {
+				next = `,"_links":{"next":"/rest/api/search?start=20"}`
+			}
+			_, _ = fmt.Fprintf(w, `{"results":[%s],"start":%d,"size":%d,"totalSize":45%s}`, strings.Join(results, ","), start, len(results), next)
+		}))
+		defer capped.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: capped.URL, Token: "t"})
+		result, err := client.searchAll(ctx, "label = x", "", 100)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != 45 || result.Truncated || strings.Join(starts, ",") != "0,20,40" {
+			t.Errorf("unexpected result: size=%d truncated=%v starts=%v", result.Size, result.Truncated, starts)
+		}
+
+		starts = nil
+		result, err = client.searchAll(ctx, "label = x", "", 30)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != 30 || !result.Truncated || strings.Join(starts, ",") != "0,20" {
+			t.Errorf("unexpected result: size=%d truncated=%v starts=%v", result.Size, result.Truncated, starts)
+		}
+	})
+
+	t.Run("stops at maxFetchBytes", func(t *testing.T) {
+		padding := strings.Repeat("x", 200*1024)
+		large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			results := make([]string, limit)
+			for i := range results {
+				results[i] = fmt.Sprintf(`{"id":"%03d","body":"%s"}`, i, padding)
+			}
+			_, _ = fmt.Fprintf(w, `{"results":[%s],"size":%d,"totalSize":5000}`, strings.Join(results, ","), limit)
+		}))
+		defer large.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: large.URL, Token: "t"})
+		result, err := client.searchAll(ctx, "label = x", "", 10)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != 10 {
+			t.Errorf("unexpected small result: size=%d", result.Size)
+		}
+
+		result, err = client.searchAll(ctx, "label = x", "", maxFetchResults)
+		if err != nil {
+			t.Fatalf("searchAll failed: %v", err)
+		}
+		if result.Size != maxFetchBytes/(len(padding)+22) || !result.Truncated {
+			t.Errorf("unexpected capped result: size=%d truncated=%v", result.Size, result.Truncated)
+		}
+	})
+}
+
+// TestHandleSearchContentFetchAll tests the fetchAll mode of confluence_search_content.
+func TestHandleSearchContentFetchAll(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("start") == "0" {
+			results := make([]string, 50)
+			for i := range results {
+				results[i] = fmt.Sprintf(`{"id":"%d"}`, i)
+			}
+			_, _ = fmt.Fprintf(w, `{"results":[%s],"size":50,"totalSize":51}`, strings.Join(results, ","))
+			return
+		}
+		_, _ = w.Write([]byte(`{"results":[{"id":"50"}],"size":1,"totalSize":51}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": "type = page", "fetchAll": true, "limit": float64(5)}}}
+	result, err := handleSearchContent(client)(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	var out SearchAllResult
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if out.Size != 51 || out.Truncated || out.CQL != "type = page" {
+		t.Errorf("unexpected result: size=%d truncated=%v cql=%q", out.Size, out.Truncated, out.CQL)
+	}
+}
+
+// TestHandleFindByLabel tests finding content by label.
+func TestHandleBulkLabel(t *testing.T) {
+	var mu sync.Mutex
+	var searches []url.Values
+	var changes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/rest/api/search" {
+			searches = append(searches, r.URL.Query())
+			_, _ = w.Write([]byte(`{"results":[` +
+				`{"content":{"id":"1","title":"One","metadata":{"labels":{"results":[{"prefix":"global","name":"old"}]}}}},` +
+				`{"content":{"id":"2","title":"Two","metadata":{"labels":{"results":[{"prefix":"global","name":"new"}]}}}},` +
+				`{"content":{"id":"3","title":"Three","metadata":{"labels":{"results":[{"prefix":"global","name":"old"},{"prefix":"global","name":"new"}]}}}},` +
+				`{"space":{"key":"DOC"}}],"start":0,"limit":50,"size":4,"totalSize":4}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		changes = append(changes, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("name")+strings.TrimSpace(string(body)))
+		if strings.Contains(r.URL.Path, "/3/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) (*mcp.CallToolResult, BulkLabelReport) {
+		t.Helper()
+		result, err := handleBulkLabel(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report BulkLabelReport
+		if !result.IsError {
+			if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, report
+	}
+
+	args := map[string]any{"cql": "label = old", "add": []any{"new"}, "remove": []any{"old"}, "dryRun": true}
+	_, report := call(args)
+	if report.Matched != 3 || report.Changed != 2 || len(changes) != 0 || searches[0].Get("expand") != "content.metadata.labels" {
+		t.Errorf("unexpected dry run %+v with changes %v", report, changes)
+	}
+	if got := fmt.Sprint(report.Results); got != "[{1 One [new] [old] } {3 Three [] [old] }]" {
+		t.Errorf("expected the content whose labels change, got %s", got)
+	}
+
+	args["dryRun"] = false
+	_, report = call(args)
+	if report.Changed != 1 || report.Failed != 1 || report.Results[1].Error == "" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	slices.Sort(changes)
+	want := []string{`DELETE /rest/api/content/1/label old`, `DELETE /rest/api/content/3/label old`, `POST /rest/api/content/1/label [{"prefix":"global","name":"new"}]`}
+	if !slices.Equal(changes, want) {
+		t.Errorf("unexpected requests %q", changes)
+	}
+
+	for _, args := range []map[string]any{{"add": []any{"x"}}, {"cql": "label = old"}, {"cql": "label = old", "add": "Old", "remove": "old"}} {
+		if result, _ := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestHandleBulkMove(t *testing.T) {
+	var mu sync.Mutex
+	var moves []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/rest/api/search":
+			_, _ = w.Write([]byte(`{"results":[` +
+				`{"content":{"id":"2","type":"page","title":"Two","ancestors":[{"id":"90"},{"id":"91"}],"space":{"key":"DOC"}}},` +
+				`{"content":{"id":"7","type":"blogpost","title":"News"}},` +
+				`{"content":{"id":"1","type":"page","title":"One","space":{"key":"DOC"}}}],"start":0,"limit":50,"size":3,"totalSize":3}`))
+		case r.URL.Path == "/rest/api/space/OPS":
+			_, _ = w.Write([]byte(`{"key":"OPS","homepage":{"id":"50"}}`))
+		case strings.HasSuffix(r.URL.Path, "/child/page"):
+			// Pages 4 and 9 come after page 8 under the same parent; every other page is a first child.
+			parent := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rest/api/content/"), "/child/page")
+			if parent == "94" {
+				_, _ = w.Write([]byte(`{"results":[{"id":"8"},{"id":"4"},{"id":"9"}]}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"results":[{"id":%q},{"id":"99"}]}`, strings.TrimPrefix(parent, "9"))
+		case r.Method == "GET":
+			id := strings.TrimPrefix(r.URL.Path, "/rest/api/content/")
+			if id == "404" || r.URL.Query().Get("expand") != "ancestors,space" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			parent := "9" + id
+			if id == "9" {
+				parent = "94"
+			}
+			_, _ = fmt.Fprintf(w, `{"id":%q,"type":"page","title":"Page %s","ancestors":[{"id":%q}],"space":{"key":"DOC"}}`, id, id, parent)
+		case r.Method == "PUT":
+			moves = append(moves, r.URL.Path)
+			if strings.HasPrefix(r.URL.Path, "/rest/api/content/3/") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) (*mcp.CallToolResult, BulkMoveReport) {
+		t.Helper()
+		result, err := handleBulkMove(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report BulkMoveReport
+		if !result.IsError {
+			if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, report
+	}
+
+	_, report := call(map[string]any{"cql": "space = DOC order by title", "targetSpaceKey": "OPS"})
+	if report.TargetID != "50" || report.Moved != 2 || report.Rollback != nil || fmt.Sprint(report.Skipped) != "[7]" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if want := []string{"/rest/api/content/2/move/append/50", "/rest/api/content/1/move/append/50"}; !slices.Equal(moves, want) {
+		t.Errorf("expected the pages appended in order %q, got %q", want, moves)
+	}
+	if got := fmt.Sprint(report.Results); got != "[{2 Two 91 DOC   } {1 One  DOC   }]" {
+		t.Errorf("expected the former parents, got %s", got)
+	}
+
+	moves = nil
+	_, report = call(map[string]any{"contentIds": []any{"4", "3", "5"}, "targetId": "60", "dryRun": true})
+	if report.Moved != 0 || len(report.Results) != 3 || len(moves) != 0 {
+		t.Errorf("unexpected dry run %+v with moves %q", report, moves)
+	}
+
+	_, report = call(map[string]any{"contentIds": []any{"4", "5", "3", "6"}, "targetId": "60"})
+	if report.Moved != 2 || len(report.Results) != 3 || report.Results[2].Error == "" || fmt.Sprint(report.NotMoved) != "[6]" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.Results[0].FromPreviousSiblingID != "8" || report.Results[1].FromPreviousSiblingID != "" {
+		t.Errorf("expected the former previous siblings, got %+v", report.Results)
+	}
+	want := []MoveArgs{{ContentID: "4", TargetID: "8", Position: "below"}, {ContentID: "5", TargetID: "99", Position: "above"}}
+	if !slices.Equal(report.Rollback, want) {
+		t.Errorf("expected rollback %+v, got %+v", want, report.Rollback)
+	}
+
+	// Page 9 was right after page 4, so page 4 has to be back before page 9 can go below it.
+	_, report = call(map[string]any{"contentIds": []any{"9", "4", "3"}, "targetId": "60"})
+	want = []MoveArgs{{ContentID: "4", TargetID: "8", Position: "below"}, {ContentID: "9", TargetID: "4", Position: "below"}}
+	if report.Moved != 2 || !slices.Equal(report.Rollback, want) {
+		t.Errorf("expected rollback %+v, got %+v", want, report.Rollback)
+	}
+
+	// With every child of the former parent moved, the first goes back as its only child.
+	want = []MoveArgs{{ContentID: "a", TargetID: "p", Position: "append"}, {ContentID: "b", TargetID: "a", Position: "below"}}
+	if got := rollbackMoves([]BulkMoveResult{
+		{ID: "b", FromParentID: "p", FromPreviousSiblingID: "a"},
+		{ID: "a", FromParentID: "p", FromNextSiblingID: "b"},
+	}); !slices.Equal(got, want) {
+		t.Errorf("expected rollback %+v, got %+v", want, got)
+	}
+
+	moves = nil
+	for _, args := range []map[string]any{
+		{"targetId": "60"},
+		{"contentIds": []any{"4"}, "cql": "type = page", "targetId": "60"},
+		{"contentIds": []any{"4"}},
+		{"contentIds": []any{"4", "404"}, "targetId": "60"},
+		{"contentIds": []any{"4", "60"}, "targetId": "60"},
+	} {
+		if result, _ := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	if len(moves) != 0 {
+		t.Errorf("expected no moves after errors, got %q", moves)
+	}
+}
+
+func TestHandleFindByLabel(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := `label in ("runbook", "on-call") AND space = "OPS"`
+		if cql := r.URL.Query().Get("cql"); cql != want {
+			t.Errorf("expected cql %s, got %s", want, cql)
+		}
+		_, _ = w.Write([]byte(`{"results":[{"id":"1"}],"size":1,"totalSize":1}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+	handler := handleFindByLabel(client)
+
+	t.Run("labels and space", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"labels":   []any{"runbook", "on-call"},
+			"spaceKey": "OPS",
+		}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		var out SearchAllResult
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+			t.Fatalf("failed to unmarshal result: %v", err)
+		}
+		if out.Size != 1 || out.Truncated {
+			t.Errorf("unexpected result: %+v", out)
+		}
+	})
+
+	t.Run("missing labels", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected labels error")
+		}
+	})
+}
+
+// TestStorageToText tests converting storage format to plain text.
+func TestStorageToText(t *testing.T) {
+	got := storageToText("<h1>Title</h1><p>Fish &amp; chips\n  are<br/>great</p>")
+	if got != "Title Fish & chips are great" {
+		t.Errorf("storageToText() = %q", got)
+	}
+}
+
+// TestTruncateText tests truncating text to a maximum length.
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("short", 10); got != "short" {
+		t.Errorf("expected untouched text, got %q", got)
+	}
+	if got := truncateText("héllo world", 6); got != "héllo…" {
+		t.Errorf("expected truncated text, got %q", got)
+	}
+}
+
+// TestHandleGetChildren tests listing child pages.
+func TestHandleGetChildren(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("with excerpts", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/rest/api/content/123/child/page" {
+				t.Errorf("expected path /rest/api/content/123/child/page, got %s", r.URL.Path)
+			}
+			if r.URL.Query().Get("expand") != "body.storage" {
+				t.Errorf("expected expand body.storage, got %s", r.URL.Query().Get("expand"))
+			}
+			_, _ = w.Write([]byte(`{"results":[{"id":"124","title":"Child","body":{"storage":{"value":"<p>Child text</p>"}}}],"size":1}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "includeExcerpt": true}}}
+		result, err := handleGetChildren(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !strings.Contains(text, `"excerpt":"Child text"`) || strings.Contains(text, `"body"`) {
+			t.Errorf("expected excerpt instead of body, got %s", text)
+		}
+	})
+
+	t.Run("without excerpts", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("expand") != "" {
+				t.Errorf("expected no expand, got %s", r.URL.Query().Get("expand"))
+			}
+			_, _ = w.Write([]byte(`{"results":[{"id":"124","title":"Child"}]}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, err := handleGetChildren(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("invalid excerpt response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`not json`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "includeExcerpt": true}}}
+		result, _ := handleGetChildren(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for invalid JSON")
+		}
+	})
+
+	t.Run("missing contentId", func(t *testing.T) {
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{}}}
+		result, _ := handleGetChildren(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for missing contentId")
+		}
+	})
+}
+
+// TestWebURL tests building absolute web UI links.
+func TestWebURL(t *testing.T) {
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "https://example.com/wiki/rest/api", Token: "t"})
+	if got := client.webURL("/display/OPS/Runbook"); got != "https://example.com/wiki/display/OPS/Runbook" {
+		t.Errorf("unexpected web URL: %s", got)
+	}
+	if got := client.webURL(""); got != "" {
+		t.Errorf("expected empty web URL, got %s", got)
+	}
+}
+
+// TestHandleGetDescendants tests building page trees.
+func TestHandleGetDescendants(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/content/1":
+			_, _ = w.Write([]byte(`{"id":"1","title":"Root","_links":{"webui":"/pages/viewpage.action?pageId=1"}}`))
+		case "/rest/api/content/1/child/page":
+			_, _ = w.Write([]byte(`{"results":[{"id":"2","title":"A","_links":{"webui":"/display/S/A"}},{"id":"3","title":"B"}]}`))
+		case "/rest/api/content/2/child/page":
+			_, _ = w.Write([]byte(`{"results":[{"id":"4","title":"A1"}]}`))
+		case "/rest/api/content/3/child/page", "/rest/api/content/4/child/page":
+			_, _ = w.Write([]byte(`{"results":[]}`))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetDescendants(client)
+
+	t.Run("full tree", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "1"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		var tree PageTree
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &tree); err != nil {
+			t.Fatalf("failed to unmarshal result: %v", err)
+		}
+		if tree.Count != 3 || tree.Truncated {
+			t.Errorf("unexpected tree: %+v", tree)
+		}
+		if len(tree.Root.Children) != 2 || tree.Root.Children[0].Children[0].Title != "A1" {
+			t.Errorf("unexpected structure: %+v", tree.Root)
+		}
+		if tree.Root.Children[0].URL != server.URL+"/display/S/A" {
+			t.Errorf("unexpected URL: %s", tree.Root.Children[0].URL)
+		}
+	})
+
+	t.Run("depth limit", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "1", "depth": float64(1)}}}
+		result, _ := handler(ctx, req)
+		var tree PageTree
+		_ = json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &tree)
+		if tree.Count != 2 || len(tree.Root.Children[0].Children) != 0 {
+			t.Errorf("expected only first level, got %+v", tree)
+		}
+	})
+
+	t.Run("page limit", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "1", "maxPages": float64(1)}}}
+		result, _ := handler(ctx, req)
+		var tree PageTree
+		_ = json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &tree)
+		if tree.Count != 1 || !tree.Truncated {
+			t.Errorf("expected truncated tree, got %+v", tree)
+		}
+	})
+
+	t.Run("invalid depth", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "1", "depth": float64(0)}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for invalid depth")
+		}
+	})
+}
+
+// TestHandleMoveContent tests moving pages.
+func TestHandleMoveContent(t *testing.T) {
+	ctx := context.Background()
+	var movedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if r.URL.Path != "/rest/api/space/NEW" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			_, _ = w.Write([]byte(`{"key":"NEW","homepage":{"id":"500","title":"Home"}}`))
+			return
+		}
+		if r.Method != "PUT" {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		movedPath = r.URL.Path
+		_, _ = w.Write([]byte(`{"pageId":"123"}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleMoveContent(client)
+
+	tests := []struct {
+		name     string
+		args     map[string]any
+		wantPath string
+		wantErr  bool
+	}{
+		{"append by default", map[string]any{"contentId": "123", "targetId": "200"}, "/rest/api/content/123/move/append/200", false},
+		{"above sibling", map[string]any{"contentId": "123", "targetId": "200", "position": "above"}, "/rest/api/content/123/move/above/200", false},
+		{"to another space", map[string]any{"contentId": "123", "targetSpaceKey": "NEW"}, "/rest/api/content/123/move/append/500", false},
+		{"invalid position", map[string]any{"contentId": "123", "targetId": "200", "position": "inside"}, "", true},
+		{"missing target", map[string]any{"contentId": "123"}, "", true},
+		{"sibling in space", map[string]any{"contentId": "123", "targetSpaceKey": "NEW", "position": "below"}, "", true},
+		{"invalid target", map[string]any{"contentId": "123", "targetId": "../x"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movedPath = ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := handler(ctx, req)
+			if err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if result.IsError != tt.wantErr {
+				t.Fatalf("expected IsError=%v, got %v", tt.wantErr, result.Content)
+			}
+			if movedPath != tt.wantPath {
+				t.Errorf("expected move path %q, got %q", tt.wantPath, movedPath)
+			}
+		})
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/rest/api/content":
+			_, _ = w.Write([]byte(`{"id":"100","type":"page","title":"New"}`))
+		case strings.HasSuffix(r.URL.Path, "/label"):
+			_, _ = w.Write([]byte(`{"results":[{"prefix":"global","name":"draft"}],"size":1}`))
+		case strings.Contains(r.URL.Path, "/move/append/404"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte(`{"pageId":"100"}`))
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) (*mcp.CallToolResult, BatchReport) {
+		t.Helper()
+		result, err := handleBatch(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report BatchReport
+		if !result.IsError {
+			if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, report
+	}
+	operations := []any{
+		map[string]any{"op": "create", "arguments": map[string]any{"title": "New", "spaceKey": "DOC", "content": "<p>x</p>"}},
+		map[string]any{"op": "move", "arguments": map[string]any{"contentId": "$1", "targetId": "404"}},
+		map[string]any{"op": "label", "arguments": map[string]any{"contentId": "$1", "labels": []any{"draft"}}},
+		map[string]any{"op": "move", "arguments": map[string]any{"contentId": "$2", "targetId": "200"}},
+	}
+
+	_, report := call(map[string]any{"operations": operations})
+	if report.Succeeded != 1 || report.Failed != 1 || report.Skipped != 2 || report.Results[0].ID != "100" || report.Results[1].Error == "" {
+		t.Errorf("expected the batch to stop at the failed move, got %+v", report)
+	}
+	if want := []string{"POST /rest/api/content", "PUT /rest/api/content/100/move/append/404"}; !slices.Equal(requests, want) {
+		t.Errorf("expected requests %q, got %q", want, requests)
+	}
+
+	requests = nil
+	_, report = call(map[string]any{"operations": operations, "continueOnError": true})
+	if report.Succeeded != 2 || report.Failed != 2 || report.Skipped != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if label := report.Results[2]; label.Status != "succeeded" || label.ID != "100" || !strings.Contains(string(label.Result), "draft") {
+		t.Errorf("expected the label result, got %+v", label)
+	}
+	if move := report.Results[3]; move.Status != "failed" || !strings.Contains(move.Error, "operation 2") {
+		t.Errorf("expected the reference to the failed move to fail, got %+v", move)
+	}
+	if len(requests) != 3 {
+		t.Errorf("expected the failed reference not to be sent, got %q", requests)
+	}
+
+	for _, args := range []map[string]any{
+		{},
+		{"operations": []any{map[string]any{"op": "delete", "arguments": map[string]any{}}}},
+		{"operations": []any{map[string]any{"op": "create"}}},
+	} {
+		if result, _ := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+
+	// Operations cannot run tools the filter leaves out.
+	s := setupServer(singleClientRegistry(client), ToolsConfig{ToolFilter: ToolFilter{Exclude: []string{"confluence_move_content"}}})
+	params, _ := json.Marshal(map[string]any{"name": "confluence_batch", "arguments": map[string]any{"operations": operations}})
+	response := s.HandleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":`+string(params)+`}`)).(mcp.JSONRPCResponse)
+	if result := response.Result.(mcp.CallToolResult); !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "confluence_move_content is not enabled") {
+		t.Errorf("expected the filtered tool to be rejected, got %+v", result)
+	}
+}
+
+// TestIsStatus tests matching API error status codes.
+func TestIsStatus(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &APIError{StatusCode: 404, Body: "missing"})
+	if !isStatus(err, 404, 405) {
+		t.Error("expected wrapped 404 to match")
+	}
+	if isStatus(err, 500) {
+		t.Error("expected 404 not to match 500")
+	}
+	if isStatus(fmt.Errorf("plain"), 404) {
+		t.Error("expected plain error not to match")
+	}
+}
+
+// TestHandleCopyContent tests copying pages natively and client-side.
+func TestHandleCopyContent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("native single page copy", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/rest/api/content/123/copy" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			var payload CopyPageRequest
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			if payload.Destination.Type != "parent_page" || payload.Destination.Value != "200" {
+				t.Errorf("unexpected destination: %+v", payload.Destination)
+			}
+			if !payload.CopyLabels || payload.CopyAttachments || payload.PageTitle != "Copy" {
+				t.Errorf("unexpected options: %+v", payload)
+			}
+			_, _ = w.Write([]byte(`{"id":"124","title":"Copy"}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId":       "123",
+			"targetParentId":  "200",
+			"title":           "Copy",
+			"copyAttachments": false,
+		}}}
+		result, err := handleCopyContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("native hierarchy copy into space", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/rest/api/space/NEW":
+				_, _ = w.Write([]byte(`{"key":"NEW","homepage":{"id":"500"}}`))
+			case "/rest/api/content/123/pagehierarchy/copy":
+				var payload CopyPageHierarchyRequest
+				_ = json.NewDecoder(r.Body).Decode(&payload)
+				if payload.DestinationPageID != "500" || payload.OriginalPageID != "123" {
+					t.Errorf("unexpected payload: %+v", payload)
+				}
+				if payload.TitleOptions == nil || payload.TitleOptions.Prefix != "Copy of " {
+					t.Errorf("unexpected title options: %+v", payload.TitleOptions)
+				}
+				_, _ = w.Write([]byte(`{"id":"task-1"}`))
+			default:
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId":       "123",
+			"targetSpaceKey":  "NEW",
+			"includeChildren": true,
+			"titlePrefix":     "Copy of ",
+		}}}
+		result, err := handleCopyContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, "task-1") {
+			t.Error("expected long task in result")
+		}
+	})
+
+	t.Run("client-side fallback", func(t *testing.T) {
+		var created []ConfluencePage
+		var labelled, uploaded []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/copy"):
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/rest/api/content/200":
+				_, _ = w.Write([]byte(`{"id":"200","space":{"key":"DST"}}`))
+			case r.URL.Path == "/rest/api/content/123":
+				_, _ = w.Write([]byte(`{"id":"123","title":"Parent","body":{"storage":{"value":"<p>p</p>"}},"metadata":{"labels":{"results":[{"prefix":"global","name":"doc"}]}}}`))
+			case r.URL.Path == "/rest/api/content/124":
+				_, _ = w.Write([]byte(`{"id":"124","title":"Child","body":{"storage":{"value":"<p>c</p>"}}}`))
+			case r.URL.Path == "/rest/api/content" && r.Method == "POST":
+				var page ConfluencePage
+				_ = json.NewDecoder(r.Body).Decode(&page)
+				page.ID = fmt.Sprintf("new-%d", len(created))
+				created = append(created, page)
+				_ = json.NewEncoder(w).Encode(page)
+			case strings.HasSuffix(r.URL.Path, "/label"):
+				labelled = append(labelled, r.URL.Path)
+				_, _ = w.Write([]byte(`{}`))
+			case r.URL.Path == "/rest/api/content/123/child/attachment" && r.Method == "GET":
+				_, _ = w.Write([]byte(`{"results":[{"id":"att1","title":"diagram.png","_links":{"download":"/download/attachments/123/diagram.png"}}]}`))
+			case r.URL.Path == "/rest/api/content/124/child/attachment":
+				_, _ = w.Write([]byte(`{"results":[]}`))
+			case r.URL.Path == "/download/attachments/123/diagram.png":
+				if r.Header.Get("Authorization") != "Bearer t" {
+					t.Errorf("expected authorized download")
+				}
+				_, _ = w.Write([]byte("png-bytes"))
+			case strings.HasSuffix(r.URL.Path, "/child/attachment") && r.Method == "POST":
+				if r.Header.Get("X-Atlassian-Token") != "no-check" {
+					t.Errorf("expected XSRF bypass header")
+				}
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Fatalf("failed to read upload: %v", err)
+				}
+				data, _ := io.ReadAll(file)
+				if header.Filename != "diagram.png" || string(data) != "png-bytes" {
+					t.Errorf("unexpected upload %s: %s", header.Filename, data)
+				}
+				uploaded = append(uploaded, r.URL.Path)
+				_, _ = w.Write([]byte(`{}`))
+			case r.URL.Path == "/rest/api/content/123/child/page":
+				_, _ = w.Write([]byte(`{"results":[{"id":"124","title":"Child"}]}`))
+			case r.URL.Path == "/rest/api/content/124/child/page":
+				_, _ = w.Write([]byte(`{"results":[]}`))
+			default:
+				t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId":       "123",
+			"targetParentId":  "200",
+			"includeChildren": true,
+			"titlePrefix":     "Copy of ",
+		}}}
+		result, err := handleCopyContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if len(created) != 2 || created[0].Title != "Copy of Parent" || created[0].Ancestors[0].ID != "200" || created[0].Space.Key != "DST" {
+			t.Fatalf("unexpected root copy: %+v", created)
+		}
+		if created[1].Ancestors[0].ID != "new-0" {
+			t.Errorf("expected child under copied parent, got %+v", created[1].Ancestors)
+		}
+		if len(labelled) != 1 || len(uploaded) != 1 {
+			t.Errorf("expected labels and attachment copied, got %v %v", labelled, uploaded)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"method":"client"`) {
+			t.Error("expected client-side method in result")
+		}
+	})
+
+	t.Run("copy under itself", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/rest/api/content/124" && r.Method == "GET":
+				_, _ = w.Write([]byte(`{"id":"124","ancestors":[{"id":"1"},{"id":"123"}]}`))
+			default:
+				t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		for _, target := range []string{"123", "124"} {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+				"contentId":       "123",
+				"targetParentId":  target,
+				"includeChildren": true,
+			}}}
+			result, _ := handleCopyContent(client)(ctx, req)
+			if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "descendants") {
+				t.Errorf("expected error copying under %s, got %v", target, result.Content)
+			}
+		}
+	})
+
+	t.Run("native error is not retried", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "targetSpaceKey": "S"}}}
+		result, _ := handleCopyContent(client)(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "status 403") {
+			t.Errorf("expected 403 error, got %v", result.Content)
+		}
+	})
+
+	t.Run("missing target", func(t *testing.T) {
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, _ := handleCopyContent(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for missing target")
+		}
+	})
+}
+
+// TestHandleGetHistory tests listing content versions.
+func TestHandleGetHistory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("history and versions", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/rest/api/content/123/history":
+				_, _ = w.Write([]byte(`{"createdBy":{"username":"alice"},"latest":true}`))
+			case "/rest/api/content/123/version":
+				if r.URL.Query().Get("limit") != "5" || r.URL.Query().Get("expand") != "by" {
+					t.Errorf("unexpected query %s", r.URL.RawQuery)
+				}
+				_, _ = w.Write([]byte(`{"results":[{"number":2,"message":"fix","by":{"username":"bob"}}]}`))
+			default:
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "limit": float64(5)}}}
+		result, err := handleGetHistory(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		var out map[string]map[string]any
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+			t.Fatalf("failed to unmarshal result: %v", err)
+		}
+		if out["history"]["latest"] != true || out["versions"]["results"] == nil {
+			t.Errorf("unexpected result: %v", out)
+		}
+	})
+
+	t.Run("versions error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/version") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, _ := handleGetHistory(client)(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "error getting versions") {
+			t.Errorf("expected versions error, got %v", result.Content)
+		}
+	})
+}
+
+// TestHandleGetContentVersion tests retrieving a historical version of content.
+func TestHandleGetContentVersion(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("status") != "historical" || q.Get("version") != "3" {
+			t.Errorf("expected historical version 3, got %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"id":"123","version":{"number":3}}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+	handler := handleGetContent(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "version": float64(3)}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+
+	t.Run("invalid version", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "version": float64(0)}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for invalid version")
+		}
+	})
+}
+
+// TestHandleRestoreVersion tests restoring a previous version.
+func TestHandleRestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/rest/api/content/123/version" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+		var payload VersionRestoreRequest
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload.OperationKey != "restore" || payload.Params.VersionNumber != 2 || payload.Params.Message != "undo bot edit" {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+		_, _ = w.Write([]byte(`{"number":5}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleRestoreVersion(client)
+
+	tests := []struct {
+		name    string
+		args    map[string]any
+		wantErr bool
+	}{
+		{"restore", map[string]any{"contentId": "123", "version": float64(2), "versionComment": "undo bot edit"}, false},
+		{"missing version", map[string]any{"contentId": "123", "versionComment": "undo"}, true},
+		{"missing comment", map[string]any{"contentId": "123", "version": float64(2)}, true},
+		{"blank comment", map[string]any{"contentId": "123", "version": float64(2), "versionComment": "  "}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := handler(ctx, req)
+			if err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if result.IsError != tt.wantErr {
+				t.Errorf("expected IsError=%v, got %v", tt.wantErr, result.Content)
+			}
+		})
+	}
+}
+
+// TestHandleGetPageByTitle tests resolving pages by space and title.
+func TestHandleGetPageByTitle(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/rest/api/content" || q.Get("spaceKey") != "OPS" || q.Get("type") != "page" {
+			t.Errorf("unexpected request %s?%s", r.URL.Path, r.URL.RawQuery)
+		}
+		if q.Get("expand") != "version,body.storage" {
+			t.Errorf("expected expand version,body.storage, got %s", q.Get("expand"))
+		}
+		if q.Get("title") == "Runbook" {
+			_, _ = w.Write([]byte(`{"results":[{"id":"123","title":"Runbook"}],"size":1}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetPageByTitle(client)
+
+	t.Run("found", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "title": "Runbook", "expand": "version"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if result.Content[0].(mcp.TextContent).Text != `{"id":"123","title":"Runbook"}` {
+			t.Errorf("unexpected result: %s", result.Content[0].(mcp.TextContent).Text)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "title": "Missing", "expand": "version"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `no page titled "Missing"`) {
+			t.Errorf("expected not found error, got %v", result.Content)
+		}
+	})
+
+	t.Run("missing title", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected title error")
+		}
+	})
+}
+
+// TestParseConfluenceURL tests extracting content references from URLs.
+func TestParseConfluenceURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    ContentRef
+		wantErr bool
+	}{
+		{url: "https://wiki.example.com/pages/viewpage.action?pageId=123", want: ContentRef{ID: "123"}},
+		{url: "https://wiki.example.com/x/KQAB", want: ContentRef{ID: "65577"}},
+		{url: "https://wiki.example.com/wiki/x/Fc1bBw", want: ContentRef{ID: "123456789"}},
+		{url: "https://wiki.example.com/display/OPS/On-call+Runbook", want: ContentRef{SpaceKey: "OPS", Title: "On-call Runbook", Type: "page"}},
+		{url: "https://wiki.example.com/display/OPS/2024/01/15/Release%20Notes", want: ContentRef{SpaceKey: "OPS", Title: "Release Notes", Type: "blogpost", PostingDay: "2024-01-15"}},
+		{url: "https://wiki.example.com/spaces/OPS/pages/456/Title", want: ContentRef{ID: "456"}},
+		{url: "https://wiki.example.com/dashboard.action", wantErr: true},
+		{url: "https://wiki.example.com/x/!!!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := parseConfluenceURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfluenceURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseConfluenceURL() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleResolveURL tests resolving URLs to content.
+func TestHandleResolveURL(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("expand") != "space,version" {
+			t.Errorf("expected expand space,version, got %s", r.URL.Query().Get("expand"))
+		}
+		switch r.URL.Path {
+		case "/rest/api/content/65577":
+			_, _ = w.Write([]byte(`{"id":"65577","title":"Tiny"}`))
+		case "/rest/api/content":
+			if r.URL.Query().Get("postingDay") != "2024-01-15" || r.URL.Query().Get("type") != "blogpost" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"results":[{"id":"77","title":"Notes"}]}`))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleResolveURL(client)
+
+	t.Run("tiny link", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"url": server.URL + "/x/KQAB"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"id":"65577"`) {
+			t.Errorf("unexpected result: %v", result.Content)
+		}
+	})
+
+	t.Run("blog post URL", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"url": server.URL + "/display/OPS/2024/01/15/Notes"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if result.Content[0].(mcp.TextContent).Text != `{"id":"77","title":"Notes"}` {
+			t.Errorf("unexpected result: %v", result.Content)
+		}
+	})
+
+	t.Run("unrecognized URL", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"url": "https://example.com/"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for unrecognized URL")
+		}
+	})
+}
+
+// TestHandleGetRestrictions tests reading content restrictions.
+func TestHandleGetRestrictions(t *testing.T) {
+	ctx := context.Background()
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		if r.URL.Query().Get("expand") != "restrictions.user,restrictions.group" {
+			t.Errorf("unexpected expand %s", r.URL.Query().Get("expand"))
+		}
+		_, _ = w.Write([]byte(`{"read":{"restrictions":{"user":{"results":[]}}}}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetRestrictions(client)
+
+	tests := []struct {
+		name     string
+		args     map[string]any
+		wantPath string
+		wantErr  bool
+	}{
+		{"all operations", map[string]any{"contentId": "123"}, "/rest/api/content/123/restriction/byOperation", false},
+		{"single operation", map[string]any{"contentId": "123", "operation": "update"}, "/rest/api/content/123/restriction/byOperation/update", false},
+		{"invalid operation", map[string]any{"contentId": "123", "operation": "delete"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath = ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := handler(ctx, req)
+			if err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if result.IsError != tt.wantErr || gotPath != tt.wantPath {
+				t.Errorf("unexpected result %v for path %q", result.Content, gotPath)
+			}
+		})
+	}
+}
+
+// TestHandleSetRestrictions tests adding and removing content restrictions.
+func TestHandleSetRestrictions(t *testing.T) {
+	ctx := context.Background()
+	var applied []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{
+				"read":{"restrictions":{"user":{"results":[{"username":"alice"}]},"group":{"results":[]}}},
+				"update":{"restrictions":{"user":{"results":[]},"group":{"results":[{"name":"editors"}]}}}
+			}`))
+			return
+		}
+		applied = append(applied, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleSetRestrictions(client)
+
+	t.Run("dry run", func(t *testing.T) {
+		applied = nil
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId": "123",
+			"action":    "add",
+			"operation": "read",
+			"users":     []any{"bob", "alice"},
+			"groups":    []any{"staff"},
+			"dryRun":    true,
+		}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if len(applied) != 0 {
+			t.Errorf("expected no changes in dry run, got %v", applied)
+		}
+		var out struct {
+			Resulting map[string]RestrictionSet `json:"resulting"`
+		}
+		_ = json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out)
+		read := out.Resulting["read"]
+		if strings.Join(read.Users, ",") != "alice,bob" || strings.Join(read.Groups, ",") != "staff" {
+			t.Errorf("unexpected resulting read restrictions: %+v", read)
+		}
+	})
+
+	t.Run("apply removal", func(t *testing.T) {
+		applied = nil
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId": "123",
+			"action":    "remove",
+			"operation": "update",
+			"users":     []any{"alice"},
+			"groups":    []any{"editors"},
+		}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		want := []string{
+			"DELETE /rest/api/content/123/restriction/byOperation/update/user?userName=alice",
+			"DELETE /rest/api/content/123/restriction/byOperation/update/group/editors?",
+		}
+		if strings.Join(applied, "|") != strings.Join(want, "|") {
+			t.Errorf("unexpected requests: %v", applied)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		for _, args := range []map[string]any{
+			{"contentId": "123", "action": "grant", "operation": "read", "users": []any{"a"}},
+			{"contentId": "123", "action": "add", "operation": "delete", "users": []any{"a"}},
+			{"contentId": "123", "action": "add", "operation": "read"},
+			{"contentId": "123", "action": "add", "operation": "read", "groups": []any{"../admins"}},
+		} {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+			result, _ := handler(ctx, req)
+			if !result.IsError {
+				t.Errorf("expected error for %v", args)
+			}
+		}
+	})
+}
+
+// TestHandleWatchContent tests watching and unwatching content.
+func TestHandleWatchContent(t *testing.T) {
+	ctx := context.Background()
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	tests := []struct {
+		name     string
+		watch    bool
+		args     map[string]any
+		want     string
+		wantText string
+	}{
+		{"watch as current user", true, map[string]any{"contentId": "123"}, "POST /rest/api/user/watch/content/123?", "current user is watching content 123"},
+		{"unwatch for user", false, map[string]any{"contentId": "123", "username": "bob"}, "DELETE /rest/api/user/watch/content/123?username=bob", "bob is no longer watching content 123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := handleWatchContent(client, tt.watch)(ctx, req)
+			if err != nil || result.IsError {
+				t.Fatalf("handler failed: %v, %v", err, result)
+			}
+			if got != tt.want {
+				t.Errorf("expected request %q, got %q", tt.want, got)
+			}
+			if result.Content[0].(mcp.TextContent).Text != tt.wantText {
+				t.Errorf("unexpected result: %v", result.Content)
+			}
+		})
+	}
+
+	t.Run("missing contentId", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{}}}
+		result, _ := handleWatchContent(client, true)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for missing contentId")
+		}
+	})
+}
+
+// TestHandleGetWatchers tests listing page and space watchers.
+func TestHandleGetWatchers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("page and space watchers", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/wiki/json/listwatchers.action" || r.URL.Query().Get("pageId") != "123" {
+				t.Errorf("unexpected request %s?%s", r.URL.Path, r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"pageWatchers":[{"name":"alice","fullName":"Alice"}],"spaceWatchers":null}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/wiki/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, err := handleGetWatchers(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		want := `{"pageWatchers":[{"name":"alice","fullName":"Alice"}],"spaceWatchers":[]}`
+		if result.Content[0].(mcp.TextContent).Text != want {
+			t.Errorf("unexpected result: %s", result.Content[0].(mcp.TextContent).Text)
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, _ := handleGetWatchers(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for API 403")
+		}
+	})
+}
+
+// TestHandleContentProperties tests reading, writing, and deleting content properties.
+func TestHandleContentProperties(t *testing.T) {
+	ctx := context.Background()
+	var written []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/content/123/property/existing":
+			_, _ = w.Write([]byte(`{"key":"existing","value":{"a":1},"version":{"number":3}}`))
+		case r.Method == "GET" && r.URL.Path == "/rest/api/content/123/property/new":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "GET" && r.URL.Path == "/rest/api/content/123/property":
+			_, _ = w.Write([]byte(`{"results":[{"key":"existing"}]}`))
+		case r.Method == "PUT" || r.Method == "POST":
+			var prop Property
+			_ = json.NewDecoder(r.Body).Decode(&prop)
+			version := 0
+			if prop.Version != nil {
+				version = prop.Version.Number
+			}
+			value, _ := json.Marshal(prop.Value)
+			written = append(written, fmt.Sprintf("%s %s %s v%d", r.Method, r.URL.Path, value, version))
+			_ = json.NewEncoder(w).Encode(prop)
+		case r.Method == "DELETE":
+			written = append(written, "DELETE "+r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	call := func(handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		result, err := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return result
+	}
+
+	t.Run("get single and list", func(t *testing.T) {
+		result := call(handleGetContentProperty(client), map[string]any{"contentId": "123", "key": "existing"})
+		if result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"a":1`) {
+			t.Errorf("unexpected result: %v", result.Content)
+		}
+		result = call(handleGetContentProperty(client), map[string]any{"contentId": "123"})
+		if result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"results"`) {
+			t.Errorf("unexpected result: %v", result.Content)
+		}
+	})
+
+	t.Run("set updates and creates", func(t *testing.T) {
+		written = nil
+		call(handleSetContentProperty(client), map[string]any{"contentId": "123", "key": "existing", "value": map[string]any{"a": float64(2)}})
+		call(handleSetContentProperty(client), map[string]any{"contentId": "123", "key": "existing", "value": "x", "version": float64(9)})
+		call(handleSetContentProperty(client), map[string]any{"contentId": "123", "key": "new", "value": []any{"x"}})
+		want := []string{
+			`PUT /rest/api/content/123/property/existing {"a":2} v4`,
+			`PUT /rest/api/content/123/property/existing "x" v9`,
+			`POST /rest/api/content/123/property ["x"] v0`,
+		}
+		if strings.Join(written, "|") != strings.Join(want, "|") {
+			t.Errorf("unexpected writes: %v", written)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		written = nil
+		result := call(handleDeleteContentProperty(client), map[string]any{"contentId": "123", "key": "existing", "confirm": true})
+		if result.IsError || len(written) != 1 || written[0] != "DELETE /rest/api/content/123/property/existing" {
+			t.Errorf("unexpected delete: %v %v", result.Content, written)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		if result := call(handleSetContentProperty(client), map[string]any{"contentId": "123", "key": "k"}); !result.IsError {
+			t.Error("expected error for missing value")
+		}
+		if result := call(handleDeleteContentProperty(client), map[string]any{"contentId": "123", "key": "../k"}); !result.IsError {
+			t.Error("expected error for invalid key")
+		}
+	})
+}
+
+// TestHandleSpaceProperties tests reading, writing, and deleting space properties.
+func TestHandleSpaceProperties(t *testing.T) {
+	ctx := context.Background()
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/space/OPS/property/config":
+			_, _ = w.Write([]byte(`{"key":"config","value":{"owner":"ops"},"version":{"number":1}}`))
+		case r.Method == "GET":
+			_, _ = w.Write([]byte(`{"results":[]}`))
+		case r.Method == "PUT":
+			var prop Property
+			_ = json.NewDecoder(r.Body).Decode(&prop)
+			if prop.Version == nil || prop.Version.Number != 2 {
+				t.Errorf("expected version 2, got %+v", prop.Version)
+			}
+			_ = json.NewEncoder(w).Encode(prop)
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	t.Run("get", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "key": "config"}}}
+		result, err := handleGetSpaceProperty(client)(ctx, req)
+		if err != nil || result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "owner") {
+			t.Fatalf("unexpected result: %v, %v", err, result)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		requests = nil
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "key": "config", "value": map[string]any{"owner": "sre"}}}}
+		result, err := handleSetSpaceProperty(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if strings.Join(requests, "|") != "GET /rest/api/space/OPS/property/config|PUT /rest/api/space/OPS/property/config" {
+			t.Errorf("unexpected requests: %v", requests)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "key": "config", "confirm": true}}}
+		result, err := handleDeleteSpaceProperty(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+	})
+
+	t.Run("invalid space key", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "../x"}}}
+		result, _ := handleGetSpaceProperty(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for invalid space key")
+		}
+	})
+}
+
+// TestHandleCreateSpace tests creating public and private spaces.
+func TestHandleCreateSpace(t *testing.T) {
+	ctx := context.Background()
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		var payload SpacePayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Key != "NEW" || payload.Name != "New Space" {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+		_ = json.NewEncoder(w).Encode(payload)
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleCreateSpace(client)
+
+	t.Run("public with description", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "NEW", "name": "New Space", "description": "Team docs"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if gotPath != "/rest/api/space" || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"plain":{"value":"Team docs","representation":"plain"}`) {
+			t.Errorf("unexpected request %s: %v", gotPath, result.Content)
+		}
+	})
+
+	t.Run("private", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "NEW", "name": "New Space", "private": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if gotPath != "/rest/api/space/_private" {
+			t.Errorf("expected private space endpoint, got %s", gotPath)
+		}
+	})
+
+	t.Run("missing name", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "NEW"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for missing name")
+		}
+	})
+}
+
+// TestWaitForLongTask tests polling long-running tasks.
+func TestWaitForLongTask(t *testing.T) {
+	ctx := context.Background()
+	oldInterval, oldTimeout := longTaskPollInterval, longTaskTimeout
+	longTaskPollInterval = time.Millisecond
+	defer func() { longTaskPollInterval, longTaskTimeout = oldInterval, oldTimeout }()
+
+	var polls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/longtask/task-1" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		polls++
+		_, _ = fmt.Fprintf(w, `{"id":"task-1","percentageComplete":%d,"successful":true}`, min(polls*50, 100))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+
+	t.Run("completes", func(t *testing.T) {
+		status, err := client.waitForLongTask(ctx, "task-1")
+		if err != nil {
+			t.Fatalf("waitForLongTask failed: %v", err)
+		}
+		if polls != 2 || status.PercentageComplete != 100 {
+			t.Errorf("unexpected status after %d polls: %+v", polls, status)
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		polls = -100
+		longTaskTimeout = 5 * time.Millisecond
+		_, err := client.waitForLongTask(ctx, "task-1")
+		if err == nil || !strings.Contains(err.Error(), "did not finish in time") {
+			t.Errorf("expected timeout error, got %v", err)
+		}
+	})
+
+	t.Run("invalid task ID", func(t *testing.T) {
+		if _, err := client.waitForLongTask(ctx, "../x"); err == nil {
+			t.Error("expected error for invalid task ID")
+		}
+	})
+}
+
+// TestHandleDeleteSpace tests deleting and archiving spaces.
+func TestHandleDeleteSpace(t *testing.T) {
+	ctx := context.Background()
+	oldInterval := longTaskPollInterval
+	longTaskPollInterval = time.Millisecond
+	defer func() { longTaskPollInterval = oldInterval }()
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"id":"task-9","links":{"status":"/rest/api/longtask/task-9"}}`))
+		case r.URL.Path == "/rest/api/longtask/task-9":
+			_, _ = w.Write([]byte(`{"id":"task-9","percentageComplete":100,"successful":true,"finished":true}`))
+		case r.Method == "GET":
+			_, _ = w.Write([]byte(`{"key":"OLD","name":"Old Space"}`))
+		case r.Method == "PUT":
+			var payload map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			if payload["status"] != "archived" || payload["name"] != "Old Space" {
+				t.Errorf("unexpected archive payload: %v", payload)
+			}
+			if payload["key"] == "KEEP" {
+				// An instance without archiving ignores the status.
+				_, _ = w.Write([]byte(`{"key":"KEEP","status":"current"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"key":"OLD","status":"archived"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleDeleteSpace(client)
+
+	t.Run("requires confirmation", func(t *testing.T) {
+		requests = nil
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OLD"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError || len(requests) != 0 {
+			t.Errorf("expected confirmation error without requests, got %v %v", result.Content, requests)
+		}
+	})
+
+	t.Run("delete returns task", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OLD", "confirm": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "task-9") {
+			t.Fatalf("unexpected result: %v, %v", err, result)
+		}
+	})
+
+	t.Run("delete and wait", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OLD", "confirm": true, "wait": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"finished":true`) {
+			t.Fatalf("unexpected result: %v, %v", err, result)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		requests = nil
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OLD", "confirm": true, "archive": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if strings.Join(requests, "|") != "GET /rest/api/space/OLD|PUT /rest/api/space/OLD" {
+			t.Errorf("unexpected requests: %v", requests)
+		}
+	})
+
+	t.Run("archive ignored", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "KEEP", "confirm": true, "archive": true}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "not supported") {
+			t.Errorf("expected an unsupported archiving error, got %v", result.Content)
+		}
+	})
+}
+
+// TestHandleUpdateSpace tests updating space metadata.
+func TestHandleUpdateSpace(t *testing.T) {
+	ctx := context.Background()
+	var put SpacePayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"key":"OPS","name":"Operations"}`))
+			return
+		}
+		put = SpacePayload{}
+		_ = json.NewDecoder(r.Body).Decode(&put)
+		_ = json.NewEncoder(w).Encode(put)
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleUpdateSpace(client)
+
+	t.Run("keeps current name", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "description": "Runbooks", "homepageId": "42"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if put.Name != "Operations" || put.Description.Plain.Value != "Runbooks" || put.Homepage.ID != "42" {
+			t.Errorf("unexpected payload: %+v", put)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS", "name": "SRE"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if put.Name != "SRE" || put.Description != nil || put.Homepage != nil {
+			t.Errorf("unexpected payload: %+v", put)
+		}
+	})
+
+	t.Run("nothing to update", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS"}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Error("expected error without changes")
+		}
+	})
+}
+
+// TestHandleGetSpacePermissions tests reporting space permissions.
+func TestHandleGetSpacePermissions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rest expansion", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/rest/api/space/OPS" || r.URL.Query().Get("expand") != "permissions" {
+				t.Errorf("unexpected request %s?%s", r.URL.Path, r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"permissions":[{"operation":{"operation":"read","targetType":"space"},"subjects":{"user":{"results":[{"username":"alice"}]},"group":{"results":[{"name":"staff"}]}}}]}`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS"}}}
+		result, err := handleGetSpacePermissions(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		want := `{"spaceKey":"OPS","source":"rest","permissions":[{"permission":"read:space","users":["alice"],"groups":["staff"]}]}`
+		if result.Content[0].(mcp.TextContent).Text != want {
+			t.Errorf("unexpected result: %s", result.Content[0].(mcp.TextContent).Text)
+		}
+	})
+
+	t.Run("json-rpc fallback", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				_, _ = w.Write([]byte(`{"key":"OPS"}`))
+				return
+			}
+			if r.URL.Path != "/rpc/json-rpc/confluenceservice-v2/getSpacePermissionSets" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			var params []string
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			if len(params) != 1 || params[0] != "OPS" {
+				t.Errorf("unexpected params: %v", params)
+			}
+			_, _ = w.Write([]byte(`[{"type":"VIEWSPACE","spacePermissions":[{"userName":"alice"},{"groupName":"staff"},{}]}]`))
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS"}}}
+		result, err := handleGetSpacePermissions(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		want := `{"spaceKey":"OPS","source":"json-rpc","permissions":[{"permission":"VIEWSPACE","users":["alice"],"groups":["staff"],"anonymous":true}]}`
+		if result.Content[0].(mcp.TextContent).Text != want {
+			t.Errorf("unexpected result: %s", result.Content[0].(mcp.TextContent).Text)
+		}
+	})
+
+	t.Run("rest error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "OPS"}}}
+		result, _ := handleGetSpacePermissions(client)(ctx, req)
+		if !result.IsError {
+			t.Error("expected error for API 403")
+		}
+	})
+}
+
+// TestHandleChangeSpacePermission tests granting and revoking space permissions.
+func TestHandleChangeSpacePermission(t *testing.T) {
+	ctx := context.Background()
+	var gotPath string
+	var gotParams []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotParams = nil
+		_ = json.NewDecoder(r.Body).Decode(&gotParams)
+		if strings.Contains(r.URL.Path, "removePermission") {
+			_, _ = w.Write([]byte(`false`))
+			return
+		}
+		_, _ = w.Write([]byte(`true`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	tests := []struct {
+		name       string
+		grant      bool
+		args       map[string]any
+		wantError  bool
+		wantPath   string
+		wantParams string
+	}{
+		{"grant to group", true, map[string]any{"spaceKey": "OPS", "permission": "editspace", "group": "staff"}, false,
+			"/rpc/json-rpc/confluenceservice-v2/addPermissionToSpace", "EDITSPACE,staff,OPS"},
+		{"grant to anonymous", true, map[string]any{"spaceKey": "OPS", "permission": "VIEWSPACE", "anonymous": true}, false,
+			"/rpc/json-rpc/confluenceservice-v2/addAnonymousPermissionToSpace", "VIEWSPACE,OPS"},
+		{"revoke returning false", false, map[string]any{"spaceKey": "OPS", "permission": "COMMENT", "user": "bob"}, true,
+			"/rpc/json-rpc/confluenceservice-v2/removePermissionFromSpace", "COMMENT,bob,OPS"},
+		{"unknown permission", true, map[string]any{"spaceKey": "OPS", "permission": "ADMIN", "user": "bob"}, true, "", ""},
+		{"no subject", true, map[string]any{"spaceKey": "OPS", "permission": "COMMENT"}, true, "", ""},
+		{"two subjects", true, map[string]any{"spaceKey": "OPS", "permission": "COMMENT", "user": "bob", "group": "staff"}, true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotParams = "", nil
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := handleChangeSpacePermission(client, tt.grant)(ctx, req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.IsError != tt.wantError {
+				t.Errorf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("path = %q, want %q", gotPath, tt.wantPath)
+			}
+			if strings.Join(gotParams, ",") != tt.wantParams {
+				t.Errorf("params = %v, want %q", gotParams, tt.wantParams)
+			}
+		})
+	}
+}
+
+// TestHandleGetSpaceContent tests listing the content of a space.
+func TestHandleGetSpaceContent(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/space/DEV/content/page" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("depth") != "root" || r.URL.Query().Get("expand") != "body.storage" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"results":[{"id":"1","title":"Home","body":{"storage":{"value":"<p>Welcome</p>"}}}],"size":1}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetSpaceContent(client)
+
+	t.Run("root pages with excerpts", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "DEV", "depth": "root", "includeExcerpt": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !strings.Contains(text, `"excerpt":"Welcome"`) || strings.Contains(text, "storage") {
+			t.Errorf("unexpected result: %s", text)
+		}
+	})
+
+	for _, args := range []map[string]any{
+		{"spaceKey": "DEV", "type": "comment"},
+		{"spaceKey": "DEV", "depth": "children"},
+		{},
+	} {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+// TestHandleGetSpaceHomepage tests retrieving a space homepage.
+func TestHandleGetSpaceHomepage(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/space/DEV":
+			_, _ = w.Write([]byte(`{"key":"DEV","homepage":{"id":"42","title":"DEV Home"}}`))
+		case "/rest/api/space/EMPTY":
+			_, _ = w.Write([]byte(`{"key":"EMPTY"}`))
+		case "/rest/api/content/42":
+			if got := r.URL.Query().Get("expand"); got != "body.storage,version" {
+				t.Errorf("unexpected expand %q", got)
+			}
+			_, _ = w.Write([]byte(`{"id":"42","title":"DEV Home","body":{"storage":{"value":"<p>Hi</p>"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetSpaceHomepage(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "DEV"}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if !strings.Contains(result.Content[0].(mcp.TextContent).Text, "<p>Hi</p>") {
+		t.Errorf("expected homepage body, got %v", result.Content)
+	}
+
+	for _, key := range []string{"EMPTY", "MISSING"} {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": key}}}
+		result, _ := handler(ctx, req)
+		if !result.IsError {
+			t.Errorf("expected error for space %s", key)
+		}
+	}
+}
+
+// TestHandleListBlogposts tests listing blog posts with date and author filters.
+func TestHandleListBlogposts(t *testing.T) {
+	ctx := context.Background()
+	var gotCQL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCQL = r.URL.Query().Get("cql")
+		_, _ = w.Write([]byte(`{"results":[{"content":{"id":"7","title":"Release notes","history":{"createdBy":{"username":"jdoe","displayName":"Jane Doe"},"createdDate":"2024-03-02T10:00:00.000Z"},"body":{"storage":{"value":"<p>Version 2 is out</p>"}},"_links":{"webui":"/display/DEV/2024/03/02/Release+notes"}}}],"size":1,"totalSize":1}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleListBlogposts(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"spaceKey": "DEV", "from": "2024-03-01", "to": "2024-03-31", "author": "jdoe",
+	}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	wantCQL := `type = blogpost AND space = "DEV" AND created >= "2024-03-01" AND created <= "2024-03-31" AND creator = "jdoe" ORDER BY created DESC`
+	if gotCQL != wantCQL {
+		t.Errorf("cql = %q, want %q", gotCQL, wantCQL)
+	}
+
+	var out struct {
+		Results []BlogPostEntry `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := BlogPostEntry{
+		ID: "7", Title: "Release notes", Author: "Jane Doe", Date: "2024-03-02T10:00:00.000Z",
+		URL: server.URL + "/display/DEV/2024/03/02/Release+notes", Excerpt: "Version 2 is out",
+	}
+	if len(out.Results) != 1 || out.Results[0] != want {
+		t.Errorf("unexpected results: %+v", out.Results)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "DEV", "from": "March 1"}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error for invalid date")
+	}
+}
+
+// TestHandleListTemplates tests listing space and global templates.
+func TestHandleListTemplates(t *testing.T) {
+	ctx := context.Background()
+	var gotPath, gotSpace string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotSpace = r.URL.Path, r.URL.Query().Get("spaceKey")
+		_, _ = w.Write([]byte(`{"results":[{"templateId":"98305","name":"Runbook"}],"size":1}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleListTemplates(client)
+
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantPath  string
+		wantSpace string
+		wantError bool
+	}{
+		{"global page templates", map[string]any{}, "/rest/api/template/page", "", false},
+		{"space blueprints", map[string]any{"spaceKey": "OPS", "type": "blueprint"}, "/rest/api/template/blueprint", "OPS", false},
+		{"invalid type", map[string]any{"type": "macro"}, "", "", true},
+		{"invalid space key", map[string]any{"spaceKey": "../x"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotSpace = "", ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, _ := handler(ctx, req)
+			if result.IsError != tt.wantError {
+				t.Fatalf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if gotPath != tt.wantPath || gotSpace != tt.wantSpace {
+				t.Errorf("request = %s spaceKey=%q, want %s spaceKey=%q", gotPath, gotSpace, tt.wantPath, tt.wantSpace)
+			}
+		})
+	}
+}
+
+// TestApplyTemplateVariables tests template variable substitution.
+func TestApplyTemplateVariables(t *testing.T) {
+	body := `<at:declarations><at:string at:name="service" /></at:declarations><h1><at:var at:name="service" /></h1><p>Owner: <at:var at:name="owner"></at:var></p>`
+
+	got, err := applyTemplateVariables(body, map[string]string{"service": "A&B", "owner": "ops"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `<h1>A&amp;B</h1><p>Owner: ops</p>`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := applyTemplateVariables(body, map[string]string{}); err == nil || !strings.Contains(err.Error(), "service, owner") {
+		t.Errorf("expected missing variables error, got %v", err)
+	}
+}
+
+// TestHandleCreateFromTemplate tests creating a page from a template.
+func TestHandleCreateFromTemplate(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/template/98305":
+			_, _ = w.Write([]byte(`{"templateId":"98305","name":"Runbook","body":{"storage":{"value":"<p><at:var at:name=\"service\" /></p>","representation":"storage"}}}`))
+		case r.Method == "POST" && r.URL.Path == "/rest/api/content":
+			var page ConfluencePage
+			_ = json.NewDecoder(r.Body).Decode(&page)
+			if page.Body.Storage.Value != "<p>billing</p>" || page.Space.Key != "OPS" || len(page.Ancestors) != 1 {
+				t.Errorf("unexpected payload: %+v", page)
+			}
+			_, _ = w.Write([]byte(`{"id":"500","title":"Billing runbook"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleCreateFromTemplate(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"templateId": "98305", "spaceKey": "OPS", "title": "Billing runbook", "parentId": "10",
+		"variables": map[string]any{"service": "billing"},
+	}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+
+	for _, args := range []map[string]any{
+		{"templateId": "98305", "spaceKey": "OPS", "title": "No vars"},
+		{"templateId": "abc", "spaceKey": "OPS", "title": "Bad ID"},
+		{"templateId": "1", "spaceKey": "OPS", "title": "Unknown"},
+		{"templateId": "98305", "spaceKey": "OPS"},
+	} {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+		if result, _ := handler(ctx, req); !result.IsError {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+// TestHandleCreateAndUpdateTemplate tests creating and updating page templates.
+func TestHandleCreateAndUpdateTemplate(t *testing.T) {
+	ctx := context.Background()
+	var sent ContentTemplate
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/template/98305":
+			_, _ = w.Write([]byte(`{"templateId":"98305","name":"Runbook","templateType":"page","description":"Old","space":{"key":"OPS"},"body":{"storage":{"value":"<p>old</p>","representation":"storage"}}}`))
+		case (r.Method == "POST" || r.Method == "PUT") && r.URL.Path == "/rest/api/template":
+			sent = ContentTemplate{}
+			_ = json.NewDecoder(r.Body).Decode(&sent)
+			_, _ = w.Write([]byte(`{"templateId":"98305"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	t.Run("create", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"name": "RFC", "content": "<h1>Summary</h1>", "spaceKey": "ENG", "labels": []any{"rfc"},
+		}}}
+		result, err := handleCreateTemplate(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if sent.Name != "RFC" || sent.TemplateType != "page" || sent.Space.Key != "ENG" || len(sent.Labels) != 1 {
+			t.Errorf("unexpected template: %+v", sent)
+		}
+	})
+
+	t.Run("update keeps unchanged fields", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"templateId": "98305", "content": "<p>new</p>",
+		}}}
+		result, err := handleUpdateTemplate(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if sent.TemplateID != "98305" || sent.Name != "Runbook" || sent.Description != "Old" || sent.Body.Storage.Value != "<p>new</p>" {
+			t.Errorf("unexpected template: %+v", sent)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		for _, tc := range []struct {
+			handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+			args    map[string]any
+		}{
+			{handleCreateTemplate(client), map[string]any{"content": "<p/>"}},
+			{handleCreateTemplate(client), map[string]any{"name": "X"}},
+			{handleUpdateTemplate(client), map[string]any{"templateId": "x"}},
+			{handleUpdateTemplate(client), map[string]any{"templateId": "1"}},
+		} {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tc.args}}
+			if result, _ := tc.handler(ctx, req); !result.IsError {
+				t.Errorf("expected error for %v", tc.args)
+			}
+		}
+	})
+}
+
+// TestHandleSearchUsers tests searching users by name.
+func TestHandleSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("cql"); got != `type = user AND user.fullname ~ "Smith"` {
+			t.Errorf("unexpected cql %q", got)
+		}
+		_, _ = w.Write([]byte(`{"results":[{"user":{"username":"jsmith","displayName":"John Smith"}}],"size":1}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleSearchUsers(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"query": " Smith "}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if !strings.Contains(result.Content[0].(mcp.TextContent).Text, "jsmith") {
+		t.Errorf("unexpected result: %v", result.Content)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"query": " "}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error for empty query")
+	}
+}
+
+// TestHandleGetCurrentUser tests retrieving the authenticated user.
+func TestHandleGetCurrentUser(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/user/current" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"type":"known","username":"bot","userKey":"8a7f"}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{}}}
+	result, err := handleGetCurrentUser(client)(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"username":"bot"`) {
+		t.Errorf("unexpected result: %v", result.Content)
+	}
+
+	badToken := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "wrong"})
+	if result, _ := handleGetCurrentUser(badToken)(ctx, req); !result.IsError {
+		t.Error("expected error for rejected token")
+	}
+}
+
+// TestGroupTools tests the group listing and membership handlers.
+func TestGroupTools(t *testing.T) {
+	ctx := context.Background()
+	var gotPath, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.EscapedPath(), r.URL.Query().Get("username")
+		_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	tests := []struct {
+		name      string
+		handler   func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args      map[string]any
+		wantPath  string
+		wantQuery string
+		wantError bool
+	}{
+		{"list groups", handleListGroups(client), map[string]any{}, "/rest/api/group", "", false},
+		{"group members", handleGetGroupMembers(client), map[string]any{"groupName": "release managers"}, "/rest/api/group/release%20managers/member", "", false},
+		{"user groups", handleGetUserGroups(client), map[string]any{"username": "jdoe"}, "/rest/api/user/memberof", "jdoe", false},
+		{"missing group", handleGetGroupMembers(client), map[string]any{}, "", "", true},
+		{"missing username", handleGetUserGroups(client), map[string]any{}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotQuery = "", ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, _ := tt.handler(ctx, req)
+			if result.IsError != tt.wantError {
+				t.Fatalf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if gotPath != tt.wantPath || gotQuery != tt.wantQuery {
+				t.Errorf("request = %s username=%q, want %s username=%q", gotPath, gotQuery, tt.wantPath, tt.wantQuery)
+			}
+		})
+	}
+}
+
+// TestHandleGetUserContent tests the CQL built for a user's content.
+func TestHandleGetUserContent(t *testing.T) {
+	ctx := context.Background()
+	var gotCQL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCQL = r.URL.Query().Get("cql")
+		_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetUserContent(client)
+
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantCQL   string
+		wantError bool
+	}{
+		{"any role", map[string]any{"username": "jdoe"},
+			`(creator = "jdoe" OR contributor = "jdoe") AND type in (page, blogpost) ORDER BY lastmodified DESC`, false},
+		{"creator in window", map[string]any{"username": "jdoe", "role": "creator", "from": "2024-01-01", "spaceKey": "DEV", "type": "page"},
+			`creator = "jdoe" AND lastmodified >= "2024-01-01" AND space = "DEV" AND type = "page" ORDER BY lastmodified DESC`, false},
+		{"invalid role", map[string]any{"username": "jdoe", "role": "owner"}, "", true},
+		{"invalid date", map[string]any{"username": "jdoe", "to": "yesterday"}, "", true},
+		{"missing username", map[string]any{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCQL = ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, _ := handler(ctx, req)
+			if result.IsError != tt.wantError {
+				t.Fatalf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if gotCQL != tt.wantCQL {
+				t.Errorf("cql = %q, want %q", gotCQL, tt.wantCQL)
+			}
+		})
+	}
+}
+
+// TestHandleConvertMentions tests converting @username references into mention markup.
+func TestHandleConvertMentions(t *testing.T) {
+	ctx := context.Background()
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		switch r.URL.Query().Get("username") {
+		case "jdoe":
+			_, _ = w.Write([]byte(`{"username":"jdoe","userKey":"8a7f01"}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleConvertMentions(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"content": "<p>@jdoe please review, cc @ghost and @jdoe. Mail jdoe@example.com.</p>",
+	}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	var out struct {
+		Content    string   `json:"content"`
+		Mentioned  []string `json:"mentioned"`
+		Unresolved []string `json:"unresolved"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	link := `<ac:link><ri:user ri:userkey="8a7f01" /></ac:link>`
+	want := "<p>" + link + " please review, cc @ghost and " + link + ". Mail jdoe@example.com.</p>"
+	if out.Content != want {
+		t.Errorf("content = %q, want %q", out.Content, want)
+	}
+	if lookups != 2 || fmt.Sprint(out.Mentioned) != "[jdoe]" || fmt.Sprint(out.Unresolved) != "[ghost]" {
+		t.Errorf("unexpected lookups=%d mentioned=%v unresolved=%v", lookups, out.Mentioned, out.Unresolved)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"content": "hi @broken"}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error when user lookup fails")
+	}
+}
+
+// TestHandleExportWord tests exporting a page as a Word document.
+func TestHandleExportWord(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exportword" || r.URL.Query().Get("pageId") != "123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("MIME-Version: 1.0"))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleExportWord(client)
+
+	t.Run("embedded resource", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		resource, ok := result.Content[1].(mcp.EmbeddedResource)
+		if !ok {
+			t.Fatalf("expected embedded resource, got %T", result.Content[1])
+		}
+		blob := resource.Resource.(mcp.BlobResourceContents)
+		if blob.MIMEType != "application/msword" || blob.Blob != "TUlNRS1WZXJzaW9uOiAxLjA=" {
+			t.Errorf("unexpected resource: %+v", blob)
+		}
+	})
+
+	t.Run("unknown page", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "999"}}}
+		if result, _ := handler(ctx, req); !result.IsError {
+			t.Error("expected error for unknown page")
+		}
+	})
+}
+
+// TestHandleExportSpace tests exporting a space through JSON-RPC.
+func TestHandleExportSpace(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rpc/json-rpc/confluenceservice-v2/exportSpace" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var params []string
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		if len(params) != 2 || params[1] != "TYPE_HTML" {
+			t.Errorf("unexpected params: %v", params)
+		}
+		_, _ = fmt.Fprintf(w, `"http://%s/download/temp/Confluence-space-export-%s.html.zip"`, r.Host, params[0])
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleExportSpace(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "DEV", "format": "HTML"}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	want := fmt.Sprintf(`{"downloadUrl":"%s/download/temp/Confluence-space-export-DEV.html.zip","format":"html","spaceKey":"DEV"}`, server.URL)
+	if got := result.Content[0].(mcp.TextContent).Text; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"spaceKey": "DEV", "format": "pdf"}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+// TestLikeTools tests liking content and reading like counts.
+func TestLikeTools(t *testing.T) {
+	ctx := context.Background()
+	likes := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rest/api/content/123":
+			_, _ = w.Write([]byte(`{"id":"123","title":"Test Page"}`))
+		case r.URL.Path == "/rest/likes/1.0/content/123/likes":
+			switch r.Method {
+			case "POST":
+				likes++
+			case "DELETE":
+				likes--
+			}
+			_, _ = w.Write([]byte(`{"likes":[` + strings.TrimSuffix(strings.Repeat(`{"user":{"name":"u"}},`, likes), ",") + `]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+	result, err := handleLikeContent(client, true)(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("like failed: %v, %v", err, result)
+	}
+	if got := result.Content[0].(mcp.TextContent).Text; got != "Liked content 123 (2 likes)" {
+		t.Errorf("unexpected like result: %s", got)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "includeLikes": true}}}
+	result, err = handleGetContent(client)(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("get content failed: %v, %v", err, result)
+	}
+	if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"likeCount":2`) {
+		t.Errorf("expected like count, got %s", result.Content[0].(mcp.TextContent).Text)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123"}}}
+	result, _ = handleLikeContent(client, false)(ctx, req)
+	if got := result.Content[0].(mcp.TextContent).Text; got != "Unliked content 123 (1 likes)" {
+		t.Errorf("unexpected unlike result: %s", got)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "999"}}}
+	if result, _ := handleLikeContent(client, true)(ctx, req); !result.IsError {
+		t.Error("expected error for unknown content")
+	}
+}
+
+// TestFavouriteTools tests managing and listing favourites.
+func TestFavouriteTools(t *testing.T) {
+	ctx := context.Background()
+	var gotMethod, gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+		_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	tests := []struct {
+		name       string
+		handler    func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args       map[string]any
+		wantMethod string
+		wantPath   string
+	}{
+		{"add", handleFavouriteContent(client, true), map[string]any{"contentId": "123"}, "PUT", "/rest/api/relation/favourite/from/user/current/to/content/123"},
+		{"remove", handleFavouriteContent(client, false), map[string]any{"contentId": "123"}, "DELETE", "/rest/api/relation/favourite/from/user/current/to/content/123"},
+		{"list", handleListFavourites(client), map[string]any{}, "GET", "/rest/api/relation/favourite/from/user/current/to/content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, err := tt.handler(ctx, req)
+			if err != nil || result.IsError {
+				t.Fatalf("handler failed: %v, %v", err, result)
+			}
+			if gotMethod != tt.wantMethod || gotPath != tt.wantPath {
+				t.Errorf("request = %s %s, want %s %s", gotMethod, gotPath, tt.wantMethod, tt.wantPath)
+			}
+		})
+	}
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "../123"}}}
+	if result, _ := handleFavouriteContent(client, true)(ctx, req); !result.IsError {
+		t.Error("expected error for invalid content ID")
+	}
+}
+
+// TestHandleRecentlyUpdated tests listing recently modified content.
+func TestHandleRecentlyUpdated(t *testing.T) {
+	ctx := context.Background()
+	var gotCQL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCQL = r.URL.Query().Get("cql")
+		_, _ = w.Write([]byte(`{"results":[{"content":{"id":"9","type":"page","title":"Standup","space":{"key":"DEV"},"version":{"number":4,"when":"2024-05-01T09:00:00.000Z","by":{"username":"jdoe","displayName":"Jane Doe"}},"_links":{"webui":"/display/DEV/Standup"}}}],"totalSize":1}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleRecentlyUpdated(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"days": float64(2), "spaces": []any{"DEV", "OPS"}, "contributors": "jdoe",
+	}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	wantCQL := `lastmodified >= now("-48h") AND space in ("DEV", "OPS") AND contributor in ("jdoe") AND type in (page, blogpost) ORDER BY lastmodified DESC`
+	if gotCQL != wantCQL {
+		t.Errorf("cql = %q, want %q", gotCQL, wantCQL)
+	}
+
+	var out struct {
+		Results []RecentEntry `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := RecentEntry{
+		ID: "9", Type: "page", Title: "Standup", Space: "DEV", ModifiedBy: "Jane Doe",
+		Modified: "2024-05-01T09:00:00.000Z", Version: 4, URL: server.URL + "/display/DEV/Standup",
+	}
+	if len(out.Results) != 1 || out.Results[0] != want {
+		t.Errorf("unexpected results: %+v", out.Results)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"hours": float64(0)}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error for empty time window")
+	}
+}
+
+// TestExtractTasks tests parsing inline tasks from storage format.
+func TestExtractTasks(t *testing.T) {
+	storage := `<ac:task-list>` +
+		`<ac:task><ac:task-id>1</ac:task-id><ac:task-status>incomplete</ac:task-status><ac:task-body>Ship it <ac:link><ri:user ri:userkey="key1" /></ac:link> by <time datetime="2024-06-01" /></ac:task-body></ac:task>` +
+		`<ac:task><ac:task-id>2</ac:task-id><ac:task-status>complete</ac:task-status><ac:task-body>Write notes</ac:task-body></ac:task>` +
+		`</ac:task-list>`
+
+	tasks := extractTasks(storage)
+	want := []InlineTask{
+		{TaskID: "1", Status: "incomplete", Text: "Ship it by", AssigneeKey: "key1", DueDate: "2024-06-01"},
+		{TaskID: "2", Status: "complete", Text: "Write notes"},
+	}
+	if len(tasks) != len(want) {
+		t.Fatalf("got %d tasks, want %d", len(tasks), len(want))
+	}
+	for i := range want {
+		if tasks[i] != want[i] {
+			t.Errorf("task %d = %+v, want %+v", i, tasks[i], want[i])
+		}
+	}
+}
+
+// TestHandleGetTasks tests collecting tasks from a page and from a CQL search.
+func TestHandleGetTasks(t *testing.T) {
+	ctx := context.Background()
+	body := `<ac:task><ac:task-status>incomplete</ac:task-status><ac:task-body>Fix <ri:user ri:userkey="key1" /></ac:task-body></ac:task><ac:task><ac:task-status>complete</ac:task-status><ac:task-body>Done</ac:task-body></ac:task>`
+	encoded, _ := json.Marshal(body)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/content/5":
+			_, _ = fmt.Fprintf(w, `{"id":"5","title":"Plan","body":{"storage":{"value":%s}}}`, encoded)
+		case "/rest/api/search":
+			_, _ = fmt.Fprintf(w, `{"results":[{"content":{"id":"6","title":"Retro","body":{"storage":{"value":%s}}}}]}`, encoded)
+		case "/rest/api/user":
+			_, _ = w.Write([]byte(`{"username":"jdoe"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetTasks(client)
+
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantSize  int
+		wantError bool
+	}{
+		{"page, all tasks", map[string]any{"contentId": "5"}, 2, false},
+		{"cql, open tasks", map[string]any{"cql": "space = DEV", "status": "incomplete"}, 1, false},
+		{"invalid status", map[string]any{"contentId": "5", "status": "open"}, 0, true},
+		{"no source", map[string]any{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, _ := handler(ctx, req)
+			if result.IsError != tt.wantError {
+				t.Fatalf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if tt.wantError {
+				return
+			}
+			var out struct {
+				Tasks []InlineTask `json:"tasks"`
+				Size  int          `json:"size"`
+			}
+			if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if out.Size != tt.wantSize || out.Tasks[0].Assignee != "jdoe" || out.Tasks[0].ContentTitle == "" {
+				t.Errorf("unexpected tasks: %+v", out)
+			}
+		})
+	}
+}
+
+// TestHandleServerInfo tests reporting the instance version and cluster state.
+func TestHandleServerInfo(t *testing.T) {
+	ctx := context.Background()
+	clusterAllowed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/applinks/1.0/manifest":
+			_, _ = w.Write([]byte(`{"version":"8.5.4","buildNumber":9012,"url":"https://wiki.example.com"}`))
+		case "/rest/zdu/cluster":
+			if !clusterAllowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"state":"STABLE","nodes":[{"id":"node1"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{}}}
+
+	result, err := handleServerInfo(client)(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	want := `{"version":"8.5.4","buildNumber":"9012","baseUrl":"https://wiki.example.com","cluster":{"state":"STABLE","nodes":[{"id":"node1"}]}}`
+	if got := result.Content[0].(mcp.TextContent).Text; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	clusterAllowed = false
+	result, _ = handleServerInfo(client)(ctx, req)
+	if result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"clusterError":"API error (status 403)`) {
+		t.Errorf("expected cluster error to be reported, got %v", result.Content)
+	}
+
+	broken := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/wiki/rest/api", Token: "t"})
+	if result, _ := handleServerInfo(broken)(ctx, req); !result.IsError {
+		t.Error("expected error when the manifest is unavailable")
+	}
+}
+
+// TestHandleGetTaskStatus tests checking and waiting for long-running tasks.
+func TestHandleGetTaskStatus(t *testing.T) {
+	ctx := context.Background()
+	oldInterval := longTaskPollInterval
+	longTaskPollInterval = time.Millisecond
+	defer func() { longTaskPollInterval = oldInterval }()
+
+	var polls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/longtask/task-1":
+			polls++
+			_, _ = fmt.Fprintf(w, `{"id":"task-1","percentageComplete":%d,"successful":true}`, min(polls*50, 100))
+		case "/rest/api/content/123/pagehierarchy/copy":
+			_, _ = w.Write([]byte(`{"id":"task-1","links":{"status":"/rest/api/longtask/task-1"}}`))
+		case "/rest/api/content/200":
+			_, _ = w.Write([]byte(`{"id":"200","ancestors":[{"id":"1"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetTaskStatus(client)
+
+	t.Run("single check", func(t *testing.T) {
+		polls = 0
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"taskId": "task-1"}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if polls != 1 || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"percentageComplete":50`) {
+			t.Errorf("unexpected result after %d polls: %v", polls, result.Content)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		polls = 0
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"taskId": "task-1", "wait": true}}}
+		result, err := handler(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if polls != 2 || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"percentageComplete":100`) {
+			t.Errorf("unexpected result after %d polls: %v", polls, result.Content)
+		}
+	})
+
+	t.Run("copy waits for hierarchy task", func(t *testing.T) {
+		polls = 0
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId": "123", "targetParentId": "200", "includeChildren": true, "wait": true,
+		}}}
+		result, err := handleCopyContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"percentageComplete":100`) {
+			t.Errorf("expected final task status, got %v", result.Content)
+		}
+	})
+
+	t.Run("invalid task ID", func(t *testing.T) {
+		for _, id := range []any{"", "../x", 5} {
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"taskId": id}}}
+			if result, _ := handler(ctx, req); !result.IsError {
+				t.Errorf("expected error for task ID %v", id)
+			}
+		}
+	})
+}
+
+// TestHandleValidateCQL tests validating CQL queries.
+func TestHandleValidateCQL(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") != "0" {
+			t.Errorf("expected limit=0, got %s", r.URL.RawQuery)
+		}
+		switch r.URL.Query().Get("cql") {
+		case "space = DEV":
+			_, _ = w.Write([]byte(`{"results":[],"totalSize":42}`))
+		case "space = ":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"statusCode":400,"message":"Could not parse cql : space = "}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleValidateCQL(client)
+
+	tests := []struct {
+		cql       string
+		want      string
+		wantError bool
+	}{
+		{"space = DEV", `{"cql":"space = DEV","totalSize":42,"valid":true}`, false},
+		{"space = ", `{"cql":"space = ","message":"Could not parse cql : space = ","valid":false}`, false},
+		{"type = page", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": tt.cql}}}
+		result, _ := handler(ctx, req)
+		if result.IsError != tt.wantError {
+			t.Errorf("%q: IsError = %v, want %v", tt.cql, result.IsError, tt.wantError)
+			continue
+		}
+		if !tt.wantError && result.Content[0].(mcp.TextContent).Text != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.cql, result.Content[0].(mcp.TextContent).Text, tt.want)
+		}
+	}
+}
+
+// TestHandleFind tests assembling CQL from structured criteria.
+func TestHandleFind(t *testing.T) {
+	ctx := context.Background()
+	var gotCQL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCQL = r.URL.Query().Get("cql")
+		_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleFind(client)
+
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantCQL   string
+		wantError bool
+	}{
+		{"text search", map[string]any{"spaceKey": "DEV,OPS", "textContains": `say "hi"`},
+			`space in ("DEV", "OPS") AND text ~ "say \"hi\""`, false},
+		{"filters only", map[string]any{"type": []any{"page"}, "label": "runbook", "creator": "jdoe", "titleContains": "deploy", "modifiedAfter": "2024-01-01"},
+			`type = "page" AND label = "runbook" AND creator = "jdoe" AND title ~ "deploy" AND lastmodified >= "2024-01-01" ORDER BY lastmodified DESC`, false},
+		{"injection attempt stays quoted", map[string]any{"spaceKey": `DEV" OR space != "X`},
+			`space = "DEV\" OR space != \"X" ORDER BY lastmodified DESC`, false},
+		{"invalid date", map[string]any{"modifiedBefore": "01/02/2024"}, "", true},
+		{"no criteria", map[string]any{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCQL = ""
+			req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}}
+			result, _ := handler(ctx, req)
+			if result.IsError != tt.wantError {
+				t.Fatalf("IsError = %v, want %v: %v", result.IsError, tt.wantError, result.Content)
+			}
+			if gotCQL != tt.wantCQL {
+				t.Errorf("cql = %q, want %q", gotCQL, tt.wantCQL)
+			}
+		})
+	}
+}
+
+// TestHandleSearchContentCompact tests compact search results with highlighted excerpts.
+func TestHandleSearchContentCompact(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("excerpt") != "highlight" {
+			t.Errorf("expected excerpt=highlight, got %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"results":[{"content":{"id":"7","type":"page","title":"Deploy guide"},"title":"@@@hl@@@Deploy@@@endhl@@@ guide","excerpt":"How to\n @@@hl@@@deploy@@@endhl@@@ the app","url":"/display/DEV/Deploy+guide","resultGlobalContainer":{"title":"Development"},"lastModified":"2024-05-01T09:00:00.000Z"}],"start":10,"size":1,"totalSize":11}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleSearchContent(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": "text ~ deploy", "compact": true, "start": float64(10)}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	var out struct {
+		Results   []SearchHit `json:"results"`
+		TotalSize int         `json:"totalSize"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := SearchHit{
+		Rank: 11, ID: "7", Type: "page", Title: "**Deploy** guide", Space: "Development",
+		URL: server.URL + "/display/DEV/Deploy+guide", Excerpt: "How to **deploy** the app", LastModified: "2024-05-01T09:00:00.000Z",
+	}
+	if len(out.Results) != 1 || out.Results[0] != want || out.TotalSize != 11 {
+		t.Errorf("unexpected results: %+v", out)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": "text ~ deploy", "compact": true, "fetchAll": true}}}
+	result, err = handler(ctx, req)
+	if err != nil || result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"rank":1,`) {
+		t.Errorf("unexpected fetchAll result: %v, %v", err, result.Content)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": "text ~ deploy", "excerpt": "bold"}}}
+	if result, _ := handler(ctx, req); !result.IsError {
+		t.Error("expected error for invalid excerpt strategy")
+	}
+}
+
+// TestHandleSiteSearch tests searching all facets at once.
+func TestHandleSiteSearch(t *testing.T) {
+	ctx := context.Background()
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cql := r.URL.Query().Get("cql")
+		queries = append(queries, cql)
+		switch {
+		case strings.HasPrefix(cql, "type = page "):
+			_, _ = w.Write([]byte(`{"results":[{"content":{"id":"1","type":"page","title":"Deploy"},"title":"Deploy"}],"totalSize":12}`))
+		case strings.HasPrefix(cql, "type = user "):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			_, _ = w.Write([]byte(`{"results":[],"totalSize":0}`))
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleSiteSearch(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"query": "deploy", "types": "page,space,user", "spaceKey": "DEV"}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	wantQueries := []string{
+		`type = page AND text ~ "deploy" AND space = "DEV"`,
+		`type = space AND space.title ~ "deploy" AND space = "DEV"`,
+		`type = user AND user.fullname ~ "deploy"`,
+	}
+	if strings.Join(queries, "\n") != strings.Join(wantQueries, "\n") {
+		t.Errorf("queries = %q, want %q", queries, wantQueries)
+	}
+
+	var out struct {
+		Facets []SearchFacet `json:"facets"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(out.Facets) != 3 || out.Facets[0].TotalSize != 12 || len(out.Facets[0].Results) != 1 ||
+		out.Facets[1].TotalSize != 0 || out.Facets[2].Error == "" {
+		t.Errorf("unexpected facets: %+v", out.Facets)
+	}
+
+	for _, args := range []map[string]any{{"query": "  "}, {"query": "x", "types": "wiki"}} {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+		if result, _ := handler(ctx, req); !result.IsError {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+// TestHandleConvertBody tests converting bodies between representations.
+func TestHandleConvertBody(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/contentbody/convert/storage" || r.URL.Query().Get("pageIdContext") != "123" {
+			t.Errorf("unexpected request %s?%s", r.URL.Path, r.URL.RawQuery)
+		}
+		var body BodyStorage
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Representation != "wiki" || body.Value != "h1. Title" {
+			t.Errorf("unexpected body: %+v", body)
+		}
+		_, _ = w.Write([]byte(`{"value":"<h1>Title</h1>","representation":"storage"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleConvertBody(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"value": "h1. Title", "from": "wiki", "to": "storage", "contentId": "123",
+	}}}
+	result, err := handler(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if got := result.Content[0].(mcp.TextContent).Text; got != "<h1>Title</h1>" {
+		t.Errorf("got %q", got)
+	}
+
+	for _, args := range []map[string]any{
+		{"value": "x", "from": "storage", "to": "wiki"},
+		{"value": "x", "from": "markdown", "to": "storage"},
+		{"from": "storage", "to": "view"},
+	} {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}
+		if result, _ := handler(ctx, req); !result.IsError {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+// TestMarkdownToStorage tests converting Markdown blocks and inline markup to storage format.
+func TestMarkdownToStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"heading", "## Title ##", "<h2>Title</h2>"},
+		{"paragraph", "one\ntwo\n\nthree", "<p>one two</p><p>three</p>"},
+		{"inline", "**b** *i* ~~s~~ `a<b` snake_case & x", "<p><strong>b</strong> <em>i</em> <del>s</del> <code>a&lt;b</code> snake_case &amp; x</p>"},
+		{"escapes", `\*not em\*`, "<p>*not em*</p>"},
+		{"link", `[the **docs**](https://example.com "Docs")`, `<p><a href="https://example.com">the <strong>docs</strong></a></p>`},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com">https://example.com</a></p>`},
+		{"external image", "![Logo](https://example.com/logo.png)", `<p><ac:image ac:alt="Logo"><ri:url ri:value="https://example.com/logo.png" /></ac:image></p>`},
+		{"attachment image", "![](diagram.png)", `<p><ac:image><ri:attachment ri:filename="diagram.png" /></ac:image></p>`},
+		{"nested list", "- a\n- b\n  1. c\n  2. d\n\n- e", "<ul><li>a</li><li>b<ol><li>c</li><li>d</li></ol></li><li>e</li></ul>"},
+		{"list then paragraph", "1. a\n\nafter", "<ol><li>a</li></ol><p>after</p>"},
+		{"table", "| A | B |\n|---|:-:|\n| 1 | x \\| y |", "<table><tbody><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>x | y</td></tr></tbody></table>"},
+		{"code fence", "```go\nif a < b {}\n```", `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">go</ac:parameter><ac:plain-text-body><![CDATA[if a < b {}]]></ac:plain-text-body></ac:structured-macro>`},
+		{"code fence with cdata end", "~~~\n]]>\n~~~", `<ac:structured-macro ac:name="code"><ac:plain-text-body><![CDATA[]]]]><![CDATA[>]]></ac:plain-text-body></ac:structured-macro>`},
+		{"quote", "> a\n> b", "<blockquote><p>a b</p></blockquote>"},
+		{"rule", "a\n\n***", "<p>a</p><hr />"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markdownToStorage(tt.markdown); got != tt.want {
+				t.Errorf("markdownToStorage(%q)\n got %s\nwant %s", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleContentMarkdownInput tests creating and updating content from Markdown.
+func TestHandleContentMarkdownInput(t *testing.T) {
+	ctx := context.Background()
+	var written string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"id":"123","type":"page","title":"T","version":{"number":1}}`))
+			return
+		}
+		var page ConfluencePage
+		_ = json.NewDecoder(r.Body).Decode(&page)
+		written = page.Body.Storage.Value
+		_, _ = w.Write([]byte(`{"id":"123"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	t.Run("create", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"title": "T", "spaceKey": "TEST", "content": "# Hi", "contentFormat": "markdown",
+		}}}
+		result, err := handleCreateContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if written != "<h1>Hi</h1>" {
+			t.Errorf("unexpected body: %s", written)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"contentId": "123", "content": "- item", "contentFormat": "markdown",
+		}}}
+		result, err := handleUpdateContent(client)(ctx, req)
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		if written != "<ul><li>item</li></ul>" {
+			t.Errorf("unexpected body: %s", written)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+			"title": "T", "spaceKey": "TEST", "content": "x", "contentFormat": "rst",
+		}}}
+		if result, _ := handleCreateContent(client)(ctx, req); !result.IsError {
+			t.Error("expected error for unknown contentFormat")
+		}
+	})
+}
+
+// TestStorageToMarkdown tests converting storage format to Markdown.
+func TestStorageToMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    string
+	}{
+		{"heading", "<h2>A &amp; B</h2>", "## A & B"},
+		{"inline", "<p>Some <strong>bold </strong>and <em>em</em>&nbsp;<code>x</code> <a href=\"https://example.com\">link</a></p>", "Some **bold** and *em* `x` [link](https://example.com)"},
+		{"line break", "<p>one<br/>two</p>", "one\ntwo"},
+		{"page link", `<p><ac:link><ri:page ri:content-title="Other" /></ac:link> and <ac:link><ri:page ri:content-title="X" /><ac:plain-text-link-body><![CDATA[label]]></ac:plain-text-link-body></ac:link></p>`, "Other and label"},
+		{"image", `<p><ac:image ac:alt="d"><ri:attachment ri:filename="d.png" /></ac:image></p>`, "![d](d.png)"},
+		{"nested list", "<ul><li><p>a</p><ol><li>b</li></ol></li><li>c</li></ul>", "- a\n  1. b\n- c"},
+		{"tasks", "<ac:task-list><ac:task><ac:task-id>1</ac:task-id><ac:task-status>complete</ac:task-status><ac:task-body>done</ac:task-body></ac:task><ac:task><ac:task-id>2</ac:task-id><ac:task-status>incomplete</ac:task-status><ac:task-body>todo</ac:task-body></ac:task></ac:task-list>", "- [x] done\n- [ ] todo"},
+		{"table", "<table><tbody><tr><th>A</th><th>B</th></tr><tr><td><p>1|2</p></td></tr></tbody></table>", "| A | B |\n| --- | --- |\n| 1\\|2 |  |"},
+		{"code macro", `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">go</ac:parameter><ac:plain-text-body><![CDATA[a < b]]></ac:plain-text-body></ac:structured-macro>`, "```go\na < b\n```"},
+		{"panel macro", `<ac:structured-macro ac:name="note"><ac:rich-text-body><p>Careful <ac:structured-macro ac:name="status"><ac:parameter ac:name="title">DONE</ac:parameter></ac:structured-macro></p></ac:rich-text-body></ac:structured-macro>`, "> **Note**\n>\n> Careful [DONE]"},
+		{"dropped macro", `<p>a</p><ac:structured-macro ac:name="toc" /><hr />`, "a\n\n---"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storageToMarkdown(tt.storage)
+			if err != nil {
+				t.Fatalf("storageToMarkdown failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("storageToMarkdown(%q)\n got %q\nwant %q", tt.storage, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleGetContentMarkdown tests returning a content body as Markdown.
+func TestHandleGetContentMarkdown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"123","title":"T","body":{"storage":{"value":"<h1>Hi</h1><p>x</p>","representation":"storage"}}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetContent(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "outputFormat": "markdown"}}}
+	result, err := handler(context.Background(), req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	var content struct {
+		Title string `json:"title"`
+		Body  map[string]struct {
+			Value string `json:"value"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.Title != "T" || content.Body["markdown"].Value != "# Hi\n\nx" {
+		t.Errorf("unexpected content: %+v", content)
+	}
+	if _, ok := content.Body["storage"]; ok {
+		t.Error("expected storage body to be replaced")
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "outputFormat": "pdf"}}}
+	if result, _ := handler(context.Background(), req); !result.IsError {
+		t.Error("expected error for unknown outputFormat")
+	}
+}
+
+func TestStripMacros(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    string
+	}{
+		{"body and parameters", `<p>a</p><ac:structured-macro ac:name="jira" ac:schema-version="1"><ac:parameter ac:name="key">X-1</ac:parameter></ac:structured-macro><p>b</p>`,
+			`<p>a</p><ac:structured-macro ac:name="jira" /><p>b</p>`},
+		{"nested", `<ac:structured-macro ac:name="expand"><ac:rich-text-body><ac:structured-macro ac:name="code"/><ac:structured-macro ac:name="info"><ac:rich-text-body>x</ac:rich-text-body></ac:structured-macro></ac:rich-text-body></ac:structured-macro>`,
+			`<ac:structured-macro ac:name="expand" />`},
+		{"empty", `<ac:structured-macro ac:name="toc" ac:macro-id="1"/>`, `<ac:structured-macro ac:name="toc" />`},
+		{"unclosed", `<p>a</p><ac:structured-macro ac:name="info"><p>b</p>`, `<p>a</p><ac:structured-macro ac:name="info"><p>b</p>`},
+		{"no macros", `<p>a</p>`, `<p>a</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripMacros(tt.storage); got != tt.want {
+				t.Errorf("stripMacros() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleGetContentMacros(t *testing.T) {
+	include := func(name, title, space string) string {
+		if space != "" {
+			space = ` ri:space-key="` + space + `"`
+		}
+		return `<ac:structured-macro ac:name="` + name + `"><ac:parameter ac:name=""><ac:link><ri:page ri:content-title="` + title + `"` + space + ` /></ac:link></ac:parameter></ac:structured-macro>`
+	}
+	pages := map[string]string{
+		"DOC/Shared": `<p>shared</p>` + include("include", "Loop", ""),
+		"DOC/Loop":   `<p>loop</p>` + include("include", "Shared", ""),
+		"OPS/Other":  `<p>rest</p><ac:structured-macro ac:name="excerpt"><ac:rich-text-body><p>summary</p></ac:rich-text-body></ac:structured-macro>`,
+	}
+	page := `<p>intro</p>` + include("include", "Shared", "") + include("excerpt-include", "Other", "OPS") + include("include", "Missing", "")
+	var expands []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/api/content/1" {
+			expands = append(expands, r.URL.Query().Get("expand"))
+			body, _ := json.Marshal(map[string]any{"id": "1", "title": "Main", "space": map[string]any{"key": "DOC"},
+				"body": map[string]any{"storage": map[string]any{"value": page, "representation": "storage"}}})
+			_, _ = w.Write(body)
+			return
+		}
+		storage, ok := pages[r.URL.Query().Get("spaceKey")+"/"+r.URL.Query().Get("title")]
+		if !ok {
+			_, _ = w.Write([]byte(`{"results":[],"size":0}`))
+			return
+		}
+		body, _ := json.Marshal(map[string]any{"results": []any{map[string]any{"body": map[string]any{"storage": map[string]any{"value": storage}}}}, "size": 1})
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetContent(client)
+	body := func(args map[string]any) string {
+		t.Helper()
+		args["contentId"] = "1"
+		result, err := handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("handler failed: %v, %v", err, result)
+		}
+		var content struct {
+			Body map[string]struct {
+				Value string `json:"value"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &content); err != nil {
+			t.Fatal(err)
+		}
+		for _, representation := range content.Body {
+			return representation.Value
+		}
+		return ""
+	}
+
+	want := `<p>intro</p><p>shared</p><p>loop</p>` + include("include", "Shared", "") + `<p>summary</p>` + include("include", "Missing", "")
+	if got := body(map[string]any{"macros": "expand"}); got != want {
+		t.Errorf("unexpected expanded body:\n got %s\nwant %s", got, want)
+	}
+	if !strings.Contains(expands[0], "space") {
+		t.Errorf("expected the space of the page to be expanded, got %q", expands[0])
+	}
+	if got := body(map[string]any{"macros": "expand", "outputFormat": "text"}); got != "intro\nshared\nloop\nsummary" {
+		t.Errorf("unexpected expanded text %q", got)
+	}
+	if got, want := body(map[string]any{"macros": "strip"}), `<p>intro</p><ac:structured-macro ac:name="include" />`+
+		`<ac:structured-macro ac:name="excerpt-include" /><ac:structured-macro ac:name="include" />`; got != want {
+		t.Errorf("unexpected stripped body:\n got %s\nwant %s", got, want)
+	}
+
+	for _, args := range []map[string]any{{"macros": "inline"}, {"macros": "strip", "outputFormat": "view"}} {
+		args["contentId"] = "1"
+		if result, _ := handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+// TestStorageToPlainText tests extracting normalized plain text from storage format.
+func TestStorageToPlainText(t *testing.T) {
+	storage := `<h1>Title</h1><p>Some <strong>bold</strong>   text&nbsp;here<br/>next</p>` +
+		`<ac:structured-macro ac:name="info"><ac:parameter ac:name="title">Hidden</ac:parameter><ac:rich-text-body><p>Panel</p></ac:rich-text-body></ac:structured-macro>` +
+		`<table><tbody><tr><th>A</th><th>B</th></tr><tr><td><p>1</p></td><td>2</td></tr></tbody></table>` +
+		`<ul><li>one</li><li>two <ac:link><ri:page ri:content-title="Page" /></ac:link></li></ul>`
+	got, err := storageToPlainText(storage)
+	if err != nil {
+		t.Fatalf("storageToPlainText failed: %v", err)
+	}
+	want := "Title\nSome bold text here\nnext\nPanel\nA B\n1\n2\none\ntwo"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestHandleGetContentText tests returning a content body as plain text within a character budget.
+func TestHandleGetContentText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"123","body":{"storage":{"value":"<p>Hello <em>big</em> world</p>","representation":"storage"}}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetContent(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "outputFormat": "text", "maxChars": float64(9)}}}
+	result, err := handler(context.Background(), req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, `"text":{"representation":"text","value":"Hello big…"}`) {
+		t.Errorf("unexpected content: %s", text)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "outputFormat": "text", "maxChars": float64(0)}}}
+	if result, _ := handler(context.Background(), req); !result.IsError {
+		t.Error("expected error for non-positive maxChars")
+	}
+}
+
+// TestHandleGetContentView tests requesting the rendered view body instead of storage.
+func TestHandleGetContentView(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if expand := r.URL.Query().Get("expand"); expand != "version,body.view" {
+			t.Errorf("unexpected expand %q", expand)
+		}
+		_, _ = w.Write([]byte(`{"id":"123","body":{"view":{"value":"<p>rendered</p>","representation":"view"}}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "expand": "version", "outputFormat": "view"}}}
+	result, err := handleGetContent(client)(context.Background(), req)
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "rendered") {
+		t.Errorf("unexpected content: %s", text)
+	}
+}
+
+// TestHandleContentWikiInput tests creating and updating content from wiki markup.
+func TestHandleContentWikiInput(t *testing.T) {
+	ctx := context.Background()
+	var written, pageContext string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rest/api/contentbody/convert/storage":
+			pageContext = r.URL.Query().Get("pageIdContext")
+			var body BodyStorage
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.Representation != "wiki" {
+				t.Errorf("expected wiki representation, got %q", body.Representation)
+			}
+			if body.Value == "broken" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"value":"<h1>` + strings.TrimPrefix(body.Value, "h1. ") + `</h1>","representation":"storage"}`))
+		case r.Method == "GET":
+			_, _ = w.Write([]byte(`{"id":"123","type":"page","title":"T","version":{"number":1}}`))
+		default:
+			var page ConfluencePage
+			_ = json.NewDecoder(r.Body).Decode(&page)
+			written = page.Body.Storage.Value
+			_, _ = w.Write([]byte(`{"id":"123"}`))
+		}
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"title": "T", "spaceKey": "TEST", "content": "h1. New", "contentFormat": "wiki",
+	}}}
+	if result, err := handleCreateContent(client)(ctx, req); err != nil || result.IsError {
+		t.Fatalf("create failed: %v, %v", err, result)
+	}
+	if written != "<h1>New</h1>" || pageContext != "" {
+		t.Errorf("unexpected create: body %q, context %q", written, pageContext)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"contentId": "123", "content": "h1. Updated", "contentFormat": "wiki",
+	}}}
+	if result, err := handleUpdateContent(client)(ctx, req); err != nil || result.IsError {
+		t.Fatalf("update failed: %v, %v", err, result)
+	}
+	if written != "<h1>Updated</h1>" || pageContext != "123" {
+		t.Errorf("unexpected update: body %q, context %q", written, pageContext)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"contentId": "123", "content": "broken", "contentFormat": "wiki",
+	}}}
+	result, _ := handleUpdateContent(client)(ctx, req)
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "error converting wiki markup") {
+		t.Errorf("expected conversion error, got %v", result)
+	}
+}
+
+// TestValidateStorage tests rejecting malformed storage format with its position.
+func TestValidateStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		wantErr string
+	}{
+		{"valid", `<p>a &amp; b&nbsp;&#38;</p><ac:structured-macro ac:name="code"><ac:plain-text-body><![CDATA[a && b]]></ac:plain-text-body></ac:structured-macro>`, ""},
+		{"template variable", `<p><at:var at:name="x" /></p>`, ""},
+		{"bare ampersand", "<p>a & b</p>", "line 1, column 7 in <p>: invalid character entity & (no semicolon)"},
+		{"mismatched tag", "<p>x\n<div>y</p>", "line 2, column 11 in <div>: element <div> closed by </p>"},
+		{"unclosed tag", "<p>x", "element <p> is never closed"},
+		{"unknown element prefix", "<p><foo:bar /></p>", `unknown namespace prefix "foo" in element <foo:bar>`},
+		{"unknown attribute prefix", `<ac:image bad:x="1" />`, `unknown namespace prefix "bad" in attribute bad:x of <ac:image>`},
+		{"unknown entity", "<p>&bogus;</p>", "invalid character entity &bogus;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStorage(tt.storage)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestEscapeBareAmpersands tests escaping bare ampersands outside CDATA sections.
+func TestEscapeBareAmpersands(t *testing.T) {
+	got := escapeBareAmpersands("a & b &amp; &#38; &#x26; &nbsp;<![CDATA[x && y]]> c&d")
+	want := "a &amp; b &amp; &#38; &#x26; &nbsp;<![CDATA[x && y]]> c&amp;d"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestHandleCreateContentValidation tests that invalid storage content is rejected before it is sent.
+func TestHandleCreateContentValidation(t *testing.T) {
+	var posted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page ConfluencePage
+		_ = json.NewDecoder(r.Body).Decode(&page)
+		posted = page.Body.Storage.Value
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleCreateContent(client)
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"title": "T", "spaceKey": "TEST", "content": "<p>R&D</p>",
+	}}}
+	result, _ := handler(context.Background(), req)
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "invalid storage format at line 1") {
+		t.Errorf("expected validation error, got %v", result)
+	}
+	if posted != "" {
+		t.Error("expected invalid content not to be sent")
+	}
+
+	req.Params.Arguments.(map[string]any)["autoEscape"] = true
+	if result, err := handler(context.Background(), req); err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if posted != "<p>R&amp;D</p>" {
+		t.Errorf("unexpected posted content %q", posted)
+	}
+}
+
+// TestBuildMacro tests generating macro markup from structured parameters.
+func TestBuildMacro(t *testing.T) {
+	tests := []struct {
+		name    string
+		macro   string
+		params  map[string]string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "code", macro: "code", params: map[string]string{"title": "A & B", "language": "go"}, body: "x]]>y",
+			want: `<ac:structured-macro ac:name="code" ac:schema-version="1"><ac:parameter ac:name="language">go</ac:parameter><ac:parameter ac:name="title">A &amp; B</ac:parameter><ac:plain-text-body><![CDATA[x]]]]><![CDATA[>y]]></ac:plain-text-body></ac:structured-macro>`,
+		},
+		{
+			name: "toc", macro: "toc", params: map[string]string{"maxLevel": "3"},
+			want: `<ac:structured-macro ac:name="toc" ac:schema-version="1"><ac:parameter ac:name="maxLevel">3</ac:parameter></ac:structured-macro>`,
+		},
+		{
+			name: "include", macro: "include", params: map[string]string{"title": "Other", "spaceKey": "DOC"},
+			want: `<ac:structured-macro ac:name="include" ac:schema-version="1"><ac:parameter ac:name=""><ac:link><ri:page ri:content-title="Other" ri:space-key="DOC" /></ac:link></ac:parameter></ac:structured-macro>`,
+		},
+		{
+			name: "expand", macro: "expand", params: map[string]string{"title": "More"}, body: "<p>hidden</p>",
+			want: `<ac:structured-macro ac:name="expand" ac:schema-version="1"><ac:parameter ac:name="title">More</ac:parameter><ac:rich-text-body><p>hidden</p></ac:rich-text-body></ac:structured-macro>`,
+		},
+		{name: "unknown macro", macro: "gadget", wantErr: `unknown macro "gadget"`},
+		{name: "unknown parameter", macro: "toc", params: map[string]string{"depth": "2"}, wantErr: `unknown parameter "depth"`},
+		{name: "missing required", macro: "status", wantErr: `parameter "title" is required`},
+		{name: "invalid value", macro: "status", params: map[string]string{"title": "OK", "colour": "Pink"}, wantErr: "must be one of Grey, Red"},
+		{name: "jira without issue", macro: "jira", params: map[string]string{"server": "Jira"}, wantErr: "either key or jqlQuery"},
+		{name: "unexpected body", macro: "toc", body: "x", wantErr: "does not take a body"},
+		{name: "invalid rich text body", macro: "expand", body: "<p>open", wantErr: "invalid body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildMacro(tt.macro, tt.params, tt.body)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildMacro failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+			if err := validateStorage(got); err != nil {
+				t.Errorf("generated markup is invalid: %v", err)
+			}
+		})
+	}
+}
+
+// TestMacroTools tests listing macros and building macro markup through the tools.
+func TestMacroTools(t *testing.T) {
+	ctx := context.Background()
+
+	result, err := handleListMacros()(ctx, mcp.CallToolRequest{})
+	if err != nil || result.IsError {
+		t.Fatalf("list failed: %v, %v", err, result)
+	}
+	var macros []MacroDefinition
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &macros); err != nil || len(macros) != len(macroCatalog) {
+		t.Errorf("unexpected catalog: %v, %v", macros, err)
+	}
+
+	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"name": "jira", "parameters": map[string]any{"key": "ABC-1", "maximumIssues": float64(5)},
+	}}}
+	result, err = handleBuildMacro()(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("build failed: %v, %v", err, result)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, `<ac:parameter ac:name="key">ABC-1</ac:parameter>`) ||
+		!strings.Contains(text, `<ac:parameter ac:name="maximumIssues">5</ac:parameter>`) {
+		t.Errorf("unexpected markup: %s", text)
+	}
+
+	req = mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"name": "status"}}}
+	if result, _ := handleBuildMacro()(ctx, req); !result.IsError {
+		t.Error("expected error for missing status title")
+	}
+}
+
+// TestExtractTables tests extracting tables with headers and merged cells.
+func TestExtractTables(t *testing.T) {
+	storage := `<p>intro</p><table><tbody>` +
+		`<tr><th>Name</th><th>Q1</th><th>Q2</th></tr>` +
+		`<tr><td rowspan="2"><p>Team <strong>A</strong></p></td><td colspan="2">10</td></tr>` +
+		`<tr><td>3</td><td>4</td></tr>` +
+		`</tbody></table><table><tr><td>x</td></tr><tr><td>y</td><td>z</td></tr></table>`
+
+	tables, err := extractTables(storage)
+	if err != nil {
+		t.Fatalf("extractTables failed: %v", err)
+	}
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %d", len(tables))
+	}
+
+	first := tables[0]
+	if strings.Join(first.Headers, ",") != "Name,Q1,Q2" {
+		t.Errorf("unexpected headers %v", first.Headers)
+	}
+	if fmt.Sprint(first.Rows) != "[[Team A 10 10] [Team A 3 4]]" {
+		t.Errorf("unexpected rows %v", first.Rows)
+	}
+	want := []MergedCell{{Row: 1, Column: 0, RowSpan: 2, ColSpan: 1}, {Row: 1, Column: 1, RowSpan: 1, ColSpan: 2}}
+	if fmt.Sprint(first.MergedCells) != fmt.Sprint(want) {
+		t.Errorf("unexpected merged cells %v", first.MergedCells)
+	}
+
+	second := tables[1]
+	if second.Index != 1 || second.Headers != nil || fmt.Sprint(second.Rows) != "[[x ] [y z]]" {
+		t.Errorf("unexpected second table %+v", second)
+	}
+
+	out, err := tablesToCSV(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Name,Q1,Q2\nTeam A,10,10\nTeam A,3,4\n\nx,\ny,z\n" {
+		t.Errorf("unexpected CSV %q", out)
+	}
+}
+
+// TestHandleExtractTables tests extracting tables through the tool.
+func TestHandleExtractTables(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"123","body":{"storage":{"value":"<table><tr><th>A</th></tr><tr><td>1</td></tr></table><table><tr><td>2</td></tr></table>"}}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleExtractTables(client)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"json", map[string]any{"contentId": "123"}, `[{"index":0,"headers":["A"],"rows":[["1"]]},{"index":1,"rows":[["2"]]}]`},
+		{"csv", map[string]any{"contentId": "123", "format": "csv", "tableIndex": float64(1)}, "2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: tt.args}})
+			if err != nil || result.IsError {
+				t.Fatalf("handler failed: %v, %v", err, result)
+			}
+			if got := result.Content[0].(mcp.TextContent).Text; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, args := range []map[string]any{
+		{"contentId": "123", "tableIndex": float64(2)},
+		{"contentId": "123", "format": "xml"},
+	} {
+		if result, _ := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}}); !result.IsError {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+// TestChunkStorage tests splitting a body into heading sections with anchors and token estimates.
+func TestChunkStorage(t *testing.T) {
+	storage := `<p>Intro text</p><h1>Getting Started</h1><p>Install it.</p>` +
+		`<ac:layout><ac:layout-section><ac:layout-cell><h2>On Linux</h2><p>Use the package.</p></ac:layout-cell></ac:layout-section></ac:layout>` +
+		`<h1>FAQ</h1><h2>On Linux</h2><p>Again</p>`
+
+	chunks, err := chunkStorage("My Page", storage, false)
+	if err != nil {
+		t.Fatalf("chunkStorage failed: %v", err)
+	}
+
+	want := []struct {
+		anchor, heading, path, content string
+	}{
+		{"MyPage", "", "", "Intro text"},
+		{"MyPage-GettingStarted", "Getting Started", "Getting Started", "# Getting Started\n\nInstall it."},
+		{"MyPage-OnLinux", "On Linux", "Getting Started/On Linux", "## On Linux\n\nUse the package."},
+		{"MyPage-FAQ", "FAQ", "FAQ", "# FAQ"},
+		{"MyPage-OnLinux.1", "On Linux", "FAQ/On Linux", "## On Linux\n\nAgain"},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("expected %d chunks, got %d: %+v", len(want), len(chunks), chunks)
+	}
+	for i, w := range want {
+		c := chunks[i]
+		if c.Anchor != w.anchor || c.Heading != w.heading || strings.Join(c.Path, "/") != w.path || c.Content != w.content {
+			t.Errorf("chunk %d: got %+v, want %+v", i, c, w)
+		}
+		if c.Tokens != estimateTokens(c.Content) || c.Tokens == 0 {
+			t.Errorf("chunk %d: unexpected token estimate %d", i, c.Tokens)
+		}
+	}
+
+	plain, err := chunkStorage("My Page", storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain[1].Content != "Getting Started\nInstall it." {
+		t.Errorf("unexpected plain text chunk %q", plain[1].Content)
+	}
+}
+
+// TestHandleGetContentChunked tests chunked retrieval of all sections and of a single section.
+
+func TestEditSection(t *testing.T) {
+	storage := `<p>Intro</p><h1>Setup</h1><p>Install it.</p><h2>On Linux</h2><p>Use the package.</p>` +
+		`<ac:layout><ac:layout-section><ac:layout-cell><h2>On Mac</h2><p>Use brew.</p></ac:layout-cell></ac:layout-section></ac:layout>` +
+		`<h1>FAQ</h1><p>None &amp; more<br/></p><h2>On Linux</h2><p>Again</p>`
+	tests := []struct {
+		name, section, editMode, want string
+	}{
+		{"replace up to a sibling heading", "MyPage-OnLinux", "replace", `<h2>On Linux</h2><p>new</p><ac:layout>`},
+		{"replace with subsections", "Setup", "replace", `<p>Intro</p><h1>Setup</h1><p>new</p><h1>FAQ</h1>`},
+		{"replace in a layout cell", "MyPage-OnMac", "replace", `<h2>On Mac</h2><p>new</p></ac:layout-cell>`},
+		{"append before the next heading", "MyPage-OnLinux", "append", `<p>Use the package.</p><p>new</p><ac:layout>`},
+		{"append after subsections", "FAQ", "append", `<p>Again</p><p>new</p>`},
+		{"prepend", "FAQ", "prepend", `<h1>FAQ</h1><p>new</p><p>None &amp; more`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := editSection("My Page", storage, tt.section, tt.editMode, "<p>new</p>")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) || validateStorage(got) != nil {
+				t.Errorf("expected %q in %q", tt.want, got)
+			}
+			if strings.Count(got, "<p>new</p>") != 1 || tt.editMode != "replace" && len(got) != len(storage)+len("<p>new</p>") {
+				t.Errorf("expected the rest of the page to be kept, got %q", got)
+			}
+		})
+	}
+
+	if _, err := editSection("My Page", storage, "Missing", "replace", ""); err == nil || !strings.Contains(err.Error(), "MyPage-OnLinux.1") {
+		t.Errorf("expected an error listing the sections, got %v", err)
+	}
+	if _, err := editSection("My Page", "<p>flat</p>", "Setup", "replace", ""); err == nil || !strings.Contains(err.Error(), "no headings") {
+		t.Errorf("expected an error for a page without headings, got %v", err)
+	}
+}
+
+func TestHandleUpdateSection(t *testing.T) {
+	var put ConfluencePage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"id":"1","type":"page","title":"Log","version":{"number":4},"body":{"storage":{"value":"<h2>Decisions</h2><table><tbody><tr><td>a</td></tr></tbody></table><h2>Next</h2>","representation":"storage"}}}`))
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&put)
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		args["contentId"] = "1"
+		result, err := handleUpdateSection(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := call(map[string]any{"section": "Decisions", "editMode": "append", "content": "- b", "contentFormat": "markdown", "versionComment": "add b"})
+	if result.IsError {
+		t.Fatalf("unexpected error %v", result.Content)
+	}
+	want := "<h2>Decisions</h2><table><tbody><tr><td>a</td></tr></tbody></table><ul><li>b</li></ul><h2>Next</h2>"
+	if put.Body.Storage.Value != want || put.Version.Number != 5 || put.Version.Message != "add b" || put.Title != "Log" {
+		t.Errorf("unexpected update %+v with body %q", put, put.Body.Storage.Value)
+	}
+
+	for _, args := range []map[string]any{
+		{"editMode": "append", "content": "<p>x</p>"},
+		{"section": "Decisions", "editMode": "insert", "content": "<p>x</p>"},
+		{"section": "Decisions", "editMode": "prepend"},
+		{"section": "Decisions", "content": "<p>x"},
+		{"section": "Elsewhere", "content": "<p>x</p>"},
+	} {
+		if result := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+func TestHandleGetContentChunked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"123","title":"Doc","body":{"storage":{"value":"<h1>A</h1><p>a</p><h1>B</h1><p>b</p>"}}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	handler := handleGetContent(client)
+	ctx := context.Background()
+
+	decode := func(result *mcp.CallToolResult) []ContentChunk {
+		var content struct {
+			Body   any            `json:"body"`
+			Chunks []ContentChunk `json:"chunks"`
+		}
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &content); err != nil {
+			t.Fatal(err)
+		}
+		if content.Body != nil {
+			t.Error("expected body to be replaced by chunks")
+		}
+		return content.Chunks
+	}
+
+	result, err := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "chunked": true}}})
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if chunks := decode(result); len(chunks) != 2 || chunks[1].Anchor != "Doc-B" {
+		t.Errorf("unexpected chunks %+v", chunks)
+	}
+
+	result, err = handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "section": "B"}}})
+	if err != nil || result.IsError {
+		t.Fatalf("handler failed: %v, %v", err, result)
+	}
+	if chunks := decode(result); len(chunks) != 1 || chunks[0].Content != "# B\n\nb" {
+		t.Errorf("unexpected section %+v", chunks)
+	}
+
+	result, _ = handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "123", "section": "Doc-C"}}})
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "available sections: Doc-A, Doc-B") {
+		t.Errorf("expected missing section error, got %v", result)
+	}
+}
+
+// TestNewServeFunc tests selecting the transport.
+func TestNewServeFunc(t *testing.T) {
+	for _, transport := range []string{"stdio", "sse"} {
+		if serve, err := newServeFunc(transport, "localhost:0", ""); err != nil || serve == nil {
+			t.Errorf("%s: unexpected error %v", transport, err)
+		}
+	}
+	if _, err := newServeFunc("websocket", "", ""); err == nil {
+		t.Error("expected error for unknown transport")
+	}
+}
+
+// TestRequireBearerToken tests the bearer token check of the network transport.
+func TestRequireBearerToken(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"disabled", "", "", http.StatusNoContent},
+		{"valid", "secret", "Bearer secret", http.StatusNoContent},
+		{"missing", "secret", "", http.StatusUnauthorized},
+		{"wrong", "secret", "Bearer other", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/sse", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			requireBearerToken(tt.token, next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+// TestServeSSE tests serving over SSE and shutting down when the context is cancelled.
+func TestServeSSE(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveSSE(ctx, setupServer(singleClientRegistry(client), ToolsConfig{}), nil, nil, addr, "secret")
+	}()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/sse", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("server did not start: %v", err)
+	}
+	line := make([]byte, 64)
+	n, _ := resp.Body.Read(line)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(line[:n]), "event: endpoint") {
+		t.Errorf("unexpected SSE response %d %q", resp.StatusCode, line[:n])
+	}
+
+	unauthorized, err := http.Get("http://" + addr + "/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = unauthorized.Body.Close()
+	if unauthorized.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", unauthorized.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected shutdown error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+// TestServeStdioDrainsCalls tests that stopping the stdio transport lets a tool call in flight finish with its context intact.
+func TestServeStdioDrainsCalls(t *testing.T) {
+	s := mcpserver.NewMCPServer("test", "1.0.0", mcpserver.WithToolCapabilities(true))
+	started, release := make(chan struct{}), make(chan struct{})
+	s.AddTool(mcp.NewTool("slow"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(started)
+		<-release
+		return mcp.NewToolResultText(fmt.Sprintf("finished, cancelled %v", ctx.Err() != nil)), nil
+	})
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveStdio(ctx, s, nil, nil, inReader, outWriter)
+	}()
+
+	_, _ = inWriter.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}` + "\n"))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool call did not start")
+	}
+
+	cancel()
+	close(release)
+	line, err := bufio.NewReader(outReader).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, "finished, cancelled false") {
+		t.Errorf("unexpected response %s", line)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected shutdown error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+// TestDrainer tests that draining rejects new calls and cancels calls that outlast the deadline.
+func TestDrainer(t *testing.T) {
+	d := newDrainer(mcpserver.NewMCPServer("test", "1.0.0"))
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	handler := d.middleware(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return mcp.NewToolResultText("cancelled"), nil
+	})
+	go func() { _, _ = handler(context.Background(), mcp.CallToolRequest{}) }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d.drain(ctx)
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the call to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call was not cancelled")
+	}
+
+	result, _ := d.middleware(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		t.Error("handler must not run while draining")
+		return nil, nil
+	})(context.Background(), mcp.CallToolRequest{})
+	if !result.IsError {
+		t.Error("expected new calls to be rejected while draining")
+	}
+}
+
+// TestLoadRegistry tests loading a single default instance and several named instances.
+func TestLoadRegistry(t *testing.T) {
+	t.Run("single instance", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_API_TOKEN", "token")
+		t.Setenv("CONFLUENCE_BASE_URL", "https://confluence.example.com")
+		registry, err := loadRegistry(&FileConfig{})
+		if err != nil {
+			t.Fatalf("loadRegistry failed: %v", err)
+		}
+		if len(registry.names) != 1 || registry.names[0] != defaultInstance {
+			t.Errorf("unexpected instances %v", registry.names)
+		}
+	})
+
+	t.Run("named instances", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_INSTANCES", "staging, prod-eu")
+		t.Setenv("CONFLUENCE_STAGING_API_TOKEN", "s")
+		t.Setenv("CONFLUENCE_STAGING_HOST", "staging.example.com")
+		t.Setenv("CONFLUENCE_PROD_EU_API_TOKEN", "p")
+		t.Setenv("CONFLUENCE_PROD_EU_BASE_URL", "https://prod.example.com")
+		t.Setenv("CONFLUENCE_PROD_EU_SPACE_PERMISSIONS_READ_ONLY", "true")
+		registry, err := loadRegistry(&FileConfig{})
+		if err != nil {
+			t.Fatalf("loadRegistry failed: %v", err)
+		}
+		if strings.Join(registry.names, ",") != "staging,prod-eu" {
+			t.Errorf("unexpected instances %v", registry.names)
+		}
+		staging := registry.clients["staging"].config
+		if staging.BaseURL != "https://staging.example.com/rest/api" || staging.Token != "s" {
+			t.Errorf("unexpected staging config %+v", staging)
+		}
+		if prod := registry.clients["prod-eu"].config; prod.Token != "p" || !prod.SpacePermissionsReadOnly {
+			t.Errorf("unexpected prod config %+v", prod)
+		}
+	})
+
+	t.Run("missing instance settings", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_INSTANCES", "staging")
+		_, err := loadRegistry(&FileConfig{})
+		if err == nil || !strings.Contains(err.Error(), "instance staging: CONFLUENCE_STAGING_API_TOKEN") {
+			t.Errorf("expected missing token error, got %v", err)
+		}
+	})
+
+	t.Run("duplicate instance", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_INSTANCES", "a,a")
+		t.Setenv("CONFLUENCE_A_API_TOKEN", "t")
+		t.Setenv("CONFLUENCE_A_HOST", "a.example.com")
+		if _, err := loadRegistry(&FileConfig{}); err == nil {
+			t.Error("expected error for duplicate instance")
+		}
+	})
+}
+
+// TestSetupServerMultipleInstances tests routing tool calls to the instance named by the instance argument.
+func TestSetupServerMultipleInstances(t *testing.T) {
+	newInstance := func(name string, readOnly bool) *ConfluenceClient {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/rest/api/search" {
+				start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+				_, _ = fmt.Fprintf(w, `{"results":[{"content":{"id":"%d","title":"%s"}}],"start":%d,"limit":1,"size":1,"totalSize":3}`, start, name, start)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"1","title":"` + name + `"}`))
+		}))
+		t.Cleanup(server.Close)
+		return NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t", SpacePermissionsReadOnly: readOnly})
+	}
+	registry := newClientRegistry()
+	registry.add("staging", newInstance("staging", false))
+	registry.add("production", newInstance("production", true))
+	s := setupServer(registry, ToolsConfig{})
+
+	tool := s.GetTool("confluence_get_content")
+	if tool == nil {
+		t.Fatal("expected confluence_get_content to be registered")
+	}
+	if _, ok := tool.Tool.InputSchema.Properties["instance"]; !ok {
+		t.Error("expected an instance argument")
+	}
+
+	call := func(toolName string, args map[string]any) string {
+		result, err := s.GetTool(toolName).Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Name: toolName, Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	if got := call("confluence_get_content", map[string]any{"contentId": "1"}); !strings.Contains(got, "staging") {
+		t.Errorf("expected default instance, got %s", got)
+	}
+	if got := call("confluence_get_content", map[string]any{"contentId": "1", "instance": "production"}); !strings.Contains(got, "production") {
+		t.Errorf("expected production instance, got %s", got)
+	}
+	if got := call("confluence_get_content", map[string]any{"contentId": "1", "instance": "dev"}); !strings.Contains(got, `unknown instance "dev"`) {
+		t.Errorf("expected unknown instance error, got %s", got)
+	}
+	if got := call("confluence_grant_space_permission", map[string]any{"instance": "production"}); !strings.Contains(got, "not available on instance production") {
+		t.Errorf("expected read-only instance error, got %s", got)
+	}
+
+	// A cursor goes on with the instance of the call that made it.
+	var page struct {
+		NextCursor string `json:"nextCursor"`
+	}
+	if err := json.Unmarshal([]byte(call("confluence_search_content", map[string]any{"cql": "type = page", "limit": float64(1), "instance": "production"})), &page); err != nil || page.NextCursor == "" {
+		t.Fatalf("expected a next cursor, got %+v, %v", page, err)
+	}
+	if got := call("confluence_search_content", map[string]any{"cursor": page.NextCursor}); !strings.Contains(got, `"id":"1"`) || !strings.Contains(got, "production") {
+		t.Errorf("expected the next page of production, got %s", got)
+	}
+}
+
+// TestLoadFileConfig tests reading the configuration file and letting environment variables override it.
+func TestLoadFileConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	content := `baseUrl: https://file.example.com
+tokenEnv: FILE_TOKEN
+timeout: 5s
+transport: sse
+listen: ":9000"
+tools:
+  exclude: ["confluence_delete_*"]
+logging:
+  level: debug
+instances:
+  - name: staging
+    baseUrl: https://staging.example.com
+    token: staging-token
+    spacePermissionsReadOnly: true
+`
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := loadFileConfig(filename)
+	if err != nil {
+		t.Fatalf("loadFileConfig failed: %v", err)
+	}
+	if file.Transport != "sse" || file.Listen != ":9000" || file.Logging.Level != "debug" || file.Timeout != 5*time.Second {
+		t.Errorf("unexpected file config %+v", file)
+	}
+
+	t.Run("file values", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_INSTANCES", "")
+		t.Setenv("CONFLUENCE_STAGING_API_TOKEN", "")
+		registry, err := loadRegistry(file)
+		if err != nil {
+			t.Fatalf("loadRegistry failed: %v", err)
+		}
+		staging := registry.clients["staging"].config
+		if staging.BaseURL != "https://staging.example.com/rest/api" || staging.Token != "staging-token" || !staging.SpacePermissionsReadOnly {
+			t.Errorf("unexpected staging config %+v", staging)
+		}
+	})
+
+	t.Run("environment overrides", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_STAGING_API_TOKEN", "env-token")
+		t.Setenv("CONFLUENCE_STAGING_TIMEOUT", "1m")
+		registry, err := loadRegistry(file)
+		if err != nil {
+			t.Fatalf("loadRegistry failed: %v", err)
+		}
+		staging := registry.clients["staging"].config
+		if staging.Token != "env-token" || staging.Timeout != time.Minute {
+			t.Errorf("unexpected staging config %+v", staging)
+		}
+	})
+
+	t.Run("token reference", func(t *testing.T) {
+		t.Setenv("FILE_TOKEN", "referenced")
+		config, err := loadConfigWithPrefix("CONFLUENCE_", file.InstanceFileConfig)
+		if err != nil {
+			t.Fatalf("loadConfigWithPrefix failed: %v", err)
+		}
+		if config.Token != "referenced" || config.BaseURL != "https://file.example.com/rest/api" || config.Timeout != 5*time.Second {
+			t.Errorf("unexpected config %+v", config)
+		}
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		for _, content := range []string{"baseUrl: [", "unknown: true", "tools:\n  include: ['[']", "instances:\n  - baseUrl: x"} {
+			if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadFileConfig(filename); err == nil {
+				t.Errorf("expected an error for %q", content)
+			}
+		}
+	})
+}
+
+// TestToolFilter tests selecting tools by name patterns.
+func TestToolFilter(t *testing.T) {
+	filter := ToolFilter{Include: []string{"confluence_get_*", "confluence_search"}, Exclude: []string{"confluence_get_content_history"}}
+	tests := map[string]bool{
+		"confluence_get_content":         true,
+		"confluence_search":              true,
+		"confluence_get_content_history": false,
+		"confluence_delete_content":      false,
+	}
+	for name, want := range tests {
+		if got := filter.allows(name); got != want {
+			t.Errorf("allows(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	s := setupServer(singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})), ToolsConfig{ToolFilter: ToolFilter{Exclude: []string{"*"}}})
+	if tools := s.ListTools(); len(tools) != 0 {
+		t.Errorf("expected no tools, got %d", len(tools))
+	}
+}
+
+// TestRunCLI tests the version command, flag overrides, and rejection of unknown commands.
+func TestRunCLI(t *testing.T) {
+	var out strings.Builder
+	if err := runCLI([]string{"version"}, &out); err != nil {
+		t.Fatalf("version failed: %v", err)
+	}
+	if out.String() != "atlassian-confluence-dc-go-mcp "+version+"\n" {
+		t.Errorf("unexpected version output %q", out.String())
+	}
+
+	if err := runCLI([]string{"frobnicate"}, &out); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected unknown command error, got %v", err)
+	}
+
+	if err := runCLI([]string{"serve", "-transport", "carrier-pigeon"}, &out); err == nil {
+		t.Error("expected an error for an unknown transport")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wiki/rest/api/user/current" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"username":"jdoe","displayName":"Jane Doe"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("CONFLUENCE_INSTANCES", "")
+	t.Setenv("CONFLUENCE_MCP_CONFIG", "")
+	t.Setenv("CONFLUENCE_API_TOKEN", "t")
+	t.Setenv("CONFLUENCE_BASE_URL", "http://unreachable.invalid")
+	t.Setenv("CONFLUENCE_TIMEOUT", "")
+	out.Reset()
+	if err := runCLI([]string{"check", "-base-url", server.URL + "/wiki", "-timeout", "5s"}, &out); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "default: "+server.URL+"/wiki/rest/api: OK, authenticated as Jane Doe (jdoe)") {
+		t.Errorf("unexpected check output %q", out.String())
+	}
+}
+
+// TestRunCheck tests that the check reports every instance and fails when one is unreachable.
+func TestRunCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"username":"jdoe","displayName":"Jane Doe"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("CONFLUENCE_INSTANCES", "a,b")
+	t.Setenv("CONFLUENCE_A_API_TOKEN", "good")
+	t.Setenv("CONFLUENCE_A_BASE_URL", server.URL)
+	t.Setenv("CONFLUENCE_B_API_TOKEN", "bad")
+	t.Setenv("CONFLUENCE_B_BASE_URL", server.URL)
+
+	var out strings.Builder
+	err := runCheck(context.Background(), &FileConfig{}, &out)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 instances failed") {
+		t.Errorf("expected one failed instance, got %v", err)
+	}
+	if !strings.Contains(out.String(), "a: ") || !strings.Contains(out.String(), "b: "+server.URL+"/rest/api: FAILED") {
+		t.Errorf("unexpected check output %q", out.String())
+	}
+}
+
+// TestHandleHealth tests the health check for a working instance and for the failures it explains.
+func TestHandleHealth(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("healthy", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/rest/api/user/current":
+				_, _ = w.Write([]byte(`{"username":"jdoe","displayName":"Jane Doe"}`))
+			case "/rest/applinks/1.0/manifest":
+				_, _ = w.Write([]byte(`{"version":"8.5.4","buildNumber":8804}`))
+			case "/rest/api/audit":
+				w.WriteHeader(http.StatusForbidden)
+			case "/rest/api/content/0/copy", "/rest/api/contentbody/convert/storage":
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		result, err := handleHealth(client)(ctx, mcp.CallToolRequest{})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected failure: %v %v", err, result)
+		}
+		var report HealthReport
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Username != "jdoe" || report.Version != "8.5.4" || report.BuildNumber != "8804" {
+			t.Errorf("unexpected report %+v", report)
+		}
+		want := map[string]bool{"audit": true, "copy": true, "pageHierarchyCopy": false, "bodyConversion": true}
+		for name, available := range want {
+			if report.Capabilities[name] != available {
+				t.Errorf("capability %s: expected %v, got %v", name, available, report.Capabilities[name])
+			}
+		}
+	})
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+	}{
+		{"rejected token", http.StatusUnauthorized, "", "rejected the API token"},
+		{"forbidden", http.StatusForbidden, "", "denied the API token access"},
+		{"wrong path", http.StatusNotFound, "", "no Confluence REST API found"},
+		{"anonymous", http.StatusOK, `{"type":"anonymous"}`, "treats the API token as anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+			result, _ := handleHealth(client)(ctx, mcp.CallToolRequest{})
+			if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, tt.message) {
+				t.Errorf("expected error containing %q, got %v", tt.message, result.Content)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+		result, _ := handleHealth(client)(ctx, mcp.CallToolRequest{})
+		if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "cannot reach Confluence") {
+			t.Errorf("expected unreachable error, got %v", result.Content)
+		}
+	})
+}
+
+// TestAuthenticators tests that the client sends its credentials as the configured scheme requires.
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ConfluenceConfig
+		want   string
+	}{
+		{"bearer", ConfluenceConfig{Token: "pat"}, "Bearer pat"},
+		{"basic", ConfluenceConfig{Username: "svc-mcp", Password: "secret"}, "Basic c3ZjLW1jcDpzZWNyZXQ="},
+		{"token as basic password", ConfluenceConfig{Token: "pat", AuthScheme: "basic"}, "Basic OnBhdA=="},
+		{"custom header", ConfluenceConfig{Token: "pat", AuthScheme: "header:X-Proxy-Token"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != tt.want {
+					t.Errorf("expected Authorization %q, got %q", tt.want, got)
+				}
+				if tt.config.AuthScheme == "header:X-Proxy-Token" && r.Header.Get("X-Proxy-Token") != "pat" {
+					t.Errorf("expected the token in X-Proxy-Token, got %q", r.Header.Get("X-Proxy-Token"))
+				}
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			config := tt.config
+			config.BaseURL = server.URL + "/rest/api"
+			if _, err := NewConfluenceClient(&config).doRequest(context.Background(), "GET", "/space", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestTokenSources tests reading the token from a file and from a command.
+func TestTokenSources(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(filename, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFLUENCE_BASE_URL", "https://confluence.example.com")
+	t.Setenv("CONFLUENCE_API_TOKEN", "")
+
+	t.Run("file", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_API_TOKEN_FILE", filename)
+		t.Setenv("CONFLUENCE_TOKEN_COMMAND", "false")
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if config.Token != "file-token" || config.TokenFile != filename || config.TokenCommand != "" {
+			t.Errorf("unexpected config %+v", config)
+		}
+	})
+
+	t.Run("command", func(t *testing.T) {
+		t.Setenv("CONFLUENCE_TOKEN_COMMAND", "go env GOOS")
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if config.Token != runtime.GOOS {
+			t.Errorf("expected the command output as token, got %q", config.Token)
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		if _, err := readToken(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+			t.Error("expected an error for a missing token file")
+		}
+		if _, err := readToken("", "go tool no-such-tool"); err == nil || !strings.Contains(err.Error(), "token command failed") {
+			t.Errorf("expected a token command error, got %v", err)
+		}
+	})
+}
+
+// TestWatchCredentials tests that a changed token file is picked up without a restart.
+func TestWatchCredentials(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(filename, []byte("old-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "old-token", TokenFile: filename})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchCredentials(ctx, singleClientRegistry(client), 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(filename, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Make the change visible on file systems with coarse modification times.
+	if err := os.Chtimes(filename, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := client.doRequest(context.Background(), "GET", "/space", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		last := seen[len(seen)-1]
+		mu.Unlock()
+		if last == "Bearer new-token" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token was not reloaded, last sent %q", last)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if changed, err := client.reloadToken(); err != nil || changed {
+		t.Errorf("expected an unchanged token, got %v %v", changed, err)
+	}
+	if changed, _ := NewConfluenceClient(&ConfluenceConfig{Token: "env"}).reloadToken(); changed {
+		t.Error("a token from the environment must not be reloaded")
+	}
+}
+
+// TestTLSOptions tests trusting a private CA and skipping certificate verification.
+func TestTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, block, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFLUENCE_API_TOKEN", "t")
+	t.Setenv("CONFLUENCE_BASE_URL", server.URL)
+	tests := []struct {
+		name       string
+		caCert     string
+		skipVerify string
+		wantErr    bool
+	}{
+		{"untrusted", "", "", true},
+		{"private CA", caCert, "", false},
+		{"skip verify", "", "true", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFLUENCE_CA_CERT", tt.caCert)
+			t.Setenv("CONFLUENCE_TLS_SKIP_VERIFY", tt.skipVerify)
+			config, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+			_, err = NewConfluenceClient(config).doRequest(context.Background(), "GET", "/space", nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("doRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), false); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	_ = os.WriteFile(empty, []byte("not a certificate"), 0o600)
+	if _, err := newTLSConfig(empty, false); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("expected an error for a file without certificates, got %v", err)
+	}
+}
+
+// TestKerberosConfig tests the validation of Kerberos settings that needs no KDC.
+func TestKerberosConfig(t *testing.T) {
+	krb5conf := filepath.Join(t.TempDir(), "krb5.conf")
+	content := "[libdefaults]\n  default_realm = EXAMPLE.COM\n[realms]\n  EXAMPLE.COM = {\n    kdc = kdc.example.com:88\n  }\n"
+	if err := os.WriteFile(krb5conf, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFLUENCE_BASE_URL", "https://confluence.example.com")
+	t.Setenv("CONFLUENCE_API_TOKEN", "")
+	t.Setenv("CONFLUENCE_AUTH_SCHEME", "kerberos")
+	t.Setenv("CONFLUENCE_KRB5_CONFIG", krb5conf)
+	t.Setenv("KRB5CCNAME", "")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		message string
+	}{
+		{"token", map[string]string{"CONFLUENCE_API_TOKEN": "t"}, "do not apply to kerberos authentication"},
+		{"no credentials", nil, "CONFLUENCE_KRB5_KEYTAB or CONFLUENCE_KRB5_CCACHE is required"},
+		{"principal without realm", map[string]string{"CONFLUENCE_KRB5_KEYTAB": "/nonexistent", "CONFLUENCE_KRB5_PRINCIPAL": "svc-mcp"}, "user@REALM"},
+		{"missing keytab", map[string]string{"CONFLUENCE_KRB5_KEYTAB": "/nonexistent", "CONFLUENCE_KRB5_PRINCIPAL": "svc-mcp@EXAMPLE.COM"}, "failed to load keytab"},
+		{"missing ccache", map[string]string{"CONFLUENCE_KRB5_CCACHE": "/nonexistent"}, "failed to load credentials cache"},
+		{"missing config", map[string]string{"CONFLUENCE_KRB5_CONFIG": "/nonexistent", "CONFLUENCE_KRB5_CCACHE": "/nonexistent"}, "failed to load Kerberos configuration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := loadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+// testSession is a minimal MCP client session.
+type testSession struct {
+	id string
+}
+
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return make(chan mcp.JSONRPCNotification, 10)
+}
+func (s *testSession) SessionID() string { return s.id }
+
+// TestSessionCredentials tests that a session's own token replaces the shared one for that session only.
+func TestSessionCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if user == "invalid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"username":"` + user + `"}`))
+	}))
+	defer server.Close()
+
+	registry := singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "shared"}))
+	s := setupServer(registry, ToolsConfig{})
+	enableSessionCredentials(s, registry)
+	newSubscriptions(s, registry, "")
+	if hooks := hooksOf(s); len(hooks.OnAfterInitialize) != 1 || len(hooks.OnUnregisterSession) != 2 {
+		t.Errorf("expected the hooks of both features, got %d and %d", len(hooks.OnAfterInitialize), len(hooks.OnUnregisterSession))
+	}
+
+	call := func(ctx context.Context, name string, args map[string]any) string {
+		params, _ := json.Marshal(map[string]any{"name": name, "arguments": args})
+		message := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":%s}`, params)
+		response, ok := s.HandleMessage(ctx, []byte(message)).(mcp.JSONRPCResponse)
+		if !ok {
+			t.Fatalf("unexpected response to %s", name)
+		}
+		return response.Result.(mcp.CallToolResult).Content[0].(mcp.TextContent).Text
+	}
+
+	alice := s.WithContext(context.Background(), &testSession{id: "alice"})
+	bob := s.WithContext(context.Background(), &testSession{id: "bob"})
+
+	if got := call(alice, "confluence_set_credentials", map[string]any{"token": "invalid"}); !strings.Contains(got, "error checking token") {
+		t.Errorf("expected the invalid token to be rejected, got %s", got)
+	}
+	if got := call(alice, "confluence_set_credentials", map[string]any{"token": "alice-pat"}); !strings.Contains(got, "act as alice-pat") {
+		t.Errorf("unexpected result %s", got)
+	}
+	if got := call(alice, "confluence_get_current_user", nil); !strings.Contains(got, `"alice-pat"`) {
+		t.Errorf("expected alice's token, got %s", got)
+	}
+	if got := call(bob, "confluence_get_current_user", nil); !strings.Contains(got, `"shared"`) {
+		t.Errorf("expected the shared token for another session, got %s", got)
+	}
+
+	call(alice, "confluence_set_credentials", map[string]any{"token": ""})
+	if got := call(alice, "confluence_get_current_user", nil); !strings.Contains(got, `"shared"`) {
+		t.Errorf("expected the shared token after clearing, got %s", got)
+	}
+
+	store := &sessionCredentials{tokens: map[string]map[string]string{}}
+	req := mcp.CallToolRequest{Header: http.Header{sessionTokenHeader: {"header-pat"}}}
+	_, _ = store.middleware(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if got := sessionToken(ctx, defaultInstance); got != "header-pat" {
+			t.Errorf("expected the header token, got %q", got)
+		}
+		return nil, nil
+	})(bob, req)
+}
+
+// TestImpersonation tests that tool calls tell Confluence which user they act for.
+func TestImpersonation(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Remote-User")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t", ImpersonationHeader: "X-Remote-User", ImpersonateUser: "svc-default", ImpersonationAllowedUsers: []string{"jdoe"}})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	tool := s.GetTool("confluence_get_current_user")
+	if _, ok := tool.Tool.InputSchema.Properties["impersonateUser"]; !ok {
+		t.Fatal("expected an impersonateUser argument")
+	}
+
+	sessionCtx := context.WithValue(context.Background(), sessionTokensKey{}, map[string]string{"": "session-pat"})
+	for _, tt := range []struct {
+		ctx        context.Context
+		user, want string
+		wantErr    bool
+	}{
+		{context.Background(), "JDoe", "JDoe", false},
+		{context.Background(), "", "svc-default", false},
+		{context.Background(), "admin", "", true},
+		{sessionCtx, "jdoe", "", false},
+		{sessionCtx, "", "", false},
+	} {
+		got = "unset"
+		args := map[string]any{}
+		if tt.user != "" {
+			args["impersonateUser"] = tt.user
+		}
+		result, err := tool.Handler(tt.ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.wantErr {
+			if !result.IsError || got != "unset" {
+				t.Errorf("expected acting for %q to be refused, got a request for %q", tt.user, got)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected to act for %q, got %q", tt.want, got)
+		}
+	}
+
+	// Without allowed users, calls cannot name a user but still act for the configured one.
+	fixed := setupServer(singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t", ImpersonationHeader: "X-Remote-User", ImpersonateUser: "svc-default"})), ToolsConfig{})
+	if _, ok := fixed.GetTool("confluence_get_current_user").Tool.InputSchema.Properties["impersonateUser"]; ok {
+		t.Error("expected no impersonateUser argument without allowed users")
+	}
+	plain := setupServer(singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL, Token: "t"})), ToolsConfig{})
+	if _, ok := plain.GetTool("confluence_get_current_user").Tool.InputSchema.Properties["impersonateUser"]; ok {
+		t.Error("expected no impersonateUser argument without an impersonation header")
+	}
+
+	t.Setenv("CONFLUENCE_API_TOKEN", "t")
+	t.Setenv("CONFLUENCE_BASE_URL", "https://confluence.example.com")
+	t.Setenv("CONFLUENCE_IMPERSONATE_USER", "jdoe")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "CONFLUENCE_IMPERSONATION_HEADER is required") {
+		t.Errorf("expected a missing header error, got %v", err)
+	}
+	t.Setenv("CONFLUENCE_IMPERSONATION_HEADER", "X-Remote-User")
+	t.Setenv("CONFLUENCE_IMPERSONATION_ALLOWED_USERS", "jdoe, asmith")
+	if config, err := loadConfig(); err != nil || !slices.Equal(config.ImpersonationAllowedUsers, []string{"jdoe", "asmith"}) {
+		t.Errorf("expected the allowed users, got %v", err)
+	}
+}
+
+// TestConditionalCache tests that cached responses are revalidated and served on 304 Not Modified,
+// separately for each user.
+func TestConditionalCache(t *testing.T) {
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := `"v1-` + r.Header.Get("Authorization") + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(`{"id":"1","title":"` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer server.Close()
+
+	config := &ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "alice", HTTPCacheBytes: 1 << 20}
+	alice := NewConfluenceClient(config)
+	for range 3 {
+		var page ConfluencePage
+		if err := alice.getJSON(context.Background(), "/content/1", nil, &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Title != "Bearer alice" {
+			t.Fatalf("unexpected title %q", page.Title)
+		}
+	}
+	if requests != 3 || notModified != 2 {
+		t.Errorf("expected 3 requests with 2 revalidated, got %d and %d", requests, notModified)
+	}
+
+	bob := NewConfluenceClient(&ConfluenceConfig{BaseURL: config.BaseURL, Token: "bob", HTTPCacheBytes: 1 << 20})
+	bob.httpClient.Transport = alice.httpClient.Transport
+	var page ConfluencePage
+	if err := bob.getJSON(context.Background(), "/content/1", nil, &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Bearer bob" || notModified != 2 {
+		t.Errorf("expected bob's own response, got %q", page.Title)
+	}
+
+	cache := alice.httpClient.Transport.(*conditionalCache)
+	cache.maxBytes = int64(len(`{"id":"1","title":"Bearer bob"}`))
+	cache.put(&cachedResponse{key: "other", body: []byte("x")})
+	if len(cache.entries) != 1 || cache.size > cache.maxBytes {
+		t.Errorf("expected eviction down to one entry, got %d entries of %d bytes", len(cache.entries), cache.size)
+	}
+}
+
+// TestReferenceCache tests that lookups of reference data are answered from memory until a change
+// or a clear, while other requests always reach Confluence.
+func TestReferenceCache(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		_, _ = w.Write([]byte(`{"key":"DOC"}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t", CacheTTL: time.Minute})
+	ctx := context.Background()
+	for range 2 {
+		for _, path := range []string{"/space/DOC", "/template/page", "/content/1"} {
+			if _, err := client.doRequest(ctx, "GET", path, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if requests["GET /rest/api/space/DOC"] != 1 || requests["GET /rest/api/template/page"] != 1 || requests["GET /rest/api/content/1"] != 2 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	if _, err := client.doRequest(ctx, "PUT", "/space/DOC", nil, map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/space/DOC", "/template/page"} {
+		if _, err := client.doRequest(ctx, "GET", path, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests["GET /rest/api/space/DOC"] != 2 {
+		t.Errorf("expected the space to be read again after a change, got %d reads", requests["GET /rest/api/space/DOC"])
+	}
+
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	result, err := s.GetTool("confluence_cache_clear").Handler(ctx, mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; text != "Cleared 2 cached entries" {
+		t.Errorf("unexpected result %q", text)
+	}
+
+	for _, tt := range []struct {
+		path string
+		cql  string
+		want bool
+	}{
+		{"/search", "type=space", true},
+		{"/search", "type = user AND user.fullname ~ \"a\"", true},
+		{"/search", "type=page", false},
+		{"/space/DOC/content/page", "", false},
+		{"/user/watch/content/1", "", false},
+		{"/user/current", "", false},
+		{"/user", "", true},
+	} {
+		if got := isReferenceLookup(tt.path, url.Values{"cql": {tt.cql}}); got != tt.want {
+			t.Errorf("isReferenceLookup(%q, %q) = %v, want %v", tt.path, tt.cql, got, tt.want)
+		}
+	}
+}
+
+// TestGzipResponses tests that responses are requested with gzip and decompressed, also when they
+// come from the conditional cache.
+func TestGzipResponses(t *testing.T) {
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", `"v1"`)
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte(`{"id":"1","title":"Compressed"}`))
+		_ = zw.Close()
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t", HTTPCacheBytes: 1 << 20})
+	for range 2 {
+		var page ConfluencePage
+		if err := client.getJSON(context.Background(), "/content/1", nil, &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Title != "Compressed" {
+			t.Errorf("unexpected title %q", page.Title)
+		}
+	}
+	if acceptEncoding != "gzip" {
+		t.Errorf("expected Accept-Encoding gzip, got %q", acceptEncoding)
+	}
+
+	empty := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: io.NopCloser(strings.NewReader(""))}
+	if err := decompressResponse(empty); err != nil {
+		t.Errorf("expected an empty body to be accepted, got %v", err)
+	}
+	broken := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: io.NopCloser(strings.NewReader("not gzip"))}
+	if err := decompressResponse(broken); err == nil {
+		t.Error("expected an error for a body that is not gzip")
+	}
+}
+
+// TestGetMany tests that a bulk fetch runs concurrently and reports each ID's result or error in order.
+func TestGetMany(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/rest/api/content/")
+		if id == "404" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No content found with id 404"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"` + id + `"}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	ids := []any{"404"}
+	for i := range 20 {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	ids = append(ids, "1")
+	result, err := handleGetMany(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentIds": ids}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Results []FetchResult `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Results) != 21 {
+		t.Fatalf("expected 21 results, got %d", len(out.Results))
+	}
+	if out.Results[0].ID != "404" || !strings.Contains(out.Results[0].Error, "status 404") {
+		t.Errorf("expected an error for the missing content, got %+v", out.Results[0])
+	}
+	if out.Results[5].ID != "4" || string(out.Results[5].Content) != `{"id":"4"}` {
+		t.Errorf("unexpected result %+v", out.Results[5])
+	}
+	if peak < 2 || peak > bulkFetchWorkers {
+		t.Errorf("expected between 2 and %d concurrent requests, got %d", bulkFetchWorkers, peak)
+	}
+
+	result, _ = handleGetMany(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentIds": []any{"../space"}}}})
+	if !result.IsError {
+		t.Error("expected an error for an unsafe content ID")
+	}
+}
+
+// TestTruncation tests that read tool results are cut into parts that can be continued, and that
+// write tools are left alone.
+func TestTruncation(t *testing.T) {
+	body := strings.Repeat("line of text\n", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{MaxResultBytes: 500})
+	tool := s.GetTool("confluence_get_content")
+	if _, ok := tool.Tool.InputSchema.Properties["offset"]; !ok {
+		t.Fatal("expected an offset argument on a read tool")
+	}
+	if _, ok := s.GetTool("confluence_update_content").Tool.InputSchema.Properties["offset"]; ok {
+		t.Error("expected no offset argument on a write tool")
+	}
+
+	var parts []string
+	offset := 0
+	for len(parts) < 10 {
+		args := map[string]any{"contentId": "1", "offset": float64(offset), "maxTokens": float64(100)}
+		result, err := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsError {
+			t.Fatalf("unexpected error %v", result.Content)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		note := result.Content[1].(mcp.TextContent).Text
+		if len(text) > 400 || !strings.HasSuffix(text, "\n") {
+			t.Errorf("expected a part of at most 400 bytes ending at a line break, got %d bytes", len(text))
+		}
+		parts = append(parts, text)
+		if strings.HasPrefix(note, "[End of result") {
+			break
+		}
+		if _, err := fmt.Sscanf(note[strings.Index(note, "offset "):], "offset %d", &offset); err != nil {
+			t.Fatalf("no offset in note %q: %v", note, err)
+		}
+	}
+	if strings.Join(parts, "") != body {
+		t.Error("expected the parts to add up to the whole result")
+	}
+
+	result, _ := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"contentId": "1", "offset": float64(len(body))}}})
+	if !result.IsError {
+		t.Error("expected an error for an offset beyond the end")
+	}
+
+	if start, end := cutText("aé", 2, 1); start != 3 || end != 3 {
+		t.Errorf("expected the offset to move past the middle of a character, got %d-%d", start, end)
+	}
+}
+
+// TestAPIErrorParsing tests that Confluence's error envelope is turned into a readable message and a code.
+func TestAPIErrorParsing(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+		code   string
+	}{
+		{"not found", 404, `{"statusCode":404,"data":{"errors":[]},"message":"No content found with id: 123","reason":"Not Found"}`,
+			"API error (status 404): No content found with id: 123 (code: not_found)", "not_found"},
+		{"version conflict", 409, `{"statusCode":409,"message":"Version must be incremented on update. Current version is: 5","reason":"Conflict"}`,
+			"API error (status 409): Version must be incremented on update. Current version is: 5 (code: version_conflict)", "version_conflict"},
+		{"validation", 400, `{"statusCode":400,"message":"Could not create content","data":{"errors":[{"message":{"key":"title.exists","translation":"A page with this title already exists"}},{"fieldName":"space","message":"Space is required"}]}}`,
+			"API error (status 400): Could not create content; A page with this title already exists; space: Space is required (code: validation_failed)", "validation_failed"},
+		{"reason only", 403, `{"statusCode":403,"reason":"Forbidden"}`, "API error (status 403): Forbidden (code: forbidden)", "forbidden"},
+		{"not JSON", 502, `<html>Bad Gateway</html>`, "API error (status 502): <html>Bad Gateway</html> (code: server_error)", "server_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := newAPIError(tt.status, []byte(tt.body))
+			if got := apiErr.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+			if got := apiErr.Code(); got != tt.code {
+				t.Errorf("Code() = %q, want %q", got, tt.code)
+			}
+			if apiErr.Body != tt.body {
+				t.Error("expected the raw body to be kept")
+			}
+		})
+	}
+
+	long := newAPIError(500, []byte(strings.Repeat("x", 5000)))
+	if len(long.Error()) > maxErrorMessageBytes+100 {
+		t.Errorf("expected a long body to be cut, got %d bytes", len(long.Error()))
+	}
+}
+
+// TestUpdateContentVersionConflict tests that an update is retried on top of a version saved by
+// someone else, and that failIfChanged stops it when the body changed.
+func TestUpdateContentVersionConflict(t *testing.T) {
+	var mu sync.Mutex
+	version, body := 1, "<p>original</p>"
+	var puts []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodGet {
+			_, _ = fmt.Fprintf(w, `{"id":"1","type":"page","title":"T","version":{"number":%d},"body":{"storage":{"value":%q,"representation":"storage"}}}`, version, body)
+			// Someone else saves the page right after the read, the first two times.
+			if version < 3 {
+				version++
+				body = fmt.Sprintf("<p>edit %d</p>", version)
+			}
+			return
+		}
+		var payload ConfluencePage
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		puts = append(puts, payload.Version.Number)
+		if payload.Version.Number != version+1 {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = fmt.Fprintf(w, `{"statusCode":409,"message":"Version must be incremented on update. Current version is: %d","reason":"Conflict"}`, version)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) *mcp.CallToolResult {
+		args["contentId"] = "1"
+		args["content"] = "<p>mine</p>"
+		result, err := handleUpdateContent(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := call(map[string]any{}); result.IsError {
+		t.Fatalf("expected the update to succeed after retries, got %v", result.Content)
+	}
+	if !slices.Equal(puts, []int{2, 3, 4}) {
+		t.Errorf("expected updates to versions 2, 3, and 4, got %v", puts)
+	}
+
+	version, body, puts = 1, "<p>original</p>", nil
+	result := call(map[string]any{"failIfChanged": true})
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "changed by someone else") {
+		t.Errorf("expected a changed content error, got %v", result.Content)
+	}
+
+	version, body, puts = 1, "<p>original</p>", nil
+	result = call(map[string]any{"maxRetries": float64(0)})
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "code: version_conflict") || len(puts) != 1 {
+		t.Errorf("expected a version conflict without retries, got %v after %v", result.Content, puts)
+	}
+}
+
+func TestUpdateContentEditMode(t *testing.T) {
+	version, body := 1, "<p>a</p>"
+	var puts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = fmt.Fprintf(w, `{"id":"1","type":"page","title":"T","version":{"number":%d},"body":{"storage":{"value":%q,"representation":"storage"}}}`, version, body)
+			return
+		}
+		var payload ConfluencePage
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		puts = append(puts, payload.Body.Storage.Value)
+		if len(puts) == 1 {
+			// Someone else adds a paragraph before the first update arrives.
+			version, body = 2, "<p>a</p><p>b</p>"
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"statusCode":409,"message":"Version must be incremented on update. Current version is: 2","reason":"Conflict"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		args["contentId"] = "1"
+		result, err := handleUpdateContent(client)(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := call(map[string]any{"editMode": "append", "content": "<p>c</p>"}); result.IsError {
+		t.Fatalf("unexpected error %v", result.Content)
+	}
+	if !slices.Equal(puts, []string{"<p>a</p><p>c</p>", "<p>a</p><p>b</p><p>c</p>"}) {
+		t.Errorf("expected the content appended to the newest body, got %q", puts)
+	}
+	if result := call(map[string]any{"editMode": "prepend", "content": "# Top", "contentFormat": "markdown"}); result.IsError || puts[2] != "<h1>Top</h1><p>a</p><p>b</p>" {
+		t.Errorf("expected the content prepended, got %v and %q", result.Content, puts[2])
+	}
+	for _, args := range []map[string]any{{"editMode": "append"}, {"editMode": "merge", "content": "<p>x</p>"}} {
+		if result := call(args); !result.IsError {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+// TestCorrelationIDs tests that a tool call sends one correlation ID with its requests and logs
+// them at debug level without secrets.
+func TestCorrelationIDs(t *testing.T) {
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get(requestIDHeader))
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+
+	var logs strings.Builder
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactAttr})))
+	defer slog.SetDefault(previous)
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "secret-token"})
+	handler := correlate(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		for range 2 {
+			if _, err := client.doRequest(ctx, "GET", "/content/1", url.Values{"os_authToken": {"hunter2"}}, nil); err != nil {
+				return nil, err
+			}
+		}
+		slog.InfoContext(ctx, "check", "token", "secret-token")
+		return mcp.NewToolResultText("ok"), nil
+	})
+	if _, err := handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "confluence_get_content"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("expected both requests to carry the same correlation ID, got %q", ids)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "requestId="+ids[0]) || !strings.Contains(out, "status=200") || !strings.Contains(out, "tool=confluence_get_content") {
+		t.Errorf("expected the requests and the call to be logged, got:\n%s", out)
+	}
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "secret-token") {
+		t.Errorf("expected secrets to be masked, got:\n%s", out)
+	}
+}
+
+// TestResourceSubscriptions tests subscribing to content over stdio and being notified when it
+// changes, by polling or by a signed webhook.
+func TestResourceSubscriptions(t *testing.T) {
+	var mu sync.Mutex
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"id":"42","title":"Runbook","version":{"number":%d}}`, version)
+	}))
+	defer server.Close()
+
+	registry := singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"}))
+	s := setupServer(registry, ToolsConfig{})
+	sub := newSubscriptions(s, registry, "hook-secret")
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = serveStdio(ctx, s, sub, nil, inReader, outWriter)
+	}()
+	out := bufio.NewReader(outReader)
+	send := func(message string) {
+		if _, err := inWriter.Write([]byte(message + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() map[string]any {
+		lines := make(chan string, 1)
+		go func() {
+			line, _ := out.ReadString('\n')
+			lines <- line
+		}()
+		select {
+		case line := <-lines:
+			var message map[string]any
+			if err := json.Unmarshal([]byte(line), &message); err != nil {
+				t.Fatalf("invalid message %q: %v", line, err)
+			}
+			return message
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+			return nil
+		}
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	initialized := receive()
+	capabilities := initialized["result"].(map[string]any)["capabilities"].(map[string]any)
+	if subscribe, _ := capabilities["resources"].(map[string]any)["subscribe"].(bool); !subscribe {
+		t.Errorf("expected the subscribe capability, got %v", capabilities)
+	}
+	send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	uri := "confluence://default/content/42"
+	send(`{"jsonrpc":"2.0","id":"sub-1","method":"resources/subscribe","params":{"uri":"` + uri + `"}}`)
+	if response := receive(); response["id"] != "sub-1" || response["error"] != nil {
+		t.Fatalf("unexpected subscribe response %v", response)
+	}
+	send(`{"jsonrpc":"2.0","id":2,"method":"resources/subscribe","params":{"uri":"confluence://other/content/42"}}`)
+	if response := receive(); response["error"] == nil {
+		t.Errorf("expected an error for an unknown instance, got %v", response)
+	}
+
+	// Wait for the version at the time of subscribing to be recorded.
+	for i := 0; ; i++ {
+		sub.mu.Lock()
+		_, known := sub.versions[uri]
+		sub.mu.Unlock()
+		if known {
+			break
+		}
+		if i == 100 {
+			t.Fatal("version was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	sub.check(ctx, uri)
+	notification := receive()
+	if notification["method"] != "notifications/resources/updated" || notification["params"].(map[string]any)["uri"] != uri {
+		t.Errorf("unexpected notification %v", notification)
+	}
+
+	body := `{"event":"page_updated","page":{"id":42}}`
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write([]byte(body))
+	for _, tt := range []struct {
+		signature string
+		want      int
+	}{
+		{"sha256=bad", http.StatusUnauthorized},
+		{"sha256=" + hex.EncodeToString(mac.Sum(nil)), http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", tt.signature)
+		rec := httptest.NewRecorder()
+		mu.Lock()
+		version = 3
+		mu.Unlock()
+		sub.handleWebhook(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("expected status %d for signature %q, got %d", tt.want, tt.signature, rec.Code)
+		}
+	}
+	if notification := receive(); notification["method"] != "notifications/resources/updated" {
+		t.Errorf("expected a notification after the webhook, got %v", notification)
+	}
+
+	send(`{"jsonrpc":"2.0","id":3,"method":"resources/read","params":{"uri":"` + uri + `"}}`)
+	read := receive()
+	contents := read["result"].(map[string]any)["contents"].([]any)
+	if !strings.Contains(contents[0].(map[string]any)["text"].(string), `"title":"Runbook"`) {
+		t.Errorf("unexpected resource contents %v", contents)
+	}
+
+	send(`{"jsonrpc":"2.0","id":4,"method":"resources/unsubscribe","params":{"uri":"` + uri + `"}}`)
+	receive()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.sessions) != 0 {
+		t.Errorf("expected no subscriptions left, got %v", sub.sessions)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/content/1":
+			_, _ = w.Write([]byte(`{"id":"1","type":"page","status":"current","title":"Home","space":{"key":"DOC"},
+				"version":{"number":4,"when":"2026-01-02T03:04:05Z"},"body":{"storage":{"value":"<p>Hello &amp; welcome</p>"}},
+				"_links":{"webui":"/display/DOC/Home"}}`))
+		case "/rest/api/search":
+			_, _ = w.Write([]byte(`{"results":[{"content":{"id":"2","type":"page","title":"Notes","_links":{"webui":"/x/2"}},
+				"title":"@@@hl@@@Notes@@@endhl@@@","excerpt":"some  notes","url":"/x/2","lastModified":"2026-02-03T00:00:00Z"}],
+				"start":0,"limit":1,"size":1,"totalSize":3}`))
+		case "/rest/api/space":
+			_, _ = w.Write([]byte(`{"results":[{"key":"DOC"}],"size":1}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	call := func(name string, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		tool := s.GetTool(name)
+		if tool.Tool.OutputSchema.Type != "object" && tool.Tool.RawOutputSchema == nil {
+			t.Errorf("expected an output schema on %s", name)
+		}
+		result, err := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error %v %v", err, result.Content)
+		}
+		return result
+	}
+
+	if _, ok := s.GetTool("confluence_get_content").Tool.OutputSchema.Properties["url"]; !ok {
+		t.Error("expected the content schema to describe url")
+	}
+	content, ok := call("confluence_get_content", map[string]any{"contentId": "1"}).StructuredContent.(ContentOutput)
+	want := ContentOutput{ID: "1", Type: "page", Status: "current", Title: "Home", Space: "DOC", Version: 4,
+		URL: server.URL + "/display/DOC/Home", Excerpt: "Hello & welcome", LastModified: "2026-01-02T03:04:05Z"}
+	if !ok || content != want {
+		t.Errorf("expected %+v, got %+v", want, content)
+	}
+
+	list, ok := call("confluence_search_content", map[string]any{"cql": "type=page"}).StructuredContent.(ContentListOutput)
+	if !ok || len(list.Results) != 1 || !list.HasMore || list.TotalSize != 3 {
+		t.Fatalf("unexpected list %+v", list)
+	}
+	if hit := list.Results[0]; hit.Title != "Notes" || hit.Excerpt != "some notes" || hit.URL != server.URL+"/x/2" || hit.LastModified == "" {
+		t.Errorf("unexpected search result %+v", hit)
+	}
+
+	spaces, ok := call("confluence_list_spaces", map[string]any{}).StructuredContent.(objectOutput)
+	if !ok || spaces["size"] != float64(1) {
+		t.Errorf("expected the JSON result as an object, got %#v", spaces)
+	}
+}
+
+func TestToolAnnotations(t *testing.T) {
+	s := setupServer(singleClientRegistry(NewConfluenceClient(&ConfluenceConfig{BaseURL: "http://localhost", Token: "t"})), ToolsConfig{})
+	removing := regexp.MustCompile(`_(?:delete|remove|revoke|unwatch|unlike)_`)
+	for name, tool := range s.ListTools() {
+		hints := tool.Tool.Annotations
+		if hints.ReadOnlyHint == nil || hints.DestructiveHint == nil || hints.IdempotentHint == nil {
+			t.Errorf("expected %s to have all hints", name)
+			continue
+		}
+		if readToolPattern.MatchString(name) && (!*hints.ReadOnlyHint || *hints.DestructiveHint) {
+			t.Errorf("expected %s to be read-only", name)
+		}
+		if removing.MatchString(name) && (*hints.ReadOnlyHint || !*hints.DestructiveHint) {
+			t.Errorf("expected %s to be destructive", name)
+		}
+		if strings.Contains(name, "_create_") && (*hints.DestructiveHint || *hints.IdempotentHint) {
+			t.Errorf("expected %s to be additive", name)
+		}
+	}
+	if hints := s.GetTool("confluence_update_content").Tool.Annotations; !*hints.DestructiveHint || *hints.IdempotentHint {
+		t.Error("expected updates to be destructive and not idempotent")
+	}
+}
+
+func TestProgressNotifications(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"1","title":"Page"}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+
+	var mu sync.Mutex
+	var sent []map[string]any
+	reporter := &progressReporter{send: func(params map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, params)
+	}}
+	ctx := context.WithValue(context.Background(), progressKey{}, reporter)
+
+	client.getMany(ctx, []string{"1", "2", "3"}, nil)
+	if len(sent) != 3 {
+		t.Fatalf("expected a notification per fetch, got %d", len(sent))
+	}
+	for i, params := range sent {
+		if params["progress"] != float64(i+1) || params["total"] != float64(3) {
+			t.Errorf("unexpected notification %v", params)
+		}
+	}
+
+	reportProgress(ctx, 1, 10, "again")
+	if last := sent[len(sent)-1]; last["progress"] != float64(4) || last["total"] != nil {
+		t.Errorf("expected progress to keep growing, got %v", last)
+	}
+
+	// Without a reporter, nothing is sent.
+	reportStep(context.Background(), "ignored")
+	reportWhileWaiting(context.Background(), "ignored")()
+	if len(sent) != 4 {
+		t.Errorf("expected no more notifications, got %d", len(sent))
+	}
+}
+
+// loggingSession is a test session that keeps its notifications and MCP log level.
+type loggingSession struct {
+	testSession
+	notifications chan mcp.JSONRPCNotification
+	level         mcp.LoggingLevel
+}
+
+func (s *loggingSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return s.notifications }
+func (s *loggingSession) SetLogLevel(level mcp.LoggingLevel)                  { s.level = level }
+func (s *loggingSession) GetLogLevel() mcp.LoggingLevel                       { return s.level }
+
+func TestClientLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	var logs strings.Builder
+	previous := slog.Default()
+	slog.SetDefault(slog.New(&clientLogHandler{next: slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelError})}))
+	defer slog.SetDefault(previous)
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	if err := enableClientLogging(s, LoggingConfig{ClientLevel: "verbose"}); err == nil {
+		t.Error("expected an invalid client log level to be rejected")
+	}
+	if err := enableClientLogging(s, LoggingConfig{ClientLevel: "warning"}); err != nil {
+		t.Fatal(err)
+	}
+	session := &loggingSession{testSession: testSession{id: "logging"}, notifications: make(chan mcp.JSONRPCNotification, 10)}
+	if err := s.RegisterSession(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	if session.level != mcp.LoggingLevelWarning {
+		t.Fatalf("expected the configured level for a new session, got %q", session.level)
+	}
+
+	call := func() {
+		t.Helper()
+		ctx := s.WithContext(context.Background(), session)
+		s.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"confluence_get_content","arguments":{"contentId":"1"}}}`))
+	}
+	call()
+	select {
+	case notification := <-session.notifications:
+		params := notification.Params.AdditionalFields
+		data, _ := params["data"].(map[string]any)
+		if notification.Method != "notifications/message" || params["level"] != mcp.LoggingLevelWarning ||
+			data["message"] != "rate limited by Confluence" || data["retryAfter"] != "30" {
+			t.Errorf("unexpected log message %+v", notification)
+		}
+	default:
+		t.Fatal("expected a log message for the rate limit")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected the server log to keep its own level, got %q", logs.String())
+	}
+
+	session.SetLogLevel(mcp.LoggingLevelError)
+	call()
+	if len(session.notifications) != 0 {
+		t.Errorf("expected no log messages below the session's level, got %d", len(session.notifications))
+	}
+}
+
+func TestCompactOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"1","title":"Page","version":{"number":12345678901234567890,"by":{"username":"jdoe","profilePicture":{"path":"/a.png"}},"_expandable":{"content":"/rest/api/content/1"}},` +
+			`"body":{"storage":{"value":"<p>A & B</p>","_expandable":{"content":"/rest/api/content/1"}}},"ancestors":[{"id":"0","_links":{"self":"x"}}],"extensions":null,` +
+			`"_links":{"self":"http://example.com/rest/api/content/1","webui":"/pages/viewpage.action?pageId=1"},"_expandable":{"children":""}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	call := func(config ToolsConfig, args map[string]any) string {
+		t.Helper()
+		tool := setupServer(singleClientRegistry(client), config).GetTool("confluence_get_content")
+		args["contentId"] = "1"
+		args["outputFormat"] = "storage"
+		result, err := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error %v %v", err, result.Content)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	want := `{"ancestors":[{"id":"0"}],"body":{"storage":{"value":"<p>A & B</p>"}},"id":"1","title":"Page","version":{"by":{"username":"jdoe"},"number":12345678901234567890}}`
+	if got := call(ToolsConfig{}, map[string]any{"compact": true}); got != want {
+		t.Errorf("unexpected compact result:\n got %s\nwant %s", got, want)
+	}
+	if got := call(ToolsConfig{Compact: true}, map[string]any{}); got != want {
+		t.Errorf("expected compact results by default when enabled, got %s", got)
+	}
+	if got := call(ToolsConfig{Compact: true}, map[string]any{"compact": false}); !strings.Contains(got, `"_links"`) {
+		t.Errorf("expected a call to turn compaction off, got %s", got)
+	}
+	if got := call(ToolsConfig{}, map[string]any{}); !strings.Contains(got, `"_expandable"`) {
+		t.Errorf("expected full results by default, got %s", got)
+	}
+
+	search := setupServer(singleClientRegistry(client), ToolsConfig{}).GetTool("confluence_search_content")
+	if description := search.Tool.InputSchema.Properties["compact"].(map[string]any)["description"]; !strings.Contains(description.(string), "rank") {
+		t.Errorf("expected the search tool to keep its own compact argument, got %q", description)
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/api/search" {
+			_, _ = w.Write([]byte(`{"results":[{"content":{"id":"1","title":"One"},"excerpt":"a"},{"content":{"id":"2","title":"Two"},"excerpt":"b"}],` +
+				`"start":0,"limit":2,"size":2,"totalSize":3}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"1","title":"Page","version":{"number":3,"when":"2024-01-01"},"body":{"storage":{"value":"<p>x</p>","representation":"storage"}},` +
+			`"labels":["a","b"],"_links":{"webui":"/x"}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	call := func(name string, args map[string]any) string {
+		t.Helper()
+		result, err := s.GetTool(name).Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error %v %v", err, result.Content)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	cursor, err := encodeCursor(pageCursor{Tool: "confluence_search_content", Args: map[string]any{"cql": "type = page", "fields": "results.content.id,totalSize", "limit": float64(2), "start": float64(2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tool string
+		args map[string]any
+		want string
+	}{
+		{"nested fields", "confluence_get_content", map[string]any{"contentId": "1", "outputFormat": "storage", "fields": "id, title,version.number,body.storage.value"},
+			`{"body":{"storage":{"value":"<p>x</p>"}},"id":"1","title":"Page","version":{"number":3}}`},
+		{"missing and scalar paths", "confluence_get_content", map[string]any{"contentId": "1", "outputFormat": "storage", "fields": "id,missing,title.length,labels.name"},
+			`{"id":"1","labels":["a","b"]}`},
+		{"fields of list items", "confluence_search_content", map[string]any{"cql": "type = page", "limit": float64(2), "fields": "results.content.id,totalSize"},
+			`{"nextCursor":"` + cursor + `","results":[{"content":{"id":"1"}},{"content":{"id":"2"}}],"totalSize":3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(tt.tool, tt.args); got != tt.want {
+				t.Errorf("unexpected result:\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := call("confluence_get_content", map[string]any{"contentId": "1", "outputFormat": "storage", "fields": " , "}); !strings.Contains(got, `"_links"`) {
+		t.Errorf("expected every field without paths, got %s", got)
+	}
+}
+
+func TestWebURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/api/search" {
+			_, _ = w.Write([]byte(`{"results":[{"content":{"id":"1","type":"page","title":"One"},"url":"/display/DOC/One"},` +
+				`{"content":{"id":"2","type":"comment","_links":{"tinyui":"/x/Ag"}}},{"space":{"key":"DOC","type":"global","_links":{"webui":"/display/DOC"}}}],"size":3}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"3","type":"blogpost","title":"News","_links":{"webui":"/display/DOC/2024/01/01/News","tinyui":"/x/Aw"}}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	call := func(name string, args map[string]any) map[string]any {
+		t.Helper()
+		result, err := s.GetTool(name).Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error %v %v", err, result.Content)
+		}
+		var value map[string]any
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	content := call("confluence_get_content", map[string]any{"contentId": "3", "compact": true})
+	if content["webUrl"] != server.URL+"/display/DOC/2024/01/01/News" {
+		t.Errorf("expected the web UI link of the content, got %v", content["webUrl"])
+	}
+
+	results := call("confluence_search_content", map[string]any{"cql": "text ~ x"})["results"].([]any)
+	var links []any
+	for _, item := range results {
+		for _, entity := range item.(map[string]any) {
+			if entity, ok := entity.(map[string]any); ok {
+				links = append(links, entity["webUrl"])
+			}
+		}
+	}
+	want := []any{server.URL + "/pages/viewpage.action?pageId=1", server.URL + "/x/Ag", nil}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		t.Errorf("expected links %v, got %v", want, links)
+	}
+}
+
+func TestSummaryResultFormat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"results":[{"content":{"id":"1","type":"page","title":"Plan","_links":{"webui":"/display/DOC/Plan"}},` +
+			`"title":"@@@hl@@@Plan@@@endhl@@@","excerpt":"The  plan\nfor 2024","url":"/display/DOC/Plan","resultGlobalContainer":{"title":"Docs","displayUrl":"/display/DOC"},` +
+			`"lastModified":"2024-01-02T03:04:05.000Z"}],"start":0,"limit":1,"size":1,"totalSize":2}`))
+	}))
+	defer server.Close()
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	if _, ok := s.GetTool("confluence_get_content").Tool.InputSchema.Properties["resultFormat"]; ok {
+		t.Error("expected no resultFormat argument on a tool that returns one page")
+	}
+
+	tool := s.GetTool("confluence_search_content")
+	result, err := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{
+		"cql": "text ~ plan", "limit": float64(1), "resultFormat": "summary",
+	}}})
+	if err != nil || result.IsError {
+		t.Fatalf("unexpected error %v %v", err, result.Content)
+	}
+	want := `[{"id":"1","title":"Plan","type":"page","space":"DOC","url":"` + server.URL + `/display/DOC/Plan","lastModified":"2024-01-02T03:04:05.000Z","excerpt":"The plan for 2024"}]`
+	if got := result.Content[0].(mcp.TextContent).Text; got != want {
+		t.Errorf("unexpected summary:\n got %s\nwant %s", got, want)
+	}
+	cursor := result.StructuredContent.(ContentListOutput).NextCursor
+	if len(result.Content) != 2 || !strings.Contains(result.Content[1].(mcp.TextContent).Text, "cursor "+cursor) || cursor == "" {
+		t.Errorf("expected a note with the next cursor, got %v", result.Content)
+	}
+
+	result, _ = tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cql": "text ~ plan", "resultFormat": "table"}}})
+	if !result.IsError {
+		t.Error("expected an unknown result format to be rejected")
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		size := min(2, 5-start)
+		var results []string
+		for i := range size {
+			results = append(results, fmt.Sprintf(`{"content":{"id":"%d","title":"Page %d"}}`, start+i, start+i))
+		}
+		fmt.Fprintf(w, `{"results":[%s],"start":%d,"limit":2,"size":%d,"totalSize":5}`, strings.Join(results, ","), start, size)
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	tool := s.GetTool("confluence_search_content")
+	if _, ok := tool.Tool.InputSchema.Properties["cursor"]; !ok {
+		t.Fatal("expected a cursor argument")
+	}
+	if _, ok := s.GetTool("confluence_get_content").Tool.InputSchema.Properties["cursor"]; ok {
+		t.Error("expected no cursor argument on a tool without pages")
+	}
+
+	var ids []string
+	args := map[string]any{"cql": "space = DOC", "limit": float64(2)}
+	for pages := 0; pages < 5; pages++ {
+		result, err := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+		if err != nil || result.IsError {
+			t.Fatalf("unexpected error %v %v", err, result.Content)
+		}
+		var page struct {
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &page); err != nil {
+			t.Fatal(err)
+		}
+		list := result.StructuredContent.(ContentListOutput)
+		if list.NextCursor != page.NextCursor {
+			t.Errorf("expected the same cursor in the structured output, got %q and %q", list.NextCursor, page.NextCursor)
+		}
+		for _, item := range list.Results {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		args = map[string]any{"cursor": page.NextCursor}
+	}
+	if strings.Join(ids, ",") != "0,1,2,3,4" {
+		t.Errorf("expected every result once, got %v", ids)
+	}
+	for _, query := range queries {
+		if query.Get("cql") != "space = DOC" || query.Get("limit") != "2" {
+			t.Errorf("expected the cursor to keep the query, got %v", query)
+		}
+	}
+
+	result, _ := tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cursor": "not a cursor"}}})
+	if !result.IsError {
+		t.Error("expected an error for an invalid cursor")
+	}
+	cursor, _ := encodeCursor(pageCursor{Tool: "confluence_get_children", Args: map[string]any{"start": float64(2)}})
+	result, _ = tool.Handler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"cursor": cursor}}})
+	if !result.IsError {
+		t.Error("expected an error for the cursor of another tool")
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/space":
+			_, _ = w.Write([]byte(`{"results":[{"key":"DOC","name":"Documentation"},{"key":"DEV","name":"Developers"},{"key":"HR","name":"People docs"}]}`))
+		case "/rest/api/search":
+			if !strings.Contains(r.URL.Query().Get("cql"), `space = "DOC"`) {
+				t.Errorf("expected labels of the chosen space, got %q", r.URL.Query().Get("cql"))
+			}
+			_, _ = w.Write([]byte(`{"results":[{"content":{"metadata":{"labels":{"results":[{"name":"release-notes"},{"name":"howto"}]}}}},
+				{"content":{"metadata":{"labels":{"results":[{"name":"release-plan"}]}}}}]}`))
+		case "/rest/api/template/page":
+			_, _ = w.Write([]byte(`{"results":[{"name":"Meeting notes","body":{"storage":{"value":"<p>Agenda</p>"}}},{"name":"Retrospective"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	registry := &ClientRegistry{names: []string{"main", "legacy"}, clients: map[string]*ConfluenceClient{
+		"main":   NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"}),
+		"legacy": NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"}),
+	}}
+	comp := newCompletions(registry)
+	tests := []struct {
+		argument, value string
+		chosen          map[string]string
+		want            []string
+	}{
+		{"instance", "l", nil, []string{"legacy"}},
+		{"spaceKey", "d", nil, []string{"DEV", "DOC", "HR"}},
+		{"spaceKey", "dev", map[string]string{"instance": "legacy"}, []string{"DEV"}},
+		{"label", "release", map[string]string{"spaceKey": "DOC"}, []string{"release-notes", "release-plan"}},
+		{"templateName", "notes", nil, []string{"Meeting notes"}},
+		{"contentId", "1", nil, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := comp.complete(context.Background(), tt.argument, tt.value, tt.chosen)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("complete(%s, %q) = %v, %v, want %v", tt.argument, tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := comp.complete(context.Background(), "spaceKey", "", map[string]string{"instance": "other"}); err == nil {
+		t.Error("expected an error for an unknown instance")
+	}
+
+	s := setupServer(registry, ToolsConfig{})
+	contents, err := readTemplateResource(registry)(context.Background(), mcp.ReadResourceRequest{Params: mcp.ReadResourceParams{URI: "confluence://main/space/DOC/template/Meeting%20notes"}})
+	if err != nil || !strings.Contains(contents[0].(mcp.TextResourceContents).Text, "Agenda") {
+		t.Errorf("unexpected template resource %v %v", contents, err)
+	}
+	request := `{"jsonrpc":"2.0","id":7,"method":"completion/complete","params":{"ref":{"type":"ref/resource","uri":"` + spaceURITemplate +
+		`"},"argument":{"name":"spaceKey","value":"DO"}}}`
+	want := `{"id":7,"jsonrpc":"2.0","result":{"completion":{"hasMore":false,"total":2,"values":["DOC","HR"]}}}`
+
+	t.Run("stdio", func(t *testing.T) {
+		inReader, inWriter := io.Pipe()
+		outReader, outWriter := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = serveStdio(ctx, s, nil, comp, inReader, outWriter)
+		}()
+		_, _ = inWriter.Write([]byte(request + "\n"))
+		line, err := bufio.NewReader(outReader).ReadString('\n')
+		if err != nil || strings.TrimSpace(line) != want {
+			t.Errorf("unexpected answer %q %v", line, err)
+		}
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		httpServer := httptest.NewServer(comp.answerMessages(mcpserver.NewSSEServer(s)))
+		defer httpServer.Close()
+		resp, err := http.Get(httpServer.URL + "/sse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		events := bufio.NewReader(resp.Body)
+		var endpoint string
+		for found := false; !found; {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			endpoint, found = strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		}
+		posted, err := http.Post(httpServer.URL+endpoint, "application/json", strings.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = posted.Body.Close()
+		if posted.StatusCode != http.StatusAccepted {
+			t.Fatalf("expected the request to be accepted, got %d", posted.StatusCode)
+		}
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+				if data != want {
+					t.Errorf("unexpected answer %q", data)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestDeleteConfirmation(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			_, _ = w.Write([]byte(`{"id":"task-1"}`))
+		case r.URL.Path == "/rest/api/search":
+			_, _ = w.Write([]byte(`{"results":[],"totalSize":42}`))
+		default:
+			_, _ = w.Write([]byte(`{"key":"OLD","name":"Old Space"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewConfluenceClient(&ConfluenceConfig{BaseURL: server.URL + "/rest/api", Token: "t"})
+	s := setupServer(singleClientRegistry(client), ToolsConfig{})
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = serveStdio(ctx, s, nil, nil, inReader, outWriter)
+	}()
+	out := bufio.NewReader(outReader)
+	send := func(message string) {
+		t.Helper()
+		if _, err := inWriter.Write([]byte(message + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() map[string]any {
+		t.Helper()
+		line, err := out.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var message map[string]any
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"test","version":"1"}}}`)
+	receive()
+	send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	for _, action := range []string{"decline", "accept"} {
+		send(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"confluence_delete_space","arguments":{"spaceKey":"OLD"}}}`)
+		request := receive()
+		params, _ := request["params"].(map[string]any)
+		if request["method"] != "elicitation/create" || !strings.Contains(fmt.Sprint(params["message"]), "Old Space (OLD) will be deleted permanently with its 42 pages") {
+			t.Fatalf("expected a confirmation request with a summary, got %v", request)
+		}
+		reply, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": request["id"], "result": map[string]any{"action": action, "content": map[string]any{"confirm": true}}})
+		send(string(reply))
+
+		result, _ := receive()["result"].(map[string]any)
+		if isError, _ := result["isError"].(bool); isError != (action == "decline") {
+			t.Errorf("unexpected result after %s: %v", action, result)
+		}
+	}
+	if len(deleted) != 1 {
+		t.Errorf("expected one deletion, got %v", deleted)
+	}
+}